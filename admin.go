@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// PurgeResult is returned by the purge endpoint
+type PurgeResult struct {
+	Snippets int `json:"snippets"`
+	Files    int `json:"files"`
+	Kept     int `json:"kept"` // immutable snippets left in place
+}
+
+// adminIdentity works out whether a request may use the admin endpoints,
+// returning who it is for the logs. Either the configured admin token or,
+// under mTLS, an admin CN will do.
+func adminIdentity(r *http.Request) (string, bool) {
+	if cfg.AdminToken != "" {
+		token := r.Header.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1 {
+			return "admin token from " + clientIP(r), true
+		}
+	}
+	if cfg.AuthEnabled {
+		if cn := requestOwner(r); isAdminCN(cn) {
+			return cn, true
+		}
+	}
+	return "", false
+}
+
+// purgeHandler handles "POST /admin/purge?confirm=yes", deleting every
+// snippet and file. Immutable snippets are kept unless ?immutable=yes is
+// given as well.
+func purgeHandler(w http.ResponseWriter, r *http.Request) {
+	who, ok := adminIdentity(r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if r.URL.Query().Get("confirm") != "yes" {
+		http.Error(w, "This deletes everything; add ?confirm=yes to go ahead", http.StatusBadRequest)
+		return
+	}
+
+	result := purgeAll(r.URL.Query().Get("immutable") == "yes")
+	logInfof("Purge by %s removed %d snippets and %d files, kept %d immutable snippets", who, result.Snippets, result.Files, result.Kept)
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// purgeAll clears the snippets and files, removes everything under the
+// uploads directory (including chunked uploads in progress) and saves the
+// empty state. Immutable snippets survive unless includeImmutable is set.
+func purgeAll(includeImmutable bool) PurgeResult {
+	snippetsMu.Lock()
+	var result PurgeResult
+	kept := make(map[string]Snippet)
+	var purged []string
+	for id, snippet := range snippets {
+		if snippet.Immutable && !includeImmutable {
+			kept[id] = snippet
+		} else {
+			purged = append(purged, id)
+		}
+	}
+	logSnippetDelete(purged...)
+	result.Snippets = len(snippets) - len(kept)
+	result.Kept = len(kept)
+	snippets = kept
+	snippetsMu.Unlock()
+
+	filesMu.Lock()
+	result.Files = len(files)
+	files = make(map[string]FileInfo)
+	filesMu.Unlock()
+
+	uploadSessionsMu.Lock()
+	uploadSessions = make(map[string]*uploadSession)
+	uploadSessionsMu.Unlock()
+
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		logErrorf("Error reading uploads directory: %v", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(uploadsDir, entry.Name())); err != nil {
+			logErrorf("Error removing %s: %v", entry.Name(), err)
+		}
+	}
+
+	saveSnippetsToFile(snippetsFile)
+	saveFilesToFile(filesFile)
+	return result
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupPurgeTest seeds snippets, files and a chunked upload in temp dirs
+func setupPurgeTest(t *testing.T) {
+	t.Helper()
+
+	originalSnippets := snippets
+	originalFiles := files
+	originalSessions := uploadSessions
+	originalUploadsDir := uploadsDir
+	originalSnippetsFile := snippetsFile
+	originalFilesFile := filesFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		files = originalFiles
+		uploadSessions = originalSessions
+		uploadsDir = originalUploadsDir
+		snippetsFile = originalSnippetsFile
+		filesFile = originalFilesFile
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	snippetsFile = filepath.Join(tmpDir, "snippets.json")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(filepath.Join(uploadsDir, ".chunks", "abc"), 0755)
+	os.WriteFile(filepath.Join(uploadsDir, ".chunks", "abc", "0"), []byte("chunk"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "orphan.txt"), []byte("b"), 0644)
+
+	snippets = map[string]Snippet{
+		"one": {Title: "One", Text: "1"},
+		"two": {Title: "Two", Text: "2"},
+	}
+	files = map[string]FileInfo{
+		"1-a.txt": {ID: "1-a.txt", Name: "a.txt", StoredName: "1-a.txt"},
+	}
+	uploadSessions = map[string]*uploadSession{
+		"abc": {ID: "abc", Chunks: 2, Received: map[int]int64{0: 1}},
+	}
+	cfg.AdminToken = "s3cret"
+}
+
+// purgeRequest builds a purge request with the given token and confirm value
+func purgeRequest(token, confirm string) *http.Request {
+	target := "/admin/purge"
+	if confirm != "" {
+		target += "?confirm=" + confirm
+	}
+	req := httptest.NewRequest("POST", target, nil)
+	if token != "" {
+		req.Header.Set("X-Admin-Token", token)
+	}
+	return req
+}
+
+// Test purge removes everything from both maps and disk
+func TestPurgeHandler(t *testing.T) {
+	setupPurgeTest(t)
+
+	w := httptest.NewRecorder()
+	purgeHandler(w, purgeRequest("s3cret", "yes"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("purgeHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var result PurgeResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Snippets != 2 || result.Files != 1 {
+		t.Errorf("PurgeResult = %+v, want 2 snippets and 1 file", result)
+	}
+
+	if len(snippets) != 0 || len(files) != 0 || len(uploadSessions) != 0 {
+		t.Errorf("Maps not empty after purge: %d snippets, %d files, %d uploads", len(snippets), len(files), len(uploadSessions))
+	}
+	entries, _ := os.ReadDir(uploadsDir)
+	if len(entries) != 0 {
+		t.Errorf("Uploads directory has %d entries after purge, want 0", len(entries))
+	}
+
+	// The empty state is persisted
+	if _, err := os.Stat(snippetsFile); err != nil {
+		t.Fatalf("Snippets not saved: %v", err)
+	}
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	files = make(map[string]FileInfo)
+	loadFilesFromFile(filesFile)
+	if len(snippets) != 0 || len(files) != 0 {
+		t.Errorf("Saved state not empty: %d snippets, %d files", len(snippets), len(files))
+	}
+}
+
+// Test purge refuses without confirmation or credentials
+func TestPurgeHandler_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		confirm string
+		want    int
+	}{
+		{"no confirmation", "s3cret", "", http.StatusBadRequest},
+		{"wrong confirmation", "s3cret", "true", http.StatusBadRequest},
+		{"no token", "", "yes", http.StatusForbidden},
+		{"wrong token", "guess", "yes", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupPurgeTest(t)
+
+			w := httptest.NewRecorder()
+			purgeHandler(w, purgeRequest(tt.token, tt.confirm))
+
+			if w.Code != tt.want {
+				t.Errorf("purgeHandler() status = %d, want %d", w.Code, tt.want)
+			}
+			if len(snippets) != 2 || len(files) != 1 {
+				t.Error("Rejected purge removed content")
+			}
+		})
+	}
+}
+
+// Test adminIdentity accepts admin CNs under mTLS and nothing when unconfigured
+func TestAdminIdentity(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	withCN := func(cn string) *http.Request {
+		req := httptest.NewRequest("POST", "/admin/purge", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}},
+		}
+		return req
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		req     *http.Request
+		wantWho string
+		wantOK  bool
+	}{
+		{"nothing configured", func(c *Config) {}, purgeRequest("", "yes"), "", false},
+		{"token", func(c *Config) { c.AdminToken = "s3cret" }, purgeRequest("s3cret", "yes"), "admin token from 192.0.2.1", true},
+		{"admin cn", func(c *Config) {
+			c.AuthEnabled = true
+			c.AdminCNs = []string{"root"}
+		}, withCN("root"), "root", true},
+		{"regular cn", func(c *Config) {
+			c.AuthEnabled = true
+			c.AdminCNs = []string{"root"}
+		}, withCN("alice"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = DefaultConfig()
+			tt.modify(&cfg)
+
+			who, ok := adminIdentity(tt.req)
+			if ok != tt.wantOK || who != tt.wantWho {
+				t.Errorf("adminIdentity() = (%q, %v), want (%q, %v)", who, ok, tt.wantWho, tt.wantOK)
+			}
+		})
+	}
+}
+
+// Test purge keeps immutable snippets unless told otherwise
+func TestPurgeHandler_Immutable(t *testing.T) {
+	setupPurgeTest(t)
+	snippets["audit"] = Snippet{Title: "Audit", Text: "a", Immutable: true}
+
+	w := httptest.NewRecorder()
+	purgeHandler(w, purgeRequest("s3cret", "yes"))
+
+	var result PurgeResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Snippets != 2 || result.Kept != 1 {
+		t.Errorf("PurgeResult = %+v, want 2 snippets removed and 1 kept", result)
+	}
+	if _, exists := snippets["audit"]; !exists || len(snippets) != 1 {
+		t.Errorf("Snippets after purge = %v, want just the immutable one", snippets)
+	}
+
+	w = httptest.NewRecorder()
+	purgeHandler(w, httptest.NewRequest("POST", "/admin/purge?confirm=yes&immutable=yes", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Purge without a token status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	req := purgeRequest("s3cret", "yes&immutable=yes")
+	w = httptest.NewRecorder()
+	purgeHandler(w, req)
+	if len(snippets) != 0 {
+		t.Errorf("Have %d snippets after purging with immutable=yes, want 0", len(snippets))
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The JSON API lists the same snippets and files as the index page, in the
+// same newest-first order, for building other front ends:
+//
+//	GET /api/snippets?limit=20&offset=40
+//	GET /api/files?limit=20
+//
+// Mirrors that need to page through snippets while new ones arrive use a
+// cursor instead of an offset, which walks them oldest first:
+//
+//	GET /api/snippets?cursor=&limit=100
+//	GET /api/snippets?cursor=<next_cursor>&limit=100
+//
+// and creates snippets with POST /api/snippets, answering with every link
+// the client could want.
+//
+// Listings never include snippet text or file contents. Under mTLS the usual
+// owner filtering applies, same as the index.
+//
+// Errors come back as {"error": {"code": "...", "message": "..."}}, with one
+// of the apiErr codes below so clients don't have to parse messages.
+
+const (
+	// defaultAPILimit is the page size when no limit is given
+	defaultAPILimit = 100
+	// maxAPILimit caps the page size a client can ask for
+	maxAPILimit = 1000
+)
+
+// Machine-readable API error codes
+const (
+	apiErrNotFound     = "not_found"
+	apiErrTooLarge     = "too_large"
+	apiErrRateLimited  = "rate_limited"
+	apiErrUnauthorized = "unauthorized"
+	apiErrInvalidInput = "invalid_input"
+	apiErrMethod       = "method_not_allowed"
+	apiErrPassword     = "wrong_password"
+	apiErrDestroyed    = "destroyed"
+	apiErrFull         = "full"
+	apiErrInvite       = "invite_required"
+	apiErrInternal     = "internal"
+)
+
+// APIError is the body of every API error response
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail says what went wrong
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeAPIError writes a JSON error response for the API routes, which
+// use this instead of http.Error.
+func writeAPIError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: msg}})
+}
+
+// notFoundHandler answers requests no route matched: API paths get the JSON
+// error, everything else the not-found page. mux only spots a wrong method
+// when no later route shares a matcher with the right one, which under a
+// base path is almost never, so paths that some other method would match
+// get the 405 from here.
+func notFoundHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if allowed := allowedMethods(router, r); len(allowed) > 0 {
+			methodNotAllowed(w, r, allowed)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, sitePath("/api/")) {
+			writeAPIError(w, http.StatusNotFound, apiErrNotFound, "No such API endpoint")
+			return
+		}
+		renderNotFound(w, r, "Page not found")
+	})
+}
+
+// methodNotAllowedHandler answers requests for a route that exists, but not
+// with that method
+func methodNotAllowedHandler(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methodNotAllowed(w, r, allowedMethods(router, r))
+	})
+}
+
+// routeMethods are the methods allowedMethods tries a path with. OPTIONS
+// isn't one: the CORS preflight route takes it for any API path.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// allowedMethods returns the methods some route would take r's path with
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		if method == r.Method {
+			continue
+		}
+		try := r.Clone(r.Context())
+		try.Method = method
+		var match mux.RouteMatch
+		if router.Match(try, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// methodNotAllowed responds with a 405 whose Allow header lists the methods
+// that would work. API paths get the JSON error.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if strings.HasPrefix(r.URL.Path, sitePath("/api/")) {
+		writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethod, r.Method+" isn't allowed here")
+		return
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// wantsJSON reports whether a page request asked for JSON instead of HTML,
+// with ?format=json or an Accept header naming application/json.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// wantsText reports whether a page request asked for plain text, with
+// ?format=text or an Accept header naming text/plain, as terminal clients do.
+func wantsText(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "text" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/plain")
+}
+
+// APISnippet is one entry in the snippet listing
+type APISnippet struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	CreatedAt time.Time  `json:"created_at"`
+	Views     int        `json:"views"`
+	ExpiresAt *time.Time `json:"expires_at"` // null means never
+	Tags      []string   `json:"tags,omitempty"`
+}
+
+// APISnippetPage is the response to "GET /api/snippets?cursor=...". An
+// empty NextCursor means there's nothing after this page.
+type APISnippetPage struct {
+	Items      []APISnippet `json:"items"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+// APIFile is one entry in the file listing
+type APIFile struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// APINewSnippet is the body of "POST /api/snippets". Only text is needed;
+// the rest work like the paste form's fields.
+type APINewSnippet struct {
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Language   string   `json:"language"`
+	Render     string   `json:"render"`
+	Visibility string   `json:"visibility"`
+	Expires    string   `json:"expires"`   // "1h", "1d", "7d", "30d" or "never"
+	Burn       *bool    `json:"burn"`      // null means default_burn
+	Immutable  bool     `json:"immutable"` // can't be combined with burn or expires
+	Password   string   `json:"password"`
+	Tags       []string `json:"tags"`
+}
+
+// APICreatedSnippet is the response to "POST /api/snippets". The URLs are
+// absolute; delete_url takes a POST with the delete token as 'token'.
+type APICreatedSnippet struct {
+	ID          string     `json:"id"`
+	DisplayURL  string     `json:"display_url"`
+	RawURL      string     `json:"raw_url"`
+	DownloadURL string     `json:"download_url"`
+	DeleteURL   string     `json:"delete_url"`
+	DeleteToken string     `json:"delete_token"`
+	ExpiresAt   *time.Time `json:"expires_at"` // null means never
+}
+
+// apiCreateSnippetOverhead is how much room the JSON around a snippet's
+// text gets on top of max_snippet_bytes
+const apiCreateSnippetOverhead = 64 << 10
+
+// parsePagination reads the limit and offset query parameters.
+func parsePagination(r *http.Request) (limit, offset int, err error) {
+	limit = defaultAPILimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAPILimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxAPILimit)
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be 0 or more")
+		}
+	}
+	return limit, offset, nil
+}
+
+// page returns the [offset, offset+limit) window of a listing of n items.
+func page(n, limit, offset int) (start, end int) {
+	start = min(offset, n)
+	end = min(start+limit, n)
+	return start, end
+}
+
+// apiSnippetsHandler handles "GET /api/snippets".
+func apiSnippetsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, err.Error())
+		return
+	}
+
+	owner, filtered := ownerFilter(r)
+	if r.URL.Query().Has("cursor") {
+		if offset != 0 {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, "offset can't be used with cursor")
+			return
+		}
+		after, err := parseSnippetCursor(r.URL.Query().Get("cursor"))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, "invalid cursor")
+			return
+		}
+		writeJSON(w, http.StatusOK, snippetPageAfter(owner, filtered, after, limit))
+		return
+	}
+
+	list := getAllSnippetsDescending(owner, filtered, 0)
+	start, end := page(len(list), limit, offset)
+
+	results := []APISnippet{}
+	for _, info := range list[start:end] {
+		results = append(results, apiSnippet(info))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// apiSnippet turns a listing entry into its API form
+func apiSnippet(info SnippetInfo) APISnippet {
+	snippet := APISnippet{
+		ID:        info.ID,
+		Title:     info.Title,
+		CreatedAt: info.CreatedAt,
+		Views:     info.Views,
+		Tags:      info.Tags,
+	}
+	if !info.ExpiresAt.IsZero() {
+		expiresAt := info.ExpiresAt
+		snippet.ExpiresAt = &expiresAt
+	}
+	return snippet
+}
+
+// snippetCursor marks a place in the oldest-first listing: the creation time
+// and ID of the last snippet a client has seen. The zero cursor is the
+// start. Since snippets are ordered by when they were created, with the ID
+// breaking ties, new ones always land after every cursor handed out, so
+// pages don't shift under a client as it walks them.
+type snippetCursor struct {
+	createdAt time.Time
+	id        string
+}
+
+// String encodes the cursor for next_cursor
+func (c snippetCursor) String() string {
+	raw := c.createdAt.UTC().Format(time.RFC3339Nano) + "/" + c.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseSnippetCursor decodes a cursor from snippetCursor.String. Empty is
+// the start of the listing.
+func parseSnippetCursor(value string) (snippetCursor, error) {
+	if value == "" {
+		return snippetCursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return snippetCursor{}, err
+	}
+	stamp, id, found := strings.Cut(string(raw), "/")
+	if !found || id == "" {
+		return snippetCursor{}, errors.New("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, stamp)
+	if err != nil {
+		return snippetCursor{}, err
+	}
+	return snippetCursor{createdAt: createdAt, id: id}, nil
+}
+
+// before reports whether the snippet created at createdAt with this ID comes
+// before c in the listing, so has already been seen.
+func (c snippetCursor) before(createdAt time.Time, id string) bool {
+	if !createdAt.Equal(c.createdAt) {
+		return createdAt.Before(c.createdAt)
+	}
+	return id <= c.id
+}
+
+// snippetPageAfter returns up to limit listed snippets following the cursor,
+// oldest first, with the cursor for the next page if there are more.
+func snippetPageAfter(owner string, filtered bool, after snippetCursor, limit int) APISnippetPage {
+	snippetsMu.RLock()
+	now := time.Now()
+	var list []SnippetInfo
+	for id, snippet := range snippets {
+		if filtered && snippet.Owner != owner {
+			continue
+		}
+		if snippet.expired(now) || snippet.Visibility == visibilityUnlisted || after.before(snippet.CreatedAt, id) {
+			continue
+		}
+		list = append(list, snippetInfo(id, snippet))
+	}
+	snippetsMu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	result := APISnippetPage{Items: []APISnippet{}}
+	if len(list) > limit {
+		last := list[limit-1]
+		result.NextCursor = snippetCursor{createdAt: last.CreatedAt, id: last.ID}.String()
+		list = list[:limit]
+	}
+	for _, info := range list {
+		result.Items = append(result.Items, apiSnippet(info))
+	}
+	return result
+}
+
+// apiFilesHandler handles "GET /api/files".
+func apiFilesHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePagination(r)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, err.Error())
+		return
+	}
+
+	owner, filtered := ownerFilter(r)
+	list := listFileEntries(owner, filtered, 0)
+	start, end := page(len(list), limit, offset)
+
+	results := []APIFile{}
+	for _, entry := range list[start:end] {
+		results = append(results, APIFile{
+			ID:         entry.ID,
+			Name:       entry.Name,
+			Size:       entry.Size,
+			Checksum:   entry.Checksum,
+			UploadedAt: entry.UploadedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// apiCreateSnippetHandler handles "POST /api/snippets", saving a snippet
+// from a JSON APINewSnippet.
+func apiCreateSnippetHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireInvite(w, r, "paste", true) {
+		return
+	}
+	if refuseWhenFull(w, r, true) {
+		return
+	}
+	// Escaping can make the JSON bigger than the text it carries
+	limit := 2*int64(cfg.MaxSnippetBytes) + apiCreateSnippetOverhead
+	var req APINewSnippet
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(&req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, apiErrTooLarge, tooLargeMessage())
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, "Body must be a JSON object: "+err.Error())
+		return
+	}
+
+	if req.Text == "" {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, "text is required")
+		return
+	}
+	if len(req.Text) > cfg.MaxSnippetBytes {
+		writeAPIError(w, http.StatusRequestEntityTooLarge, apiErrTooLarge, tooLargeMessage())
+		return
+	}
+	if !validRenderMode(req.Render) {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, fmt.Sprintf("Unknown render mode %q", req.Render))
+		return
+	}
+	if !validVisibility(req.Visibility) {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, fmt.Sprintf("Unknown visibility %q", req.Visibility))
+		return
+	}
+	if !validLanguage(req.Language) {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, fmt.Sprintf("Unknown language %q", req.Language))
+		return
+	}
+	ttl, err := parseExpiry(req.Expires)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, err.Error())
+		return
+	}
+
+	var burnValues []string
+	if req.Burn != nil {
+		burnValues = []string{strconv.FormatBool(*req.Burn)}
+	}
+	burn := pasteBurns(burnValues)
+	if req.Immutable && (burn || ttl != 0) {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, "Immutable snippets can't burn after reading or expire")
+		return
+	}
+
+	title := req.Title
+	if title == "" {
+		title = derivedTitle(req.Text)
+	}
+	language := req.Language
+	if language == "" {
+		language = detectLanguage(req.Text)
+	}
+
+	token, err := generateDeleteToken()
+	if err != nil {
+		logErrorf("Error generating delete token: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Failed to save snippet")
+		return
+	}
+	tags, err := parseTags(strings.Join(req.Tags, ","))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidInput, err.Error())
+		return
+	}
+	passwordHash, err := uploadPasswordHash(req.Password)
+	if err != nil {
+		logErrorf("Error hashing paste password: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Failed to save snippet")
+		return
+	}
+
+	snippet := Snippet{
+		Title:            title,
+		BurnAfterReading: burn,
+		DeleteTokenHash:  hashDeleteToken(token),
+		CreatedAt:        time.Now(),
+		Owner:            requestOwner(r),
+		Render:           req.Render,
+		ExpiresAt:        expiryTime(ttl),
+		Language:         language,
+		Visibility:       req.Visibility,
+		Immutable:        req.Immutable,
+		PasswordHash:     passwordHash,
+		Tags:             tags,
+	}
+	snippet.SetBody(req.Text)
+	id, err := addSnippet(r, snippet, "")
+	if err != nil {
+		logErrorf("Error generating snippet ID: %v", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Failed to save snippet")
+		return
+	}
+
+	created := APICreatedSnippet{
+		ID:          id,
+		DisplayURL:  absoluteURL(r, "/display/"+id),
+		RawURL:      absoluteURL(r, "/raw/"+id),
+		DownloadURL: absoluteURL(r, "/raw/"+id+"?download=1"),
+		DeleteURL:   absoluteURL(r, "/delete/"+id),
+		DeleteToken: token,
+	}
+	if !snippet.ExpiresAt.IsZero() {
+		created.ExpiresAt = &snippet.ExpiresAt
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// setupAPITest fills the snippets and files with a few entries of each
+func setupAPITest(t *testing.T) time.Time {
+	t.Helper()
+
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	snippetsFile = filepath.Join(tmpDir, "snippets.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	now := time.Now()
+	snippets = map[string]Snippet{
+		"old":     {Title: "Old", Text: "old secret body", CreatedAt: now.Add(-2 * time.Hour), Views: 3},
+		"mid":     {Title: "Mid", Text: "mid secret body", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(time.Hour)},
+		"new":     {Title: "New", Text: "new secret body", CreatedAt: now},
+		"expired": {Title: "Gone", Text: "gone secret body", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+	}
+
+	os.WriteFile(filepath.Join(uploadsDir, "1-a.txt"), []byte("file secret contents"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "2-b.txt"), []byte("more"), 0644)
+	files = map[string]FileInfo{
+		"1-a.txt": {ID: "1-a.txt", Name: "a.txt", UploadedAt: now.Add(-time.Hour), Checksum: "abc123"},
+		"2-b.txt": {ID: "2-b.txt", Name: "b.txt", UploadedAt: now},
+	}
+	return now
+}
+
+// Test the snippet listing's shape, order and that bodies aren't included
+func TestAPISnippetsHandler(t *testing.T) {
+	now := setupAPITest(t)
+
+	req := httptest.NewRequest("GET", "/api/snippets", nil)
+	w := httptest.NewRecorder()
+	apiSnippetsHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("apiSnippetsHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Listing leaked snippet text: %s", w.Body.String())
+	}
+
+	var raw []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(raw) != 3 {
+		t.Fatalf("Listing has %d snippets, want 3 (expired left out)", len(raw))
+	}
+	for _, key := range []string{"id", "title", "created_at", "views", "expires_at"} {
+		if _, ok := raw[0][key]; !ok {
+			t.Errorf("Snippet entry missing %q", key)
+		}
+	}
+	if len(raw[0]) != 5 {
+		t.Errorf("Snippet entry has fields %v, want exactly 5", raw[0])
+	}
+
+	var list []APISnippet
+	json.Unmarshal(w.Body.Bytes(), &list)
+	var ids []string
+	for _, s := range list {
+		ids = append(ids, s.ID)
+	}
+	if got := strings.Join(ids, ","); got != "new,mid,old" {
+		t.Errorf("Snippet order = %s, want new,mid,old", got)
+	}
+	if list[2].Views != 3 {
+		t.Errorf("Views = %d, want 3", list[2].Views)
+	}
+	if list[0].ExpiresAt != nil {
+		t.Errorf("ExpiresAt = %v, want null for a snippet that never expires", list[0].ExpiresAt)
+	}
+	if list[1].ExpiresAt == nil || !list[1].ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("ExpiresAt = %v, want %v", list[1].ExpiresAt, now.Add(time.Hour))
+	}
+}
+
+// Test limit and offset page through the snippet listing
+func TestAPISnippetsHandler_Pagination(t *testing.T) {
+	setupAPITest(t)
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		wantIDs    string
+	}{
+		{"?limit=2", http.StatusOK, "new,mid"},
+		{"?limit=2&offset=2", http.StatusOK, "old"},
+		{"?offset=1", http.StatusOK, "mid,old"},
+		{"?offset=10", http.StatusOK, ""},
+		{"?limit=0", http.StatusBadRequest, ""},
+		{"?limit=abc", http.StatusBadRequest, ""},
+		{"?limit=100000", http.StatusBadRequest, ""},
+		{"?offset=-1", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/snippets"+tt.query, nil)
+		w := httptest.NewRecorder()
+		apiSnippetsHandler(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("apiSnippetsHandler(%s) status = %d, want %d", tt.query, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var list []APISnippet
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Errorf("apiSnippetsHandler(%s) response not JSON: %v", tt.query, err)
+			continue
+		}
+		var ids []string
+		for _, s := range list {
+			ids = append(ids, s.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.wantIDs {
+			t.Errorf("apiSnippetsHandler(%s) = %s, want %s", tt.query, got, tt.wantIDs)
+		}
+	}
+}
+
+// Test walking the listing by cursor sees every snippet exactly once, oldest
+// first, even with snippets sharing a creation time and new ones added
+// between pages
+func TestAPISnippetsHandler_Cursor(t *testing.T) {
+	setupAPITest(t)
+
+	base := time.Now().Add(-24 * time.Hour)
+	snippets = make(map[string]Snippet)
+	var want []string
+	for i := range 23 {
+		id := fmt.Sprintf("s%02d", i)
+		// Every third one shares its predecessor's timestamp
+		snippets[id] = Snippet{Title: id, CreatedAt: base.Add(time.Duration(i-i%3) * time.Second), Tags: []string{"batch", id}}
+		want = append(want, id)
+	}
+	snippets["unlisted"] = Snippet{CreatedAt: base, Visibility: visibilityUnlisted}
+
+	var seen []string
+	cursor, pages := "", 0
+	for {
+		req := httptest.NewRequest("GET", "/api/snippets?limit=5&cursor="+url.QueryEscape(cursor), nil)
+		w := httptest.NewRecorder()
+		apiSnippetsHandler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Page %d status = %d: %s", pages, w.Code, w.Body.String())
+		}
+		var page APISnippetPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Page %d not JSON: %v", pages, err)
+		}
+		if len(page.Items) > 5 {
+			t.Fatalf("Page %d has %d items, want at most 5", pages, len(page.Items))
+		}
+		for _, item := range page.Items {
+			seen = append(seen, item.ID)
+			// Same shape as the offset pages, tags included
+			if wantTags := snippets[item.ID].Tags; !slices.Equal(item.Tags, wantTags) {
+				t.Errorf("Snippet %s tags = %v, want %v", item.ID, item.Tags, wantTags)
+			}
+		}
+
+		// A paste arrives while the mirror is partway through
+		if pages < 3 {
+			id := fmt.Sprintf("new%d", pages)
+			snippets[id] = Snippet{Title: id, CreatedAt: time.Now().Add(time.Duration(pages) * time.Second)}
+			want = append(want, id)
+		}
+
+		pages++
+		if page.NextCursor == "" {
+			break
+		}
+		if pages > 20 {
+			t.Fatal("Cursor never ran out")
+		}
+		cursor = page.NextCursor
+	}
+
+	if got, wantIDs := strings.Join(seen, ","), strings.Join(want, ","); got != wantIDs {
+		t.Errorf("Cursor walk saw\n%s\nwant\n%s", got, wantIDs)
+	}
+
+	for _, bad := range []string{"?cursor=!!!", "?cursor=bm90LWEtY3Vyc29y", "?cursor=&offset=5"} {
+		req := httptest.NewRequest("GET", "/api/snippets"+bad, nil)
+		w := httptest.NewRecorder()
+		apiSnippetsHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("apiSnippetsHandler(%s) status = %d, want %d", bad, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Test the file listing's shape, order and that contents aren't included
+func TestAPIFilesHandler(t *testing.T) {
+	setupAPITest(t)
+
+	req := httptest.NewRequest("GET", "/api/files?limit=10", nil)
+	w := httptest.NewRecorder()
+	apiFilesHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("apiFilesHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("Listing leaked file contents: %s", w.Body.String())
+	}
+
+	var raw []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(raw) != 2 {
+		t.Fatalf("Listing has %d files, want 2", len(raw))
+	}
+	for _, key := range []string{"id", "name", "size", "checksum", "uploaded_at"} {
+		if _, ok := raw[0][key]; !ok {
+			t.Errorf("File entry missing %q", key)
+		}
+	}
+
+	var list []APIFile
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if list[0].ID != "2-b.txt" || list[1].ID != "1-a.txt" {
+		t.Errorf("File order = %s,%s, want 2-b.txt,1-a.txt", list[0].ID, list[1].ID)
+	}
+	if list[1].Size != int64(len("file secret contents")) {
+		t.Errorf("Size = %d, want %d", list[1].Size, len("file secret contents"))
+	}
+	if list[1].Checksum != "abc123" {
+		t.Errorf("Checksum = %q, want abc123", list[1].Checksum)
+	}
+	if list[0].Name != "b.txt" || list[1].Name != "a.txt" {
+		t.Errorf("Names = %s,%s, want the uploaded names b.txt,a.txt", list[0].Name, list[1].Name)
+	}
+
+	// A file with no metadata goes by its ID
+	os.WriteFile(filepath.Join(uploadsDir, "3-c.txt"), []byte("untracked"), 0644)
+	w = httptest.NewRecorder()
+	apiFilesHandler(w, httptest.NewRequest("GET", "/api/files", nil))
+	list = nil
+	json.Unmarshal(w.Body.Bytes(), &list)
+	i := slices.IndexFunc(list, func(f APIFile) bool { return f.ID == "3-c.txt" })
+	if i < 0 || list[i].Name != "3-c.txt" {
+		t.Errorf("Untracked file entry in %+v, want it named by its ID", list)
+	}
+}
+
+// Test an empty listing is an empty array rather than null
+func TestAPIFilesHandler_Empty(t *testing.T) {
+	setupAPITest(t)
+	files = make(map[string]FileInfo)
+	uploadsDir = t.TempDir()
+
+	req := httptest.NewRequest("GET", "/api/files", nil)
+	w := httptest.NewRecorder()
+	apiFilesHandler(w, req)
+
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("apiFilesHandler() body = %q, want []", body)
+	}
+}
+
+// Test unknown API paths get the JSON error envelope, and other paths don't
+func TestAPIErrors(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	tests := []struct {
+		name       string
+		basePath   string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown endpoint", "", "/api/nope", http.StatusNotFound, apiErrNotFound},
+		{"unknown endpoint under base path", "/pasty", "/pasty/api/nope", http.StatusNotFound, apiErrNotFound},
+		{"bad limit", "", "/api/snippets?limit=-1", http.StatusBadRequest, apiErrInvalidInput},
+		{"bad offset", "", "/api/files?offset=x", http.StatusBadRequest, apiErrInvalidInput},
+		{"not an API path", "", "/nope/nope", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.BasePath = tt.basePath
+			router := newRouter()
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+
+			var apiErr APIError
+			err := json.Unmarshal(w.Body.Bytes(), &apiErr)
+			if tt.wantCode == "" {
+				if err == nil {
+					t.Errorf("GET %s returned a JSON error %q, want the plain 404", tt.path, w.Body.String())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET %s body %q isn't a JSON error: %v", tt.path, w.Body.String(), err)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if apiErr.Error.Code != tt.wantCode {
+				t.Errorf("Error code = %q, want %q", apiErr.Error.Code, tt.wantCode)
+			}
+			if apiErr.Error.Message == "" {
+				t.Error("Error message is empty")
+			}
+		})
+	}
+}
+
+// Test unknown paths get the not-found page and wrong methods a 405 listing
+// the right ones
+func TestRouterErrors(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	originalNotFound := tmplNotFound
+	t.Cleanup(func() {
+		cfg = originalCfg
+		tmplNotFound = originalNotFound
+	})
+	tmplNotFound = template.Must(template.New("notfound").Parse(`Not found: {{.Message}}`))
+
+	tests := []struct {
+		name       string
+		basePath   string
+		method     string
+		path       string
+		wantStatus int
+		wantAllow  string
+		wantBody   string
+	}{
+		{"unknown page", "", "GET", "/nope/nope", http.StatusNotFound, "", "Not found: Page not found"},
+		{"save with GET", "", "GET", "/save", http.StatusMethodNotAllowed, "POST", "Method not allowed"},
+		{"save with GET under base path", "/pasty", "GET", "/pasty/save", http.StatusMethodNotAllowed, "POST", "Method not allowed"},
+		{"API endpoint with DELETE", "", "DELETE", "/api/snippets", http.StatusMethodNotAllowed, "GET, POST", apiErrMethod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.BasePath = tt.basePath
+			router := newRouter()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.wantStatus)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", allow, tt.wantAllow)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("Body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// Test creating a snippet through the API returns working absolute links
+func TestAPICreateSnippetHandler(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+	cfg.BasePath = "/pasty"
+	router := newRouter()
+
+	body := `{"title": "Notes", "text": "line one\nline two\n", "expires": "1h"}`
+	req := httptest.NewRequest("POST", "/pasty/api/snippets", strings.NewReader(body))
+	req.Host = "paste.example.com"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/snippets status = %d, want %d: %s", w.Code, http.StatusCreated, w.Body.String())
+	}
+	var created APICreatedSnippet
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if _, ok := snippets[created.ID]; !ok {
+		t.Fatalf("Snippet %q not stored", created.ID)
+	}
+	if created.DeleteToken == "" || !validDeleteToken(snippets[created.ID], created.DeleteToken) {
+		t.Errorf("delete_token %q doesn't delete the snippet", created.DeleteToken)
+	}
+	if created.ExpiresAt == nil || time.Until(*created.ExpiresAt) > time.Hour {
+		t.Errorf("expires_at = %v, want within the hour", created.ExpiresAt)
+	}
+
+	for name, tt := range map[string]struct {
+		got, wantPath string
+	}{
+		"display_url":  {created.DisplayURL, "/pasty/display/" + created.ID},
+		"raw_url":      {created.RawURL, "/pasty/raw/" + created.ID},
+		"download_url": {created.DownloadURL, "/pasty/raw/" + created.ID},
+		"delete_url":   {created.DeleteURL, "/pasty/delete/" + created.ID},
+	} {
+		u, err := url.Parse(tt.got)
+		if err != nil || u.Scheme != "http" || u.Host != "paste.example.com" || u.Path != tt.wantPath {
+			t.Errorf("%s = %q, want http://paste.example.com%s", name, tt.got, tt.wantPath)
+		}
+	}
+
+	raw, _ := url.Parse(created.RawURL)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", raw.RequestURI(), nil))
+	if w.Body.String() != "line one\nline two\n" {
+		t.Errorf("GET raw_url = %q, want the snippet text", w.Body.String())
+	}
+
+	download, _ := url.Parse(created.DownloadURL)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", download.RequestURI(), nil))
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("GET download_url Content-Disposition = %q, want an attachment", cd)
+	}
+}
+
+// Test bad API snippet bodies get JSON errors
+func TestAPICreateSnippetHandler_Errors(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+	cfg.MaxSnippetBytes = 10
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"not json", "text=hello", http.StatusBadRequest, apiErrInvalidInput},
+		{"no text", `{"title": "x"}`, http.StatusBadRequest, apiErrInvalidInput},
+		{"bad expiry", `{"text": "x", "expires": "1y"}`, http.StatusBadRequest, apiErrInvalidInput},
+		{"immutable burn", `{"text": "x", "burn": true, "immutable": true}`, http.StatusBadRequest, apiErrInvalidInput},
+		{"too large", `{"text": "more than ten bytes"}`, http.StatusRequestEntityTooLarge, apiErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(snippets)
+			w := httptest.NewRecorder()
+			apiCreateSnippetHandler(w, httptest.NewRequest("POST", "/api/snippets", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var apiErr APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Error.Code != tt.wantCode {
+				t.Errorf("body = %q, want error code %s", w.Body.String(), tt.wantCode)
+			}
+			if len(snippets) != before {
+				t.Error("Rejected request stored a snippet")
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/zip"
+	"net/http"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+)
+
+// maxArchiveEntries caps how many entries an archive listing shows. A zip
+// can claim millions of tiny entries; past this the listing is cut short.
+const maxArchiveEntries = 1000
+
+// ArchiveEntry is one file inside an archive
+type ArchiveEntry struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"` // uncompressed
+}
+
+// ArchiveListing is what "GET /archive/{id}" returns. Total counts every
+// entry, including any left out once the listing hit maxArchiveEntries.
+type ArchiveListing struct {
+	Entries   []ArchiveEntry `json:"entries"`
+	Total     int            `json:"total"`
+	Truncated bool           `json:"truncated"`
+}
+
+// isArchiveFile checks if the file is a zip archive we can list
+func isArchiveFile(filename string) bool {
+	return filepath.Ext(filename) == ".zip"
+}
+
+// listZipEntries reads the entries of a zip file from its central
+// directory. Nothing is extracted.
+func listZipEntries(path string) (ArchiveListing, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return ArchiveListing{}, err
+	}
+	defer zr.Close()
+
+	listing := ArchiveListing{Entries: []ArchiveEntry{}, Total: len(zr.File)}
+	for _, f := range zr.File {
+		if len(listing.Entries) == maxArchiveEntries {
+			listing.Truncated = true
+			break
+		}
+		listing.Entries = append(listing.Entries, ArchiveEntry{Name: f.Name, Size: f.UncompressedSize64})
+	}
+	return listing, nil
+}
+
+// archiveHandler handles "GET /archive/{id}", listing what's in an uploaded
+// zip as JSON. Password-protected files need unlocking first, or ?pw=.
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := filepath.Base(mux.Vars(r)["id"])
+	fi, exists := resolveFile(fileID)
+	if !exists {
+		renderNotFound(w, r, "File not found")
+		return
+	}
+	fullPath := fi.storedPath()
+	if !checkFilePassword(w, r, fi, "file") {
+		return
+	}
+	if !isArchiveFile(fi.Name) {
+		http.Error(w, "Not a zip archive", http.StatusBadRequest)
+		return
+	}
+
+	listing, err := listZipEntries(fullPath)
+	if err != nil {
+		logErrorf("Error reading archive %s: %v", fileID, err)
+		http.Error(w, "Could not read archive", http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
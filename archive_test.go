@@ -0,0 +1,133 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeZip builds a zip holding the given files
+func makeZip(t *testing.T, contents map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range contents {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to zip: %v", name, err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Test an uploaded zip's entries are listed by /archive/ and the file page
+func TestArchiveHandler(t *testing.T) {
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	filesFile = filepath.Join(tmpDir, "files.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+	os.WriteFile(filepath.Join(uploadsDir, "1-notes.txt"), []byte("not a zip"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-broken.zip"), []byte("not a zip either"), 0644)
+
+	// Upload the zip the usual way
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "bundle.zip")
+	part.Write(makeZip(t, map[string]string{"readme.txt": "hello", "src/main.go": "package main\n"}))
+	writer.Close()
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	var zipID string
+	for id := range files {
+		zipID = id
+	}
+
+	router := newRouter()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/archive/"+zipID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /archive/%s status = %d, want %d", zipID, w.Code, http.StatusOK)
+	}
+	var listing ArchiveListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("Failed to parse listing: %v", err)
+	}
+	sizes := map[string]uint64{}
+	for _, entry := range listing.Entries {
+		sizes[entry.Name] = entry.Size
+	}
+	if len(sizes) != 2 || sizes["readme.txt"] != 5 || sizes["src/main.go"] != 13 {
+		t.Errorf("Listing = %+v, want readme.txt (5) and src/main.go (13)", listing.Entries)
+	}
+	if listing.Total != 2 || listing.Truncated {
+		t.Errorf("Total = %d, Truncated = %v, want 2, false", listing.Total, listing.Truncated)
+	}
+
+	// The file page lists them too
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/file/"+zipID, nil))
+	if !strings.Contains(w.Body.String(), "src/main.go") {
+		t.Errorf("File page doesn't list the archive contents")
+	}
+
+	for _, tt := range []struct {
+		id   string
+		want int
+	}{
+		{"1-notes.txt", http.StatusBadRequest},
+		{"1-broken.zip", http.StatusUnprocessableEntity},
+		{"nope.zip", http.StatusNotFound},
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/archive/"+tt.id, nil))
+		if w.Code != tt.want {
+			t.Errorf("GET /archive/%s status = %d, want %d", tt.id, w.Code, tt.want)
+		}
+	}
+}
+
+// Test a zip with more entries than we list is cut short
+func TestListZipEntries_Cap(t *testing.T) {
+	contents := map[string]string{}
+	for i := 0; i < maxArchiveEntries+5; i++ {
+		contents[fmt.Sprintf("f%d", i)] = ""
+	}
+	path := filepath.Join(t.TempDir(), "many.zip")
+	os.WriteFile(path, makeZip(t, contents), 0644)
+
+	listing, err := listZipEntries(path)
+	if err != nil {
+		t.Fatalf("listZipEntries() error = %v", err)
+	}
+	if len(listing.Entries) != maxArchiveEntries || listing.Total != maxArchiveEntries+5 || !listing.Truncated {
+		t.Errorf("listZipEntries() = %d entries, total %d, truncated %v; want %d, %d, true",
+			len(listing.Entries), listing.Total, listing.Truncated, maxArchiveEntries, maxArchiveEntries+5)
+	}
+}
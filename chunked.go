@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Chunked uploads let a client send a large file in pieces and retry just the
+// piece that failed:
+//
+//	POST /upload/init?filename=big.mp4&chunks=3&size=...   -> {"upload_id": "..."}
+//	PUT  /upload/{uploadID}/{chunkIndex}          (raw chunk bytes, 0-based)
+//	GET  /upload/{uploadID}                       -> chunks received so far
+//	POST /upload/{uploadID}/complete              -> assembles the file
+//
+// Chunks are kept under uploads/.chunks/<uploadID>/ until completion. The
+// whole file is held to cfg.MaxUploadBytes: the optional declared size at
+// init, the chunks received so far on every PUT, and the assembled file on
+// completion.
+
+const (
+	// maxChunkBytes caps the size of a single chunk
+	maxChunkBytes = 64 << 20
+	// maxUploadChunks caps how many chunks a single upload can have
+	maxUploadChunks = 10000
+	// uploadSessionTimeout is how long an upload can sit idle before it's
+	// considered abandoned and cleaned up
+	uploadSessionTimeout = time.Hour
+)
+
+// uploadSession tracks a chunked upload in progress
+type uploadSession struct {
+	ID               string
+	Filename         string
+	Chunks           int
+	BurnAfterReading bool
+	Owner            string
+	Expiry           time.Duration
+	PasswordHash     string
+	Received         map[int]int64 // chunk index to its size in bytes
+	LastActivity     time.Time
+}
+
+// UploadStatus is the JSON view of an upload session
+type UploadStatus struct {
+	UploadID string `json:"upload_id"`
+	Chunks   int    `json:"chunks"`
+	Received []int  `json:"received"`
+}
+
+var (
+	uploadSessions   = make(map[string]*uploadSession)
+	uploadSessionsMu sync.Mutex
+)
+
+// chunksDir returns where an upload's chunks are stored
+func chunksDir(uploadID string) string {
+	return filepath.Join(uploadsDir, ".chunks", uploadID)
+}
+
+// getUploadSession looks up a session and marks it active. Callers must hold
+// uploadSessionsMu.
+func getUploadSession(uploadID string) (*uploadSession, bool) {
+	session, exists := uploadSessions[uploadID]
+	if exists {
+		session.LastActivity = time.Now()
+	}
+	return session, exists
+}
+
+// receivedBytes totals the chunks received, leaving out the one at skip
+// (pass -1 to count them all). Callers must hold uploadSessionsMu.
+func (s *uploadSession) receivedBytes(skip int) int64 {
+	var total int64
+	for index, size := range s.Received {
+		if index != skip {
+			total += size
+		}
+	}
+	return total
+}
+
+// status returns the session's JSON view. Callers must hold uploadSessionsMu.
+func (s *uploadSession) status() UploadStatus {
+	received := []int{}
+	for index := range s.Received {
+		received = append(received, index)
+	}
+	sort.Ints(received)
+	return UploadStatus{UploadID: s.ID, Chunks: s.Chunks, Received: received}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// initUploadHandler handles "POST /upload/init", starting a chunked upload.
+// Expects 'filename' and 'chunks' (the number of chunks that will be sent),
+// and optionally 'size', the file's total size, so an upload that's too big
+// is turned away before any of it is sent.
+func initUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireInvite(w, r, "upload", false) {
+		return
+	}
+	filename := cleanFileName(r.FormValue("filename"))
+	if filename == "" {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+
+	chunks, err := strconv.Atoi(r.FormValue("chunks"))
+	if err != nil || chunks < 1 || chunks > maxUploadChunks {
+		http.Error(w, fmt.Sprintf("chunks must be between 1 and %d", maxUploadChunks), http.StatusBadRequest)
+		return
+	}
+
+	if declared := r.FormValue("size"); declared != "" {
+		size, err := strconv.ParseInt(declared, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "size must be a number of bytes", http.StatusBadRequest)
+			return
+		}
+		if size > cfg.MaxUploadBytes {
+			logWarnf("Rejected chunked upload of %s: declared %s", filename, humanBytes(size))
+			http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	ttl, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The content is checked again on completion
+	if err := checkUploadType(filename, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	passwordHash, err := uploadPasswordHash(r.FormValue("password"))
+	if err != nil {
+		logErrorf("Error hashing upload password: %v", err)
+		http.Error(w, "Cannot start upload", http.StatusInternalServerError)
+		return
+	}
+
+	uploadID := randomString(22)
+
+	if err := os.MkdirAll(chunksDir(uploadID), 0755); err != nil {
+		logErrorf("Error creating chunk directory: %v", err)
+		http.Error(w, "Cannot start upload", http.StatusInternalServerError)
+		return
+	}
+
+	session := &uploadSession{
+		ID:               uploadID,
+		Filename:         filename,
+		Chunks:           chunks,
+		BurnAfterReading: r.FormValue("burn") == "true",
+		Owner:            requestOwner(r),
+		Expiry:           ttl,
+		PasswordHash:     passwordHash,
+		Received:         make(map[int]int64),
+		LastActivity:     time.Now(),
+	}
+
+	uploadSessionsMu.Lock()
+	uploadSessions[uploadID] = session
+	status := session.status()
+	uploadSessionsMu.Unlock()
+
+	logDebugf("Started chunked upload %s for %s (%d chunks)", uploadID, filename, chunks)
+	writeJSON(w, http.StatusCreated, status)
+}
+
+// uploadChunkHandler handles "PUT /upload/{uploadID}/{chunkIndex}". Sending
+// the same chunk again replaces it, which is how a failed chunk is retried.
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uploadID := vars["uploadID"]
+
+	index, err := strconv.Atoi(vars["chunkIndex"])
+	if err != nil {
+		index = -1
+	}
+
+	uploadSessionsMu.Lock()
+	session, exists := getUploadSession(uploadID)
+	var chunks int
+	var others int64
+	if exists {
+		chunks = session.Chunks
+		others = session.receivedBytes(index)
+	}
+	uploadSessionsMu.Unlock()
+	if !exists {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+
+	if index < 0 || index >= chunks {
+		http.Error(w, fmt.Sprintf("Chunk index must be between 0 and %d", chunks-1), http.StatusBadRequest)
+		return
+	}
+
+	// A chunk can only take the upload up to the size limit
+	limit := min(int64(maxChunkBytes), cfg.MaxUploadBytes-others)
+	if limit < 0 {
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	release, ok := takeUploadSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Same .part-then-rename approach as storeUpload, so a half-written chunk
+	// is never mistaken for a complete one
+	chunkPath := filepath.Join(chunksDir(uploadID), strconv.Itoa(index))
+	partPath := chunkPath + partSuffix
+	dst, err := os.Create(partPath)
+	if err != nil {
+		logErrorf("Error creating chunk file: %v", err)
+		http.Error(w, "Cannot save chunk", http.StatusInternalServerError)
+		return
+	}
+	size, err := io.Copy(dst, http.MaxBytesReader(w, r.Body, limit))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logWarnf("Rejected chunk %d of upload %s: over the size limit", index, uploadID)
+		os.Remove(partPath)
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err == nil {
+		err = os.Rename(partPath, chunkPath)
+	}
+	if err != nil {
+		logErrorf("Error saving chunk %d of upload %s: %v", index, uploadID, err)
+		os.Remove(partPath)
+		http.Error(w, "Cannot save chunk", http.StatusInternalServerError)
+		return
+	}
+
+	uploadSessionsMu.Lock()
+	session, exists = getUploadSession(uploadID)
+	if !exists {
+		// Cleaned up or completed while we were writing
+		uploadSessionsMu.Unlock()
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+	// Other chunks may have arrived meanwhile
+	if session.receivedBytes(index)+size > cfg.MaxUploadBytes {
+		uploadSessionsMu.Unlock()
+		os.Remove(chunkPath)
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	session.Received[index] = size
+	status := session.status()
+	uploadSessionsMu.Unlock()
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// uploadStatusHandler handles "GET /upload/{uploadID}", reporting which
+// chunks have arrived so a client can resume.
+func uploadStatusHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["uploadID"]
+
+	uploadSessionsMu.Lock()
+	session, exists := getUploadSession(uploadID)
+	var status UploadStatus
+	if exists {
+		status = session.status()
+	}
+	uploadSessionsMu.Unlock()
+
+	if !exists {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// completeUploadHandler handles "POST /upload/{uploadID}/complete". Once every
+// chunk has arrived they're concatenated into a normal upload.
+func completeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	uploadID := mux.Vars(r)["uploadID"]
+
+	// Assembling writes the whole file out again. The slot is taken first so
+	// a busy server leaves the session to be completed later.
+	release, ok := takeUploadSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Take the session out of the map so it can't be completed twice
+	uploadSessionsMu.Lock()
+	session, exists := uploadSessions[uploadID]
+	complete := exists && len(session.Received) == session.Chunks
+	var status UploadStatus
+	if complete {
+		delete(uploadSessions, uploadID)
+	} else if exists {
+		status = session.status()
+	}
+	uploadSessionsMu.Unlock()
+
+	if !exists {
+		http.Error(w, "Unknown upload", http.StatusNotFound)
+		return
+	}
+	if !complete {
+		// Tell the client which chunks are still missing
+		writeJSON(w, http.StatusConflict, status)
+		return
+	}
+	defer os.RemoveAll(chunksDir(uploadID))
+
+	var chunkFiles []*os.File
+	var total int64
+	for index := 0; index < session.Chunks; index++ {
+		chunk, err := os.Open(filepath.Join(chunksDir(uploadID), strconv.Itoa(index)))
+		var stat os.FileInfo
+		if err == nil {
+			defer chunk.Close()
+			stat, err = chunk.Stat()
+		}
+		if err != nil {
+			logErrorf("Error opening chunk %d of upload %s: %v", index, uploadID, err)
+			http.Error(w, "Cannot assemble upload", http.StatusInternalServerError)
+			return
+		}
+		total += stat.Size()
+		chunkFiles = append(chunkFiles, chunk)
+	}
+	if total > cfg.MaxUploadBytes {
+		logWarnf("Rejected chunked upload %s: %s assembled", uploadID, humanBytes(total))
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	assembled := func() io.Reader {
+		readers := make([]io.Reader, len(chunkFiles))
+		for i, chunk := range chunkFiles {
+			chunk.Seek(0, io.SeekStart)
+			readers[i] = chunk
+		}
+		return io.MultiReader(readers...)
+	}
+
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(assembled(), head)
+	if err := checkUploadType(session.Filename, head[:n]); err != nil {
+		logWarnf("Rejected chunked upload %s: %v", uploadID, err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fileID := newFileID(session.Filename)
+	fi := FileInfo{
+		ID:               fileID,
+		Name:             session.Filename,
+		StoredName:       newStoredName(fileID, time.Now()),
+		BurnAfterReading: session.BurnAfterReading,
+		Owner:            session.Owner,
+		ExpiresAt:        expiryTime(session.Expiry),
+		PasswordHash:     session.PasswordHash,
+	}
+	existingID, err := storeUpload(&fi, assembled())
+	if err != nil {
+		logErrorf("Error assembling upload %s: %v", uploadID, err)
+		http.Error(w, "Cannot assemble upload", http.StatusInternalServerError)
+		return
+	}
+	if existingID != "" {
+		fileID = existingID
+	} else {
+		addFile(fi)
+		notifyFileWebhook(r, fi)
+	}
+
+	logDebugf("Completed chunked upload %s as %s", uploadID, fileID)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"id":  fileID,
+		"url": sitePath("/file/" + fileID),
+	})
+}
+
+// cleanupUploadSessions removes sessions idle for longer than timeout, along
+// with their chunks.
+func cleanupUploadSessions(timeout time.Duration) {
+	cutoff := time.Now().Add(-timeout)
+
+	uploadSessionsMu.Lock()
+	var expired []string
+	for id, session := range uploadSessions {
+		if session.LastActivity.Before(cutoff) {
+			expired = append(expired, id)
+			delete(uploadSessions, id)
+		}
+	}
+	uploadSessionsMu.Unlock()
+
+	for _, id := range expired {
+		logInfof("Removing abandoned chunked upload %s", id)
+		os.RemoveAll(chunksDir(id))
+	}
+}
+
+// startUploadSessionCleanup periodically cleans up abandoned uploads.
+func startUploadSessionCleanup() {
+	go func() {
+		for range time.Tick(uploadSessionTimeout / 4) {
+			cleanupUploadSessions(uploadSessionTimeout)
+		}
+	}()
+}
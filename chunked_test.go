@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// setupChunkedTest points uploads at a temp dir and resets upload state
+func setupChunkedTest(t *testing.T) {
+	t.Helper()
+
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	originalSessions := uploadSessions
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+		uploadSessions = originalSessions
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	uploadSessions = make(map[string]*uploadSession)
+	os.MkdirAll(uploadsDir, 0755)
+}
+
+// startChunkedUpload calls the init handler and returns the upload ID
+func startChunkedUpload(t *testing.T, filename string, chunks int) string {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/upload/init?filename="+filename+"&chunks="+strconv.Itoa(chunks), nil)
+	w := httptest.NewRecorder()
+	initUploadHandler(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("initUploadHandler() status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	var status UploadStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse init response: %v", err)
+	}
+	return status.UploadID
+}
+
+// putChunk sends one chunk and returns the response
+func putChunk(uploadID string, index int, data string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PUT", "/upload/"+uploadID+"/"+strconv.Itoa(index), strings.NewReader(data))
+	req = mux.SetURLVars(req, map[string]string{"uploadID": uploadID, "chunkIndex": strconv.Itoa(index)})
+	w := httptest.NewRecorder()
+	uploadChunkHandler(w, req)
+	return w
+}
+
+// completeChunkedUpload calls the complete handler
+func completeChunkedUpload(uploadID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/upload/"+uploadID+"/complete", nil)
+	req = mux.SetURLVars(req, map[string]string{"uploadID": uploadID})
+	w := httptest.NewRecorder()
+	completeUploadHandler(w, req)
+	return w
+}
+
+// Test a 3-chunk upload, sent out of order, assembles correctly
+func TestChunkedUpload(t *testing.T) {
+	setupChunkedTest(t)
+
+	uploadID := startChunkedUpload(t, "big.txt", 3)
+
+	chunks := []string{"first-", "second-", "third"}
+	for _, index := range []int{2, 0, 1} {
+		if w := putChunk(uploadID, index, chunks[index]); w.Code != http.StatusOK {
+			t.Fatalf("uploadChunkHandler(%d) status = %d, want %d", index, w.Code, http.StatusOK)
+		}
+	}
+
+	w := completeChunkedUpload(uploadID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("completeUploadHandler() status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var result map[string]string
+	json.Unmarshal(w.Body.Bytes(), &result)
+	fileID := result["id"]
+
+	fi, exists := files[fileID]
+	if !exists {
+		t.Fatalf("Assembled file %q not registered in files map", fileID)
+	}
+	if fi.Name != "big.txt" {
+		t.Errorf("File name = %q, want big.txt", fi.Name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(uploadsDir, fileID))
+	if err != nil {
+		t.Fatalf("Failed to read assembled file: %v", err)
+	}
+	if string(data) != "first-second-third" {
+		t.Errorf("Assembled content = %q, want %q", data, "first-second-third")
+	}
+
+	if _, err := os.Stat(chunksDir(uploadID)); !os.IsNotExist(err) {
+		t.Error("Chunk directory should be removed after completion")
+	}
+	if _, exists := uploadSessions[uploadID]; exists {
+		t.Error("Upload session should be removed after completion")
+	}
+}
+
+// Test completing with a missing chunk is refused and reports what arrived
+func TestChunkedUpload_MissingChunk(t *testing.T) {
+	setupChunkedTest(t)
+
+	uploadID := startChunkedUpload(t, "big.txt", 3)
+	putChunk(uploadID, 0, "first-")
+	putChunk(uploadID, 2, "third")
+
+	w := completeChunkedUpload(uploadID)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("completeUploadHandler() status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	var status UploadStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if len(status.Received) != 2 || status.Received[0] != 0 || status.Received[1] != 2 {
+		t.Errorf("Received = %v, want [0 2]", status.Received)
+	}
+	if len(files) != 0 {
+		t.Errorf("Incomplete upload registered %d files, want 0", len(files))
+	}
+
+	// Resume by sending the missing chunk
+	putChunk(uploadID, 1, "second-")
+	if w := completeChunkedUpload(uploadID); w.Code != http.StatusOK {
+		t.Errorf("completeUploadHandler() after resume status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// Test max_upload_bytes holds for chunked uploads: against the declared
+// size, the chunks sent so far, and the file they assemble into
+func TestChunkedUpload_SizeLimit(t *testing.T) {
+	setupChunkedTest(t)
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	cfg.MaxUploadBytes = 10
+
+	req := httptest.NewRequest("POST", "/upload/init?filename=big.txt&chunks=2&size=11", nil)
+	w := httptest.NewRecorder()
+	initUploadHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Init declaring 11 bytes status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	uploadID := startChunkedUpload(t, "big.txt", 2)
+	if w := putChunk(uploadID, 0, "123456"); w.Code != http.StatusOK {
+		t.Fatalf("First chunk status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := putChunk(uploadID, 1, "12345"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Chunk past the limit status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	// Replacing a chunk only counts the new one
+	if w := putChunk(uploadID, 0, "1234"); w.Code != http.StatusOK {
+		t.Errorf("Replaced chunk status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := putChunk(uploadID, 1, "123456"); w.Code != http.StatusOK {
+		t.Errorf("Chunk up to the limit status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The limit is checked again on the file as assembled
+	cfg.MaxUploadBytes = 9
+	if w := completeChunkedUpload(uploadID); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("completeUploadHandler() status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(files) != 0 {
+		t.Errorf("Oversized upload registered %d files, want 0", len(files))
+	}
+}
+
+// Test chunk requests with bad IDs or indexes
+func TestUploadChunkHandler_Invalid(t *testing.T) {
+	setupChunkedTest(t)
+
+	uploadID := startChunkedUpload(t, "big.txt", 2)
+
+	if w := putChunk("nonexistent", 0, "x"); w.Code != http.StatusNotFound {
+		t.Errorf("Unknown upload status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := putChunk(uploadID, 2, "x"); w.Code != http.StatusBadRequest {
+		t.Errorf("Out of range chunk status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// Test abandoned upload sessions get cleaned up
+func TestCleanupUploadSessions(t *testing.T) {
+	setupChunkedTest(t)
+
+	staleID := startChunkedUpload(t, "stale.txt", 2)
+	putChunk(staleID, 0, "x")
+	freshID := startChunkedUpload(t, "fresh.txt", 2)
+
+	uploadSessions[staleID].LastActivity = time.Now().Add(-2 * uploadSessionTimeout)
+
+	cleanupUploadSessions(uploadSessionTimeout)
+
+	if _, exists := uploadSessions[staleID]; exists {
+		t.Error("Stale upload session should have been removed")
+	}
+	if _, err := os.Stat(chunksDir(staleID)); !os.IsNotExist(err) {
+		t.Error("Stale upload chunks should have been removed")
+	}
+	if _, exists := uploadSessions[freshID]; !exists {
+		t.Error("Fresh upload session should have been kept")
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP returns the address of the client behind a request, for logs and
+// abuse handling. With cfg.TrustProxy set it believes the X-Forwarded-For
+// (or X-Real-IP) header a reverse proxy adds; otherwise those headers are
+// ignored, since any client can send them.
+func clientIP(r *http.Request) string {
+	if cfg.TrustProxy {
+		if ip := forwardedIP(r.Header.Get("X-Forwarded-For")); ip != "" {
+			return ip
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedIP picks the client out of an X-Forwarded-For list: the left-most
+// address that isn't one of our own proxies (loopback or private), or the
+// left-most address if they all are. Entries that aren't IPs are skipped.
+func forwardedIP(header string) string {
+	first := ""
+	for _, entry := range strings.Split(header, ",") {
+		ip := net.ParseIP(strings.TrimSpace(entry))
+		if ip == nil {
+			continue
+		}
+		if !trustedProxyIP(ip) {
+			return ip.String()
+		}
+		if first == "" {
+			first = ip.String()
+		}
+	}
+	return first
+}
+
+// trustedProxyIP reports whether ip looks like one of our proxies rather
+// than a client out on the internet.
+func trustedProxyIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+// Test the client address is only taken from proxy headers when configured
+func TestClientIP(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	tests := []struct {
+		name       string
+		trustProxy bool
+		remoteAddr string
+		xff        string
+		realIP     string
+		want       string
+	}{
+		{"direct connection", false, "203.0.113.7:51234", "", "", "203.0.113.7"},
+		{"direct connection over IPv6", false, "[2001:db8::1]:51234", "", "", "2001:db8::1"},
+		{"spoofed XFF ignored", false, "203.0.113.7:51234", "198.51.100.1", "", "203.0.113.7"},
+		{"spoofed X-Real-IP ignored", false, "203.0.113.7:51234", "", "198.51.100.1", "203.0.113.7"},
+		{"one proxy hop", true, "10.0.0.2:8080", "198.51.100.1", "", "198.51.100.1"},
+		{"internal proxies skipped", true, "127.0.0.1:8080", "10.1.2.3, 198.51.100.1, 10.0.0.5", "", "198.51.100.1"},
+		{"all internal", true, "127.0.0.1:8080", "192.168.1.20, 10.0.0.5", "", "192.168.1.20"},
+		{"garbage entries skipped", true, "127.0.0.1:8080", "unknown, 198.51.100.1", "", "198.51.100.1"},
+		{"X-Real-IP", true, "127.0.0.1:8080", "", "198.51.100.1", "198.51.100.1"},
+		{"no headers behind proxy", true, "10.0.0.2:8080", "", "", "10.0.0.2"},
+		{"bad headers fall back", true, "10.0.0.2:8080", "nope", "nope", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.TrustProxy = tt.trustProxy
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
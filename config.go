@@ -0,0 +1,588 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Config holds the optional settings read from config.json. Anything left
+// out of the file keeps its value from DefaultConfig.
+type Config struct {
+	// MaxSnippets caps how many snippets are kept; the oldest are evicted
+	// to make room. Zero means no limit.
+	MaxSnippets int `json:"max_snippets"`
+
+	// SnippetSoftLimit is the snippet count past which every save logs a
+	// warning and /healthz reports degraded. At SnippetHardLimit new pastes
+	// get a 503. Zero means no limit.
+	SnippetSoftLimit int `json:"snippet_soft_limit"`
+	SnippetHardLimit int `json:"snippet_hard_limit"`
+
+	// IndexSnippetCount is how many of the newest snippets the index lists.
+	// Zero lists them all. The API pages through every snippet regardless.
+	IndexSnippetCount int `json:"index_snippet_count"`
+
+	// MaxIndexFiles caps how many of the newest files the index lists. Zero
+	// lists them all. The API pages through every file regardless.
+	MaxIndexFiles int `json:"max_index_files"`
+
+	// MaxSnippetBytes caps the size of a snippet's text, whether it's
+	// pasted, posted raw or appended to.
+	MaxSnippetBytes int `json:"max_snippet_bytes"`
+
+	// MaxUploadBytes caps the size of a file uploaded through the form,
+	// fetched with /upload-url or assembled from a chunked upload.
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+
+	// ShardUploads stores new uploads in uploads/YYYY/MM/DD/ rather than all
+	// in uploads/ itself. Files already stored either way keep working.
+	ShardUploads bool `json:"shard_uploads"`
+
+	// MaxConcurrentUploads caps how many uploads, fetches and chunked ones
+	// included, are written to disk at once; more wait briefly for a slot,
+	// then get a 503. 0 means no limit.
+	MaxConcurrentUploads int `json:"max_concurrent_uploads"`
+
+	// SSLEnabled serves HTTPS using CertFile and KeyFile.
+	SSLEnabled bool   `json:"ssl_enabled"`
+	CertFile   string `json:"cert_file"`
+	KeyFile    string `json:"key_file"`
+
+	// RedirectHTTP also listens for plain HTTP on RedirectHTTPPort and
+	// sends everything there to the HTTPS site. Needs SSLEnabled.
+	RedirectHTTP     bool `json:"redirect_http"`
+	RedirectHTTPPort int  `json:"redirect_http_port"`
+
+	// MinTLSVersion is the oldest TLS version HTTPS accepts: "1.0", "1.1",
+	// "1.2" or "1.3". CipherSuites limits TLS 1.2 and older to the named
+	// suites, e.g. "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"; empty leaves
+	// Go's defaults. TLS 1.3 suites can't be configured.
+	MinTLSVersion string   `json:"min_tls_version"`
+	CipherSuites  []string `json:"cipher_suites"`
+
+	// AuthEnabled requires clients to present a certificate signed by
+	// CACertFile whose CN matches Username, or one of AllowedCNs (mTLS).
+	// Needs SSLEnabled.
+	AuthEnabled bool   `json:"auth_enabled"`
+	CACertFile  string `json:"ca_cert_file"`
+	Username    string `json:"username"`
+
+	// CACertFiles trusts several CAs at once, e.g. during a CA rotation.
+	// Entries can be PEM files or directories of them. When set it's used
+	// instead of CACertFile.
+	CACertFiles []string `json:"ca_cert_files"`
+
+	// AllowedCNs lets several people connect. A cert is accepted if its CN,
+	// or one of its DNS or email SANs, is listed. When set it's used instead
+	// of Username.
+	AllowedCNs []string `json:"allowed_cns"`
+
+	// IdleExpiry removes snippets nobody has viewed in this many days,
+	// counting from when they were created if they never have been.
+	// Immutable snippets are kept. Zero keeps idle snippets.
+	IdleExpiry int `json:"idle_expiry"`
+
+	// CompressSnippetsOver keeps snippet bodies bigger than this many bytes
+	// gzipped in memory. Zero keeps them all as plain text.
+	// CompressSnippetsOnDisk writes them to snippets.json compressed too;
+	// it's ignored with EncryptionKey set.
+	CompressSnippetsOver   int  `json:"compress_snippets_over"`
+	CompressSnippetsOnDisk bool `json:"compress_snippets_on_disk"`
+
+	// FilePreviewBytes is how much of a text file its page shows. Zero
+	// turns previews off.
+	FilePreviewBytes int `json:"file_preview_bytes"`
+
+	// InlineImageMaxBytes is the largest image a file page embeds as a data
+	// URI instead of loading it from /stream. Zero turns it off.
+	InlineImageMaxBytes int64 `json:"inline_image_max_bytes"`
+
+	// RequireAuthToView makes viewing snippets and files, not just the API,
+	// need credentials: a client certificate under AuthEnabled, or a bearer
+	// token from APITokens.
+	RequireAuthToView bool `json:"require_auth_to_view"`
+
+	// SaveInterval batches snippet saves: new snippets and view counts are
+	// written to snippets.json at most this often, in seconds. Deletions
+	// and burns are always written straight away. Zero writes on every
+	// change.
+	SaveInterval int `json:"save_interval"`
+
+	// AdminCNs can see everyone's snippets and files on the index when auth
+	// is enabled; everybody else only sees their own.
+	AdminCNs []string `json:"admin_cns"`
+
+	// SiteTitle and SiteTagline brand the page headers and titles.
+	SiteTitle   string `json:"site_title"`
+	SiteTagline string `json:"site_tagline"`
+
+	// LogoURL is an image shown beside each page's heading, and
+	// CustomCSSPath a stylesheet in StaticDir loaded after the built-in
+	// styles, e.g. "custom.css". Both are left out when empty.
+	LogoURL       string `json:"logo_url"`
+	CustomCSSPath string `json:"custom_css_path"`
+
+	// ReadOnly rejects anything that would create or delete content, leaving
+	// existing snippets and files viewable.
+	ReadOnly bool `json:"read_only"`
+
+	// TrustProxy takes the client address from X-Forwarded-For or X-Real-IP,
+	// and whether the client used https from X-Forwarded-Proto. Only turn it
+	// on behind a reverse proxy that sets them, or clients can claim any
+	// address they like.
+	TrustProxy bool `json:"trust_proxy"`
+
+	// AllowedOrigins are the other origins whose pages may call /api/ from
+	// the browser, e.g. "https://app.example.com". "*" allows any origin.
+	// CORSCredentials lets those calls carry cookies and client certs; it
+	// can't be combined with "*".
+	AllowedOrigins  []string `json:"allowed_origins"`
+	CORSCredentials bool     `json:"cors_credentials"`
+
+	// DataDir holds snippets.json, files.json, the uploads and everything
+	// else that's kept, and is created at startup. The -datadir, -snippets
+	// and -uploads flags win over it.
+	DataDir string `json:"data_dir"`
+
+	// StaticDir holds the favicon and anything served under /static/.
+	StaticDir string `json:"static_dir"`
+
+	// TemplateDir holds the page templates.
+	TemplateDir string `json:"template_dir"`
+
+	// DevMode parses templates again on every request, so template edits
+	// show up without a restart. Leave it off in production.
+	DevMode bool `json:"dev_mode"`
+
+	// Server timeouts in seconds, zero meaning no timeout. They guard against
+	// clients that hold connections open without doing anything. Uploads,
+	// downloads and streams lift the read/write deadlines for their own
+	// requests (see longTransfer), so WriteTimeout doesn't cut off a movie
+	// halfway through.
+	ReadTimeout       int `json:"read_timeout"`
+	ReadHeaderTimeout int `json:"read_header_timeout"`
+	WriteTimeout      int `json:"write_timeout"`
+	IdleTimeout       int `json:"idle_timeout"`
+
+	// EncryptionKey is a base64 AES-256 key (32 bytes). When set, snippet
+	// bodies are encrypted in snippets.json.
+	EncryptionKey string `json:"encryption_key"`
+
+	// AdminToken unlocks the /admin/ endpoints, /export and /import when
+	// sent in the X-Admin-Token header. Without it they're only open to
+	// AdminCNs under mTLS, or not at all.
+	AdminToken string `json:"admin_token"`
+
+	// APITokens lock /api/ down to requests with an "Authorization: Bearer"
+	// header carrying one of these tokens. Only each token's SHA-256 is kept
+	// here. Empty leaves /api/ open.
+	APITokens []APIToken `json:"api_tokens"`
+
+	// InviteCodes, when set, are needed to paste or upload by any route,
+	// forms, raw pastes, the API, URL fetches and chunked uploads alike: the
+	// X-Invite-Code header or "invite" field must match one of them. Empty
+	// leaves them all open.
+	InviteCodes []string `json:"invite_codes"`
+
+	// BasePath serves the app under a path prefix such as "/pasty", for
+	// running behind a reverse proxy. Empty means the root.
+	BasePath string `json:"base_path"`
+
+	// DomainName is the host, e.g. "paste.example.com", that absolute links
+	// such as QR codes and API responses point at, for when the Host header
+	// a proxy passes on isn't the public one. Empty uses the request's host.
+	DomainName string `json:"domain_name"`
+
+	// Dedup points an upload at an identical file the same owner already
+	// uploaded instead of storing a second copy.
+	Dedup bool `json:"dedup"`
+
+	// AllowPrivateFetch lets /upload-url fetch from loopback, private and
+	// link-local addresses. Off by default so the server can't be used to
+	// reach things on its own network.
+	AllowPrivateFetch bool `json:"allow_private_fetch"`
+
+	// PrecompressUploads keeps a gzipped copy of text-like uploads, served
+	// to clients that accept gzip.
+	PrecompressUploads bool `json:"precompress_uploads"`
+
+	// OptimizeImages re-encodes JPEG, PNG, BMP, TIFF and WebP uploads as
+	// OptimizeImageFormat ("jpeg" or "png"), with JPEGs at
+	// OptimizeImageQuality (1-100). When the copy comes out smaller it's
+	// served in place of the original, which ?original=1 still gets.
+	OptimizeImages       bool   `json:"optimize_images"`
+	OptimizeImageFormat  string `json:"optimize_image_format"`
+	OptimizeImageQuality int    `json:"optimize_image_quality"`
+
+	// NormalizeLineEndings turns "\r\n" and lone "\r" into "\n" in text
+	// pasted through the form. Off by default, so nothing pasted is changed.
+	NormalizeLineEndings bool `json:"normalize_line_endings"`
+
+	// EnableRawPaste lets "POST /" take a raw body, as sent by curl with a
+	// non-form Content-Type, as a paste. Form posts there always go to the
+	// form handler.
+	EnableRawPaste bool `json:"enable_raw_paste"`
+
+	// KeepHistory is how many earlier versions of a snippet are kept when
+	// it's appended to, listed at /history/{url}. Zero keeps none.
+	KeepHistory int `json:"keep_history"`
+
+	// LogLevel is the least severe log line written: "debug", "info", "warn"
+	// or "error". Routine saves and downloads are debug.
+	LogLevel string `json:"log_level"`
+
+	// OrphanPolicy says what happens to files in the uploads directory that
+	// files.json doesn't know about: "adopt" adds them to the file list,
+	// "quarantine" moves them to the quarantine directory next to uploads,
+	// and "ignore" leaves them be. Checked at startup and, if
+	// OrphanSweepInterval is set, every that many seconds.
+	OrphanPolicy        string `json:"orphan_policy"`
+	OrphanSweepInterval int    `json:"orphan_sweep_interval"`
+
+	// AllowedExtensions limits uploads to these file types, e.g.
+	// [".png", ".pdf", ".txt"]. Empty allows anything.
+	AllowedExtensions []string `json:"allowed_extensions"`
+
+	// IDLength is how many characters generated snippet IDs have.
+	IDLength int `json:"id_length"`
+
+	// IDAlphabet is the characters generated snippet IDs are made of: letters,
+	// digits, "-" and "_", or the name of one of idAlphabetPresets.
+	IDAlphabet string `json:"id_alphabet"`
+
+	// WebhookURL gets a JSON POST whenever a snippet is saved or a file is
+	// uploaded.
+	WebhookURL string `json:"webhook_url"`
+
+	// BurnConfirm shows burn-after-reading snippets behind a "click to
+	// reveal" page, so link previews don't burn them before anyone reads
+	// them. Turn it off to burn on the first view.
+	BurnConfirm bool `json:"burn_confirm"`
+
+	// DefaultBurn is whether new pastes burn after reading when the form
+	// doesn't say. With AllowBurnToggle off every paste gets DefaultBurn and
+	// the checkbox is hidden.
+	DefaultBurn     bool `json:"default_burn"`
+	AllowBurnToggle bool `json:"allow_burn_toggle"`
+
+	// MaxUnlockAttempts is how many wrong passwords a password-protected
+	// paste takes; the next one destroys it. 0 means never.
+	MaxUnlockAttempts int `json:"max_unlock_attempts"`
+
+	// UnlockAttemptsPerMinute caps the password attempts, right or wrong,
+	// each IP can make on protected files and pastes. 0 means no limit.
+	UnlockAttemptsPerMinute int `json:"unlock_attempts_per_minute"`
+}
+
+// APIToken is one of the bearer tokens allowed to use the API. Name says
+// whose it is in the logs.
+type APIToken struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"` // hex SHA-256 of the token
+}
+
+// caCertFiles returns the CA certs to trust for mTLS: CACertFiles if set,
+// otherwise CACertFile on its own.
+func (c Config) caCertFiles() []string {
+	if len(c.CACertFiles) > 0 {
+		return c.CACertFiles
+	}
+	if c.CACertFile != "" {
+		return []string{c.CACertFile}
+	}
+	return nil
+}
+
+// allowedCNs returns who may connect under mTLS: AllowedCNs if set,
+// otherwise just Username.
+func (c Config) allowedCNs() []string {
+	if len(c.AllowedCNs) > 0 {
+		return c.AllowedCNs
+	}
+	if c.Username != "" {
+		return []string{c.Username}
+	}
+	return nil
+}
+
+// Global config used by the handlers
+var cfg = DefaultConfig()
+
+// DefaultConfig returns the settings used when no config file is present.
+func DefaultConfig() Config {
+	return Config{
+		MaxSnippets:       0,
+		IndexSnippetCount: 10,
+		MaxSnippetBytes:   10 << 20,
+		MaxUploadBytes:    1 << 30,
+		CertFile:          "cert.pem",
+		KeyFile:           "key.pem",
+		MinTLSVersion:     "1.2",
+		RedirectHTTPPort:  8080,
+		CACertFile:        "ca_cert.pem",
+		SiteTitle:         "pasty",
+		StaticDir:         "static",
+		TemplateDir:       "templates",
+		Dedup:             true,
+		OrphanPolicy:      orphanIgnore,
+		LogLevel:          "info",
+		IDLength:          8,
+		IDAlphabet:        snippetChars,
+		BurnConfirm:       true,
+		AllowBurnToggle:   true,
+		MaxUnlockAttempts: 10,
+		FilePreviewBytes:  64 << 10,
+		SaveInterval:      2,
+
+		OptimizeImageFormat:  "jpeg",
+		OptimizeImageQuality: 85,
+
+		UnlockAttemptsPerMinute: 10,
+
+		ReadTimeout:       60,
+		ReadHeaderTimeout: 10,
+		WriteTimeout:      60,
+		IdleTimeout:       120,
+	}
+}
+
+// LoadConfig reads a JSON config file on top of the defaults. A missing file
+// is not an error; the defaults are returned as-is. Unknown keys are rejected
+// so typos don't go unnoticed.
+func LoadConfig(filename string) (Config, error) {
+	config := DefaultConfig()
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// checkConfigFile is -validate: it loads and validates filename, printing
+// "config OK" and the effective config, secrets redacted, to stdout or the
+// problem to stderr, and returns the exit code. Unlike LoadConfig, a missing
+// file is a problem here, since it's the file that's being checked.
+func checkConfigFile(filename string, stdout, stderr io.Writer) int {
+	if _, err := os.Stat(filename); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+		return 1
+	}
+	config, err := LoadConfig(filename)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", filename, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "config OK\n%s\n", config)
+	return 0
+}
+
+// idAlphabetPresets are names id_alphabet can be set to instead of listing
+// the characters. "unambiguous" leaves out 0, O, o, 1, I and l, which are
+// easy to misread when an ID is copied by hand.
+var idAlphabetPresets = map[string]string{
+	"base62":      snippetChars,
+	"unambiguous": "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789",
+}
+
+// idAlphabet returns the characters snippet IDs are generated from, with a
+// preset name resolved.
+func (c Config) idAlphabet() string {
+	if preset, ok := idAlphabetPresets[c.IDAlphabet]; ok {
+		return preset
+	}
+	return c.IDAlphabet
+}
+
+// validIDAlphabet checks an ID alphabet has at least two characters, each
+// safe in a URL path and listed once.
+func validIDAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return errors.New("id_alphabet needs at least 2 characters")
+	}
+	seen := make(map[rune]bool)
+	for _, c := range alphabet {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("id_alphabet can only use letters, digits, \"-\" and \"_\", not %q", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("id_alphabet lists %q more than once", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// redacted replaces secret settings in logs and status output
+const redacted = "***"
+
+// String returns the config as one line of JSON with secrets (the
+// encryption key, admin token, invite codes and webhook URL, which usually
+// embeds a token) replaced by "***", so it's safe to log.
+func (c Config) String() string {
+	if c.EncryptionKey != "" {
+		c.EncryptionKey = redacted
+	}
+	if c.AdminToken != "" {
+		c.AdminToken = redacted
+	}
+	if c.WebhookURL != "" {
+		c.WebhookURL = redacted
+	}
+	if len(c.InviteCodes) > 0 {
+		c.InviteCodes = []string{redacted}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Sprintf("<config: %v>", err)
+	}
+	return string(data)
+}
+
+// Validate checks for settings that can't work together.
+func (c Config) Validate() error {
+	if c.MaxSnippets < 0 {
+		return errors.New("max_snippets cannot be negative")
+	}
+	if c.IndexSnippetCount < 0 {
+		return errors.New("index_snippet_count cannot be negative")
+	}
+	if c.MaxIndexFiles < 0 {
+		return errors.New("max_index_files cannot be negative")
+	}
+	if c.MaxSnippetBytes < 1 {
+		return errors.New("max_snippet_bytes must be at least 1")
+	}
+	if c.MaxUploadBytes < 1 {
+		return errors.New("max_upload_bytes must be at least 1")
+	}
+	if _, ok := optimizeFormats[c.OptimizeImageFormat]; !ok {
+		return fmt.Errorf(`optimize_image_format must be "jpeg" or "png", not %q`, c.OptimizeImageFormat)
+	}
+	if c.OptimizeImageQuality < 1 || c.OptimizeImageQuality > 100 {
+		return errors.New("optimize_image_quality must be between 1 and 100")
+	}
+	if c.MaxConcurrentUploads < 0 {
+		return errors.New("max_concurrent_uploads cannot be negative")
+	}
+	if c.SnippetSoftLimit < 0 || c.SnippetHardLimit < 0 {
+		return errors.New("snippet_soft_limit and snippet_hard_limit cannot be negative")
+	}
+	if c.SnippetSoftLimit > 0 && c.SnippetHardLimit > 0 && c.SnippetHardLimit < c.SnippetSoftLimit {
+		return errors.New("snippet_hard_limit cannot be below snippet_soft_limit")
+	}
+	if c.MaxUnlockAttempts < 0 {
+		return errors.New("max_unlock_attempts cannot be negative")
+	}
+	if c.UnlockAttemptsPerMinute < 0 {
+		return errors.New("unlock_attempts_per_minute cannot be negative")
+	}
+	if c.IDLength < 4 {
+		return errors.New("id_length must be at least 4")
+	}
+	if err := validIDAlphabet(c.idAlphabet()); err != nil {
+		return err
+	}
+	if c.ReadTimeout < 0 || c.ReadHeaderTimeout < 0 || c.WriteTimeout < 0 || c.IdleTimeout < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+	if c.IdleExpiry < 0 {
+		return errors.New("idle_expiry cannot be negative")
+	}
+	if c.CompressSnippetsOver < 0 {
+		return errors.New("compress_snippets_over cannot be negative")
+	}
+	if c.FilePreviewBytes < 0 {
+		return errors.New("file_preview_bytes cannot be negative")
+	}
+	if c.InlineImageMaxBytes < 0 {
+		return errors.New("inline_image_max_bytes cannot be negative")
+	}
+	if c.RequireAuthToView && !c.AuthEnabled && len(c.APITokens) == 0 && c.AdminToken == "" {
+		return errors.New("require_auth_to_view needs auth_enabled, api_tokens or admin_token")
+	}
+	if c.SaveInterval < 0 {
+		return errors.New("save_interval cannot be negative")
+	}
+	if c.KeepHistory < 0 {
+		return errors.New("keep_history cannot be negative")
+	}
+	if !validLogLevel(c.LogLevel) {
+		return errors.New(`log_level must be "debug", "info", "warn" or "error"`)
+	}
+	if !validOrphanPolicy(c.OrphanPolicy) {
+		return errors.New(`orphan_policy must be "adopt", "quarantine" or "ignore"`)
+	}
+	if c.OrphanSweepInterval < 0 {
+		return errors.New("orphan_sweep_interval cannot be negative")
+	}
+	if c.CORSCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		return errors.New(`cors_credentials can't be used with allowed_origins "*"`)
+	}
+	for _, token := range c.APITokens {
+		if sum, err := hex.DecodeString(token.SHA256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("api_tokens entry %q needs a hex sha256 of the token", token.Name)
+		}
+	}
+	if _, err := decodeEncryptionKey(c.EncryptionKey); err != nil {
+		return err
+	}
+	if c.CustomCSSPath != "" && !filepath.IsLocal(c.CustomCSSPath) {
+		return errors.New("custom_css_path must be a relative path inside static_dir")
+	}
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.HasSuffix(c.BasePath, "/")) {
+		return errors.New(`base_path must start with "/" and not end with one, e.g. "/pasty"`)
+	}
+	if strings.ContainsAny(c.DomainName, "/ ") {
+		return errors.New(`domain_name must be a host name like "paste.example.com", without a scheme or path`)
+	}
+	if _, err := tlsVersion(c.MinTLSVersion); err != nil {
+		return err
+	}
+	if _, err := cipherSuiteIDs(c.CipherSuites); err != nil {
+		return err
+	}
+	if c.SSLEnabled && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("ssl_enabled requires cert_file and key_file")
+	}
+	if c.RedirectHTTP && !c.SSLEnabled {
+		return errors.New("redirect_http requires ssl_enabled")
+	}
+	if c.RedirectHTTPPort < 1 || c.RedirectHTTPPort > 65535 {
+		return errors.New("redirect_http_port must be between 1 and 65535")
+	}
+	if c.AuthEnabled {
+		// Client certs are only checked during the TLS handshake
+		if !c.SSLEnabled {
+			return errors.New("auth_enabled requires ssl_enabled")
+		}
+		if len(c.caCertFiles()) == 0 {
+			return errors.New("auth_enabled requires ca_cert_file or ca_cert_files")
+		}
+		if len(c.allowedCNs()) == 0 {
+			return errors.New("auth_enabled requires a username or allowed_cns")
+		}
+	}
+	return nil
+}
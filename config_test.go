@@ -0,0 +1,257 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Test LoadConfig with no config file present
+func TestLoadConfig_NonExistent(t *testing.T) {
+	config, err := LoadConfig(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(config, DefaultConfig()) {
+		t.Errorf("LoadConfig() = %+v, want defaults %+v", config, DefaultConfig())
+	}
+}
+
+// Test LoadConfig overrides defaults with values from the file
+func TestLoadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(filename, []byte(`{"max_snippets": 25}`), 0644)
+
+	config, err := LoadConfig(filename)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v, want nil", err)
+	}
+	if config.MaxSnippets != 25 {
+		t.Errorf("MaxSnippets = %d, want 25", config.MaxSnippets)
+	}
+}
+
+// Test LoadConfig with malformed JSON
+func TestLoadConfig_Invalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(filename, []byte(`{"max_snippets": `), 0644)
+
+	if _, err := LoadConfig(filename); err == nil {
+		t.Error("LoadConfig() error = nil, want error for malformed JSON")
+	}
+}
+
+// Test LoadConfig rejects unknown keys
+func TestLoadConfig_UnknownField(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(filename, []byte(`{"max_snipets": 25}`), 0644)
+
+	if _, err := LoadConfig(filename); err == nil {
+		t.Error("LoadConfig() error = nil, want error for misspelled key")
+	}
+}
+
+// Test -validate passes a good config with the effective settings, secrets
+// redacted, and fails a bad one with the reason
+func TestCheckConfigFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	tests := []struct {
+		name       string
+		contents   string // no file is written when empty
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{"good", `{"max_snippets": 25, "admin_token": "hunter2"}`, 0, []string{"config OK\n", `"max_snippets":25`, redacted}, ""},
+		{"malformed", `{"max_snippets": `, 1, nil, "unexpected EOF"},
+		{"unknown key", `{"max_snipets": 25}`, 1, nil, "unknown field"},
+		{"invalid", `{"max_snippets": -1}`, 1, nil, "max_snippets cannot be negative"},
+		{"missing", "", 1, nil, "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "-")+".json")
+			if tt.contents != "" {
+				os.WriteFile(filename, []byte(tt.contents), 0644)
+			}
+
+			var stdout, stderr strings.Builder
+			if code := checkConfigFile(filename, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("checkConfigFile() = %d, want %d", code, tt.wantCode)
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout = %q, want it to contain %q", stdout.String(), want)
+				}
+			}
+			if strings.Contains(stdout.String(), "hunter2") {
+				t.Error("stdout shows the admin token")
+			}
+			if tt.wantCode != 0 && stdout.Len() != 0 {
+				t.Errorf("stdout = %q for a bad config, want nothing", stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) || (tt.wantStderr != "" && !strings.Contains(stderr.String(), filename)) {
+				t.Errorf("stderr = %q, want the file and %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+// Test Config.Validate catches impossible combinations
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{"defaults", func(c *Config) {}, false},
+		{"ssl only", func(c *Config) { c.SSLEnabled = true }, false},
+		{"ssl with auth", func(c *Config) {
+			c.SSLEnabled = true
+			c.AuthEnabled = true
+			c.Username = "alice"
+		}, false},
+		{"negative max snippets", func(c *Config) { c.MaxSnippets = -1 }, true},
+		{"timeouts disabled", func(c *Config) { c.WriteTimeout = 0 }, false},
+		{"negative timeout", func(c *Config) { c.IdleTimeout = -1 }, true},
+		{"encryption key", func(c *Config) { c.EncryptionKey = testEncryptionKey }, false},
+		{"short encryption key", func(c *Config) { c.EncryptionKey = "c2hvcnQ=" }, true},
+		{"base path", func(c *Config) { c.BasePath = "/pasty" }, false},
+		{"short id length", func(c *Config) { c.IDLength = 3 }, true},
+		{"base path without leading slash", func(c *Config) { c.BasePath = "pasty" }, true},
+		{"base path with trailing slash", func(c *Config) { c.BasePath = "/pasty/" }, true},
+		{"ssl without cert file", func(c *Config) {
+			c.SSLEnabled = true
+			c.CertFile = ""
+		}, true},
+		{"auth without ssl", func(c *Config) {
+			c.AuthEnabled = true
+			c.Username = "alice"
+		}, true},
+		{"auth without username", func(c *Config) {
+			c.SSLEnabled = true
+			c.AuthEnabled = true
+		}, true},
+		{"auth without ca cert", func(c *Config) {
+			c.SSLEnabled = true
+			c.AuthEnabled = true
+			c.Username = "alice"
+			c.CACertFile = ""
+		}, true},
+		{"auth with allowed cns", func(c *Config) {
+			c.SSLEnabled = true
+			c.AuthEnabled = true
+			c.AllowedCNs = []string{"alice", "bob"}
+		}, false},
+		{"auth with ca cert list", func(c *Config) {
+			c.SSLEnabled = true
+			c.AuthEnabled = true
+			c.Username = "alice"
+			c.CACertFile = ""
+			c.CACertFiles = []string{"old_ca.pem", "new_ca.pem"}
+		}, false},
+		{"zero max snippet bytes", func(c *Config) { c.MaxSnippetBytes = 0 }, true},
+		{"zero max upload bytes", func(c *Config) { c.MaxUploadBytes = 0 }, true},
+		{"negative idle expiry", func(c *Config) { c.IdleExpiry = -1 }, true},
+		{"domain with scheme", func(c *Config) { c.DomainName = "https://paste.example.com" }, true},
+		{"negative concurrent uploads", func(c *Config) { c.MaxConcurrentUploads = -1 }, true},
+		{"empty id alphabet", func(c *Config) { c.IDAlphabet = "" }, true},
+		{"one character id alphabet", func(c *Config) { c.IDAlphabet = "a" }, true},
+		{"duplicate in id alphabet", func(c *Config) { c.IDAlphabet = "abca" }, true},
+		{"unsafe id alphabet", func(c *Config) { c.IDAlphabet = "ab/." }, true},
+		{"unambiguous id alphabet", func(c *Config) { c.IDAlphabet = "unambiguous" }, false},
+		{"custom id alphabet", func(c *Config) { c.IDAlphabet = "ABCDEF0123456789" }, false},
+		{"unknown image format", func(c *Config) { c.OptimizeImageFormat = "gif" }, true},
+		{"png image format", func(c *Config) { c.OptimizeImageFormat = "png" }, false},
+		{"zero image quality", func(c *Config) { c.OptimizeImageQuality = 0 }, true},
+		{"image quality over 100", func(c *Config) { c.OptimizeImageQuality = 101 }, true},
+		{"negative max unlock attempts", func(c *Config) { c.MaxUnlockAttempts = -1 }, true},
+		{"unlimited unlock attempts", func(c *Config) { c.MaxUnlockAttempts = 0; c.UnlockAttemptsPerMinute = 0 }, false},
+		{"negative unlock attempts per minute", func(c *Config) { c.UnlockAttemptsPerMinute = -1 }, true},
+		{"snippet limits", func(c *Config) { c.SnippetSoftLimit = 1000; c.SnippetHardLimit = 5000 }, false},
+		{"negative snippet soft limit", func(c *Config) { c.SnippetSoftLimit = -1 }, true},
+		{"hard limit below soft limit", func(c *Config) { c.SnippetSoftLimit = 1000; c.SnippetHardLimit = 500 }, true},
+		{"negative keep history", func(c *Config) { c.KeepHistory = -1 }, true},
+		{"unknown log level", func(c *Config) { c.LogLevel = "verbose" }, true},
+		{"debug log level", func(c *Config) { c.LogLevel = "debug" }, false},
+		{"negative inline image size", func(c *Config) { c.InlineImageMaxBytes = -1 }, true},
+		{"negative compress threshold", func(c *Config) { c.CompressSnippetsOver = -1 }, true},
+		{"require auth to view without auth", func(c *Config) { c.RequireAuthToView = true }, true},
+		{"require auth to view with mtls", func(c *Config) {
+			c.SSLEnabled, c.AuthEnabled, c.Username, c.RequireAuthToView = true, true, "alice", true
+		}, false},
+		{"custom css", func(c *Config) { c.CustomCSSPath = "brand/site.css" }, false},
+		{"custom css outside static dir", func(c *Config) { c.CustomCSSPath = "../secret.css" }, true},
+		{"api token", func(c *Config) { c.APITokens = []APIToken{{Name: "ci", SHA256: strings.Repeat("0f", 32)}} }, false},
+		{"api token not hashed", func(c *Config) { c.APITokens = []APIToken{{Name: "ci", SHA256: "plain-token"}} }, true},
+		{"tls 1.3", func(c *Config) { c.MinTLSVersion = "1.3" }, false},
+		{"redirect http without ssl", func(c *Config) { c.RedirectHTTP = true }, true},
+		{"redirect http", func(c *Config) { c.SSLEnabled, c.RedirectHTTP = true, true }, false},
+		{"bad redirect port", func(c *Config) { c.RedirectHTTPPort = 0 }, true},
+		{"unknown tls version", func(c *Config) { c.MinTLSVersion = "1.4" }, true},
+		{"cipher suites", func(c *Config) { c.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"} }, false},
+		{"insecure cipher suite", func(c *Config) { c.CipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"} }, true},
+		{"negative index snippet count", func(c *Config) { c.IndexSnippetCount = -1 }, true},
+		{"negative max index files", func(c *Config) { c.MaxIndexFiles = -1 }, true},
+		{"orphan quarantine", func(c *Config) { c.OrphanPolicy = orphanQuarantine }, false},
+		{"unknown orphan policy", func(c *Config) { c.OrphanPolicy = "delete" }, true},
+		{"cors credentials", func(c *Config) {
+			c.AllowedOrigins = []string{"https://app.example.com"}
+			c.CORSCredentials = true
+		}, false},
+		{"cors credentials with wildcard", func(c *Config) {
+			c.AllowedOrigins = []string{"*"}
+			c.CORSCredentials = true
+		}, true},
+		{"negative orphan sweep", func(c *Config) { c.OrphanSweepInterval = -1 }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(&config)
+			err := config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test Config.String redacts secrets but keeps everything else
+func TestConfigString(t *testing.T) {
+	config := DefaultConfig()
+	config.EncryptionKey = testEncryptionKey
+	config.AdminToken = "s3cret-admin-token"
+	config.WebhookURL = "https://hooks.example.com/T000/B000/XXXX"
+	config.InviteCodes = []string{"friends-2026"}
+	config.SiteTitle = "Scratchpad"
+
+	got := config.String()
+
+	for _, secret := range []string{testEncryptionKey, "s3cret-admin-token", "hooks.example.com", "friends-2026"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("String() = %s, leaks %q", got, secret)
+		}
+	}
+	if !strings.Contains(got, `"encryption_key":"***"`) || !strings.Contains(got, `"admin_token":"***"`) {
+		t.Errorf("String() = %s, want secrets shown as ***", got)
+	}
+	if !strings.Contains(got, `"site_title":"Scratchpad"`) {
+		t.Errorf("String() = %s, want non-secret settings included", got)
+	}
+	if strings.Contains(got, "\n") {
+		t.Errorf("String() should be a single line, got %s", got)
+	}
+
+	// Unset secrets stay empty so it's clear they're off
+	if got := DefaultConfig().String(); !strings.Contains(got, `"admin_token":""`) {
+		t.Errorf("String() = %s, want empty admin_token", got)
+	}
+	if config.AdminToken != "s3cret-admin-token" {
+		t.Error("String() modified the config")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Snippet bodies can be encrypted at rest with AES-256-GCM. Only the copy in
+// snippets.json is encrypted; the in-memory map always holds plaintext, so
+// the handlers never need to know about it.
+
+// decodeEncryptionKey parses a base64 encryption key. An empty key means
+// encryption is off and returns nil.
+func decodeEncryptionKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("encryption_key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption_key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// newGCM sets up AES-GCM for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptText encrypts plaintext, returning base64 of nonce+ciphertext.
+func encryptText(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptText reverses encryptText.
+func decryptText(key []byte, encoded string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// encryptSnippets returns a copy of snippetsMap with every body encrypted,
+// ready to be written to disk. Compressed bodies are encrypted uncompressed.
+func encryptSnippets(snippetsMap map[string]Snippet, key []byte) (map[string]Snippet, error) {
+	encrypted := make(map[string]Snippet, len(snippetsMap))
+	for id, snippet := range snippetsMap {
+		text, err := encryptText(key, snippet.Body())
+		if err != nil {
+			return nil, fmt.Errorf("encrypting snippet %s: %w", id, err)
+		}
+		snippet.Text, snippet.TextGzip = text, nil
+		snippet.Encrypted = true
+		if snippet.History, err = encryptHistory(key, snippet.History); err != nil {
+			return nil, fmt.Errorf("encrypting history of snippet %s: %w", id, err)
+		}
+		encrypted[id] = snippet
+	}
+	return encrypted, nil
+}
+
+// decryptSnippets decrypts, in place, any snippets loaded from disk with an
+// encrypted body. Plaintext snippets (saved before a key was set) are left
+// alone and get encrypted on the next save.
+func decryptSnippets(snippetsMap map[string]Snippet, key []byte) error {
+	for id, snippet := range snippetsMap {
+		if !snippet.Encrypted {
+			continue
+		}
+		if key == nil {
+			return errors.New("snippets are encrypted but no encryption_key is set")
+		}
+		text, err := decryptText(key, snippet.Text)
+		if err != nil {
+			return fmt.Errorf("decrypting snippet %s: %w", id, err)
+		}
+		snippet.Text = text
+		snippet.Encrypted = false
+		if snippet.History, err = decryptHistory(key, snippet.History); err != nil {
+			return fmt.Errorf("decrypting history of snippet %s: %w", id, err)
+		}
+		snippetsMap[id] = snippet
+	}
+	return nil
+}
+
+// encryptHistory returns a copy of a snippet's history with every version's
+// text encrypted; the snippet's Encrypted flag covers them too.
+func encryptHistory(key []byte, history []SnippetVersion) ([]SnippetVersion, error) {
+	if history == nil {
+		return nil, nil
+	}
+	encrypted := make([]SnippetVersion, len(history))
+	for i, version := range history {
+		text, err := encryptText(key, version.Text)
+		if err != nil {
+			return nil, err
+		}
+		version.Text = text
+		encrypted[i] = version
+	}
+	return encrypted, nil
+}
+
+// decryptHistory is encryptHistory the other way round.
+func decryptHistory(key []byte, history []SnippetVersion) ([]SnippetVersion, error) {
+	if history == nil {
+		return nil, nil
+	}
+	decrypted := make([]SnippetVersion, len(history))
+	for i, version := range history {
+		text, err := decryptText(key, version.Text)
+		if err != nil {
+			return nil, err
+		}
+		version.Text = text
+		decrypted[i] = version
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testEncryptionKey is a valid base64 32-byte key
+var testEncryptionKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+
+// Test decodeEncryptionKey accepts only 32-byte base64 keys
+func TestDecodeEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantNil bool
+		wantErr bool
+	}{
+		{"empty disables encryption", "", true, false},
+		{"valid", testEncryptionKey, false, false},
+		{"too short", base64.StdEncoding.EncodeToString([]byte("short")), true, true},
+		{"not base64", "not base64!!", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := decodeEncryptionKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeEncryptionKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (key == nil) != tt.wantNil {
+				t.Errorf("decodeEncryptionKey() key = %v, wantNil %v", key, tt.wantNil)
+			}
+		})
+	}
+}
+
+// Test snippets are encrypted on disk and decrypted on load
+func TestSaveAndLoadSnippets_Encrypted(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	cfg.EncryptionKey = testEncryptionKey
+	filename := filepath.Join(t.TempDir(), "snippets.json")
+	secret := "hunter2 is my password"
+	snippets = map[string]Snippet{
+		"abc": {Title: "Secret", Text: secret},
+	}
+
+	saveSnippetsToFile(filename)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read saved snippets: %v", err)
+	}
+	if bytes.Contains(data, []byte(secret)) {
+		t.Error("Saved JSON contains the plaintext snippet body")
+	}
+	if snippets["abc"].Text != secret {
+		t.Error("Saving changed the in-memory snippet")
+	}
+
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(filename)
+
+	got, exists := snippets["abc"]
+	if !exists {
+		t.Fatal("Snippet not loaded")
+	}
+	if got.Text != secret {
+		t.Errorf("Loaded text = %q, want %q", got.Text, secret)
+	}
+	if got.Encrypted {
+		t.Error("Loaded snippet still marked encrypted")
+	}
+}
+
+// Test plaintext snippets from before a key was set still load
+func TestDecryptSnippets_Mixed(t *testing.T) {
+	key, _ := decodeEncryptionKey(testEncryptionKey)
+	encrypted, err := encryptSnippets(map[string]Snippet{"new": {Text: "new text"}}, key)
+	if err != nil {
+		t.Fatalf("encryptSnippets() error = %v", err)
+	}
+	loaded := map[string]Snippet{
+		"new": encrypted["new"],
+		"old": {Text: "old text"},
+	}
+
+	if err := decryptSnippets(loaded, key); err != nil {
+		t.Fatalf("decryptSnippets() error = %v", err)
+	}
+	if loaded["new"].Text != "new text" || loaded["old"].Text != "old text" {
+		t.Errorf("decryptSnippets() = %+v", loaded)
+	}
+
+	// Without the key, encrypted snippets can't be read
+	encryptedOnly := map[string]Snippet{"new": encrypted["new"]}
+	if err := decryptSnippets(encryptedOnly, nil); err == nil {
+		t.Error("decryptSnippets() without a key should fail")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// expiryOptions maps the forms' expiry choices to how long a snippet or file
+// is kept. "never" (or nothing) keeps it until it's deleted.
+var expiryOptions = map[string]time.Duration{
+	"never": 0,
+	"1h":    time.Hour,
+	"1d":    24 * time.Hour,
+	"7d":    7 * 24 * time.Hour,
+	"30d":   30 * 24 * time.Hour,
+}
+
+// expirySweepInterval is how often expired snippets and files are cleaned up
+const expirySweepInterval = time.Minute
+
+// parseExpiry turns an expiry choice into a duration; zero means never.
+func parseExpiry(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	ttl, ok := expiryOptions[value]
+	if !ok {
+		return 0, fmt.Errorf("unknown expiry %q", value)
+	}
+	return ttl, nil
+}
+
+// expiryTime returns when something created now with the given TTL expires,
+// or the zero time if it never does.
+func expiryTime(ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// expired reports whether the file's expiry has passed.
+func (fi FileInfo) expired(now time.Time) bool {
+	return !fi.ExpiresAt.IsZero() && !now.Before(fi.ExpiresAt)
+}
+
+// expired reports whether the snippet's expiry has passed.
+func (s Snippet) expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// idle reports whether the snippet has gone cfg.IdleExpiry days without
+// being viewed.
+func (s Snippet) idle(now time.Time) bool {
+	if cfg.IdleExpiry == 0 || s.Immutable {
+		return false
+	}
+	lastActive := s.LastViewed
+	if lastActive.IsZero() {
+		lastActive = s.CreatedAt
+	}
+	return now.Sub(lastActive) >= time.Duration(cfg.IdleExpiry)*24*time.Hour
+}
+
+// sweepExpiredFiles removes expired files from disk and the files map,
+// returning how many were removed.
+func sweepExpiredFiles(now time.Time) int {
+	filesMu.Lock()
+	var expired []FileInfo
+	for id, fi := range files {
+		if fi.expired(now) {
+			expired = append(expired, fi)
+			delete(files, id)
+		}
+	}
+	filesMu.Unlock()
+
+	if len(expired) == 0 {
+		return 0
+	}
+
+	for _, fi := range expired {
+		if err := os.Remove(fi.storedPath()); err != nil && !os.IsNotExist(err) {
+			logErrorf("Error removing expired file %s: %v", fi.ID, err)
+		}
+		removePrecompressed(fi.ID)
+		removeOptimized(fi.ID)
+		logInfof("Removed expired file %s", fi.ID)
+	}
+	saveFilesToFile(filesFile)
+	return len(expired)
+}
+
+// sweepExpiredSnippets removes expired and idle snippets, returning how
+// many were removed.
+func sweepExpiredSnippets(now time.Time) int {
+	snippetsMu.Lock()
+	var expired, idle []string
+	for id, snippet := range snippets {
+		switch {
+		case snippet.expired(now):
+			expired = append(expired, id)
+		case snippet.idle(now):
+			idle = append(idle, id)
+		default:
+			continue
+		}
+		delete(snippets, id)
+	}
+	logSnippetDelete(expired...)
+	logSnippetDelete(idle...)
+	snippetsMu.Unlock()
+
+	if len(expired)+len(idle) == 0 {
+		return 0
+	}
+
+	for _, id := range expired {
+		logInfof("Removed expired snippet %s", id)
+	}
+	for _, id := range idle {
+		logInfof("Removed snippet %s, not viewed in %d days", id, cfg.IdleExpiry)
+	}
+	saveSnippetsToFile(snippetsFile)
+	return len(expired) + len(idle)
+}
+
+// startExpirySweeper periodically removes expired snippets and files.
+func startExpirySweeper() {
+	go func() {
+		for range time.Tick(expirySweepInterval) {
+			now := time.Now()
+			sweepExpiredSnippets(now)
+			sweepExpiredFiles(now)
+		}
+	}()
+}
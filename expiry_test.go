@@ -0,0 +1,387 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// setupExpiryTest points uploads at a temp dir with one expired and one
+// live file
+func setupExpiryTest(t *testing.T) {
+	t.Helper()
+
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(uploadsDir, 0755)
+
+	os.WriteFile(filepath.Join(uploadsDir, "1-old.txt"), []byte("old"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "2-new.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "3-keep.txt"), []byte("keep"), 0644)
+	files = map[string]FileInfo{
+		"1-old.txt":  {ID: "1-old.txt", Name: "old.txt", StoredName: "1-old.txt", ExpiresAt: time.Now().Add(-time.Minute)},
+		"2-new.txt":  {ID: "2-new.txt", Name: "new.txt", StoredName: "2-new.txt", ExpiresAt: time.Now().Add(time.Hour)},
+		"3-keep.txt": {ID: "3-keep.txt", Name: "keep.txt", StoredName: "3-keep.txt"},
+	}
+}
+
+// Test parseExpiry accepts the form's choices only
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"never", 0, false},
+		{"1h", time.Hour, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"5m", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseExpiry(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseExpiry(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// Test the sweep removes expired files from disk and the map
+func TestSweepExpiredFiles(t *testing.T) {
+	setupExpiryTest(t)
+
+	if removed := sweepExpiredFiles(time.Now()); removed != 1 {
+		t.Errorf("sweepExpiredFiles() removed %d, want 1", removed)
+	}
+
+	if _, exists := files["1-old.txt"]; exists {
+		t.Error("Expired file still in files map")
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "1-old.txt")); !os.IsNotExist(err) {
+		t.Error("Expired file still on disk")
+	}
+	for _, id := range []string{"2-new.txt", "3-keep.txt"} {
+		if _, exists := files[id]; !exists {
+			t.Errorf("Unexpired file %s was removed", id)
+		}
+	}
+
+	// The removal is persisted
+	files = make(map[string]FileInfo)
+	loadFilesFromFile(filesFile)
+	if _, exists := files["1-old.txt"]; exists {
+		t.Error("Expired file still in saved metadata")
+	}
+}
+
+// Test an expired file 404s even before the sweep runs
+func TestDownloadFileHandler_Expired(t *testing.T) {
+	setupExpiryTest(t)
+
+	tests := []struct {
+		id   string
+		want int
+	}{
+		{"1-old.txt", http.StatusNotFound},
+		{"2-new.txt", http.StatusOK},
+		{"3-keep.txt", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/download/"+tt.id, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": tt.id})
+		w := httptest.NewRecorder()
+
+		downloadFileHandler(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("downloadFileHandler(%s) status = %d, want %d", tt.id, w.Code, tt.want)
+		}
+	}
+}
+
+// Test the sweep removes expired snippets and expired snippets 404 before it
+// runs
+func TestSnippetExpiry(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	initTestTemplates(t)
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"old":  {Title: "Old", Text: "old", ExpiresAt: time.Now().Add(-time.Minute)},
+		"new":  {Title: "New", Text: "new", ExpiresAt: time.Now().Add(time.Hour)},
+		"keep": {Title: "Keep", Text: "keep"},
+	}
+
+	for _, tt := range []struct {
+		id   string
+		want int
+	}{
+		{"old", http.StatusNotFound},
+		{"new", http.StatusOK},
+		{"keep", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/raw/"+tt.id, nil)
+		req = mux.SetURLVars(req, map[string]string{"url": tt.id})
+		w := httptest.NewRecorder()
+		rawSnippet(w, req)
+		if w.Code != tt.want {
+			t.Errorf("rawSnippet(%s) status = %d, want %d", tt.id, w.Code, tt.want)
+		}
+
+		req = httptest.NewRequest("GET", "/display/"+tt.id, nil)
+		req = mux.SetURLVars(req, map[string]string{"url": tt.id})
+		w = httptest.NewRecorder()
+		displaySnippet(w, req)
+		if w.Code != tt.want {
+			t.Errorf("displaySnippet(%s) status = %d, want %d", tt.id, w.Code, tt.want)
+		}
+	}
+
+	if removed := sweepExpiredSnippets(time.Now()); removed != 1 {
+		t.Errorf("sweepExpiredSnippets() removed %d, want 1", removed)
+	}
+	if _, exists := snippets["old"]; exists {
+		t.Error("Expired snippet still in snippets map")
+	}
+	if len(snippets) != 2 {
+		t.Errorf("Sweep left %d snippets, want 2", len(snippets))
+	}
+}
+
+// Test handleSave sets the snippet's expiry from the form
+func TestHandleSave_Expiry(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+
+	tests := []struct {
+		expires    string
+		wantStatus int
+		wantTTL    time.Duration
+	}{
+		{"", http.StatusSeeOther, 0},
+		{"never", http.StatusSeeOther, 0},
+		{"1h", http.StatusSeeOther, time.Hour},
+		{"1y", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		snippets = make(map[string]Snippet)
+
+		form := url.Values{"title": {"T"}, "text": {"body"}, "expires": {tt.expires}}
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSave(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("handleSave(expires=%q) status = %d, want %d", tt.expires, w.Code, tt.wantStatus)
+			continue
+		}
+		for _, snippet := range snippets {
+			if tt.wantTTL == 0 && !snippet.ExpiresAt.IsZero() {
+				t.Errorf("handleSave(expires=%q) ExpiresAt = %v, want never", tt.expires, snippet.ExpiresAt)
+			}
+			if tt.wantTTL != 0 && time.Until(snippet.ExpiresAt).Round(time.Minute) != tt.wantTTL {
+				t.Errorf("handleSave(expires=%q) ExpiresAt = %v, want about %v from now", tt.expires, snippet.ExpiresAt, tt.wantTTL)
+			}
+		}
+	}
+}
+
+// Test idle_expiry reaps snippets nobody has viewed lately, alongside the
+// absolute expiry
+func TestSweepIdleSnippets(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+	initTestTemplates(t)
+
+	cfg.IdleExpiry = 7
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	now := time.Now()
+	monthAgo := now.Add(-30 * 24 * time.Hour)
+	snippets = map[string]Snippet{
+		"stale":     {Text: "stale", CreatedAt: monthAgo, LastViewed: now.Add(-8 * 24 * time.Hour)},
+		"unviewed":  {Text: "unviewed", CreatedAt: monthAgo},
+		"viewed":    {Text: "viewed", CreatedAt: monthAgo},
+		"fresh":     {Text: "fresh", CreatedAt: now.Add(-time.Hour)},
+		"expired":   {Text: "expired", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+		"immutable": {Text: "immutable", CreatedAt: monthAgo, Immutable: true},
+	}
+
+	// Viewing a snippet keeps it around
+	req := httptest.NewRequest("GET", "/display/viewed", nil)
+	req = mux.SetURLVars(req, map[string]string{"url": "viewed"})
+	displaySnippet(httptest.NewRecorder(), req)
+	if snippets["viewed"].LastViewed.IsZero() {
+		t.Fatal("displaySnippet() didn't set LastViewed")
+	}
+
+	if removed := sweepExpiredSnippets(now); removed != 3 {
+		t.Errorf("sweepExpiredSnippets() removed %d, want 3", removed)
+	}
+	for _, id := range []string{"stale", "unviewed", "expired"} {
+		if _, exists := snippets[id]; exists {
+			t.Errorf("Snippet %s survived the sweep", id)
+		}
+	}
+	for _, id := range []string{"viewed", "fresh", "immutable"} {
+		if _, exists := snippets[id]; !exists {
+			t.Errorf("Snippet %s was swept", id)
+		}
+	}
+
+	// Off by default
+	cfg.IdleExpiry = 0
+	if removed := sweepExpiredSnippets(now.Add(365 * 24 * time.Hour)); removed != 0 {
+		t.Errorf("sweepExpiredSnippets() with idle_expiry 0 removed %d, want 0", removed)
+	}
+}
+
+// setupBurnExpiryTest saves one snippet that both burns after reading and
+// expires at expiresAt, the way handleSave stores it
+func setupBurnExpiryTest(t *testing.T, expiresAt time.Time) string {
+	t.Helper()
+
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+	initTestTemplates(t)
+
+	cfg.BurnConfirm = false
+	cfg.AllowBurnToggle = true
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = make(map[string]Snippet)
+
+	form := url.Values{"title": {"T"}, "text": {"secret"}, "burn": {"true"}, "expires": {"1h"}}
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handleSave(httptest.NewRecorder(), req)
+	if len(snippets) != 1 {
+		t.Fatalf("handleSave() stored %d snippets, want 1", len(snippets))
+	}
+
+	for id, snippet := range snippets {
+		if !snippet.BurnAfterReading || snippet.ExpiresAt.IsZero() {
+			t.Fatalf("Saved snippet burn = %v, expires = %v, want both set", snippet.BurnAfterReading, snippet.ExpiresAt)
+		}
+		snippet.ExpiresAt = expiresAt
+		snippets[id] = snippet
+		return id
+	}
+	return ""
+}
+
+// displayStatus fetches /display/{id} and returns the status
+func displayStatus(id string) int {
+	req := httptest.NewRequest("GET", "/display/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"url": id})
+	w := httptest.NewRecorder()
+	displaySnippet(w, req)
+	return w.Code
+}
+
+// Test a burn snippet with an expiry read before it expires is burned on
+// that read, leaving the sweeper nothing to do
+func TestBurnAndExpiry_ReadFirst(t *testing.T) {
+	id := setupBurnExpiryTest(t, time.Now().Add(time.Hour))
+
+	if code := displayStatus(id); code != http.StatusOK {
+		t.Fatalf("First read status = %d, want %d", code, http.StatusOK)
+	}
+	if _, exists := snippets[id]; exists {
+		t.Error("Snippet not burned after reading")
+	}
+	if code := displayStatus(id); code != http.StatusNotFound {
+		t.Errorf("Second read status = %d, want %d", code, http.StatusNotFound)
+	}
+	if removed := sweepExpiredSnippets(time.Now().Add(2 * time.Hour)); removed != 0 {
+		t.Errorf("sweepExpiredSnippets() removed %d after the burn, want 0", removed)
+	}
+}
+
+// Test a burn snippet nobody reads before it expires is reaped by the
+// sweeper, and can't be read after
+func TestBurnAndExpiry_ExpiresFirst(t *testing.T) {
+	id := setupBurnExpiryTest(t, time.Now().Add(-time.Minute))
+
+	if removed := sweepExpiredSnippets(time.Now()); removed != 1 {
+		t.Errorf("sweepExpiredSnippets() removed %d, want 1", removed)
+	}
+	if _, exists := snippets[id]; exists {
+		t.Error("Expired snippet still in snippets map")
+	}
+	if code := displayStatus(id); code != http.StatusNotFound {
+		t.Errorf("Read after expiry status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+// Test readers racing each other and the sweeper show the snippet at most
+// once
+func TestBurnAndExpiry_Race(t *testing.T) {
+	id := setupBurnExpiryTest(t, time.Now().Add(time.Hour))
+
+	var shown atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if displayStatus(id) == http.StatusOK {
+				shown.Add(1)
+			}
+		})
+	}
+	wg.Go(func() { sweepExpiredSnippets(time.Now().Add(2 * time.Hour)) })
+	wg.Wait()
+
+	if n := shown.Load(); n > 1 {
+		t.Errorf("Snippet shown %d times, want at most once", n)
+	}
+	if _, exists := snippets[id]; exists {
+		t.Error("Snippet still stored after being read and expiring")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ImportResult reports what an import did with each incoming snippet
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// snapshotSnippets copies the snippets map under the read lock so callers
+// can work with it without holding the lock.
+func snapshotSnippets() map[string]Snippet {
+	snippetsMu.RLock()
+	defer snippetsMu.RUnlock()
+
+	snapshot := make(map[string]Snippet, len(snippets))
+	for id, snippet := range snippets {
+		snapshot[id] = snippet
+	}
+	return snapshot
+}
+
+// mergeSnippets merges incoming snippets into the global map. Existing IDs
+// are skipped unless overwrite is set, and immutable ones always are. An
+// overwritten snippet keeps its own delete token, not the one imported.
+func mergeSnippets(incoming map[string]Snippet, overwrite bool) ImportResult {
+	snippetsMu.Lock()
+	defer snippetsMu.Unlock()
+
+	var result ImportResult
+	for id, snippet := range incoming {
+		if existing, exists := snippets[id]; exists {
+			if !overwrite || existing.Immutable {
+				result.Skipped++
+				continue
+			}
+			snippet.DeleteTokenHash = existing.DeleteTokenHash
+		}
+		snippets[id] = snippet
+		logSnippetPut(id, snippet)
+		result.Imported++
+	}
+	return result
+}
+
+// exportSnippetsHandler handles "GET /export", returning every snippet as a
+// single pretty-printed JSON attachment. It's for admins only; under mTLS a
+// non-admin CN holding the admin token still only gets its own snippets.
+func exportSnippetsHandler(w http.ResponseWriter, r *http.Request) {
+	who, ok := adminIdentity(r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	exported := snapshotSnippets()
+	if owner, filtered := ownerFilter(r); filtered {
+		exported = snippetsOwnedBy(exported, owner)
+	}
+	data, err := json.MarshalIndent(plainSnippets(exported), "", "  ")
+	if err != nil {
+		logErrorf("Error marshaling snippets export: %v", err)
+		http.Error(w, "Failed to export snippets", http.StatusInternalServerError)
+		return
+	}
+
+	logInfof("Export by %s of %d snippets", who, len(exported))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"snippets-export.json\"")
+	w.Write(data)
+}
+
+// importSnippetsHandler handles "POST /import". The body is the same JSON
+// produced by /export. Pass ?overwrite=true to replace snippets whose IDs
+// already exist; by default they are left alone. It's for admins only.
+func importSnippetsHandler(w http.ResponseWriter, r *http.Request) {
+	who, ok := adminIdentity(r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var incoming map[string]Snippet
+	if err := json.NewDecoder(r.Body).Decode(&incoming); err != nil {
+		logErrorf("Error decoding snippets import: %v", err)
+		http.Error(w, "Invalid import JSON", http.StatusBadRequest)
+		return
+	}
+
+	// A copy of an encrypted snippets.json can be imported with the same key
+	key, _ := decodeEncryptionKey(cfg.EncryptionKey)
+	if err := decryptSnippets(incoming, key); err != nil {
+		logErrorf("Error decrypting snippets import: %v", err)
+		http.Error(w, "Cannot decrypt imported snippets", http.StatusBadRequest)
+		return
+	}
+	compressSnippets(incoming)
+
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	result := mergeSnippets(incoming, overwrite)
+
+	queueSnippetsSave()
+
+	logInfof("Import by %s: %d snippets (%d skipped)", who, result.Imported, result.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// adminRequest builds a request carrying the admin token, after setting it
+func adminRequest(t *testing.T, method, target string, body io.Reader) *http.Request {
+	t.Helper()
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	cfg.AdminToken = "admin-secret"
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	return req
+}
+
+// Test exporting snippets and importing them back into an empty store
+func TestExportImportRoundTrip(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"abc": {Title: "First", Text: "one"},
+		"xyz": {Title: "Second", Text: "two", BurnAfterReading: true},
+	}
+
+	req := adminRequest(t, "GET", "/export", nil)
+	w := httptest.NewRecorder()
+	exportSnippetsHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("exportSnippetsHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="snippets-export.json"` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	exported := w.Body.Bytes()
+
+	// Import into an empty store
+	snippets = make(map[string]Snippet)
+
+	req = adminRequest(t, "POST", "/import", bytes.NewReader(exported))
+	w = httptest.NewRecorder()
+	importSnippetsHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("importSnippetsHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var result ImportResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse import result: %v", err)
+	}
+	if result.Imported != 2 || result.Skipped != 0 {
+		t.Errorf("import result = %+v, want 2 imported, 0 skipped", result)
+	}
+
+	if got := snippets["xyz"]; got.Title != "Second" || got.Text != "two" || !got.BurnAfterReading {
+		t.Errorf("Snippet xyz mismatch after round trip: %+v", got)
+	}
+}
+
+// Test that import skips existing IDs unless overwrite is set
+func TestImportSnippets_Overwrite(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	body := `{"abc": {"title": "Imported", "text": "new"}}`
+
+	tests := []struct {
+		name      string
+		query     string
+		wantTitle string
+	}{
+		{"skip existing by default", "", "Existing"},
+		{"overwrite existing", "?overwrite=true", "Imported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = map[string]Snippet{
+				"abc": {Title: "Existing", Text: "old"},
+			}
+
+			req := adminRequest(t, "POST", "/import"+tt.query, strings.NewReader(body))
+			w := httptest.NewRecorder()
+			importSnippetsHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("importSnippetsHandler() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := snippets["abc"].Title; got != tt.wantTitle {
+				t.Errorf("Snippet title = %q, want %q", got, tt.wantTitle)
+			}
+		})
+	}
+}
+
+// Test import with malformed JSON
+func TestImportSnippets_InvalidJSON(t *testing.T) {
+	req := adminRequest(t, "POST", "/import", strings.NewReader("{not json"))
+	w := httptest.NewRecorder()
+	importSnippetsHandler(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("importSnippetsHandler() status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// Test export and import turn away requests without admin credentials
+func TestExportImport_Forbidden(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+	})
+	cfg.AdminToken = "admin-secret"
+	snippets = map[string]Snippet{
+		"abc": {Title: "Secret", Text: "once", BurnAfterReading: true},
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		handler http.HandlerFunc
+		req     func() *http.Request
+	}{
+		{"export without token", "", exportSnippetsHandler, func() *http.Request { return httptest.NewRequest("GET", "/export", nil) }},
+		{"export with wrong token", "wrong", exportSnippetsHandler, func() *http.Request { return httptest.NewRequest("GET", "/export", nil) }},
+		{"import without token", "", importSnippetsHandler, func() *http.Request {
+			return httptest.NewRequest("POST", "/import?overwrite=true", strings.NewReader(`{"abc": {"title": "pwned"}}`))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req()
+			if tt.token != "" {
+				req.Header.Set("X-Admin-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			if strings.Contains(w.Body.String(), "once") {
+				t.Error("Snippet text was given out")
+			}
+		})
+	}
+	if got := snippets["abc"]; got.Title != "Secret" {
+		t.Errorf("Snippet changed to %+v", got)
+	}
+}
+
+// Test overwriting import leaves immutable snippets alone and never takes
+// a delete token from the payload
+func TestImportSnippets_Protected(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"fixed": {Title: "Fixed", Text: "keep", Immutable: true},
+		"abc":   {Title: "Existing", Text: "old", DeleteTokenHash: hashDeleteToken("owner-token")},
+	}
+	body := `{
+		"fixed": {"title": "pwned", "text": "new"},
+		"abc": {"title": "Imported", "text": "new", "delete_token_hash": "` + hashDeleteToken("attacker-token") + `"}
+	}`
+
+	req := adminRequest(t, "POST", "/import?overwrite=true", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	importSnippetsHandler(w, req)
+
+	var result ImportResult
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Imported != 1 || result.Skipped != 1 {
+		t.Errorf("Import result = %+v, want 1 imported, 1 skipped", result)
+	}
+	if got := snippets["fixed"]; got.Title != "Fixed" || !got.Immutable {
+		t.Errorf("Immutable snippet was replaced: %+v", got)
+	}
+	if got := snippets["abc"]; got.Title != "Imported" || got.DeleteTokenHash != hashDeleteToken("owner-token") {
+		t.Errorf("Overwritten snippet = %+v, want the new text with the old delete token", got)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Files can be fetched from a URL instead of uploaded from the browser's
+// machine:
+//
+//	curl -d url=https://example.com/report.pdf http://localhost:3015/upload-url
+//
+// The fetched file is stored like any other upload and held to the same
+// max_upload_bytes. Loopback, private and link-local addresses are refused
+// unless allow_private_fetch is set. The check is made on every connection,
+// after DNS, so redirects and names that resolve to such addresses don't
+// get around it.
+
+// fetchTimeout caps how long fetching a file can take, download included
+const fetchTimeout = 5 * time.Minute
+
+// defaultFetchName is used when neither the response nor the URL names the file
+const defaultFetchName = "download"
+
+// errPrivateAddress is returned when a fetch would connect somewhere on the
+// server's own network
+var errPrivateAddress = errors.New("fetching from private addresses is not allowed")
+
+// fetchClient fetches files for /upload-url. It ignores proxy settings, since
+// the address check has to see where connections really go.
+var fetchClient = &http.Client{
+	Timeout: fetchTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: checkFetchAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// checkFetchAddress runs before each connection fetchClient makes and
+// refuses private addresses unless cfg.AllowPrivateFetch is set.
+func checkFetchAddress(network, address string, _ syscall.RawConn) error {
+	if cfg.AllowPrivateFetch {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || privateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// privateIP reports whether ip is on the server's own machine or network
+// rather than out on the internet.
+func privateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// fetchedFileName works out what to call a fetched file: the name given in
+// Content-Disposition, else the last part of the URL path. A name without an
+// extension gets one from the Content-Type, so the file is served as the
+// same type it was fetched as.
+func fetchedFileName(resp *http.Response) string {
+	name := ""
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name = path.Base(resp.Request.URL.Path)
+	}
+	name = cleanFileName(name)
+	if name == "" {
+		name = defaultFetchName
+	}
+
+	if filepath.Ext(name) == "" {
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			name += extensionForType(mediaType)
+		}
+	}
+	return name
+}
+
+// extensionForType picks a file extension for a MIME type, preferring one
+// getContentType maps back to the same type. It returns "" if there's none.
+func extensionForType(mediaType string) string {
+	exts, _ := mime.ExtensionsByType(mediaType)
+	for _, ext := range exts {
+		if getContentType(defaultFetchName+ext) == mediaType {
+			return ext
+		}
+	}
+	if len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// fetchUploadHandler handles "POST /upload-url", fetching the file at the
+// 'url' form field and storing it as an upload. It takes the same expires,
+// password and burn fields as the upload form.
+func fetchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireInvite(w, r, "upload", false) {
+		return
+	}
+	target, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := uploadPasswordHash(r.FormValue("password"))
+	if err != nil {
+		logErrorf("Error hashing upload password: %v", err)
+		http.Error(w, "Cannot save file", http.StatusInternalServerError)
+		return
+	}
+
+	// A fetch writes to disk like any other upload, so it needs a slot too
+	release, ok := takeUploadSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		http.Error(w, "url must be an http or https URL", http.StatusBadRequest)
+		return
+	}
+	resp, err := fetchClient.Do(req)
+	if errors.Is(err, errPrivateAddress) {
+		logWarnf("Refused to fetch %s for %s: %v", target.Redacted(), clientIP(r), err)
+		http.Error(w, "Fetching from private addresses is not allowed", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logErrorf("Error fetching %s: %v", target.Redacted(), err)
+		http.Error(w, "Cannot fetch URL", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, fmt.Sprintf("Fetching the URL returned %s", resp.Status), http.StatusBadGateway)
+		return
+	}
+	if resp.ContentLength > cfg.MaxUploadBytes {
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Servers don't always send a length, so the body is capped as well
+	name := fetchedFileName(resp)
+	body := bufio.NewReader(http.MaxBytesReader(w, resp.Body, cfg.MaxUploadBytes))
+
+	head, _ := body.Peek(sniffLen)
+	if err := checkUploadType(name, head); err != nil {
+		logWarnf("Rejected fetched file %s: %v", name, err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	uniqueID := newFileID(name)
+	fi := FileInfo{
+		ID:               uniqueID,
+		Name:             name,
+		StoredName:       newStoredName(uniqueID, time.Now()),
+		BurnAfterReading: r.FormValue("burn") == "true",
+		Owner:            requestOwner(r),
+		ExpiresAt:        expiryTime(ttl),
+		PasswordHash:     passwordHash,
+		ContentType:      uploadContentType(resp.Header.Get("Content-Type")),
+	}
+	existingID, err := storeUpload(&fi, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logErrorf("Error saving fetched file: %v", err)
+		http.Error(w, "Cannot save file", http.StatusInternalServerError)
+		return
+	}
+	if existingID != "" {
+		http.Redirect(w, r, sitePath("/file/"+existingID), http.StatusSeeOther)
+		return
+	}
+
+	addFile(fi)
+	notifyFileWebhook(r, fi)
+	logDebugf("Fetched %s as %s", target.Redacted(), uniqueID)
+
+	http.Redirect(w, r, sitePath("/file/"+uniqueID), http.StatusSeeOther)
+}
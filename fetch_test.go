@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupFetchTest points uploads at a temp dir and returns a server handing
+// out the given body
+func setupFetchTest(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	originalCfg := cfg
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	filesFile = filepath.Join(tmpDir, "files.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// postFetch submits the /upload-url form
+func postFetch(target string) *httptest.ResponseRecorder {
+	form := url.Values{"url": {target}}
+	req := httptest.NewRequest("POST", "/upload-url", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	fetchUploadHandler(w, req)
+	return w
+}
+
+// Test a fetched file is stored under the name from the URL
+func TestFetchUploadHandler(t *testing.T) {
+	server := setupFetchTest(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fetched contents"))
+	})
+	// httptest servers listen on loopback
+	cfg.AllowPrivateFetch = true
+
+	w := postFetch(server.URL + "/docs/notes.txt")
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("fetchUploadHandler() status = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body.String())
+	}
+	if len(files) != 1 {
+		t.Fatalf("fetchUploadHandler() created %d files, want 1", len(files))
+	}
+	for id, fi := range files {
+		if fi.Name != "notes.txt" {
+			t.Errorf("Name = %q, want notes.txt", fi.Name)
+		}
+		if loc := w.Header().Get("Location"); loc != "/file/"+id {
+			t.Errorf("Location = %q, want /file/%s", loc, id)
+		}
+		data, err := os.ReadFile(filepath.Join(uploadsDir, id))
+		if err != nil || string(data) != "fetched contents" {
+			t.Errorf("Stored file = %q (%v), want %q", data, err, "fetched contents")
+		}
+		if fi.Checksum == "" {
+			t.Error("Fetched file has no checksum")
+		}
+	}
+}
+
+// Test files bigger than max_upload_bytes are refused, with or without a
+// Content-Length, and nothing is left behind
+func TestFetchUploadHandler_TooLarge(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"content length", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 100)))
+		}},
+		{"no content length", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("x", 10)))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(strings.Repeat("x", 90)))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := setupFetchTest(t, tt.handler)
+			cfg.AllowPrivateFetch = true
+			cfg.MaxUploadBytes = 50
+
+			w := postFetch(server.URL + "/big.txt")
+
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("fetchUploadHandler() status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+			}
+			if len(files) != 0 {
+				t.Errorf("Oversize fetch registered %d files, want 0", len(files))
+			}
+			if stored, _ := os.ReadDir(uploadsDir); len(stored) != 0 {
+				t.Errorf("Found %d stray files in uploads directory, want 0", len(stored))
+			}
+		})
+	}
+}
+
+// Test loopback addresses are refused without allow_private_fetch, before
+// anything is requested from them
+func TestFetchUploadHandler_PrivateAddress(t *testing.T) {
+	hit := false
+	server := setupFetchTest(t, func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+		w.Write([]byte("internal"))
+	})
+
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, target := range []string{server.URL + "/secret.txt", localhost + "/secret.txt"} {
+		w := postFetch(target)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("fetchUploadHandler(%s) status = %d, want %d", target, w.Code, http.StatusForbidden)
+		}
+	}
+	if hit {
+		t.Error("Server on a private address was fetched from")
+	}
+	if len(files) != 0 {
+		t.Errorf("Blocked fetch registered %d files, want 0", len(files))
+	}
+}
+
+// Test URLs that aren't http or https are rejected
+func TestFetchUploadHandler_BadURL(t *testing.T) {
+	setupFetchTest(t, http.NotFound)
+
+	for _, target := range []string{"", "not a url", "ftp://example.com/a.txt", "file:///etc/passwd"} {
+		if w := postFetch(target); w.Code != http.StatusBadRequest {
+			t.Errorf("fetchUploadHandler(%q) status = %d, want %d", target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// Test fetched files are named from Content-Disposition or the URL, with an
+// extension from the Content-Type when they have none
+func TestFetchedFileName(t *testing.T) {
+	tests := []struct {
+		path        string
+		disposition string
+		contentType string
+		want        string
+	}{
+		{"/a/report.pdf", "", "application/pdf", "report.pdf"},
+		{"/download", `attachment; filename="real name.txt"`, "", "real name.txt"},
+		{"/download", `attachment; filename="../../etc/passwd.txt"`, "", "passwd.txt"},
+		{"/image", "", "image/png", "image.png"},
+		{"/readme", "", "text/plain; charset=utf-8", "readme.txt"},
+		{"/", "", "", "download"},
+		{"", "", "application/x-unknown-thing", "download"},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{
+			Header:  http.Header{},
+			Request: &http.Request{URL: &url.URL{Path: tt.path}},
+		}
+		if tt.disposition != "" {
+			resp.Header.Set("Content-Disposition", tt.disposition)
+		}
+		if tt.contentType != "" {
+			resp.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := fetchedFileName(resp); got != tt.want {
+			t.Errorf("fetchedFileName(%q, %q, %q) = %q, want %q", tt.path, tt.disposition, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+// Test a fetch needs an upload slot like any other upload, and is turned
+// away with a 503 before fetching anything when they're all taken
+func TestFetchUploadHandler_ConcurrencyLimit(t *testing.T) {
+	fetched := false
+	server := setupFetchTest(t, func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte("fetched contents"))
+	})
+	cfg.AllowPrivateFetch = true
+
+	originalSlots := uploadSlots
+	originalWait := uploadSlotWait
+	t.Cleanup(func() {
+		uploadSlots = originalSlots
+		uploadSlotWait = originalWait
+	})
+	uploadSlots = newUploadSlots(1)
+	uploadSlotWait = 10 * time.Millisecond
+
+	uploadSlots <- struct{}{}
+	w := postFetch(server.URL + "/notes.txt")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Fetch with every slot taken = %d, Retry-After %q; want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if fetched || len(files) != 0 {
+		t.Error("Fetch went ahead without a slot")
+	}
+
+	<-uploadSlots
+	if w := postFetch(server.URL + "/notes.txt"); w.Code != http.StatusSeeOther {
+		t.Errorf("Fetch with a free slot status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if len(uploadSlots) != 0 {
+		t.Error("Fetch didn't give its slot back")
+	}
+}
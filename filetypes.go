@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of an upload is read to work out what it really is
+const sniffLen = 512
+
+// checkUploadType enforces cfg.AllowedExtensions. The extension has to be on
+// the list and the content has to look like what the extension claims, so
+// renaming an executable to .png doesn't get it through. head is the start
+// of the file (up to sniffLen bytes); pass nil to check just the name.
+func checkUploadType(filename string, head []byte) error {
+	if len(cfg.AllowedExtensions) == 0 {
+		return nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !extensionAllowed(ext) {
+		if ext == "" {
+			return fmt.Errorf("files without an extension are not allowed; allowed types: %s", strings.Join(cfg.AllowedExtensions, ", "))
+		}
+		return fmt.Errorf("%s files are not allowed; allowed types: %s", ext, strings.Join(cfg.AllowedExtensions, ", "))
+	}
+	if head == nil {
+		return nil
+	}
+
+	if looksExecutable(head) {
+		return fmt.Errorf("%s looks like an executable, which is not allowed", filepath.Base(filename))
+	}
+	expected := getContentType(filename)
+	sniffed := http.DetectContentType(head)
+	if !sameKindOfContent(expected, sniffed) {
+		return fmt.Errorf("%s does not look like a %s file", filepath.Base(filename), ext)
+	}
+	return nil
+}
+
+// extensionAllowed reports whether ext (lowercase, with the dot) is in
+// AllowedExtensions, which may be written with or without the dot.
+func extensionAllowed(ext string) bool {
+	if ext == "" {
+		return false
+	}
+	for _, allowed := range cfg.AllowedExtensions {
+		allowed = strings.ToLower(allowed)
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// looksExecutable spots Windows, Linux and macOS executables and scripts
+func looksExecutable(head []byte) bool {
+	signatures := [][]byte{
+		[]byte("MZ"),             // Windows PE
+		[]byte("\x7fELF"),        // Linux ELF
+		[]byte("#!"),             // shell scripts
+		{0xcf, 0xfa, 0xed, 0xfe}, // Mach-O 64-bit
+		{0xce, 0xfa, 0xed, 0xfe}, // Mach-O 32-bit
+		{0xca, 0xfe, 0xba, 0xbe}, // Mach-O universal
+	}
+	for _, signature := range signatures {
+		if bytes.HasPrefix(head, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameKindOfContent compares the MIME type an extension implies with the one
+// sniffed from the content. Sniffing can't tell every format apart, so when
+// either side is unknown it gives the benefit of the doubt; otherwise images
+// must sniff as images, text as text, and other types must match exactly.
+func sameKindOfContent(expected, sniffed string) bool {
+	sniffed, _, _ = strings.Cut(sniffed, ";")
+	if expected == "application/octet-stream" || sniffed == "application/octet-stream" {
+		return true
+	}
+	return contentFamily(expected) == contentFamily(sniffed)
+}
+
+// contentFamily groups MIME types that sniffing can't reliably tell apart
+func contentFamily(mimeType string) string {
+	switch mimeType {
+	case "application/json", "application/xml", "text/xml":
+		return "text"
+	}
+	major, _, _ := strings.Cut(mimeType, "/")
+	switch major {
+	case "text", "image", "audio", "video":
+		return major
+	}
+	return mimeType
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test checkUploadType against an allow list
+func TestCheckUploadType(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	pdfHeader := []byte("%PDF-1.4\n")
+	exeHeader := []byte("MZ\x90\x00\x03\x00\x00\x00")
+
+	tests := []struct {
+		name     string
+		allowed  []string
+		filename string
+		head     []byte
+		wantErr  bool
+	}{
+		{"empty list allows anything", nil, "setup.exe", exeHeader, false},
+		{"allowed image", []string{".png", ".pdf"}, "photo.png", pngHeader, false},
+		{"extension case and dot don't matter", []string{"PNG"}, "photo.PNG", pngHeader, false},
+		{"allowed pdf", []string{".png", ".pdf"}, "doc.pdf", pdfHeader, false},
+		{"allowed text", []string{".txt"}, "notes.txt", []byte("hello"), false},
+		{"json sniffs as text", []string{".json"}, "data.json", []byte(`{"a": 1}`), false},
+		{"name only", []string{".png"}, "photo.png", nil, false},
+		{"disallowed extension", []string{".png", ".pdf"}, "setup.exe", exeHeader, true},
+		{"no extension", []string{".png"}, "README", []byte("hi"), true},
+		{"executable renamed", []string{".png"}, "photo.png", exeHeader, true},
+		{"pdf renamed to png", []string{".png"}, "photo.png", pdfHeader, true},
+		{"script renamed to txt", []string{".txt"}, "notes.txt", []byte("#!/bin/sh\nrm -rf /"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AllowedExtensions = tt.allowed
+			err := checkUploadType(tt.filename, tt.head)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkUploadType(%q) error = %v, wantErr %v", tt.filename, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// defaultDataDir is where data goes when neither -datadir nor data_dir say
+const defaultDataDir = "."
+
+// Options are the command-line settings: where to listen and where the
+// data lives. Paths not given explicitly sit under DataDir.
+type Options struct {
+	Host          string
+	Port          string
+	DataDir       string
+	ConfigFile    string
+	SnippetsFile  string
+	FilesFile     string
+	UploadsDir    string
+	QuarantineDir string
+	Validate      bool // check ConfigFile and exit
+}
+
+// parseFlags parses the command line (without the program name). Errors
+// and -h have already been reported on stderr by the time it returns.
+func parseFlags(args []string) (Options, error) {
+	var opts Options
+	fs := flag.NewFlagSet("pasty", flag.ContinueOnError)
+	fs.StringVar(&opts.Host, "host", "localhost", "Host to listen on")
+	fs.StringVar(&opts.Port, "port", "3015", "Port to listen on")
+	fs.StringVar(&opts.DataDir, "datadir", defaultDataDir, "Directory for data files (snippets.json, files.json and uploads)")
+	fs.StringVar(&opts.ConfigFile, "config", "config.json", "Config file")
+	fs.StringVar(&opts.SnippetsFile, "snippets", "", "Snippets file (default <datadir>/snippets.json)")
+	fs.StringVar(&opts.UploadsDir, "uploads", "", "Uploads directory (default <datadir>/uploads)")
+	fs.BoolVar(&opts.Validate, "validate", false, "Check the config file and exit without starting the server")
+	if err := fs.Parse(args); err != nil {
+		return Options{}, err
+	}
+
+	if opts.SnippetsFile == "" {
+		opts.SnippetsFile = filepath.Join(opts.DataDir, "snippets.json")
+	}
+	if opts.UploadsDir == "" {
+		opts.UploadsDir = filepath.Join(opts.DataDir, "uploads")
+	}
+	opts.FilesFile = filepath.Join(opts.DataDir, "files.json")
+	opts.QuarantineDir = filepath.Join(opts.DataDir, "quarantine")
+	return opts, nil
+}
+
+// withDataDir moves the data paths under dir, for data_dir in the config.
+// -datadir on the command line wins over it, and -snippets and -uploads
+// keep pointing where they were given.
+func (o Options) withDataDir(dir string) Options {
+	if dir == "" || o.DataDir != defaultDataDir {
+		return o
+	}
+	moved := o
+	moved.DataDir = dir
+	moved.FilesFile = filepath.Join(dir, "files.json")
+	moved.QuarantineDir = filepath.Join(dir, "quarantine")
+	if o.SnippetsFile == filepath.Join(o.DataDir, "snippets.json") {
+		moved.SnippetsFile = filepath.Join(dir, "snippets.json")
+	}
+	if o.UploadsDir == filepath.Join(o.DataDir, "uploads") {
+		moved.UploadsDir = filepath.Join(dir, "uploads")
+	}
+	return moved
+}
+
+// useDataPaths points the handlers, the savers and the shutdown handler at
+// opts' data paths, creating the data and uploads directories if needed.
+func useDataPaths(opts Options) error {
+	snippetsFile = opts.SnippetsFile
+	walFile = walPath(snippetsFile)
+	filesFile = opts.FilesFile
+	uploadsDir = opts.UploadsDir
+	quarantineDir = opts.QuarantineDir
+
+	if err := os.MkdirAll(opts.DataDir, 0755); err != nil {
+		return err
+	}
+	return os.MkdirAll(uploadsDir, 0755)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test flag defaults and overrides resolve to the right paths
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want Options
+	}{
+		{
+			name: "defaults",
+			args: nil,
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: ".", ConfigFile: "config.json",
+				SnippetsFile: "snippets.json", FilesFile: "files.json",
+				UploadsDir: "uploads", QuarantineDir: "quarantine",
+			},
+		},
+		{
+			name: "datadir",
+			args: []string{"-datadir", "/srv/pasty"},
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: "/srv/pasty", ConfigFile: "config.json",
+				SnippetsFile: "/srv/pasty/snippets.json", FilesFile: "/srv/pasty/files.json",
+				UploadsDir: "/srv/pasty/uploads", QuarantineDir: "/srv/pasty/quarantine",
+			},
+		},
+		{
+			name: "overrides",
+			args: []string{"-datadir", "/srv/pasty", "-config", "/etc/pasty/b.json", "-snippets", "/var/b/snippets.json", "-uploads", "/var/b/uploads", "-port", "3016"},
+			want: Options{
+				Host: "localhost", Port: "3016", DataDir: "/srv/pasty", ConfigFile: "/etc/pasty/b.json",
+				SnippetsFile: "/var/b/snippets.json", FilesFile: "/srv/pasty/files.json",
+				UploadsDir: "/var/b/uploads", QuarantineDir: "/srv/pasty/quarantine",
+			},
+		},
+		{
+			name: "validate",
+			args: []string{"-validate", "-config", "staging.json"},
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: ".", ConfigFile: "staging.json",
+				SnippetsFile: "snippets.json", FilesFile: "files.json",
+				UploadsDir: "uploads", QuarantineDir: "quarantine", Validate: true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlags(tt.args)
+			if err != nil {
+				t.Fatalf("parseFlags(%v) error = %v", tt.args, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseFlags(%v) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test an unknown flag is an error rather than ignored
+func TestParseFlags_Unknown(t *testing.T) {
+	if _, err := parseFlags([]string{"-nope"}); err == nil {
+		t.Error("parseFlags(-nope) error = nil, want an error")
+	}
+}
+
+// Test data_dir moves the paths the command line didn't set, and loses to
+// -datadir
+func TestOptions_WithDataDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		dataDir string
+		want    Options
+	}{
+		{
+			name:    "data_dir",
+			dataDir: "/srv/pasty",
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: "/srv/pasty", ConfigFile: "config.json",
+				SnippetsFile: "/srv/pasty/snippets.json", FilesFile: "/srv/pasty/files.json",
+				UploadsDir: "/srv/pasty/uploads", QuarantineDir: "/srv/pasty/quarantine",
+			},
+		},
+		{
+			name:    "flags win",
+			args:    []string{"-snippets", "/var/b/snippets.json", "-uploads", "/var/b/uploads"},
+			dataDir: "/srv/pasty",
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: "/srv/pasty", ConfigFile: "config.json",
+				SnippetsFile: "/var/b/snippets.json", FilesFile: "/srv/pasty/files.json",
+				UploadsDir: "/var/b/uploads", QuarantineDir: "/srv/pasty/quarantine",
+			},
+		},
+		{
+			name:    "-datadir wins",
+			args:    []string{"-datadir", "/data"},
+			dataDir: "/srv/pasty",
+			want: Options{
+				Host: "localhost", Port: "3015", DataDir: "/data", ConfigFile: "config.json",
+				SnippetsFile: "/data/snippets.json", FilesFile: "/data/files.json",
+				UploadsDir: "/data/uploads", QuarantineDir: "/data/quarantine",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseFlags(tt.args)
+			if err != nil {
+				t.Fatalf("parseFlags(%v) error = %v", tt.args, err)
+			}
+			if got := opts.withDataDir(tt.dataDir); got != tt.want {
+				t.Errorf("withDataDir(%q) = %+v, want %+v", tt.dataDir, got, tt.want)
+			}
+		})
+	}
+}
+
+// Test that with data_dir set, snippets, files, uploads and the log are all
+// kept under it
+func TestUseDataPaths(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalFiles := files
+	originalSnippetsFile := snippetsFile
+	originalWALFile := walFile
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	originalQuarantineDir := quarantineDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		files = originalFiles
+		snippetsFile = originalSnippetsFile
+		walFile = originalWALFile
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+		quarantineDir = originalQuarantineDir
+	})
+
+	dataDir := filepath.Join(t.TempDir(), "data")
+	opts, _ := parseFlags(nil)
+	if err := useDataPaths(opts.withDataDir(dataDir)); err != nil {
+		t.Fatalf("useDataPaths() error = %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dataDir, "uploads")); err != nil || !info.IsDir() {
+		t.Fatalf("Uploads directory wasn't created: %v", err)
+	}
+	for _, path := range []string{snippetsFile, walFile, filesFile, uploadsDir, quarantineDir} {
+		if !strings.HasPrefix(path, dataDir+string(filepath.Separator)) {
+			t.Errorf("%s isn't under %s", path, dataDir)
+		}
+	}
+
+	snippets = make(map[string]Snippet)
+	files = make(map[string]FileInfo)
+	form := url.Values{"title": {"Kept"}, "text": {"under the data dir"}}
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handleSave(httptest.NewRecorder(), req)
+	if w := uploadForm(t, "notes.txt", "uploaded notes", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	snippets = make(map[string]Snippet)
+	files = make(map[string]FileInfo)
+	loadSnippetsFromFile(filepath.Join(dataDir, "snippets.json"))
+	loadFilesFromFile(filepath.Join(dataDir, "files.json"))
+	if len(snippets) != 1 || len(files) != 1 {
+		t.Fatalf("Loaded %d snippets and %d files from %s, want 1 of each", len(snippets), len(files), dataDir)
+	}
+	for id := range files {
+		if data, err := os.ReadFile(filepath.Join(dataDir, "uploads", id)); err != nil || string(data) != "uploaded notes" {
+			t.Errorf("Upload not under %s/uploads: %v", dataDir, err)
+		}
+	}
+}
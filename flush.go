@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Writing snippets.json on every request gets expensive as it grows, so
+// changes that can wait are batched: handlers call queueSnippetsSave and the
+// flusher writes at most once per interval. saveSnippetsToFile also skips
+// the write when nothing changed since the last one. Deletions still call
+// saveSnippetsToFile directly so a crash can't bring a burned snippet back,
+// and shutdown saves whatever is pending. Queued changes are in the
+// write-ahead log meanwhile, so a crash doesn't lose them either.
+
+var (
+	// savedMu serializes saves and guards savedSums
+	savedMu sync.Mutex
+	// savedSums holds a hash of the snippets last written to each file
+	savedSums = make(map[string][32]byte)
+
+	// snippetsDirty is set when there are changes for the flusher to write
+	snippetsDirty atomic.Bool
+	// snippetsFlusher is set while the flusher is running
+	snippetsFlusher atomic.Bool
+)
+
+// queueSnippetsSave saves the snippets on the flusher's next tick, or right
+// away if the flusher isn't running.
+func queueSnippetsSave() {
+	if !snippetsFlusher.Load() {
+		saveSnippetsToFile(snippetsFile)
+		return
+	}
+	snippetsDirty.Store(true)
+}
+
+// flushSnippets writes out queued changes, if there are any.
+func flushSnippets() {
+	if snippetsDirty.Swap(false) {
+		saveSnippetsToFile(snippetsFile)
+	}
+}
+
+// startSnippetsFlusher starts writing queued saves every interval. With a
+// zero interval nothing is queued and every save is written immediately.
+func startSnippetsFlusher(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	snippetsFlusher.Store(true)
+	go func() {
+		for range time.Tick(interval) {
+			flushSnippets()
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test saving snippets that haven't changed doesn't rewrite the file
+func TestSaveSnippetsToFile_Unchanged(t *testing.T) {
+	for _, key := range []string{"", testEncryptionKey} {
+		name := "plain"
+		if key != "" {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			originalSnippets := snippets
+			originalCfg := cfg
+			t.Cleanup(func() {
+				snippets = originalSnippets
+				cfg = originalCfg
+			})
+			cfg.EncryptionKey = key
+
+			filename := filepath.Join(t.TempDir(), "snippets.json")
+			snippets = map[string]Snippet{"abc": {Title: "Test", Text: "Content"}}
+			saveSnippetsToFile(filename)
+
+			// Backdate the file so a rewrite would be visible in the modtime
+			past := time.Now().Add(-time.Hour).Truncate(time.Second)
+			os.Chtimes(filename, past, past)
+
+			saveSnippetsToFile(filename)
+			saveSnippetsToFile(filename)
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if !info.ModTime().Equal(past) {
+				t.Errorf("No-op saves rewrote the file, modtime %v, want %v", info.ModTime(), past)
+			}
+
+			snippets["xyz"] = Snippet{Title: "New", Text: "More"}
+			saveSnippetsToFile(filename)
+			info, _ = os.Stat(filename)
+			if info.ModTime().Equal(past) {
+				t.Error("Save after a change didn't rewrite the file")
+			}
+		})
+	}
+}
+
+// Test queued saves wait for the flusher while it's running
+func TestQueueSnippetsSave(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		snippetsFlusher.Store(false)
+		snippetsDirty.Store(false)
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{"abc": {Title: "Test", Text: "Content"}}
+
+	// Without the flusher the save happens right away
+	queueSnippetsSave()
+	if _, err := os.Stat(snippetsFile); err != nil {
+		t.Fatalf("Unbatched save didn't write the file: %v", err)
+	}
+	os.Remove(snippetsFile)
+
+	snippetsFlusher.Store(true)
+	snippets["xyz"] = Snippet{Title: "New", Text: "More"}
+	queueSnippetsSave()
+	if _, err := os.Stat(snippetsFile); !os.IsNotExist(err) {
+		t.Fatal("Queued save was written before the flush")
+	}
+
+	flushSnippets()
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	if len(snippets) != 2 {
+		t.Errorf("Flushed file has %d snippets, want 2", len(snippets))
+	}
+
+	// Nothing queued, nothing written
+	os.Remove(snippetsFile)
+	flushSnippets()
+	if _, err := os.Stat(snippetsFile); !os.IsNotExist(err) {
+		t.Error("Flush with nothing queued wrote the file")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Every snippet lives in memory and goes into snippets.json on each save, so
+// things slow down as the count grows. Past snippet_soft_limit each save
+// logs a warning and /healthz reports "degraded"; at snippet_hard_limit new
+// pastes are refused with a 503 until the operator makes room.
+//
+// A missing or read-only uploads directory would otherwise only show up as
+// failed uploads and 404s, so it's checked at startup, which stops with the
+// reason, and by /healthz, which reports it as degraded.
+
+// HealthStatus is the response to "GET /healthz"
+type HealthStatus struct {
+	Status   string `json:"status"` // "ok" or "degraded"
+	Degraded bool   `json:"degraded"`
+	Snippets int    `json:"snippets"`
+	Files    int    `json:"files"`
+
+	UploadsWritable bool `json:"uploads_writable"`
+}
+
+// snippetsDegraded reports whether count is past cfg.SnippetSoftLimit
+func snippetsDegraded(count int) bool {
+	return cfg.SnippetSoftLimit > 0 && count > cfg.SnippetSoftLimit
+}
+
+// warnSnippetSoftLimit logs the soft limit warning after a save of count
+// snippets, if they're past it
+func warnSnippetSoftLimit(count int) {
+	if snippetsDegraded(count) {
+		logWarnf("Warning: %d snippets is over snippet_soft_limit (%d); saves and lookups slow down as it grows. Set max_snippets or remove old snippets.",
+			count, cfg.SnippetSoftLimit)
+	}
+}
+
+// snippetsFull reports whether cfg.SnippetHardLimit leaves no room for
+// another snippet. Evicting for max_snippets makes room, so it's only full
+// when that can't.
+func snippetsFull() bool {
+	if cfg.SnippetHardLimit == 0 || (cfg.MaxSnippets > 0 && cfg.MaxSnippets <= cfg.SnippetHardLimit) {
+		return false
+	}
+	snippetsMu.RLock()
+	count := len(snippets)
+	snippetsMu.RUnlock()
+	return count >= cfg.SnippetHardLimit
+}
+
+// refuseWhenFull answers with a 503, as plain text or a JSON API error, and
+// reports true when snippetsFull
+func refuseWhenFull(w http.ResponseWriter, r *http.Request, asJSON bool) bool {
+	if !snippetsFull() {
+		return false
+	}
+	logWarnf("Refused paste from %s: snippet_hard_limit of %d reached. Set max_snippets or remove old snippets to make room.",
+		clientIP(r), cfg.SnippetHardLimit)
+	message := "This site has stored as many pastes as it can; ask its operator to make room"
+	if asJSON {
+		writeAPIError(w, http.StatusServiceUnavailable, apiErrFull, message)
+	} else {
+		http.Error(w, message, http.StatusServiceUnavailable)
+	}
+	return true
+}
+
+// checkUploadsDir makes sure dir exists and files can be written to it, by
+// creating and removing a probe file.
+func checkUploadsDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("uploads directory %s can't be created: %w", dir, err)
+	}
+	if err := probeWritable(dir); err != nil {
+		return fmt.Errorf("uploads directory %s isn't writable: %w", dir, err)
+	}
+	return nil
+}
+
+// probeWritable writes and removes an empty file in dir.
+func probeWritable(dir string) error {
+	probe, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// healthHandler handles "GET /healthz". It's always 200 while the server is
+// up; a degraded store still works, only slower, and a degraded uploads
+// directory leaves snippets working.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	snippetsMu.RLock()
+	snippetCount := len(snippets)
+	snippetsMu.RUnlock()
+	filesMu.RLock()
+	fileCount := len(files)
+	filesMu.RUnlock()
+
+	status := HealthStatus{Status: "ok", Snippets: snippetCount, Files: fileCount}
+	status.UploadsWritable = probeWritable(uploadsDir) == nil
+	if snippetsDegraded(snippetCount) || !status.UploadsWritable {
+		status.Status, status.Degraded = "degraded", true
+	}
+	writeJSON(w, http.StatusOK, status)
+}
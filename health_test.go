@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupHealthTest stores n snippets, with snippets.json in a temp dir
+func setupHealthTest(t *testing.T, n int) {
+	t.Helper()
+
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		uploadsDir = originalUploadsDir
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	uploadsDir = t.TempDir()
+	snippets = make(map[string]Snippet)
+	for i := 0; i < n; i++ {
+		snippets[fmt.Sprintf("snippet%d", i)] = Snippet{Text: fmt.Sprint(i)}
+	}
+}
+
+// getHealth fetches /healthz
+func getHealth(t *testing.T) HealthStatus {
+	t.Helper()
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("healthHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var status HealthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	return status
+}
+
+// Test going past snippet_soft_limit logs a warning on save and flags
+// /healthz as degraded
+func TestSnippetSoftLimit(t *testing.T) {
+	setupHealthTest(t, 3)
+	cfg.SnippetSoftLimit = 3
+
+	if status := getHealth(t); status.Degraded || status.Status != "ok" || status.Snippets != 3 {
+		t.Errorf("At the soft limit /healthz = %+v, want ok", status)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	saveSnippetsToFile(snippetsFile)
+	if strings.Contains(logged.String(), "snippet_soft_limit") {
+		t.Errorf("Save at the soft limit warned: %s", logged.String())
+	}
+
+	snippets["one-more"] = Snippet{Text: "over"}
+	saveSnippetsToFile(snippetsFile)
+	if !strings.Contains(logged.String(), "snippet_soft_limit") {
+		t.Errorf("Save past the soft limit didn't warn: %s", logged.String())
+	}
+	if status := getHealth(t); !status.Degraded || status.Status != "degraded" {
+		t.Errorf("Past the soft limit /healthz = %+v, want degraded", status)
+	}
+}
+
+// Test new pastes get a 503 at snippet_hard_limit, unless max_snippets
+// evicts to make room
+func TestSnippetHardLimit(t *testing.T) {
+	setupHealthTest(t, 2)
+	cfg.SnippetHardLimit = 2
+
+	save := func() *httptest.ResponseRecorder {
+		form := url.Values{"text": {"one too many"}}
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSave(w, req)
+		return w
+	}
+
+	if w := save(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("handleSave() at the hard limit status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if len(snippets) != 2 {
+		t.Errorf("%d snippets after a refused save, want 2", len(snippets))
+	}
+
+	w := httptest.NewRecorder()
+	apiCreateSnippetHandler(w, httptest.NewRequest("POST", "/api/snippets", strings.NewReader(`{"text": "one too many"}`)))
+	var apiErr APIError
+	json.Unmarshal(w.Body.Bytes(), &apiErr)
+	if w.Code != http.StatusServiceUnavailable || apiErr.Error.Code != apiErrFull {
+		t.Errorf("POST /api/snippets at the hard limit = %d %q, want 503 %q", w.Code, apiErr.Error.Code, apiErrFull)
+	}
+
+	cfg.MaxSnippets = 2
+	if w := save(); w.Code != http.StatusSeeOther {
+		t.Errorf("handleSave() with max_snippets evicting status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+// Test the startup check creates a missing uploads directory, and reports
+// one that can't be created or written to
+func TestCheckUploadsDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	notADir := filepath.Join(tmpDir, "file")
+	os.WriteFile(notADir, []byte("x"), 0644)
+	readOnly := filepath.Join(tmpDir, "readonly")
+	os.Mkdir(readOnly, 0555)
+
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{"missing is created", filepath.Join(tmpDir, "data", "uploads"), ""},
+		{"under a file", filepath.Join(notADir, "uploads"), "can't be created"},
+		{"read-only", readOnly, "isn't writable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dir == readOnly && probeWritable(readOnly) == nil {
+				t.Skip("Permissions aren't enforced for this user")
+			}
+			err := checkUploadsDir(tt.dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkUploadsDir() = %v, want nil", err)
+				}
+				if entries, _ := os.ReadDir(tt.dir); len(entries) != 0 {
+					t.Errorf("Probe file left behind: %v", entries)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), tt.dir) {
+				t.Errorf("checkUploadsDir() = %v, want an error naming %s that %s", err, tt.dir, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test /healthz reports an uploads directory that can't be written to
+func TestHealthHandler_UploadsNotWritable(t *testing.T) {
+	setupHealthTest(t, 0)
+
+	if status := getHealth(t); !status.UploadsWritable || status.Degraded {
+		t.Errorf("Writable uploads /healthz = %+v, want ok", status)
+	}
+
+	uploadsDir = filepath.Join(t.TempDir(), "missing")
+	if status := getHealth(t); status.UploadsWritable || !status.Degraded || status.Status != "degraded" {
+		t.Errorf("Missing uploads /healthz = %+v, want degraded", status)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// With cfg.KeepHistory set, appending to a snippet keeps the text it had
+// before as a SnippetVersion, up to that many, dropping the oldest first.
+// Versions are numbered from 1 and keep their number when older ones are
+// dropped. /history/{url} lists them and /history/{url}/{n} serves one as
+// plain text; the current text is served as usual. History is saved with the
+// snippet, and encrypted with it when there's an encryption key.
+
+// SnippetVersion is the text a snippet had up to SavedAt
+type SnippetVersion struct {
+	Number  int       `json:"number"`
+	Text    string    `json:"text"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// keepVersion records the snippet's current text in its history before it's
+// changed, if history is on.
+func (s *Snippet) keepVersion() {
+	if cfg.KeepHistory <= 0 {
+		return
+	}
+	version := SnippetVersion{Number: 1, Text: s.Body(), SavedAt: s.ModifiedAt}
+	if version.SavedAt.IsZero() {
+		version.SavedAt = s.CreatedAt
+	}
+	if len(s.History) > 0 {
+		version.Number = s.History[len(s.History)-1].Number + 1
+	}
+	// A fresh slice, so copies of the snippet held elsewhere never see it change
+	history := append(slices.Clone(s.History), version)
+	s.History = history[max(len(history)-cfg.KeepHistory, 0):]
+}
+
+// HistoryJSON is the response to "GET /history/{url}"
+type HistoryJSON struct {
+	ID       string         `json:"id"`
+	Versions []HistoryEntry `json:"versions"`
+}
+
+// HistoryEntry is one version in the listing, without its text
+type HistoryEntry struct {
+	Number  int       `json:"number"`
+	SavedAt time.Time `json:"saved_at"`
+	Size    int       `json:"size"`
+	URL     string    `json:"url"`
+}
+
+// historyHandler handles "GET /history/{url}", oldest version first.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+	snippet, ok := historySnippet(w, r, url)
+	if !ok {
+		return
+	}
+
+	listing := HistoryJSON{ID: url, Versions: []HistoryEntry{}}
+	for _, version := range snippet.History {
+		listing.Versions = append(listing.Versions, HistoryEntry{
+			Number:  version.Number,
+			SavedAt: version.SavedAt,
+			Size:    len(version.Text),
+			URL:     absoluteURL(r, "/history/"+url+"/"+strconv.Itoa(version.Number)),
+		})
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
+
+// historyVersionHandler handles "GET /history/{url}/{n}".
+func historyVersionHandler(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+	snippet, ok := historySnippet(w, r, url)
+	if !ok {
+		return
+	}
+
+	n, _ := strconv.Atoi(mux.Vars(r)["n"])
+	i := slices.IndexFunc(snippet.History, func(v SnippetVersion) bool { return v.Number == n })
+	if i < 0 {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, snippet.History[i].Text)
+}
+
+// historySnippet looks up a snippet whose history is asked for, answering
+// the request itself if it's missing or the password is needed and wrong.
+// Burn-after-reading snippets can't be appended to, so they have no history;
+// looking at it doesn't burn them.
+func historySnippet(w http.ResponseWriter, r *http.Request, url string) (Snippet, bool) {
+	snippetsMu.RLock()
+	snippet, ok := snippets[url]
+	snippetsMu.RUnlock()
+	if !ok || snippet.expired(time.Now()) {
+		http.Error(w, "Snippet not found", http.StatusNotFound)
+		return Snippet{}, false
+	}
+	if !checkRawPassword(w, r, url, snippet) {
+		return Snippet{}, false
+	}
+	return snippet, true
+}
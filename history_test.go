@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupHistoryTest stores one snippet that can be appended to with
+// "secret-token", keeping keep versions, and returns a router to call
+func setupHistoryTest(t *testing.T, keep int) http.Handler {
+	t.Helper()
+
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	cfg.KeepHistory = keep
+
+	snippets = map[string]Snippet{
+		"log": {Title: "Log", Text: "first", DeleteTokenHash: hashDeleteToken("secret-token"), CreatedAt: time.Now()},
+	}
+	return newRouter()
+}
+
+// appendTo appends text to a snippet through the router
+func appendTo(t *testing.T, router http.Handler, id, text string) {
+	t.Helper()
+	form := url.Values{"text": {text}, "token": {"secret-token"}}
+	req := httptest.NewRequest("POST", "/append/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Append status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+}
+
+// routerGet fetches path through the router
+func routerGet(router http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+// Test after two edits both earlier versions are listed and can be fetched,
+// while the snippet itself shows the latest text
+func TestHistory(t *testing.T) {
+	router := setupHistoryTest(t, 5)
+	appendTo(t, router, "log", "second")
+	appendTo(t, router, "log", "third")
+
+	w := routerGet(router, "/history/log")
+	if w.Code != http.StatusOK {
+		t.Fatalf("History status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var listing HistoryJSON
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("History isn't JSON: %v", err)
+	}
+	if len(listing.Versions) != 2 {
+		t.Fatalf("History lists %d versions, want 2", len(listing.Versions))
+	}
+	if listing.Versions[1].URL != "http://example.com/history/log/2" {
+		t.Errorf("Version URL = %q", listing.Versions[1].URL)
+	}
+
+	for n, want := range map[string]string{"1": "first", "2": "first\nsecond"} {
+		if w := routerGet(router, "/history/log/"+n); w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("Version %s = %d %q, want %q", n, w.Code, w.Body.String(), want)
+		}
+	}
+	if w := routerGet(router, "/history/log/3"); w.Code != http.StatusNotFound {
+		t.Errorf("Missing version status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := routerGet(router, "/raw/log"); w.Body.String() != "first\nsecond\nthird" {
+		t.Errorf("Current text = %q", w.Body.String())
+	}
+}
+
+// Test the oldest versions are dropped past keep_history, the rest keep
+// their numbers, and nothing is kept with it off
+func TestHistory_Cap(t *testing.T) {
+	router := setupHistoryTest(t, 1)
+	appendTo(t, router, "log", "second")
+	appendTo(t, router, "log", "third")
+
+	if w := routerGet(router, "/history/log/1"); w.Code != http.StatusNotFound {
+		t.Errorf("Dropped version status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := routerGet(router, "/history/log/2"); w.Body.String() != "first\nsecond" {
+		t.Errorf("Version 2 = %q", w.Body.String())
+	}
+
+	cfg.KeepHistory = 0
+	snippets["off"] = Snippet{Text: "one", DeleteTokenHash: hashDeleteToken("secret-token")}
+	appendTo(t, router, "off", "two")
+	if history := snippets["off"].History; len(history) != 0 {
+		t.Errorf("History kept with keep_history off: %v", history)
+	}
+}
+
+// Test history is saved with the snippet, and encrypted along with it
+func TestHistory_Persisted(t *testing.T) {
+	router := setupHistoryTest(t, 5)
+	cfg.EncryptionKey = testEncryptionKey
+	snippets["log"] = Snippet{Text: "hunter2", DeleteTokenHash: hashDeleteToken("secret-token")}
+	appendTo(t, router, "log", "changed")
+	saveSnippetsToFile(snippetsFile)
+
+	data, _ := os.ReadFile(snippetsFile)
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("snippets.json contains an earlier version in plain text")
+	}
+
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	if w := routerGet(router, "/history/log/1"); w.Body.String() != "hunter2" {
+		t.Errorf("Version 1 after reload = %q, want hunter2", w.Body.String())
+	}
+}
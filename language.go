@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// languagePlain is stored when a snippet's language is unknown
+const languagePlain = "plain"
+
+// languages are the languages a snippet can be tagged with, in the order
+// detectLanguage prefers them when scores tie
+var languages = []string{"go", "python", "javascript", "shell", "ruby", "sql", "html", "json", "yaml", languagePlain}
+
+// shebangLanguages maps interpreters named on a #! line to languages
+var shebangLanguages = map[string]string{
+	"python": "python", "python3": "python", "python2": "python",
+	"sh": "shell", "bash": "shell", "zsh": "shell",
+	"node": "javascript", "ruby": "ruby",
+}
+
+// languageHints are patterns typical of each language. Each one that shows up
+// in the text counts as a point.
+var languageHints = map[string][]*regexp.Regexp{
+	"go": {
+		regexp.MustCompile(`(?m)^package \w+$`),
+		regexp.MustCompile(`(?m)^func .*\{$`),
+		regexp.MustCompile(`\w+ := `),
+		regexp.MustCompile(`(?m)^import \($`),
+		regexp.MustCompile(`\bfmt\.\w+\(`),
+	},
+	"python": {
+		regexp.MustCompile(`(?m)^\s*def \w+\(.*\):$`),
+		regexp.MustCompile(`(?m)^\s*(from \w+ )?import \w+`),
+		regexp.MustCompile(`\bself\.`),
+		regexp.MustCompile(`(?m)^\s*(elif|else|try|except.*|for .* in .*|if .*):$`),
+		regexp.MustCompile(`\bprint\(`),
+		regexp.MustCompile(`if __name__ == .__main__.:`),
+	},
+	"javascript": {
+		regexp.MustCompile(`\bfunction\s*\w*\(`),
+		regexp.MustCompile(`(?m)^\s*(const|let|var) \w+ =`),
+		regexp.MustCompile(`=>`),
+		regexp.MustCompile(`\bconsole\.log\(`),
+		regexp.MustCompile(`\brequire\(['"]`),
+	},
+	"shell": {
+		regexp.MustCompile(`(?m)^\s*(echo|export|cd|sudo) `),
+		regexp.MustCompile(`(?m)^\s*(fi|done|esac)$`),
+		regexp.MustCompile(`\$\{?\w+\}?`),
+		regexp.MustCompile(`(?m)^\s*if \[`),
+	},
+	"ruby": {
+		regexp.MustCompile(`(?m)^\s*def \w+[^:]*$`),
+		regexp.MustCompile(`(?m)^\s*end$`),
+		regexp.MustCompile(`\bputs `),
+		regexp.MustCompile(`\bdo \|\w+\|`),
+	},
+	"sql": {
+		regexp.MustCompile(`(?i)\bselect\b.+\bfrom\b`),
+		regexp.MustCompile(`(?i)\b(insert into|create table|update \w+ set|delete from)\b`),
+		regexp.MustCompile(`(?i)\bwhere\b`),
+	},
+}
+
+// minLanguageScore is how many hints a language needs before we'll guess it
+const minLanguageScore = 2
+
+// validLanguage reports whether language can be stored on a snippet; empty
+// means detect it.
+func validLanguage(language string) bool {
+	if language == "" {
+		return true
+	}
+	for _, known := range languages {
+		if language == known {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLanguage makes a cheap best guess at the language a snippet is
+// written in, from its shebang or the shape of the text, returning
+// languagePlain when nothing stands out. It doesn't fail; a wrong guess only
+// affects highlighting.
+func detectLanguage(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		return languagePlain
+	}
+
+	if language, ok := shebangLanguage(trimmed); ok {
+		return language
+	}
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	lower := strings.ToLower(trimmed)
+	if strings.HasPrefix(lower, "<!doctype html") || strings.HasPrefix(lower, "<html") {
+		return "html"
+	}
+
+	best, bestScore := languagePlain, 0
+	for _, language := range languages {
+		score := 0
+		for _, hint := range languageHints[language] {
+			if hint.MatchString(text) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = language, score
+		}
+	}
+	if bestScore < minLanguageScore {
+		return languagePlain
+	}
+	return best
+}
+
+// shebangLanguage reads the interpreter off a leading "#!" line, handling
+// both "#!/usr/bin/python3" and "#!/usr/bin/env python3".
+func shebangLanguage(text string) (string, bool) {
+	if !strings.HasPrefix(text, "#!") {
+		return "", false
+	}
+	line, _, _ := strings.Cut(text[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false
+	}
+	interpreter := fields[0]
+	if strings.HasSuffix(interpreter, "/env") && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	interpreter = interpreter[strings.LastIndex(interpreter, "/")+1:]
+	language, ok := shebangLanguages[interpreter]
+	return language, ok
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test detectLanguage guesses obvious samples and falls back to plain
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"go", "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tmsg := \"hi\"\n\tfmt.Println(msg)\n}\n", "go"},
+		{"python", "import os\n\ndef main():\n    for name in os.listdir('.'):\n        print(name)\n\nif __name__ == '__main__':\n    main()\n", "python"},
+		{"python shebang", "#!/usr/bin/env python3\nx = 1\n", "python"},
+		{"shell shebang", "#!/bin/bash\nls\n", "shell"},
+		{"shell", "export PATH=$HOME/bin:$PATH\nif [ -f x ]; then\n  echo yes\nfi\n", "shell"},
+		{"javascript", "const express = require('express');\nconst app = express();\napp.get('/', (req, res) => res.send('hi'));\n", "javascript"},
+		{"json", `{"name": "pasty", "port": 3015}`, "json"},
+		{"html", "<!DOCTYPE html>\n<html><body>hi</body></html>", "html"},
+		{"sql", "SELECT id, name FROM users WHERE active = 1;", "sql"},
+		{"prose", "Remember to buy milk and eggs on the way home.", languagePlain},
+		{"empty", "", languagePlain},
+		{"unknown shebang", "#!/usr/bin/tclsh\nputs hi\n", languagePlain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectLanguage(tt.text); got != tt.want {
+				t.Errorf("detectLanguage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test handleSave stores the chosen language, or a detected one
+func TestHandleSave_Language(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+
+	goSource := "package main\n\nfunc main() {\n\tx := 1\n}\n"
+	tests := []struct {
+		name       string
+		language   string
+		wantStatus int
+		want       string
+	}{
+		{"detected", "", http.StatusSeeOther, "go"},
+		{"chosen", "plain", http.StatusSeeOther, "plain"},
+		{"unknown", "cobol", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+
+			form := url.Values{"title": {"T"}, "text": {goSource}, "language": {tt.language}}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handleSave() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			for _, snippet := range snippets {
+				if snippet.Language != tt.want {
+					t.Errorf("Language = %q, want %q", snippet.Language, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Log lines have a level, and only those at cfg.LogLevel or above are
+// written. Routine confirmations such as saves and downloads are debug;
+// startup, shutdown and the background sweeps are info; turned-away
+// requests and things needing attention are warn; failures are error.
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// logLevels maps the log_level setting to its level
+var logLevels = map[string]int{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// validLogLevel reports whether level is one log_level can be set to
+func validLogLevel(level string) bool {
+	_, ok := logLevels[strings.ToLower(level)]
+	return ok
+}
+
+// logEnabled reports whether lines at level are written under cfg.LogLevel.
+// An unset level means info.
+func logEnabled(level int) bool {
+	configured, ok := logLevels[strings.ToLower(cfg.LogLevel)]
+	if !ok {
+		configured = levelInfo
+	}
+	return level >= configured
+}
+
+// logDebugf logs routine confirmations nobody needs to see day to day
+func logDebugf(format string, args ...any) {
+	if logEnabled(levelDebug) {
+		log.Printf(format, args...)
+	}
+}
+
+// logInfof logs startup, shutdown and other events worth a line
+func logInfof(format string, args ...any) {
+	if logEnabled(levelInfo) {
+		log.Printf(format, args...)
+	}
+}
+
+// logWarnf logs things an operator may want to look at
+func logWarnf(format string, args ...any) {
+	if logEnabled(levelWarn) {
+		log.Printf(format, args...)
+	}
+}
+
+// logErrorf logs failures. They're written at every level.
+func logErrorf(format string, args ...any) {
+	log.Printf(format, args...)
+}
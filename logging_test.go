@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test each level lets through itself and anything more severe
+func TestLogEnabled(t *testing.T) {
+	tests := []struct {
+		configured string
+		level      int
+		want       bool
+	}{
+		{"debug", levelDebug, true},
+		{"info", levelDebug, false},
+		{"info", levelInfo, true},
+		{"warn", levelInfo, false},
+		{"warn", levelWarn, true},
+		{"warn", levelError, true},
+		{"error", levelWarn, false},
+		{"ERROR", levelError, true},
+		{"", levelDebug, false},
+		{"", levelInfo, true},
+	}
+
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	for _, tt := range tests {
+		cfg.LogLevel = tt.configured
+		if got := logEnabled(tt.level); got != tt.want {
+			t.Errorf("logEnabled(%d) at %q = %v, want %v", tt.level, tt.configured, got, tt.want)
+		}
+	}
+}
+
+// Test at warn a routine save logs nothing, but a failed one still does
+func TestLogLevel_Warn(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		log.SetOutput(os.Stderr)
+	})
+	cfg.LogLevel = "warn"
+	snippets = map[string]Snippet{"abc": {Text: "hello"}}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	saveSnippetsToFile(filepath.Join(t.TempDir(), "snippets.json"))
+	if logged.Len() != 0 {
+		t.Errorf("Routine save logged %q at warn", logged.String())
+	}
+
+	saveSnippetsToFile(filepath.Join(t.TempDir(), "missing", "snippets.json"))
+	if !strings.Contains(logged.String(), "Error writing temp file") {
+		t.Errorf("Failed save logged %q, want the error", logged.String())
+	}
+}
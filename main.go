@@ -1,123 +1,462 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math/rand"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"text/template"
+	"time"
+	"unicode"
 
 	"github.com/gorilla/mux"
-	qrcode "github.com/skip2/go-qrcode"
 )
 
 // Snippet holds the title and text of a paste
 type Snippet struct {
-	Title            string `json:"title"`
-	Text             string `json:"text"`
-	BurnAfterReading bool   `json:"burn_after_reading"`
+	Title            string           `json:"title"`
+	Text             string           `json:"text"`
+	BurnAfterReading bool             `json:"burn_after_reading"`
+	DeleteTokenHash  string           `json:"delete_token_hash,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	Owner            string           `json:"owner,omitempty"`     // client cert CN under mTLS
+	Render           string           `json:"render,omitempty"`    // "plain" (or empty) or "markdown"
+	Encrypted        bool             `json:"encrypted,omitempty"` // Text is encrypted; only ever set on disk
+	ExpiresAt        time.Time        `json:"expires_at,omitzero"` // zero means never
+	Views            int              `json:"views,omitempty"`
+	Language         string           `json:"language,omitempty"`        // for highlighting; detected when not given
+	Visibility       string           `json:"visibility,omitempty"`      // "public" (or empty) or "unlisted"
+	ModifiedAt       time.Time        `json:"modified_at,omitzero"`      // last append; zero if never changed
+	Immutable        bool             `json:"immutable,omitempty"`       // write-once: never deleted, appended to, burned or evicted
+	LastViewed       time.Time        `json:"last_viewed,omitzero"`      // zero if never viewed
+	TextGzip         []byte           `json:"text_gzip,omitempty"`       // Text gzipped when it's big, Text then being empty; see Body
+	PasswordHash     string           `json:"password_hash,omitempty"`   // bcrypt; empty means not protected
+	FailedAttempts   int              `json:"failed_attempts,omitempty"` // wrong passwords since the last right one
+	Tags             []string         `json:"tags,omitempty"`            // normalized; see parseTags
+	History          []SnippetVersion `json:"history,omitempty"`         // earlier texts, oldest first; see keepVersion
+}
+
+// burns reports whether reading the snippet should burn it. Immutable
+// snippets never burn, whatever they were imported with.
+func (s Snippet) burns() bool {
+	return s.BurnAfterReading && !s.Immutable
+}
+
+// Snippet visibilities. Unlisted snippets work by direct link but are left
+// out of the index and listings.
+const (
+	visibilityPublic   = "public"
+	visibilityUnlisted = "unlisted"
+)
+
+// validVisibility reports whether v can be used for a snippet. Empty means
+// public.
+func validVisibility(v string) bool {
+	return v == "" || v == visibilityPublic || v == visibilityUnlisted
 }
 
 // Global map: snippet ID -> Snippet
 var snippets = make(map[string]Snippet)
 
+// snippetsMu guards the snippets map. Handlers take the write lock to mutate
+// and the read lock to read; saveSnippetsToFile takes the read lock itself, so
+// don't call it while holding the write lock.
+var snippetsMu sync.RWMutex
+
 // Global paths for data storage
 var (
-	snippetsFile string
-	uploadsDir   string
+	snippetsFile  string
+	uploadsDir    string
+	quarantineDir string
 )
 
 // Templates
 var (
-	tmplIndex       *template.Template
-	tmplDisplay     *template.Template
-	tmplDisplayFile *template.Template
-	tmplView        *template.Template
+	tmplIndex        *template.Template
+	tmplDisplay      *template.Template
+	tmplDisplayFile  *template.Template
+	tmplDownloadFile *template.Template
+	tmplView         *template.Template
+	tmplNotFound     *template.Template
+	tmplReveal       *template.Template
+	tmplUnlock       *template.Template
 )
 
 // Data structures for templates
+
+// Branding is embedded in every page's template data
+type Branding struct {
+	SiteTitle   string `json:"site_title"`
+	SiteTagline string `json:"site_tagline"`
+	BasePath    string `json:"base_path"` // prefix for every link, e.g. "/pasty"
+	LogoURL     string `json:"logo_url,omitempty"`
+	CustomCSS   string `json:"custom_css,omitempty"` // URL of custom_css_path under /static/
+}
+
+// siteBranding returns the configured branding for templates
+func siteBranding() Branding {
+	b := Branding{
+		SiteTitle:   cfg.SiteTitle,
+		SiteTagline: cfg.SiteTagline,
+		BasePath:    cfg.BasePath,
+		LogoURL:     cfg.LogoURL,
+	}
+	if cfg.CustomCSSPath != "" {
+		b.CustomCSS = sitePath("/static/" + filepath.ToSlash(cfg.CustomCSSPath))
+	}
+	return b
+}
+
+// sitePath prefixes an app path such as "/display/abc" with the configured
+// base path, for links and redirects.
+func sitePath(path string) string {
+	return cfg.BasePath + path
+}
+
+// absoluteURL builds a full link to an app path on this server, for QR
+// codes, API responses and messages that are read somewhere else. The host
+// is cfg.DomainName when set, or the one the request was made to.
+func absoluteURL(r *http.Request, path string) string {
+	host := r.Host
+	if cfg.DomainName != "" {
+		host = cfg.DomainName
+	}
+	return fmt.Sprintf("%s://%s%s", scheme(r), host, sitePath(path))
+}
+
+// currentURL is the absolute URL of the page being requested, query
+// included.
+func currentURL(r *http.Request) string {
+	return absoluteURL(r, strings.TrimPrefix(r.URL.RequestURI(), cfg.BasePath))
+}
+
+// scheme tries to detect http vs https. Behind a trusted proxy TLS ends at
+// the proxy, so its X-Forwarded-Proto says what the client used.
+func scheme(r *http.Request) string {
+	if cfg.TrustProxy {
+		switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// SnippetJSON is a snippet as /display/{url}.json shows it. With
+// cfg.BurnConfirm set, a burn-after-reading snippet comes back without its
+// text and with RevealURL, which a POST must be sent to for it. So does a
+// password-protected one, whose POST needs the password.
+type SnippetJSON struct {
+	ID               string     `json:"id"`
+	Title            string     `json:"title"`
+	Text             string     `json:"text,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	Views            int        `json:"views"`
+	BurnAfterReading bool       `json:"burn_after_reading"`
+	ExpiresAt        *time.Time `json:"expires_at"` // null means never
+	RevealURL        string     `json:"reveal_url,omitempty"`
+	PasswordRequired bool       `json:"password_required,omitempty"`
+	Tags             []string   `json:"tags,omitempty"`
+}
+
 type DisplayData struct {
-	ID         string
-	Title      string
-	Text       string
-	Link       string
-	HomeQRCode string
+	Branding
+	ID          string
+	Title       string
+	Text        string
+	Link        string
+	HomeQRCode  string
+	DeleteToken string // only set for the creator, who arrives with ?token=
+	LineNumbers bool   // ?nums=1
+	Wrap        bool   // soft-wrap long lines, turned off with ?wrap=0
+	Lines       []NumberedLine
+	ReadOnly    bool
+	Immutable   bool   // no delete form or token
+	Markdown    bool   // render HTML instead of the plain text
+	HTML        string // sanitized markdown output
+	Tags        []string
+	Versions    int // earlier versions kept, listed at /history/{id}
+}
+
+// NumberedLine is one line of snippet text for the line-numbered view
+type NumberedLine struct {
+	Number int
+	Text   string
 }
 
+// The index data is also served as JSON (serveIndex with ?format=json)
+
 type FileEntry struct {
-	ID   string
-	Name string
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	Size       int64     `json:"size"`
+	SizeHuman  string    `json:"size_human"`
+	Checksum   string    `json:"checksum,omitempty"`
 }
 type IndexData struct {
-	Snippets   []SnippetInfo
-	Files      []FileEntry
-	HomeQRCode string
+	Branding
+	Snippets   []SnippetInfo `json:"snippets"`
+	Files      []FileEntry   `json:"files"`
+	HomeQRCode string        `json:"-"`
+	ReadOnly   bool          `json:"read_only"`
+
+	DefaultBurn     bool `json:"default_burn"`
+	AllowBurnToggle bool `json:"allow_burn_toggle"`
+	MaxSnippetBytes int  `json:"max_snippet_bytes"`
+	InviteRequired  bool `json:"invite_required"`
+
+	Tag string `json:"tag,omitempty"` // set on /tag/{tag}, which lists only its snippets
+
+	// Set when a paste is sent back to the form because it didn't validate
+	SaveError  string `json:"-"`
+	DraftTitle string `json:"-"`
+	DraftText  string `json:"-"`
 }
 
 // For the index page table (snippet list)
 type SnippetInfo struct {
-	ID            string
-	Title         string
-	TruncatedText string
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	TruncatedText string    `json:"truncated_text"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at,omitzero"`
+	Views         int       `json:"views"`
+	Tags          []string  `json:"tags,omitempty"`
 }
 
-// Names of snippet URLs use these simple options
-var snippetChars = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+// Custom slugs are letters, digits and dashes
+var slugPattern = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
 
-// randomString generates a random string of length n from snippetChars.
+const maxSlugLength = 64
+
+// reservedSlugs can't be used as custom slugs since they name routes or
+// would be confusing next to them
+var reservedSlugs = map[string]bool{
+	"save": true, "display": true, "delete": true, "export": true, "import": true,
+	"upload": true, "file": true, "view": true, "stream": true, "download": true,
+	"api": true, "admin": true, "raw": true, "static": true, "reveal": true,
+	"unlock": true, "qr": true, "archive": true, "append": true, "tag": true,
+	"upload-url": true, "rotate-file": true, "delete-multiple": true, "healthz": true,
+	"history": true,
+}
+
+// validateSlug checks a custom slug is usable as a snippet ID. It doesn't
+// check whether the slug is already taken.
+func validateSlug(slug string) error {
+	if len(slug) > maxSlugLength {
+		return fmt.Errorf("custom name must be at most %d characters", maxSlugLength)
+	}
+	if !slugPattern.MatchString(slug) {
+		return errors.New("custom name may only contain letters, digits and dashes")
+	}
+	if reservedSlugs[strings.ToLower(slug)] {
+		return fmt.Errorf("the name %q is reserved, pick another one", slug)
+	}
+	return nil
+}
+
+// Names of snippet URLs use these simple options (base62)
+const snippetChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randomString generates a random string of length n from snippetChars
+// using crypto/rand.
 func randomString(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = snippetChars[rand.Intn(len(snippetChars))]
+	return randomStringFrom(snippetChars, n)
+}
+
+// randomStringFrom generates a random string of length n from the
+// characters in alphabet, which must be ASCII and at most 256 of them.
+func randomStringFrom(alphabet string, n int) string {
+	// Bytes at or above this are thrown away so every character is equally
+	// likely (256 is rarely a multiple of the alphabet's size)
+	limit := 256 - 256%len(alphabet)
+
+	b := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(b) < n {
+		rand.Read(buf) // never fails since Go 1.24
+		for _, c := range buf {
+			if int(c) < limit && len(b) < n {
+				b = append(b, alphabet[int(c)%len(alphabet)])
+			}
+		}
 	}
 	return string(b)
 }
 
 func main() {
-	// Parse command-line flags
-	host := flag.String("host", "localhost", "Host to listen on")
-	port := flag.String("port", "3015", "Port to listen on")
-	datadir := flag.String("datadir", ".", "Directory for data files (snippets.json and uploads)")
-	flag.Parse()
+	// Parse command-line flags before anything is loaded
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if opts.Validate {
+		os.Exit(checkConfigFile(opts.ConfigFile, os.Stdout, os.Stderr))
+	}
 
-	// Set up data directory paths (global variables for handlers)
-	snippetsFile = filepath.Join(*datadir, "snippets.json")
-	uploadsDir = filepath.Join(*datadir, "uploads")
+	config, err := LoadConfig(opts.ConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", opts.ConfigFile, err)
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid %s: %v", opts.ConfigFile, err)
+	}
+	cfg = config
+	logInfof("Loaded config: %s", cfg)
+	uploadSlots = newUploadSlots(cfg.MaxConcurrentUploads)
 
-	// Ensure uploads directory exists
-	os.MkdirAll(uploadsDir, 0755)
+	opts = opts.withDataDir(cfg.DataDir)
+	if err := useDataPaths(opts); err != nil {
+		log.Fatalf("Could not create data directory %s: %v", opts.DataDir, err)
+	}
+	if err := checkUploadsDir(uploadsDir); err != nil {
+		log.Fatalf("Cannot store uploads: %v", err)
+	}
 
 	loadSnippetsFromFile(snippetsFile)
+	replaySnippetsWAL()
+	loadFilesFromFile(filesFile)
 
-	tmplIndex = parseTemplate("templates/index.html")
-	tmplDisplay = parseTemplate("templates/display.html")
-	tmplDisplayFile = parseTemplate("templates/display_file.html")
-	tmplView = parseTemplate("templates/view.html")
+	tmplIndex = parseTemplate("index.html")
+	tmplDisplay = parseTemplate("display.html")
+	tmplDisplayFile = parseTemplate("display_file.html")
+	tmplDownloadFile = parseTemplate("download_file.html")
+	tmplView = parseTemplate("view.html")
+	tmplNotFound = parseTemplate("notfound.html")
+	tmplReveal = parseTemplate("reveal.html")
+	tmplUnlock = parseTemplate("unlock.html")
 
-	r := mux.NewRouter()
-	r.HandleFunc("/", serveIndex).Methods("GET")
-	r.HandleFunc("/save", handleSave).Methods("POST")
-	r.HandleFunc("/display/{url}", displaySnippet).Methods("GET")
-	r.HandleFunc("/delete/{url}", deleteSnippet).Methods("POST")
+	reconcileUploads(time.Now())
+	startOrphanSweeper(time.Duration(cfg.OrphanSweepInterval) * time.Second)
+	startUploadSessionCleanup()
+	startExpirySweeper()
+	startSnippetsFlusher(time.Duration(cfg.SaveInterval) * time.Second)
+	setupGracefulShutdown()
 
-	r.HandleFunc("/upload", uploadFileHandler).Methods("POST")
-	r.HandleFunc("/file/{id}", displayFileHandler).Methods("GET")
-	r.HandleFunc("/view/{id}", viewFileHandler).Methods("GET")
-	r.HandleFunc("/stream/{id}", streamFileHandler).Methods("GET")
-	r.HandleFunc("/download/{id}", downloadFileHandler).Methods("GET")
+	addr := fmt.Sprintf("%s:%s", opts.Host, opts.Port)
+	server := newServer(addr, cfg)
 
-	setupGracefulShutdown()
+	if cfg.SSLEnabled {
+		tlsConfig, err := buildTLSConfig(cfg)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+
+		if cfg.RedirectHTTP {
+			go serveHTTPSRedirect(opts.Host, opts.Port, cfg)
+		}
+
+		fmt.Printf("Server is running at https://%s/\n", addr)
+		log.Fatal(server.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile))
+	}
 
-	addr := fmt.Sprintf("%s:%s", *host, *port)
 	fmt.Printf("Server is running at http://%s/\n", addr)
-	log.Fatal(http.ListenAndServe(addr, r))
+	log.Fatal(server.ListenAndServe())
+}
+
+// newServer builds the HTTP server with the configured timeouts. The same
+// server is used for HTTP and HTTPS.
+func newServer(addr string, config Config) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           recoverMiddleware(recordResponses(newRouter())),
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+	}
+}
+
+// newRouter sets up all of the app's routes
+func newRouter() *mux.Router {
+	root := mux.NewRouter()
+	root.Use(readOnlyMiddleware, corsMiddleware, apiTokenMiddleware)
+	root.NotFoundHandler = notFoundHandler(root)
+	root.MethodNotAllowedHandler = methodNotAllowedHandler(root)
+
+	// Behind a reverse proxy everything can live under a base path
+	r := root
+	if cfg.BasePath != "" {
+		root.Handle(cfg.BasePath, http.RedirectHandler(cfg.BasePath+"/", http.StatusMovedPermanently))
+		r = root.PathPrefix(cfg.BasePath).Subrouter()
+	}
+
+	// Static assets go first so nothing below can claim their paths
+	r.Handle("/favicon.ico", faviconHandler(cfg.StaticDir)).Methods("GET")
+	r.PathPrefix("/static/").Handler(staticHandler(sitePath("/static/"), cfg.StaticDir)).Methods("GET")
+
+	// Routes that show content, which require_auth_to_view puts behind
+	// the same auth as the API
+	view := func(path string, handler http.Handler) *mux.Route {
+		return r.Handle(path, viewAuthMiddleware(handler))
+	}
+
+	r.HandleFunc("/healthz", healthHandler).Methods("GET")
+	view("/", http.HandlerFunc(serveIndex)).Methods("GET")
+	r.HandleFunc("/", handleRootPost).Methods("POST")
+	r.HandleFunc("/save", handleSave).Methods("POST")
+	view("/display/{url}", http.HandlerFunc(displaySnippet)).Methods("GET")
+	view("/reveal/{url}", http.HandlerFunc(revealSnippet)).Methods("POST")
+	view("/raw/{url}", http.HandlerFunc(rawSnippet)).Methods("GET")
+	view("/history/{url}", http.HandlerFunc(historyHandler)).Methods("GET")
+	view("/history/{url}/{n:[0-9]+}", http.HandlerFunc(historyVersionHandler)).Methods("GET")
+	view("/tag/{tag}", http.HandlerFunc(tagHandler)).Methods("GET")
+	r.HandleFunc("/delete/{url}", deleteSnippet).Methods("POST")
+	r.HandleFunc("/delete-multiple", deleteMultipleSnippets).Methods("POST")
+	r.HandleFunc("/append/{url}", appendSnippet).Methods("POST")
+	view("/export", http.HandlerFunc(exportSnippetsHandler)).Methods("GET")
+	r.Handle("/import", longTransfer(importSnippetsHandler)).Methods("POST")
+	r.HandleFunc("/admin/purge", purgeHandler).Methods("POST")
+	r.HandleFunc("/api/snippets", apiSnippetsHandler).Methods("GET")
+	r.HandleFunc("/api/snippets", apiCreateSnippetHandler).Methods("POST")
+	r.HandleFunc("/api/files", apiFilesHandler).Methods("GET")
+	// Not .Methods("OPTIONS"), which would turn unknown API paths into 405s
+	r.PathPrefix("/api/").MatcherFunc(isPreflight).HandlerFunc(corsPreflight)
+	view("/qr/snippet/{url}", http.HandlerFunc(snippetQRHandler)).Methods("GET")
+	view("/qr/file/{id}", http.HandlerFunc(fileQRHandler)).Methods("GET")
+
+	// Big uploads and downloads can take longer than the server timeouts
+	r.Handle("/upload", longTransfer(uploadFileHandler)).Methods("POST")
+	r.Handle("/upload-url", longTransfer(fetchUploadHandler)).Methods("POST")
+	r.HandleFunc("/upload/init", initUploadHandler).Methods("POST")
+	r.HandleFunc("/upload/{uploadID}", uploadStatusHandler).Methods("GET")
+	r.HandleFunc("/upload/{uploadID}/complete", completeUploadHandler).Methods("POST")
+	r.Handle("/upload/{uploadID}/{chunkIndex}", longTransfer(uploadChunkHandler)).Methods("PUT")
+	view("/file/{id}", http.HandlerFunc(displayFileHandler)).Methods("GET")
+	view("/archive/{id}", http.HandlerFunc(archiveHandler)).Methods("GET")
+	view("/unlock/{id}", http.HandlerFunc(unlockFileHandler)).Methods("POST")
+	r.HandleFunc("/rotate-file/{id}", rotateFileHandler).Methods("POST")
+	view("/view/{id}", http.HandlerFunc(viewFileHandler)).Methods("GET")
+	view("/stream/{id}", longTransfer(streamFileHandler)).Methods("GET")
+	view("/download/{id}", longTransfer(downloadFileHandler)).Methods("GET")
+
+	return root
 }
 
 // setupGracefulShutdown sets up a handler for OS signals (Ctrl+C, SIGTERM)
@@ -128,8 +467,10 @@ func setupGracefulShutdown() {
 
 	go func() {
 		<-sigChan
-		log.Println("Gracefully shutting down...")
+		logInfof("Gracefully shutting down...")
+		// Writes out anything the flusher hasn't got to yet
 		saveSnippetsToFile(snippetsFile)
+		saveFilesToFile(filesFile)
 		os.Exit(0)
 	}()
 }
@@ -137,7 +478,7 @@ func setupGracefulShutdown() {
 // loadSnippetsFromFile loads snippet data from JSON into the global `snippets` map.
 func loadSnippetsFromFile(filename string) {
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		log.Printf("No %s file found, starting with empty data.\n", filename)
+		logInfof("No %s file found, starting with empty data.\n", filename)
 		return
 	}
 
@@ -150,174 +491,1108 @@ func loadSnippetsFromFile(filename string) {
 	decoder := json.NewDecoder(file)
 	err = decoder.Decode(&snippets)
 	if err != nil {
-		log.Fatalf("Failed to decode JSON from %s: %v", filename, err)
+		// Better to come up empty than not at all; the bad file is kept
+		// for recovery
+		logErrorf("Failed to decode JSON from %s: %v", filename, err)
+		file.Close()
+		backupCorruptFile(filename)
+		snippets = make(map[string]Snippet)
+		return
 	}
 
-	log.Printf("Loaded %d snippets from %s.\n", len(snippets), filename)
+	key, err := decodeEncryptionKey(cfg.EncryptionKey)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+	if err := decryptSnippets(snippets, key); err != nil {
+		log.Fatalf("Failed to load %s: %v", filename, err)
+	}
+	compressSnippets(snippets)
+
+	logInfof("Loaded %d snippets from %s.\n", len(snippets), filename)
+}
+
+// backupCorruptFile moves a data file that won't load out of the way, to
+// <filename>.corrupt.<timestamp>, so the next save doesn't overwrite it.
+func backupCorruptFile(filename string) {
+	backup := filename + ".corrupt." + time.Now().Format("20060102-150405")
+	if err := os.Rename(filename, backup); err != nil {
+		logErrorf("Error backing up %s: %v", filename, err)
+		return
+	}
+	logWarnf("Moved unreadable %s to %s, starting with empty data.", filename, backup)
 }
 
 // saveSnippetsToFile saves the global `snippets` map to disk as JSON.
 // This is a cheap storage option for now. Maybe use sqlite later IDK
 func saveSnippetsToFile(filename string) {
-	data, err := json.MarshalIndent(snippets, "", "  ")
+	key, err := decodeEncryptionKey(cfg.EncryptionKey)
 	if err != nil {
-		log.Printf("Error marshaling snippets data: %v", err)
+		logErrorf("Error saving snippets: %v", err)
+		return
+	}
+
+	// One save at a time, so two can't trample each other's temp file
+	savedMu.Lock()
+	defer savedMu.Unlock()
+
+	snippetsMu.RLock()
+	toSave := snippets
+	if !cfg.CompressSnippetsOnDisk || key != nil {
+		toSave = plainSnippets(snippets)
+	}
+	// The plaintext JSON tells us whether anything changed; the encrypted
+	// form is different every time
+	data, err := json.MarshalIndent(toSave, "", "  ")
+	sum := sha256.Sum256(data)
+	unchanged := err == nil && savedSums[filename] == sum
+	if err == nil && !unchanged && key != nil {
+		var encrypted map[string]Snippet
+		encrypted, err = encryptSnippets(toSave, key)
+		if err == nil {
+			data, err = json.MarshalIndent(encrypted, "", "  ")
+		}
+	}
+	count := len(snippets)
+	if err == nil {
+		// What's logged from here on isn't in this snapshot
+		rotateWAL()
+	}
+	snippetsMu.RUnlock()
+	if err != nil {
+		logErrorf("Error marshaling snippets data: %v", err)
+		return
+	}
+	if unchanged {
+		compactedWAL()
 		return
 	}
 
 	tmpFile := filename + ".tmp"
 	if err = os.WriteFile(tmpFile, data, 0644); err != nil {
-		log.Printf("Error writing temp file %s: %v", tmpFile, err)
+		logErrorf("Error writing temp file %s: %v", tmpFile, err)
 		return
 	}
 
 	// try to be atomic and stuff
 	if err = os.Rename(tmpFile, filename); err != nil {
-		log.Printf("Error renaming temp file: %v", err)
+		logErrorf("Error renaming temp file: %v", err)
 		return
 	}
+	savedSums[filename] = sum
+	compactedWAL()
 
-	log.Printf("Successfully saved %d snippets to %s.\n", len(snippets), filename)
+	logDebugf("Successfully saved %d snippets to %s.\n", count, filename)
+	warnSnippetSoftLimit(count)
 }
 
 // parseTemplate is a helper to parse a single template file.
-func parseTemplate(path string) *template.Template {
-	tmpl, err := template.ParseFiles(filepath.Clean(path))
+func parseTemplate(name string) *template.Template {
+	path := filepath.Join(cfg.TemplateDir, name)
+	tmpl, err := template.ParseFiles(path)
 	if err != nil {
 		log.Fatalf("Error parsing template %s: %v", path, err)
 	}
 	return tmpl
 }
 
+// currentTemplate returns the template a request should render with. In dev
+// mode the file is parsed again every time so edits show up without a
+// restart; an edit that doesn't parse is logged and the last good template
+// is used instead.
+func currentTemplate(cached *template.Template, name string) *template.Template {
+	if !cfg.DevMode {
+		return cached
+	}
+	path := filepath.Join(cfg.TemplateDir, name)
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		logErrorf("Error reloading template %s: %v", path, err)
+		return cached
+	}
+	return tmpl
+}
+
+// renderNotFound responds with a 404 and the not-found page explaining
+// what was missing.
+func renderNotFound(w http.ResponseWriter, r *http.Request, message string) {
+	if tmplNotFound == nil {
+		http.Error(w, message, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	data := struct {
+		Branding
+		Message string
+	}{
+		Branding: siteBranding(),
+		Message:  message,
+	}
+	if err := currentTemplate(tmplNotFound, "notfound.html").Execute(w, data); err != nil {
+		logErrorf("Template execute error: %v", err)
+	}
+}
+
 // generatePageQRCode generates a QR code for the current page URL
 func generatePageQRCode(r *http.Request) string {
-	// Build absolute URL for current page
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
-	}
-	// Use the full request URI to get the current page path
-	pageURL := fmt.Sprintf("%s://%s%s", scheme, r.Host, r.RequestURI)
+	pageURL := currentURL(r)
 
 	// Generate QR code
-	png, err := qrcode.Encode(pageURL, qrcode.Medium, 256)
+	png, err := qrPNG(pageURL, defaultQRSize)
 	if err != nil {
-		log.Printf("QR code generation error: %v", err)
+		logErrorf("QR code generation error: %v", err)
 		return ""
 	}
 	return base64.StdEncoding.EncodeToString(png)
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
-	snippets := getAllSnippetsDescending()
+	serveIndexPage(w, r, "")
+}
 
-	var fileEntries []FileEntry
+// serveIndexPage renders the index, or writes it as JSON. With a tag it
+// lists every snippet carrying it instead of the newest ones.
+func serveIndexPage(w http.ResponseWriter, r *http.Request, tag string) {
+	data := indexData(r, tag)
 
-	entries, err := os.ReadDir(uploadsDir)
-	if err != nil {
-		log.Printf("Error reading uploads directory: %v", err)
-	} else {
-		for _, entry := range entries {
-			if entry.IsDir() {
-				continue
-			}
-			fileName := entry.Name()
-			fileEntries = append(fileEntries, FileEntry{
-				ID:   fileName,
-				Name: fileName,
-			})
+	// Tools asking for JSON get the same listing; empty lists stay arrays
+	if wantsJSON(r) {
+		if data.Snippets == nil {
+			data.Snippets = []SnippetInfo{}
+		}
+		if data.Files == nil {
+			data.Files = []FileEntry{}
 		}
+		writeJSON(w, http.StatusOK, data)
+		return
+	}
+	if wantsText(r) {
+		writeTextListing(w, data.Snippets)
+		return
 	}
 
-	data := IndexData{
-		Snippets:   snippets,
-		Files:      fileEntries,
-		HomeQRCode: generatePageQRCode(r),
+	executeIndexPage(w, r, data)
+}
+
+// writeTextListing writes snippets as a plain-text table of ID, title and
+// creation time, one snippet per line under a header line.
+func writeTextListing(w http.ResponseWriter, snippets []SnippetInfo) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tCREATED")
+	for _, snippet := range snippets {
+		// Titles are free text; a tab or newline in one would break the table
+		title := strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return ' '
+			}
+			return r
+		}, snippet.Title)
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", snippet.ID, title, snippet.CreatedAt.Format(time.DateTime))
 	}
+	tw.Flush()
+}
 
-	if err := tmplIndex.Execute(w, data); err != nil {
+// renderSaveError shows the index page again with the paste that couldn't
+// be saved still in the form and why above it
+func renderSaveError(w http.ResponseWriter, r *http.Request, message string) {
+	data := indexData(r, "")
+	data.SaveError = message
+	data.DraftTitle = r.FormValue("title")
+	data.DraftText = r.FormValue("text")
+	w.WriteHeader(http.StatusBadRequest)
+	executeIndexPage(w, r, data)
+}
+
+// executeIndexPage renders index.html with data
+func executeIndexPage(w http.ResponseWriter, r *http.Request, data IndexData) {
+	data.HomeQRCode = generatePageQRCode(r)
+	if err := currentTemplate(tmplIndex, "index.html").Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	}
+}
+
+// indexData gathers what the index page lists, only the snippets tagged tag
+// when it's set
+func indexData(r *http.Request, tag string) IndexData {
+	// Under mTLS, users only see their own content unless they're an admin
+	owner, filtered := ownerFilter(r)
+
+	var snippets []SnippetInfo
+	if tag != "" {
+		snippets = getSnippetsTagged(owner, filtered, tag)
+	} else {
+		snippets = getAllSnippetsDescending(owner, filtered, cfg.IndexSnippetCount)
+	}
+
+	fileEntries := listFileEntries(owner, filtered, cfg.MaxIndexFiles)
+
+	return IndexData{
+		Branding: siteBranding(),
+		Snippets: snippets,
+		Files:    fileEntries,
+		ReadOnly: cfg.ReadOnly,
+		Tag:      tag,
+
+		DefaultBurn:     cfg.DefaultBurn,
+		AllowBurnToggle: cfg.AllowBurnToggle,
+		MaxSnippetBytes: cfg.MaxSnippetBytes,
+		InviteRequired:  len(cfg.InviteCodes) > 0,
 	}
 }
 
 // handleSave creates a new snippet, saves to map, and also saves to disk.
 func handleSave(w http.ResponseWriter, r *http.Request) {
+	if !requireInvite(w, r, "paste", false) {
+		return
+	}
+	if refuseWhenFull(w, r, false) {
+		return
+	}
+
 	title := r.FormValue("title")
 	text := r.FormValue("text")
+	if cfg.NormalizeLineEndings {
+		text = normalizeLineEndings(text)
+	}
 	if title == "" {
-		title = "None"
+		title = derivedTitle(text)
+	}
+	if len(text) > cfg.MaxSnippetBytes {
+		http.Error(w, tooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
 	}
 
-	// Check if the 'burn' checkbox was set
-	burnValue := r.FormValue("burn") // will be "true" if checked, else ""
-	burnAfterReading := (burnValue == "true")
+	burnAfterReading := pasteBurns(r.Form["burn"])
 
-	// Generate an ID and store the snippet
-	url := generateURL()
-	snippets[url] = Snippet{
+	render := r.FormValue("render")
+	if !validRenderMode(render) {
+		http.Error(w, fmt.Sprintf("Unknown render mode %q", render), http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Immutable snippets are kept for good, so they can't burn or expire
+	immutable := r.FormValue("immutable") == "true"
+	if immutable && (burnAfterReading || ttl != 0) {
+		http.Error(w, "Immutable snippets can't burn after reading or expire", http.StatusBadRequest)
+		return
+	}
+
+	visibility := r.FormValue("visibility")
+	if !validVisibility(visibility) {
+		http.Error(w, fmt.Sprintf("Unknown visibility %q", visibility), http.StatusBadRequest)
+		return
+	}
+
+	language := r.FormValue("language")
+	if !validLanguage(language) {
+		http.Error(w, fmt.Sprintf("Unknown language %q", language), http.StatusBadRequest)
+		return
+	}
+
+	// A paste validated as a format is saved only if it parses, and is then
+	// highlighted as that format
+	format := r.FormValue("validate")
+	if !validFormat(format) {
+		http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	if err := validateBody(format, text); err != nil {
+		renderSaveError(w, r, err.Error())
+		return
+	}
+	if format == validateJSON || format == validateYAML {
+		language = format
+	}
+	if language == "" {
+		language = detectLanguage(text)
+	}
+
+	// The creator gets the plaintext token once; we only keep its hash
+	token, err := generateDeleteToken()
+	if err != nil {
+		logErrorf("Error generating delete token: %v", err)
+		http.Error(w, "Failed to save snippet", http.StatusInternalServerError)
+		return
+	}
+
+	owner := requestOwner(r)
+
+	// An optional custom slug replaces the generated ID
+	slug := strings.TrimSpace(r.FormValue("slug"))
+	if slug != "" {
+		if err := validateSlug(slug); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tags, err := parseTags(r.FormValue("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := uploadPasswordHash(r.FormValue("password"))
+	if err != nil {
+		logErrorf("Error hashing paste password: %v", err)
+		http.Error(w, "Failed to save snippet", http.StatusInternalServerError)
+		return
+	}
+
+	snippet := Snippet{
 		Title:            title,
-		Text:             text,
 		BurnAfterReading: burnAfterReading,
+		DeleteTokenHash:  hashDeleteToken(token),
+		CreatedAt:        time.Now(),
+		Owner:            owner,
+		Render:           render,
+		ExpiresAt:        expiryTime(ttl),
+		Language:         language,
+		Visibility:       visibility,
+		Immutable:        immutable,
+		PasswordHash:     passwordHash,
+		Tags:             tags,
+	}
+	snippet.SetBody(text)
+	url, err := addSnippet(r, snippet, slug)
+	if errors.Is(err, errSlugTaken) {
+		http.Error(w, fmt.Sprintf("The name %q is already taken, pick another one", slug), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logErrorf("Error generating snippet ID: %v", err)
+		http.Error(w, "Failed to save snippet", http.StatusInternalServerError)
+		return
 	}
 
-	saveSnippetsToFile(snippetsFile)
+	http.Redirect(w, r, sitePath("/display/"+url)+"?token="+token, http.StatusSeeOther)
+}
+
+// pasteBurns decides whether a new paste burns after reading from the burn
+// values it was sent. The form sends a hidden "false" ahead of the checkbox,
+// so the last value wins; no value at all means cfg.DefaultBurn, which is
+// also what every paste gets when cfg.AllowBurnToggle is off.
+func pasteBurns(values []string) bool {
+	if !cfg.AllowBurnToggle || len(values) == 0 {
+		return cfg.DefaultBurn
+	}
+	value := values[len(values)-1]
+	return value == "true" || value == "1"
+}
+
+// tooLargeMessage is the error for a snippet over cfg.MaxSnippetBytes
+func tooLargeMessage() string {
+	return fmt.Sprintf("Snippets are limited to %d bytes", cfg.MaxSnippetBytes)
+}
 
-	http.Redirect(w, r, "/display/"+url, http.StatusSeeOther)
+// normalizeLineEndings turns Windows "\r\n" and old Mac "\r" line endings
+// into "\n".
+func normalizeLineEndings(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
 }
 
-// displaySnippet shows the snippet in the display template.
+// maxDerivedTitle is how many characters of the first line derivedTitle keeps
+const maxDerivedTitle = 50
+
+// derivedTitle makes a title for a snippet saved without one from the first
+// non-blank line of its text, or "Untitled" if there isn't one.
+func derivedTitle(text string) string {
+	for line := range strings.Lines(text) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if runes := []rune(line); len(runes) > maxDerivedTitle {
+			line = strings.TrimSpace(string(runes[:maxDerivedTitle])) + "…"
+		}
+		return line
+	}
+	return "Untitled"
+}
+
+// errSlugTaken is returned by addSnippet when the custom slug is in use
+var errSlugTaken = errors.New("slug already taken")
+
+// addSnippet stores a new snippet under slug, or a generated ID when slug is
+// empty, making room first if we're at the cap. It then queues the save and
+// sends the webhook, returning the snippet's ID.
+func addSnippet(r *http.Request, snippet Snippet, slug string) (string, error) {
+	snippetsMu.Lock()
+	if _, taken := snippets[slug]; slug != "" && taken {
+		snippetsMu.Unlock()
+		return "", errSlugTaken
+	}
+	for cfg.MaxSnippets > 0 && len(snippets) >= cfg.MaxSnippets {
+		if !evictOldestSnippet() {
+			break
+		}
+	}
+	url := slug
+	if url == "" {
+		var err error
+		url, err = generateURL()
+		if err != nil {
+			snippetsMu.Unlock()
+			return "", err
+		}
+	}
+	snippets[url] = snippet
+	logSnippetPut(url, snippet)
+	snippetsMu.Unlock()
+
+	if snippet.Owner != "" {
+		logInfof("Snippet %s created by %s", url, snippet.Owner)
+	}
+
+	queueSnippetsSave()
+
+	notifyWebhook(WebhookEvent{
+		Type:        "snippet",
+		ID:          url,
+		TitleOrName: snippet.Title,
+		URL:         absoluteURL(r, "/display/"+url),
+		Owner:       snippet.Owner,
+	})
+	return url, nil
+}
+
+// handleRootPost handles "POST /". With cfg.EnableRawPaste a raw body is
+// taken as the paste by handleRawSave; forms, and everything when it's off,
+// go to handleSave as if they'd been posted to /save.
+func handleRootPost(w http.ResponseWriter, r *http.Request) {
+	if cfg.EnableRawPaste && !isFormPost(r) {
+		handleRawSave(w, r)
+		return
+	}
+	handleSave(w, r)
+}
+
+// isFormPost reports whether r's body is a form a browser would send
+func isFormPost(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// handleRawSave takes the whole request body as the snippet text so pastes
+// can be made with curl --data-binary. The title comes from the
+// X-Paste-Title header or ?title=, and ?burn=1 and ?expiry= work as on the
+// form. It answers with the snippet's absolute URL as plain text, and the
+// delete token in the X-Delete-Token header.
+func handleRawSave(w http.ResponseWriter, r *http.Request) {
+	// Only the query string is read, so nothing in the body is ever taken
+	// for a form field
+	query := r.URL.Query()
+	if !requireInvite(w, r, "paste", false) {
+		return
+	}
+	if refuseWhenFull(w, r, false) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(cfg.MaxSnippetBytes)))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, tooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read paste", http.StatusBadRequest)
+		return
+	}
+	if len(body) == 0 {
+		http.Error(w, "Nothing to paste; send the text as the request body", http.StatusBadRequest)
+		return
+	}
+	text := string(body)
+
+	title := r.Header.Get("X-Paste-Title")
+	if title == "" {
+		title = query.Get("title")
+	}
+	if title == "" {
+		title = derivedTitle(text)
+	}
+
+	ttl, err := parseExpiry(query.Get("expiry"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := generateDeleteToken()
+	if err != nil {
+		logErrorf("Error generating delete token: %v", err)
+		http.Error(w, "Failed to save snippet", http.StatusInternalServerError)
+		return
+	}
+
+	snippet := Snippet{
+		Title:            title,
+		BurnAfterReading: pasteBurns(query["burn"]),
+		DeleteTokenHash:  hashDeleteToken(token),
+		CreatedAt:        time.Now(),
+		Owner:            requestOwner(r),
+		ExpiresAt:        expiryTime(ttl),
+		Language:         detectLanguage(text),
+	}
+	snippet.SetBody(text)
+	url, err := addSnippet(r, snippet, "")
+	if err != nil {
+		logErrorf("Error generating snippet ID: %v", err)
+		http.Error(w, "Failed to save snippet", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Delete-Token", token)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, absoluteURL(r, "/display/"+url))
+}
+
+// displaySnippet shows the snippet in the display template, or as a
+// SnippetJSON for /display/{url}.json and requests asking for JSON. Either
+// way a burn-after-reading snippet is burned by being shown. With
+// cfg.BurnConfirm set, burn-after-reading snippets get the reveal page (or
+// JSON without the text) instead and are only shown by revealSnippet.
+// Password-protected snippets get the password form, or JSON without the
+// text, and are likewise only shown by revealSnippet.
 func displaySnippet(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	url := vars["url"]
+	url, asJSON := snippetRequest(r)
 
+	snippetsMu.RLock()
 	snippet, ok := snippets[url]
-	if !ok {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+	snippetsMu.RUnlock()
+	if !ok || snippet.expired(time.Now()) {
+		snippetNotFound(w, r, asJSON)
+		return
+	}
+
+	if snippet.PasswordHash != "" {
+		if asJSON {
+			data := snippetJSON(url, snippet, false)
+			data.RevealURL = absoluteURL(r, "/reveal/"+url+".json")
+			data.PasswordRequired = true
+			writeJSON(w, http.StatusOK, data)
+			return
+		}
+		renderSnippetUnlockPage(w, r, url, snippet, false)
+		return
+	}
+
+	if snippet.burns() && cfg.BurnConfirm {
+		if asJSON {
+			data := snippetJSON(url, snippet, false)
+			data.RevealURL = absoluteURL(r, "/reveal/"+url+".json")
+			writeJSON(w, http.StatusOK, data)
+			return
+		}
+		renderRevealPage(w, r, url, snippet)
+		return
+	}
+
+	if snippet.burns() {
+		// Burned before it's rendered so only one reader gets it
+		if snippet, ok = burnSnippet(url); !ok {
+			snippetNotFound(w, r, asJSON)
+			return
+		}
+		showSnippet(w, r, url, snippet, asJSON)
 		return
 	}
 
+	if showSnippet(w, r, url, snippet, asJSON) {
+		countView(url)
+	}
+}
+
+// snippetRequest returns the snippet ID a display or reveal request is for,
+// and whether it wants JSON back: with a ".json" suffix on the ID, or
+// wantsJSON.
+func snippetRequest(r *http.Request) (url string, asJSON bool) {
+	url = mux.Vars(r)["url"]
+	if id, found := strings.CutSuffix(url, ".json"); found {
+		return id, true
+	}
+	return url, wantsJSON(r)
+}
+
+// snippetNotFound answers for a missing or expired snippet, as JSON or the
+// not-found page
+func snippetNotFound(w http.ResponseWriter, r *http.Request, asJSON bool) {
+	if asJSON {
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, "Snippet not found")
+		return
+	}
+	renderNotFound(w, r, "Snippet not found")
+}
+
+// showSnippet renders a snippet or writes it as JSON, reporting whether it
+// was shown.
+func showSnippet(w http.ResponseWriter, r *http.Request, url string, snippet Snippet, asJSON bool) bool {
+	if asJSON {
+		writeJSON(w, http.StatusOK, snippetJSON(url, snippet, true))
+		return true
+	}
+	return renderSnippet(w, r, url, snippet)
+}
+
+// snippetJSON builds the SnippetJSON for a snippet, leaving the text out
+// unless withText is set.
+func snippetJSON(url string, snippet Snippet, withText bool) SnippetJSON {
+	data := SnippetJSON{
+		ID:               url,
+		Title:            snippet.Title,
+		CreatedAt:        snippet.CreatedAt,
+		Views:            snippet.Views,
+		BurnAfterReading: snippet.burns(),
+		Tags:             snippet.Tags,
+	}
+	if withText {
+		data.Text = snippet.Body()
+	}
+	if !snippet.ExpiresAt.IsZero() {
+		expiresAt := snippet.ExpiresAt
+		data.ExpiresAt = &expiresAt
+	}
+	return data
+}
+
+// revealSnippet handles "POST /reveal/{url}", showing a burn-after-reading
+// snippet from the reveal page, or as JSON like displaySnippet. Being a POST,
+// link previews and prefetchers don't trigger it. The snippet is burned
+// before it's rendered so it can only be revealed once. A password-protected
+// snippet needs its password as 'password'; a wrong one gets the form again.
+func revealSnippet(w http.ResponseWriter, r *http.Request) {
+	url, asJSON := snippetRequest(r)
+
+	snippetsMu.RLock()
+	snippet, ok := snippets[url]
+	snippetsMu.RUnlock()
+	if !ok || snippet.expired(time.Now()) {
+		snippetNotFound(w, r, asJSON)
+		return
+	}
+
+	if snippet.PasswordHash != "" {
+		err := unlockSnippet(r, url, snippet, r.FormValue("password"))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			snippetNotFound(w, r, asJSON)
+			return
+		case errors.Is(err, errWrongPassword) && !asJSON:
+			// Shown with the count the wrong password left it on
+			snippetsMu.RLock()
+			snippet = snippets[url]
+			snippetsMu.RUnlock()
+			renderSnippetUnlockPage(w, r, url, snippet, true)
+			return
+		case err != nil:
+			writeUnlockError(w, err, asJSON)
+			return
+		}
+	}
+	if !snippet.burns() {
+		if showSnippet(w, r, url, snippet, asJSON) {
+			countView(url)
+		}
+		return
+	}
+
+	if snippet, ok = burnSnippet(url); !ok {
+		snippetNotFound(w, r, asJSON)
+		return
+	}
+	showSnippet(w, r, url, snippet, asJSON)
+}
+
+// renderSnippet executes the display template for a snippet, reporting
+// whether it was shown.
+func renderSnippet(w http.ResponseWriter, r *http.Request, url string, snippet Snippet) bool {
+	text := snippet.Body()
 	data := DisplayData{
-		ID:         url,
-		Title:      snippet.Title,
-		Text:       snippet.Text,
-		Link:       "/display/" + url,
-		HomeQRCode: generatePageQRCode(r),
+		Branding:    siteBranding(),
+		ID:          url,
+		Title:       snippet.Title,
+		Text:        text,
+		Link:        sitePath("/display/" + url),
+		HomeQRCode:  generatePageQRCode(r),
+		LineNumbers: r.URL.Query().Get("nums") == "1",
+		Wrap:        r.URL.Query().Get("wrap") != "0",
+		ReadOnly:    cfg.ReadOnly,
+		Immutable:   snippet.Immutable,
+		DeleteToken: checkedDeleteToken(r, snippet),
+		Tags:        snippet.Tags,
+		Versions:    len(snippet.History),
+	}
+	if snippet.Render == renderMarkdown {
+		html, err := renderMarkdownHTML(text)
+		if err != nil {
+			logErrorf("Error rendering snippet %s: %v", url, err)
+			http.Error(w, "Failed to render snippet", http.StatusInternalServerError)
+			return false
+		}
+		data.Markdown = true
+		data.HTML = html
+	} else if data.LineNumbers {
+		data.Lines = numberLines(text)
 	}
 
-	if err := tmplDisplay.Execute(w, data); err != nil {
+	if err := currentTemplate(tmplDisplay, "display.html").Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// renderRevealPage shows the "click to reveal" page for a burn-after-reading
+// snippet. Nothing from the snippet besides its ID goes on the page.
+func renderRevealPage(w http.ResponseWriter, r *http.Request, url string, snippet Snippet) {
+	data := DisplayData{
+		Branding:    siteBranding(),
+		ID:          url,
+		Link:        sitePath("/display/" + url),
+		DeleteToken: checkedDeleteToken(r, snippet),
+	}
+	if err := currentTemplate(tmplReveal, "reveal.html").Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkedDeleteToken returns the request's "token" parameter if it's the
+// snippet's delete token, and "" otherwise. Only the right token is echoed
+// back, so it can't be guessed by poking at the display page.
+func checkedDeleteToken(r *http.Request, snippet Snippet) string {
+	if token := r.FormValue("token"); token != "" && validDeleteToken(snippet, token) {
+		return token
+	}
+	return ""
+}
+
+// rawSnippet serves the snippet's source as plain text, whatever its render
+// mode. ?download=1 sends it as a .txt attachment instead. Password-protected
+// snippets need ?pw=.
+func rawSnippet(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+
+	snippetsMu.RLock()
+	snippet, ok := snippets[url]
+	snippetsMu.RUnlock()
+	if !ok || snippet.expired(time.Now()) {
+		http.Error(w, "Snippet not found", http.StatusNotFound)
 		return
 	}
 
-	// TODO, too aggressive
-	if snippet.BurnAfterReading {
-		delete(snippets, url)
-		saveSnippetsToFile(snippetsFile)
+	if !checkRawPassword(w, r, url, snippet) {
+		return
 	}
+
+	if snippet.burns() {
+		if snippet, ok = burnSnippet(url); !ok {
+			http.Error(w, "Snippet not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", url+".txt"))
+	}
+	io.WriteString(w, snippet.Body())
+
+	if !snippet.burns() {
+		countView(url)
+	}
+}
+
+// checkRawPassword checks the ?pw= password for plain-text views of a
+// protected snippet, answering the request itself when it's missing or wrong.
+func checkRawPassword(w http.ResponseWriter, r *http.Request, url string, snippet Snippet) bool {
+	if snippet.PasswordHash == "" {
+		return true
+	}
+	password := r.URL.Query().Get("pw")
+	if password == "" {
+		http.Error(w, "This paste is password protected, pass the password as ?pw=", http.StatusForbidden)
+		return false
+	}
+	if err := unlockSnippet(r, url, snippet, password); err != nil {
+		writeUnlockError(w, err, false)
+		return false
+	}
+	return true
 }
 
-// deleteSnippet removes a snippet and saves state to disk.
+// countView bumps a snippet's view count after it has been shown, and notes
+// when for cfg.IdleExpiry.
+func countView(url string) {
+	snippetsMu.Lock()
+	snippet, ok := snippets[url]
+	if ok {
+		snippet.Views++
+		snippet.LastViewed = time.Now()
+		snippets[url] = snippet
+	}
+	snippetsMu.Unlock()
+	if ok {
+		queueSnippetsSave()
+	}
+}
+
+// burnSnippet deletes a burn-after-reading snippet as it's read and returns
+// it. It reports false if another reader burned it first, or it expired or
+// was reaped by the sweeper in the meantime; either way it isn't shown.
+func burnSnippet(url string) (Snippet, bool) {
+	snippetsMu.Lock()
+	snippet, ok := snippets[url]
+	if !ok || snippet.expired(time.Now()) {
+		snippetsMu.Unlock()
+		return Snippet{}, false
+	}
+	delete(snippets, url)
+	logSnippetDelete(url)
+	snippetsMu.Unlock()
+	saveSnippetsToFile(snippetsFile)
+	return snippet, true
+}
+
+// deleteSnippet removes a snippet and saves state to disk. The snippet's
+// delete token must be supplied as the "token" form or query parameter.
 func deleteSnippet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	url := vars["url"]
+	token := r.FormValue("token")
 
+	snippetsMu.Lock()
+	snippet, ok := snippets[url]
+	if !ok {
+		snippetsMu.Unlock()
+		renderNotFound(w, r, "Snippet not found")
+		return
+	}
+	if snippet.Immutable {
+		snippetsMu.Unlock()
+		http.Error(w, "Immutable snippets can't be deleted", http.StatusForbidden)
+		return
+	}
+	if !validDeleteToken(snippet, token) {
+		snippetsMu.Unlock()
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
 	delete(snippets, url)
+	logSnippetDelete(url)
+	snippetsMu.Unlock()
 
 	saveSnippetsToFile(snippetsFile)
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, sitePath("/"), http.StatusSeeOther)
+}
+
+// deleteMultipleSnippets handles "POST /delete-multiple", deleting every
+// snippet named by a repeated 'id' field or a comma-separated 'ids' one, then
+// going back to the index. Each needs a matching delete token among the
+// 'token' fields given, unless the request is from an admin. Unknown,
+// immutable and unauthorized IDs are skipped and counted in the log.
+func deleteMultipleSnippets(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	ids := r.Form["id"]
+	for _, list := range r.Form["ids"] {
+		ids = append(ids, strings.Split(list, ",")...)
+	}
+	tokens := r.Form["token"]
+	_, admin := adminIdentity(r)
+
+	var deleted []string
+	skipped := 0
+	snippetsMu.Lock()
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		snippet, ok := snippets[id]
+		if !ok || snippet.Immutable || !(admin || anyDeleteToken(snippet, tokens)) {
+			skipped++
+			continue
+		}
+		delete(snippets, id)
+		deleted = append(deleted, id)
+	}
+	logSnippetDelete(deleted...)
+	snippetsMu.Unlock()
+
+	if len(deleted) > 0 {
+		saveSnippetsToFile(snippetsFile)
+	}
+	logInfof("Deleted %d snippets for %s, skipped %d", len(deleted), clientIP(r), skipped)
+
+	http.Redirect(w, r, sitePath("/"), http.StatusSeeOther)
+}
+
+// appendSnippet handles "POST /append/{url}", adding the 'text' field to the
+// end of a snippet on a new line. Like deleting, it needs the snippet's
+// delete token. Burn-after-reading snippets can't be appended to, since
+// they're gone once read.
+func appendSnippet(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+	text := r.FormValue("text")
+	token := r.FormValue("token")
+
+	snippetsMu.Lock()
+	snippet, ok := snippets[url]
+	if !ok || snippet.expired(time.Now()) {
+		snippetsMu.Unlock()
+		renderNotFound(w, r, "Snippet not found")
+		return
+	}
+	if snippet.Immutable {
+		snippetsMu.Unlock()
+		http.Error(w, "Immutable snippets can't be changed", http.StatusForbidden)
+		return
+	}
+	if !validDeleteToken(snippet, token) {
+		snippetsMu.Unlock()
+		http.Error(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+	if snippet.BurnAfterReading {
+		snippetsMu.Unlock()
+		http.Error(w, "Burn-after-reading snippets can't be appended to", http.StatusConflict)
+		return
+	}
+
+	body := snippet.Body()
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	body += text
+	if len(body) > cfg.MaxSnippetBytes {
+		snippetsMu.Unlock()
+		http.Error(w, tooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	snippet.keepVersion()
+	snippet.SetBody(body)
+	snippet.ModifiedAt = time.Now()
+	snippets[url] = snippet
+	logSnippetPut(url, snippet)
+	snippetsMu.Unlock()
+
+	queueSnippetsSave()
+
+	http.Redirect(w, r, sitePath("/display/"+url), http.StatusSeeOther)
+}
+
+// evictOldestSnippet removes the oldest snippet by CreatedAt. Unread burn
+// snippets are only evicted if nothing else is left, since whoever they were
+// meant for hasn't seen them yet, and immutable ones never are. It reports
+// whether anything was evicted. Callers must hold the snippetsMu write lock.
+func evictOldestSnippet() bool {
+	var oldestID, oldestBurnID string
+	var oldest, oldestBurn time.Time
+
+	for id, snippet := range snippets {
+		if snippet.Immutable {
+			continue
+		}
+		if snippet.BurnAfterReading {
+			if oldestBurnID == "" || snippet.CreatedAt.Before(oldestBurn) {
+				oldestBurnID, oldestBurn = id, snippet.CreatedAt
+			}
+			continue
+		}
+		if oldestID == "" || snippet.CreatedAt.Before(oldest) {
+			oldestID, oldest = id, snippet.CreatedAt
+		}
+	}
+
+	if oldestID == "" {
+		oldestID = oldestBurnID
+	}
+	if oldestID == "" {
+		return false
+	}
+	logInfof("Snippet limit of %d reached, evicting %s", cfg.MaxSnippets, oldestID)
+	delete(snippets, oldestID)
+	logSnippetDelete(oldestID)
+	return true
+}
+
+// generateDeleteToken returns a random hex token used to authorize deletion.
+func generateDeleteToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashDeleteToken hashes a delete token for storage, so a leaked
+// snippets.json doesn't hand out the tokens themselves.
+func hashDeleteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validDeleteToken reports whether token authorizes deleting the snippet.
+// Snippets saved before delete tokens existed have no hash and can be
+// deleted without one, same as before.
+func validDeleteToken(snippet Snippet, token string) bool {
+	if snippet.DeleteTokenHash == "" {
+		return true
+	}
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashDeleteToken(token)), []byte(snippet.DeleteTokenHash)) == 1
+}
+
+// anyDeleteToken reports whether one of tokens authorizes deleting the
+// snippet.
+func anyDeleteToken(snippet Snippet, tokens []string) bool {
+	if snippet.DeleteTokenHash == "" {
+		return true
+	}
+	for _, token := range tokens {
+		if validDeleteToken(snippet, token) {
+			return true
+		}
+	}
+	return false
 }
 
-// generateURL is a simplistic ID generator (just numeric).
-func generateURL() string {
-	for {
-		id := randomString(3) // 3-character string
+// generateURL picks a random snippet ID of cfg.IDLength characters. At the
+// default length collisions are vanishingly unlikely, but it still checks,
+// and gives up rather than spinning if a short length has filled up.
+// Callers must hold the snippetsMu write lock.
+func generateURL() (string, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		id := randomStringFrom(cfg.idAlphabet(), cfg.IDLength)
 		if _, exists := snippets[id]; !exists {
-			return id
+			return id, nil
 		}
-		// Otherwise, loop again and generate a new ID
 	}
+	return "", errors.New("could not find a free snippet ID, consider raising id_length")
+}
+
+// numberLines splits text into numbered lines. A trailing newline doesn't
+// count as an extra empty line.
+func numberLines(text string) []NumberedLine {
+	text = strings.TrimSuffix(text, "\n")
+	var lines []NumberedLine
+	for i, line := range strings.Split(text, "\n") {
+		lines = append(lines, NumberedLine{Number: i + 1, Text: line})
+	}
+	return lines
 }
 
 // truncateText returns the text truncated to maxLen with "..." appended if needed
@@ -328,26 +1603,89 @@ func truncateText(text string, maxLen int) string {
 	return text
 }
 
-// buildSnippetsList converts a snippets map to a list of SnippetInfo, with truncated text
+// snippetInfo is a snippet's listing entry, without its text
+func snippetInfo(id string, snippet Snippet) SnippetInfo {
+	return SnippetInfo{
+		ID:        id,
+		Title:     snippet.Title,
+		CreatedAt: snippet.CreatedAt,
+		ExpiresAt: snippet.ExpiresAt,
+		Views:     snippet.Views,
+		Tags:      snippet.Tags,
+	}
+}
+
+// buildSnippetsList converts a snippets map to a list of SnippetInfo, with truncated text,
+// newest first. Unlisted snippets, and expired ones the sweeper hasn't
+// removed yet, are left out.
 func buildSnippetsList(snippetsMap map[string]Snippet, maxResults int) []SnippetInfo {
 	var results []SnippetInfo
 
+	now := time.Now()
 	for idStr, snippet := range snippetsMap {
-		results = append(results, SnippetInfo{
-			ID:            idStr,
-			Title:         snippet.Title,
-			TruncatedText: truncateText(snippet.Text, 10),
-		})
+		if snippet.expired(now) || snippet.Visibility == visibilityUnlisted {
+			continue
+		}
+		results = append(results, snippetInfo(idStr, snippet))
 	}
 
+	// Map order is random, so sort by creation time (ID breaks ties)
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].CreatedAt.Equal(results[j].CreatedAt) {
+			return results[i].CreatedAt.After(results[j].CreatedAt)
+		}
+		return results[i].ID < results[j].ID
+	})
+
 	// Return up to maxResults
 	if maxResults > 0 && len(results) > maxResults {
 		results = results[:maxResults]
 	}
 
+	// Only what's listed needs its body, which may be compressed. Protected
+	// snippets don't show any of theirs.
+	for i := range results {
+		if snippet := snippetsMap[results[i].ID]; snippet.PasswordHash == "" {
+			results[i].TruncatedText = truncateText(snippet.Body(), 10)
+		}
+	}
+
 	return results
 }
 
-func getAllSnippetsDescending() []SnippetInfo {
-	return buildSnippetsList(snippets, 10)
+// getAllSnippetsDescending returns the newest snippets, up to maxResults (0
+// for all of them). When filtered is set, only snippets created by owner are
+// included.
+func getAllSnippetsDescending(owner string, filtered bool, maxResults int) []SnippetInfo {
+	snippetsMu.RLock()
+	defer snippetsMu.RUnlock()
+
+	if filtered {
+		return buildSnippetsList(snippetsOwnedBy(snippets, owner), maxResults)
+	}
+	return buildSnippetsList(snippets, maxResults)
+}
+
+// getSnippetsTagged returns every snippet carrying tag, newest first. When
+// filtered is set, only snippets created by owner are included.
+func getSnippetsTagged(owner string, filtered bool, tag string) []SnippetInfo {
+	snippetsMu.RLock()
+	defer snippetsMu.RUnlock()
+
+	tagged := snippetsTagged(snippets, tag)
+	if filtered {
+		tagged = snippetsOwnedBy(tagged, owner)
+	}
+	return buildSnippetsList(tagged, 0)
+}
+
+// snippetsOwnedBy returns the subset of snippetsMap created by owner
+func snippetsOwnedBy(snippetsMap map[string]Snippet, owner string) map[string]Snippet {
+	owned := make(map[string]Snippet)
+	for id, snippet := range snippetsMap {
+		if snippet.Owner == owner {
+			owned[id] = snippet
+		}
+	}
+	return owned
 }
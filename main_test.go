@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"maps"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -245,6 +253,38 @@ func TestLoadSnippetsFromFile_NonExistent(t *testing.T) {
 	}
 }
 
+// Test a corrupt snippets file is backed up and we start empty
+func TestLoadSnippetsFromFile_Corrupt(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "snippets.json")
+	corrupt := []byte(`{"abc": {"title": "half", "text": "x"}, "def": {"ti`)
+	if err := os.WriteFile(filename, corrupt, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(filename)
+
+	if len(snippets) != 0 {
+		t.Errorf("Expected empty snippets map, got %d entries", len(snippets))
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Corrupt file still at %s", filename)
+	}
+	backups, _ := filepath.Glob(filename + ".corrupt.*")
+	if len(backups) != 1 {
+		t.Fatalf("Found backups %v, want one", backups)
+	}
+	if got, _ := os.ReadFile(backups[0]); string(got) != string(corrupt) {
+		t.Errorf("Backup contents = %q, want the corrupt file", got)
+	}
+}
+
 // Test generateURL uniqueness
 func TestGenerateURL(t *testing.T) {
 	originalSnippets := snippets
@@ -254,10 +294,13 @@ func TestGenerateURL(t *testing.T) {
 
 	snippets = make(map[string]Snippet)
 
-	// Generate multiple URLs and verify uniqueness
+	// Generate lots of IDs and verify uniqueness, length and alphabet
 	urls := make(map[string]bool)
-	for i := 0; i < 10; i++ {
-		url := generateURL()
+	for i := 0; i < 10000; i++ {
+		url, err := generateURL()
+		if err != nil {
+			t.Fatalf("generateURL() error = %v", err)
+		}
 		if urls[url] {
 			t.Errorf("generateURL() produced duplicate: %s", url)
 		}
@@ -265,11 +308,84 @@ func TestGenerateURL(t *testing.T) {
 		snippets[url] = Snippet{} // Add to map to simulate usage
 	}
 
-	// Verify all URLs are 3 characters
 	for url := range urls {
-		if len(url) != 3 {
+		if len(url) != cfg.IDLength {
 			t.Errorf("generateURL() produced URL with wrong length: %s (len=%d)", url, len(url))
 		}
+		if strings.Trim(url, snippetChars) != "" {
+			t.Errorf("generateURL() produced URL outside the base62 alphabet: %s", url)
+		}
+	}
+}
+
+// Test generated IDs only use the configured alphabet, given outright or as
+// a preset
+func TestGenerateURL_Alphabet(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	tests := []struct {
+		alphabet string
+		want     string
+	}{
+		{"ABCDEF0123456789", "ABCDEF0123456789"},
+		{"xy_-", "xy_-"},
+		{"unambiguous", idAlphabetPresets["unambiguous"]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alphabet, func(t *testing.T) {
+			cfg.IDAlphabet = tt.alphabet
+			snippets = make(map[string]Snippet)
+
+			used := make(map[rune]bool)
+			for i := 0; i < 500; i++ {
+				url, err := generateURL()
+				if err != nil {
+					t.Fatalf("generateURL() error = %v", err)
+				}
+				snippets[url] = Snippet{}
+				for _, c := range url {
+					if !strings.ContainsRune(tt.want, c) {
+						t.Fatalf("generateURL() = %q, has %q outside %q", url, c, tt.want)
+					}
+					used[c] = true
+				}
+			}
+			// Small alphabets should have every character turn up
+			if len(tt.want) <= 16 && len(used) != len(tt.want) {
+				t.Errorf("Only %d of the %d characters were used", len(used), len(tt.want))
+			}
+		})
+	}
+
+	if strings.ContainsAny(idAlphabetPresets["unambiguous"], "0Oo1Il") {
+		t.Error("unambiguous preset has ambiguous characters")
+	}
+}
+
+// Test generateURL gives up instead of looping when IDs run out
+func TestGenerateURL_Exhausted(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	// There are only 62 IDs of length 1; take them all
+	cfg.IDLength = 1
+	snippets = make(map[string]Snippet)
+	for _, c := range snippetChars {
+		snippets[string(c)] = Snippet{}
+	}
+
+	if _, err := generateURL(); err == nil {
+		t.Error("generateURL() should fail when every ID is taken")
 	}
 }
 
@@ -284,6 +400,9 @@ func initTestTemplates(t *testing.T) {
 	if tmplIndex == nil {
 		tmplIndex = template.Must(template.New("index").Parse(`Snippets: {{len .Snippets}}`))
 	}
+	if tmplReveal == nil {
+		tmplReveal = template.Must(template.New("reveal").Parse(`Reveal {{.ID}}`))
+	}
 }
 
 // Test handleSave HTTP handler
@@ -330,29 +449,93 @@ func TestHandleSave(t *testing.T) {
 	}
 }
 
-// Test handleSave with empty title
+// Test handleSave titles untitled snippets from their first line and keeps
+// explicit titles
 func TestHandleSave_EmptyTitle(t *testing.T) {
 	originalSnippets := snippets
 	t.Cleanup(func() {
 		snippets = originalSnippets
 	})
 
-	snippets = make(map[string]Snippet)
+	tests := []struct {
+		name  string
+		title string
+		text  string
+		want  string
+	}{
+		{"first line", "", "\n   \n  Deploy notes  \nstep one\nstep two\n", "Deploy notes"},
+		{"long line capped", "", strings.Repeat("abcde ", 20), "abcde abcde abcde abcde abcde abcde abcde abcde ab…"},
+		{"empty body", "", "", "Untitled"},
+		{"blank body", "", " \n\t\n", "Untitled"},
+		{"explicit title", "My Title", "first line\nsecond", "My Title"},
+	}
 
-	form := url.Values{}
-	form.Add("text", "Test content")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
 
-	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("text", tt.text)
 
-	w := httptest.NewRecorder()
-	handleSave(w, req)
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	// Verify default title "None" was used
-	for _, snippet := range snippets {
-		if snippet.Title != "None" {
-			t.Errorf("Snippet title = %s, want 'None'", snippet.Title)
-		}
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if len(snippets) != 1 {
+				t.Fatalf("handleSave() stored %d snippets, want 1 (status %d)", len(snippets), w.Code)
+			}
+			for _, snippet := range snippets {
+				if snippet.Title != tt.want {
+					t.Errorf("Snippet title = %q, want %q", snippet.Title, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Test handleSave turns mixed line endings into "\n" with
+// normalize_line_endings on, and stores the text untouched with it off
+func TestHandleSave_NormalizeLineEndings(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+	})
+
+	mixed := "windows\r\nold mac\runix\n\r\nend"
+	tests := []struct {
+		name      string
+		normalize bool
+		want      string
+	}{
+		{"enabled", true, "windows\nold mac\nunix\n\nend"},
+		{"disabled", false, mixed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+			cfg.NormalizeLineEndings = tt.normalize
+
+			form := url.Values{"title": {"Line endings"}, "text": {mixed}}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if len(snippets) != 1 {
+				t.Fatalf("handleSave() stored %d snippets, want 1 (status %d)", len(snippets), w.Code)
+			}
+			for _, snippet := range snippets {
+				if snippet.Body() != tt.want {
+					t.Errorf("Snippet text = %q, want %q", snippet.Body(), tt.want)
+				}
+			}
+		})
 	}
 }
 
@@ -389,14 +572,49 @@ func TestDisplaySnippet(t *testing.T) {
 	}
 }
 
+// Test each display or raw view of a snippet is counted
+func TestSnippetViews(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+
+	initTestTemplates(t)
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{"abc": {Title: "Test", Text: "Content"}}
+
+	for _, handler := range []http.HandlerFunc{displaySnippet, rawSnippet, displaySnippet} {
+		req := httptest.NewRequest("GET", "/display/abc", nil)
+		req = mux.SetURLVars(req, map[string]string{"url": "abc"})
+		handler(httptest.NewRecorder(), req)
+	}
+
+	if views := snippets["abc"].Views; views != 3 {
+		t.Errorf("Views = %d, want 3", views)
+	}
+
+	// The count survives a restart
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	if views := snippets["abc"].Views; views != 3 {
+		t.Errorf("Views after reload = %d, want 3", views)
+	}
+}
+
 // Test displaySnippet with burn after reading
 func TestDisplaySnippet_BurnAfterReading(t *testing.T) {
 	originalSnippets := snippets
+	originalCfg := cfg
 	t.Cleanup(func() {
 		snippets = originalSnippets
+		cfg = originalCfg
 	})
 
 	initTestTemplates(t)
+	cfg.BurnConfirm = false
 
 	snippets = map[string]Snippet{
 		"xyz": {
@@ -422,6 +640,258 @@ func TestDisplaySnippet_BurnAfterReading(t *testing.T) {
 	}
 }
 
+// Test two readers arriving at once at a burn snippet: exactly one gets the
+// text and the other a 404
+func TestDisplaySnippet_BurnConcurrent(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+
+	initTestTemplates(t)
+	cfg.BurnConfirm = false
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+
+	for round := 0; round < 50; round++ {
+		snippetsMu.Lock()
+		snippets = map[string]Snippet{
+			"xyz": {Title: "Burn Me", Text: "Secret", BurnAfterReading: true},
+		}
+		snippetsMu.Unlock()
+
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		responses := make([]*httptest.ResponseRecorder, 2)
+		for i := range responses {
+			responses[i] = httptest.NewRecorder()
+			wg.Add(1)
+			go func(w *httptest.ResponseRecorder) {
+				defer wg.Done()
+				req := httptest.NewRequest("GET", "/display/xyz", nil)
+				req = mux.SetURLVars(req, map[string]string{"url": "xyz"})
+				<-start
+				displaySnippet(w, req)
+			}(responses[i])
+		}
+		close(start)
+		wg.Wait()
+
+		var shown, missing int
+		for _, w := range responses {
+			switch {
+			case w.Code == http.StatusOK && strings.Contains(w.Body.String(), "Secret"):
+				shown++
+			case w.Code == http.StatusNotFound:
+				missing++
+			}
+		}
+		if shown != 1 || missing != 1 {
+			t.Fatalf("Round %d: %d readers got the text and %d a 404, want 1 and 1", round, shown, missing)
+		}
+	}
+}
+
+// Test a GET of a burn snippet only shows the reveal page, and the reveal
+// POST shows and burns it
+func TestDisplaySnippet_BurnConfirm(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+
+	initTestTemplates(t)
+	cfg.BurnConfirm = true
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"xyz": {Title: "Burn Me", Text: "Secret", BurnAfterReading: true},
+	}
+	router := newRouter()
+
+	// Link previews and repeat visits just get the reveal page
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/display/xyz", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if strings.Contains(w.Body.String(), "Secret") {
+			t.Errorf("Reveal page shows the snippet text: %q", w.Body.String())
+		}
+		if _, exists := snippets["xyz"]; !exists {
+			t.Fatal("GET burned the snippet")
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/reveal/xyz", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /reveal status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), "Secret") {
+		t.Errorf("Reveal body = %q, want the snippet text", w.Body.String())
+	}
+	if _, exists := snippets["xyz"]; exists {
+		t.Error("Snippet should have been burned by the reveal")
+	}
+
+	// It can only be revealed once
+	req = httptest.NewRequest("POST", "/reveal/xyz", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Second reveal status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// Test a snippet comes back as JSON with a .json suffix or an Accept
+// header, and a burn snippet is burned by the JSON fetch
+func TestDisplaySnippet_JSON(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+
+	initTestTemplates(t)
+	cfg.BurnConfirm = false
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := created.Add(24 * 365 * time.Hour * 10)
+	router := newRouter()
+
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+	}{
+		{"suffix", "/display/abc.json", ""},
+		{"accept header", "/display/abc", "application/json"},
+		{"format query", "/display/abc?format=json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = map[string]Snippet{
+				"abc":  {Title: "Notes", Text: "hello <b>json</b>", CreatedAt: created, Views: 4, ExpiresAt: expires},
+				"burn": {Title: "Secret", Text: "only once", CreatedAt: created, BurnAfterReading: true},
+			}
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var got SnippetJSON
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Response is not JSON: %v: %s", err, w.Body.String())
+			}
+			want := SnippetJSON{ID: "abc", Title: "Notes", Text: "hello <b>json</b>", CreatedAt: created, Views: 4, ExpiresAt: &expires}
+			if got.ID != want.ID || got.Title != want.Title || got.Text != want.Text || !got.CreatedAt.Equal(want.CreatedAt) ||
+				got.Views != want.Views || got.BurnAfterReading || got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) {
+				t.Errorf("JSON = %+v, want %+v", got, want)
+			}
+			if snippets["abc"].Views != 5 {
+				t.Errorf("Views after JSON fetch = %d, want 5", snippets["abc"].Views)
+			}
+		})
+	}
+
+	// A burn snippet's JSON burns it, same as the page
+	req := httptest.NewRequest("GET", "/display/burn.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var burned SnippetJSON
+	json.Unmarshal(w.Body.Bytes(), &burned)
+	if w.Code != http.StatusOK || burned.Text != "only once" || !burned.BurnAfterReading || burned.ExpiresAt != nil {
+		t.Errorf("Burn snippet JSON = %d %+v", w.Code, burned)
+	}
+	if _, exists := snippets["burn"]; exists {
+		t.Error("JSON fetch didn't burn the snippet")
+	}
+	req = httptest.NewRequest("GET", "/display/burn.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), apiErrNotFound) {
+		t.Errorf("Second JSON fetch = %d %s, want a JSON 404", w.Code, w.Body.String())
+	}
+
+	// Plain requests still get the page
+	req = httptest.NewRequest("GET", "/display/abc", nil)
+	req.Header.Set("Accept", "text/html,*/*")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Content-Type") == "application/json" || strings.HasPrefix(w.Body.String(), "{") {
+		t.Errorf("HTML request got %s", w.Body.String())
+	}
+}
+
+// Test with burn_confirm on, a burn snippet's JSON leaves out the text and
+// points at the reveal URL, which burns it
+func TestDisplaySnippet_JSONBurnConfirm(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		cfg = originalCfg
+	})
+
+	cfg.BurnConfirm = true
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"xyz": {Title: "Burn Me", Text: "Secret", BurnAfterReading: true},
+	}
+	router := newRouter()
+
+	req := httptest.NewRequest("GET", "/display/xyz.json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var got SnippetJSON
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Text != "" || got.RevealURL != "http://example.com/reveal/xyz.json" {
+		t.Errorf("GET = %d %s, want metadata and the reveal URL", w.Code, w.Body.String())
+	}
+	if _, exists := snippets["xyz"]; !exists {
+		t.Fatal("GET burned the snippet")
+	}
+
+	req = httptest.NewRequest("POST", "/reveal/xyz.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	got = SnippetJSON{}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if w.Code != http.StatusOK || got.Text != "Secret" {
+		t.Errorf("Reveal = %d %s, want the text", w.Code, w.Body.String())
+	}
+	if _, exists := snippets["xyz"]; exists {
+		t.Error("Reveal didn't burn the snippet")
+	}
+}
+
 // Test displaySnippet with non-existent ID
 func TestDisplaySnippet_NotFound(t *testing.T) {
 	originalSnippets := snippets
@@ -437,69 +907,1464 @@ func TestDisplaySnippet_NotFound(t *testing.T) {
 
 	displaySnippet(w, req)
 
-	// Should redirect to home
-	if w.Code != http.StatusSeeOther {
-		t.Errorf("displaySnippet() status = %d, want %d (redirect)", w.Code, http.StatusSeeOther)
+	// Should render the not-found page rather than redirecting home
+	if w.Code != http.StatusNotFound {
+		t.Errorf("displaySnippet() status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if !strings.Contains(strings.ToLower(w.Body.String()), "not found") {
+		t.Errorf("Response body should mention not found, got: %s", w.Body.String())
 	}
 }
 
-// Test deleteSnippet HTTP handler
-func TestDeleteSnippet(t *testing.T) {
+// Test deleteSnippet with non-existent ID
+func TestDeleteSnippet_NotFound(t *testing.T) {
 	originalSnippets := snippets
 	t.Cleanup(func() {
 		snippets = originalSnippets
 	})
 
-	snippets = map[string]Snippet{
-		"abc": {Title: "Test", Text: "Content"},
-	}
+	snippets = make(map[string]Snippet)
 
-	req := httptest.NewRequest("POST", "/delete/abc", nil)
-	req = mux.SetURLVars(req, map[string]string{"url": "abc"})
+	req := httptest.NewRequest("POST", "/delete/nonexistent", nil)
+	req = mux.SetURLVars(req, map[string]string{"url": "nonexistent"})
 	w := httptest.NewRecorder()
 
 	deleteSnippet(w, req)
 
-	// Check redirect
-	if w.Code != http.StatusSeeOther {
-		t.Errorf("deleteSnippet() status = %d, want %d", w.Code, http.StatusSeeOther)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("deleteSnippet() status = %d, want %d", w.Code, http.StatusNotFound)
 	}
-
-	// Verify snippet was deleted
-	if _, exists := snippets["abc"]; exists {
-		t.Error("Snippet should have been deleted")
+	if !strings.Contains(strings.ToLower(w.Body.String()), "not found") {
+		t.Errorf("Response body should mention not found, got: %s", w.Body.String())
 	}
 }
 
-// Test serveIndex HTTP handler
-func TestServeIndex(t *testing.T) {
+// Test rawSnippet returns the unrendered source
+func TestRawSnippet(t *testing.T) {
 	originalSnippets := snippets
 	t.Cleanup(func() {
 		snippets = originalSnippets
 	})
 
-	initTestTemplates(t)
-
 	snippets = map[string]Snippet{
-		"abc": {Title: "Test1", Text: "Content1"},
-		"xyz": {Title: "Test2", Text: "Content2"},
+		"md":   {Title: "Notes", Text: "# Heading\n**bold**", Render: renderMarkdown},
+		"burn": {Title: "Secret", Text: "once", BurnAfterReading: true},
 	}
 
-	// Create temp uploads directory
-	tmpDir := t.TempDir()
-	originalWd, _ := os.Getwd()
-	os.Chdir(tmpDir)
-	os.MkdirAll("uploads", 0755)
+	tests := []struct {
+		id         string
+		wantStatus int
+		wantBody   string
+	}{
+		{"md", http.StatusOK, "# Heading\n**bold**"},
+		{"burn", http.StatusOK, "once"},
+		{"burn", http.StatusNotFound, ""},
+		{"missing", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/raw/"+tt.id, nil)
+		req = mux.SetURLVars(req, map[string]string{"url": tt.id})
+		w := httptest.NewRecorder()
+
+		rawSnippet(w, req)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("rawSnippet(%s) status = %d, want %d", tt.id, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		if w.Body.String() != tt.wantBody {
+			t.Errorf("rawSnippet(%s) body = %q, want %q", tt.id, w.Body.String(), tt.wantBody)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("rawSnippet(%s) Content-Type = %q, want text/plain", tt.id, ct)
+		}
+	}
+}
+
+// Test deleteSnippet HTTP handler
+func TestDeleteSnippet(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
 	t.Cleanup(func() {
-		os.Chdir(originalWd)
+		snippets = originalSnippets
+		cfg = originalCfg
 	})
 
-	req := httptest.NewRequest("GET", "/", nil)
-	w := httptest.NewRecorder()
+	tests := []struct {
+		basePath     string
+		wantLocation string
+	}{
+		{"", "/"},
+		{"/pasty", "/pasty/"},
+	}
 
-	serveIndex(w, req)
+	for _, tt := range tests {
+		cfg.BasePath = tt.basePath
+		snippets = map[string]Snippet{
+			"abc": {Title: "Test", Text: "Content"},
+		}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("serveIndex() status = %d, want %d", w.Code, http.StatusOK)
+		req := httptest.NewRequest("POST", "/delete/abc", nil)
+		req = mux.SetURLVars(req, map[string]string{"url": "abc"})
+		w := httptest.NewRecorder()
+
+		deleteSnippet(w, req)
+
+		// Check redirect, which stays under the base path
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("deleteSnippet() status = %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		if location := w.Header().Get("Location"); location != tt.wantLocation {
+			t.Errorf("Redirect with base path %q = %q, want %q", tt.basePath, location, tt.wantLocation)
+		}
+
+		// Verify snippet was deleted
+		if _, exists := snippets["abc"]; exists {
+			t.Error("Snippet should have been deleted")
+		}
+	}
+}
+
+// Test serveIndex HTTP handler
+func TestServeIndex(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	initTestTemplates(t)
+
+	snippets = map[string]Snippet{
+		"abc": {Title: "Test1", Text: "Content1"},
+		"xyz": {Title: "Test2", Text: "Content2"},
+	}
+
+	// Create temp uploads directory
+	tmpDir := t.TempDir()
+	originalWd, _ := os.Getwd()
+	os.Chdir(tmpDir)
+	os.MkdirAll("uploads", 0755)
+	t.Cleanup(func() {
+		os.Chdir(originalWd)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+
+	serveIndex(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("serveIndex() status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// Test unlisted snippets stay off the index but open by their URL
+func TestUnlistedSnippet(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		tmplIndex = originalTmplIndex
+	})
+
+	initTestTemplates(t)
+	tmplIndex = template.Must(template.New("index").Parse(`{{range .Snippets}}{{.ID}},{{end}}`))
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = make(map[string]Snippet)
+
+	for _, visibility := range []string{"", "unlisted", "bogus"} {
+		form := url.Values{"title": {"T"}, "text": {"body"}, "visibility": {visibility}, "slug": {"s-" + visibility}}
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSave(w, req)
+
+		wantStatus := http.StatusSeeOther
+		if visibility == "bogus" {
+			wantStatus = http.StatusBadRequest
+		}
+		if w.Code != wantStatus {
+			t.Errorf("handleSave(visibility=%q) status = %d, want %d", visibility, w.Code, wantStatus)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	serveIndex(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.String() != "s-," {
+		t.Errorf("Index lists %q, want only the public snippet", w.Body.String())
+	}
+
+	for _, handler := range []http.HandlerFunc{displaySnippet, rawSnippet} {
+		req := httptest.NewRequest("GET", "/display/s-unlisted", nil)
+		req = mux.SetURLVars(req, map[string]string{"url": "s-unlisted"})
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "body") {
+			t.Errorf("Unlisted snippet by URL = %d %q, want 200 with its text", w.Code, w.Body.String())
+		}
+	}
+}
+
+// Test serveIndex returns the listing as JSON when asked for it
+func TestServeIndex_JSON(t *testing.T) {
+	originalSnippets := snippets
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		tmplIndex = originalTmplIndex
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(`<html>{{len .Snippets}}</html>`))
+	uploadsDir = t.TempDir()
+	os.WriteFile(filepath.Join(uploadsDir, "1-a.txt"), []byte("a"), 0644)
+	files = map[string]FileInfo{"1-a.txt": {ID: "1-a.txt", Name: "a.txt"}}
+	snippets = map[string]Snippet{
+		"abc": {Title: "Test1", Text: "Content1", CreatedAt: time.Now()},
+		"xyz": {Title: "Test2", Text: "Content2", CreatedAt: time.Now().Add(-time.Hour)},
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		wantJSON bool
+	}{
+		{"accept header", "/", "application/json", true},
+		{"format query", "/?format=json", "", true},
+		{"browser", "/", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"default", "/", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			serveIndex(w, req)
+
+			if !tt.wantJSON {
+				if w.Body.String() != "<html>2</html>" {
+					t.Errorf("serveIndex() body = %q, want the HTML template", w.Body.String())
+				}
+				return
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var data struct {
+				Snippets []map[string]any `json:"snippets"`
+				Files    []map[string]any `json:"files"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+				t.Fatalf("serveIndex() body %q isn't JSON: %v", w.Body.String(), err)
+			}
+			if len(data.Snippets) != 2 || data.Snippets[0]["id"] != "abc" || data.Snippets[1]["id"] != "xyz" {
+				t.Errorf("snippets = %v, want abc then xyz", data.Snippets)
+			}
+			if len(data.Files) != 1 || data.Files[0]["id"] != "1-a.txt" {
+				t.Errorf("files = %v, want 1-a.txt", data.Files)
+			}
+		})
+	}
+}
+
+// Test serveIndex gives terminal clients a plain-text table of the same
+// snippets, newest first, and browsers still get HTML
+func TestServeIndex_Text(t *testing.T) {
+	originalSnippets := snippets
+	originalUploadsDir := uploadsDir
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		uploadsDir = originalUploadsDir
+		tmplIndex = originalTmplIndex
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(`<html>{{len .Snippets}}</html>`))
+	uploadsDir = t.TempDir()
+	created := time.Date(2026, 3, 1, 12, 30, 0, 0, time.Local)
+	snippets = map[string]Snippet{
+		"abc": {Title: "Newer\tpaste", Text: "Content1", CreatedAt: created},
+		"xyz": {Title: "Older", Text: "Content2", CreatedAt: created.Add(-time.Hour)},
+	}
+	want := "ID   TITLE        CREATED\n" +
+		"abc  Newer paste  2026-03-01 12:30:00\n" +
+		"xyz  Older        2026-03-01 11:30:00\n"
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		wantText bool
+	}{
+		{"accept header", "/", "text/plain", true},
+		{"format query", "/?format=text", "", true},
+		{"browser", "/", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			serveIndex(w, req)
+
+			if !tt.wantText {
+				if w.Body.String() != "<html>2</html>" {
+					t.Errorf("serveIndex() body = %q, want the HTML template", w.Body.String())
+				}
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/plain", ct)
+			}
+			if w.Body.String() != want {
+				t.Errorf("serveIndex() body =\n%s\nwant\n%s", w.Body.String(), want)
+			}
+		})
+	}
+}
+
+// Test serveIndex lists files newest first, falling back to the file's
+// modification time when there's no metadata
+func TestServeIndex_FilesNewestFirst(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		tmplIndex = originalTmplIndex
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(`{{range .Files}}{{.ID}},{{end}}`))
+	uploadsDir = t.TempDir()
+
+	now := time.Now()
+	for _, name := range []string{"a-old.txt", "b-orphan.txt", "c-new.txt"} {
+		os.WriteFile(filepath.Join(uploadsDir, name), []byte(name), 0644)
+	}
+	os.Chtimes(filepath.Join(uploadsDir, "b-orphan.txt"), now.Add(-time.Hour), now.Add(-time.Hour))
+	files = map[string]FileInfo{
+		"a-old.txt": {ID: "a-old.txt", Name: "old.txt", UploadedAt: now.Add(-2 * time.Hour)},
+		"c-new.txt": {ID: "c-new.txt", Name: "new.txt", UploadedAt: now},
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	serveIndex(w, req)
+
+	want := "c-new.txt,b-orphan.txt,a-old.txt,"
+	if w.Body.String() != want {
+		t.Errorf("serveIndex() files = %q, want %q", w.Body.String(), want)
+	}
+}
+
+// Test max_index_files keeps only the newest files on the index
+func TestServeIndex_MaxIndexFiles(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalTmplIndex := tmplIndex
+	originalCfg := cfg
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		tmplIndex = originalTmplIndex
+		cfg = originalCfg
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(`{{range .Files}}{{.ID}},{{end}}`))
+	uploadsDir = t.TempDir()
+	files = make(map[string]FileInfo)
+	cfg.MaxIndexFiles = 5
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		id := strconv.Itoa(10+i) + ".txt"
+		os.WriteFile(filepath.Join(uploadsDir, id), []byte(id), 0644)
+		files[id] = FileInfo{ID: id, Name: id, UploadedAt: now.Add(time.Duration(i) * time.Minute)}
+	}
+
+	w := httptest.NewRecorder()
+	serveIndex(w, httptest.NewRequest("GET", "/", nil))
+
+	want := "29.txt,28.txt,27.txt,26.txt,25.txt,"
+	if w.Body.String() != want {
+		t.Errorf("serveIndex() files = %q, want %q", w.Body.String(), want)
+	}
+
+	// The API still pages through all of them
+	w = httptest.NewRecorder()
+	apiFilesHandler(w, httptest.NewRequest("GET", "/api/files", nil))
+	var list []APIFile
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 20 {
+		t.Errorf("apiFilesHandler() listed %d files, want 20", len(list))
+	}
+
+	if got := buildFileEntries(files, 5); len(got) != 5 || got[0].ID != "29.txt" {
+		t.Errorf("buildFileEntries(files, 5) = %d entries starting %v, want 5 starting 29.txt", len(got), got)
+	}
+}
+
+// Test the index lists index_snippet_count of the newest snippets
+func TestServeIndex_IndexSnippetCount(t *testing.T) {
+	originalSnippets := snippets
+	originalTmplIndex := tmplIndex
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		tmplIndex = originalTmplIndex
+		cfg = originalCfg
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(`{{range .Snippets}}{{.ID}},{{end}}`))
+	snippets = make(map[string]Snippet)
+
+	now := time.Now()
+	for i := 0; i < 30; i++ {
+		snippets["s"+strconv.Itoa(10+i)] = Snippet{Title: "t", Text: "x", CreatedAt: now.Add(time.Duration(i) * time.Minute)}
+	}
+
+	tests := []struct {
+		count int
+		want  int
+	}{
+		{3, 3},
+		{25, 25},
+		{0, 30},
+	}
+	for _, tt := range tests {
+		cfg.IndexSnippetCount = tt.count
+
+		w := httptest.NewRecorder()
+		serveIndex(w, httptest.NewRequest("GET", "/", nil))
+
+		ids := strings.Split(strings.TrimSuffix(w.Body.String(), ","), ",")
+		if len(ids) != tt.want {
+			t.Errorf("index_snippet_count %d: serveIndex() listed %d snippets, want %d", tt.count, len(ids), tt.want)
+			continue
+		}
+		for i, id := range ids {
+			if want := "s" + strconv.Itoa(39-i); id != want {
+				t.Errorf("index_snippet_count %d: snippet %d = %s, want %s", tt.count, i, id, want)
+				break
+			}
+		}
+	}
+}
+
+// Test deleteSnippet requires the snippet's delete token
+func TestDeleteSnippet_Token(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	tests := []struct {
+		name        string
+		token       string
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{"correct token", "secret-token", http.StatusSeeOther, true},
+		{"wrong token", "not-the-token", http.StatusForbidden, false},
+		{"missing token", "", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = map[string]Snippet{
+				"abc": {Title: "Test", Text: "Content", DeleteTokenHash: hashDeleteToken("secret-token")},
+			}
+
+			form := url.Values{}
+			if tt.token != "" {
+				form.Add("token", tt.token)
+			}
+			req := httptest.NewRequest("POST", "/delete/abc", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req = mux.SetURLVars(req, map[string]string{"url": "abc"})
+			w := httptest.NewRecorder()
+
+			deleteSnippet(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("deleteSnippet() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if _, exists := snippets["abc"]; exists == tt.wantDeleted {
+				t.Errorf("Snippet exists = %v, want %v", exists, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+// Test deleting several snippets in one request leaves only the others,
+// skipping unknown IDs, immutable snippets and ones without their token
+func TestDeleteMultipleSnippets(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	snippets = map[string]Snippet{
+		"one":    {Text: "1", DeleteTokenHash: hashDeleteToken("token-one")},
+		"two":    {Text: "2", DeleteTokenHash: hashDeleteToken("token-two")},
+		"three":  {Text: "3"},
+		"four":   {Text: "4", DeleteTokenHash: hashDeleteToken("token-four")},
+		"frozen": {Text: "5", Immutable: true},
+	}
+
+	form := url.Values{
+		"id":    {"one", "two"},
+		"ids":   {"three, four,missing,frozen"},
+		"token": {"token-one", "token-two"},
+	}
+	req := httptest.NewRequest("POST", "/delete-multiple", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	deleteMultipleSnippets(w, req)
+
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Errorf("deleteMultipleSnippets() = %d to %q, want %d to /", w.Code, w.Header().Get("Location"), http.StatusSeeOther)
+	}
+	remaining := slices.Sorted(maps.Keys(snippets))
+	if want := []string{"four", "frozen"}; !slices.Equal(remaining, want) {
+		t.Errorf("Remaining snippets = %v, want %v", remaining, want)
+	}
+
+	saved, _ := os.ReadFile(snippetsFile)
+	if strings.Contains(string(saved), `"one"`) || !strings.Contains(string(saved), `"four"`) {
+		t.Error("snippets.json doesn't match what's left")
+	}
+}
+
+// Test handleSave hands the delete token to the creator and stores only its hash
+func TestHandleSave_DeleteToken(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	snippets = make(map[string]Snippet)
+
+	form := url.Values{}
+	form.Add("text", "Test content")
+
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to parse redirect location: %v", err)
+	}
+	token := location.Query().Get("token")
+	if token == "" {
+		t.Fatalf("Redirect %q does not carry a delete token", location)
+	}
+
+	for _, snippet := range snippets {
+		if snippet.DeleteTokenHash == token {
+			t.Error("Delete token stored in plaintext")
+		}
+		if !validDeleteToken(snippet, token) {
+			t.Error("Redirected token does not validate against stored hash")
+		}
+	}
+}
+
+// Test handleSave evicts the oldest snippet once MaxSnippets is reached
+func TestHandleSave_MaxSnippetsEviction(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	cfg.MaxSnippets = 2
+	now := time.Now()
+	snippets = map[string]Snippet{
+		"old": {Title: "Oldest", Text: "1", CreatedAt: now.Add(-2 * time.Hour)},
+		"new": {Title: "Newer", Text: "2", CreatedAt: now.Add(-1 * time.Hour)},
+	}
+
+	form := url.Values{}
+	form.Add("text", "third")
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+
+	if len(snippets) != 2 {
+		t.Errorf("Have %d snippets after save, want cap of 2", len(snippets))
+	}
+	if _, exists := snippets["old"]; exists {
+		t.Error("Oldest snippet should have been evicted")
+	}
+	if _, exists := snippets["new"]; !exists {
+		t.Error("Newer snippet should have been kept")
+	}
+}
+
+// Test eviction skips unread burn snippets while others remain
+func TestEvictOldestSnippet_PrefersNonBurn(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	now := time.Now()
+	snippets = map[string]Snippet{
+		"burn":  {Text: "secret", BurnAfterReading: true, CreatedAt: now.Add(-3 * time.Hour)},
+		"plain": {Text: "plain", CreatedAt: now.Add(-1 * time.Hour)},
+	}
+
+	evictOldestSnippet()
+	if _, exists := snippets["burn"]; !exists {
+		t.Error("Unread burn snippet evicted while a non-burn snippet was available")
+	}
+
+	evictOldestSnippet()
+	if len(snippets) != 0 {
+		t.Errorf("Have %d snippets, want burn snippet evicted as a last resort", len(snippets))
+	}
+}
+
+// Test buildSnippetsList orders newest first
+func TestBuildSnippetsList_Ordering(t *testing.T) {
+	now := time.Now()
+	results := buildSnippetsList(map[string]Snippet{
+		"a": {Title: "oldest", CreatedAt: now.Add(-2 * time.Hour)},
+		"b": {Title: "newest", CreatedAt: now},
+		"c": {Title: "middle", CreatedAt: now.Add(-1 * time.Hour)},
+	}, 0)
+
+	want := []string{"b", "c", "a"}
+	for i, id := range want {
+		if results[i].ID != id {
+			t.Errorf("results[%d].ID = %s, want %s", i, results[i].ID, id)
+		}
+	}
+}
+
+// Test the configured site title and tagline appear on the index
+func TestServeIndex_SiteTitle(t *testing.T) {
+	originalCfg := cfg
+	originalTmplIndex := tmplIndex
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		tmplIndex = originalTmplIndex
+		uploadsDir = originalUploadsDir
+	})
+
+	tmplIndex = template.Must(template.ParseFiles("templates/index.html"))
+	uploadsDir = t.TempDir()
+	cfg.SiteTitle = "Team Scratchpad"
+	cfg.SiteTagline = "Paste all the things"
+
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	serveIndex(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "<title>Team Scratchpad - Home</title>") {
+		t.Errorf("Index should use the configured site title, got: %s", body)
+	}
+	if !strings.Contains(body, "Paste all the things") {
+		t.Errorf("Index should show the configured tagline")
+	}
+}
+
+// Test the configured logo and stylesheet are referenced by the index, and
+// left out when unset
+func TestServeIndex_LogoAndCSS(t *testing.T) {
+	originalCfg := cfg
+	originalTmplIndex := tmplIndex
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		tmplIndex = originalTmplIndex
+		uploadsDir = originalUploadsDir
+	})
+
+	tmplIndex = template.Must(template.ParseFiles("templates/index.html"))
+	uploadsDir = t.TempDir()
+
+	w := httptest.NewRecorder()
+	serveIndex(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); strings.Contains(body, "site-logo") || strings.Contains(body, `rel="stylesheet"`) {
+		t.Error("Index references a logo or stylesheet with none configured")
+	}
+
+	cfg.BasePath = "/pasty"
+	cfg.LogoURL = "https://cdn.example.com/logo.png"
+	cfg.CustomCSSPath = "brand/site.css"
+
+	w = httptest.NewRecorder()
+	serveIndex(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `<img src="https://cdn.example.com/logo.png"`) {
+		t.Errorf("Index should show the configured logo, got: %s", body)
+	}
+	if !strings.Contains(body, `<link rel="stylesheet" href="/pasty/static/brand/site.css">`) {
+		t.Errorf("Index should link the custom stylesheet under /static/")
+	}
+}
+
+// Test handleSave with custom slugs
+func TestHandleSave_CustomSlug(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	tests := []struct {
+		name       string
+		slug       string
+		wantStatus int
+	}{
+		{"valid slug", "my-notes", http.StatusSeeOther},
+		{"taken slug", "taken", http.StatusConflict},
+		{"reserved word", "api", http.StatusBadRequest},
+		{"reserved word any case", "Delete", http.StatusBadRequest},
+		{"invalid characters", "no/slashes", http.StatusBadRequest},
+		{"too long", strings.Repeat("a", maxSlugLength+1), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = map[string]Snippet{
+				"taken": {Title: "Existing", Text: "keep me"},
+			}
+
+			form := url.Values{}
+			form.Add("text", "Test content")
+			form.Add("slug", tt.slug)
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleSave() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusSeeOther {
+				if got := snippets[tt.slug].Text; got != "Test content" {
+					t.Errorf("Snippet %s text = %q, want saved under the custom slug", tt.slug, got)
+				}
+				return
+			}
+			if snippets["taken"].Text != "keep me" {
+				t.Error("Rejected save clobbered an existing snippet")
+			}
+			if len(snippets) != 1 {
+				t.Errorf("Rejected save left %d snippets, want 1", len(snippets))
+			}
+		})
+	}
+}
+
+// Test numberLines splits text into numbered lines
+func TestNumberLines(t *testing.T) {
+	lines := numberLines("first\nsecond\nthird\n")
+	if len(lines) != 3 {
+		t.Fatalf("numberLines() returned %d lines, want 3", len(lines))
+	}
+	for i, want := range []string{"first", "second", "third"} {
+		if lines[i].Number != i+1 || lines[i].Text != want {
+			t.Errorf("lines[%d] = %+v, want {%d %s}", i, lines[i], i+1, want)
+		}
+	}
+}
+
+// Test displaySnippet renders line numbers and honors the wrap toggle
+func TestDisplaySnippet_LineNumbers(t *testing.T) {
+	originalSnippets := snippets
+	originalTmplDisplay := tmplDisplay
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		tmplDisplay = originalTmplDisplay
+	})
+
+	tmplDisplay = template.Must(template.ParseFiles("templates/display.html"))
+	snippets = map[string]Snippet{
+		"abc": {Title: "Code", Text: "package main\nfunc main() {}\n"},
+	}
+
+	tests := []struct {
+		query      string
+		wantNums   bool
+		wantNoWrap bool
+	}{
+		{"", false, false},
+		{"?nums=1", true, false},
+		{"?nums=1&wrap=0", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/display/abc"+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"url": "abc"})
+			w := httptest.NewRecorder()
+			displaySnippet(w, req)
+
+			body := w.Body.String()
+			hasNums := strings.Contains(body, `<span class="line" data-line="1">package main</span>`) &&
+				strings.Contains(body, `data-line="2"`)
+			if hasNums != tt.wantNums {
+				t.Errorf("Numbered lines present = %v, want %v", hasNums, tt.wantNums)
+			}
+			if hasNoWrap := strings.Contains(body, "snippet-text nowrap"); hasNoWrap != tt.wantNoWrap {
+				t.Errorf("nowrap present = %v, want %v", hasNoWrap, tt.wantNoWrap)
+			}
+		})
+	}
+}
+
+// Test newServer applies the configured timeouts
+func TestNewServer(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadTimeout = 30
+	config.ReadHeaderTimeout = 5
+	config.WriteTimeout = 45
+	config.IdleTimeout = 90
+
+	server := newServer("localhost:3015", config)
+
+	if server.Addr != "localhost:3015" {
+		t.Errorf("Addr = %q, want localhost:3015", server.Addr)
+	}
+	if server.ReadTimeout != 30*time.Second {
+		t.Errorf("ReadTimeout = %v, want 30s", server.ReadTimeout)
+	}
+	if server.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("ReadHeaderTimeout = %v, want 5s", server.ReadHeaderTimeout)
+	}
+	if server.WriteTimeout != 45*time.Second {
+		t.Errorf("WriteTimeout = %v, want 45s", server.WriteTimeout)
+	}
+	if server.IdleTimeout != 90*time.Second {
+		t.Errorf("IdleTimeout = %v, want 90s", server.IdleTimeout)
+	}
+	if server.Handler == nil {
+		t.Error("Handler not set")
+	}
+}
+
+// Test absolute links use the public scheme and host, whether the request
+// came straight in or through a proxy
+func TestAbsoluteURL(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+
+	tests := []struct {
+		name       string
+		tls        bool
+		proto      string // X-Forwarded-Proto
+		trustProxy bool
+		domain     string
+		basePath   string
+		want       string
+	}{
+		{"direct", false, "", false, "", "", "http://10.0.0.5:3015/view/abc"},
+		{"direct TLS", true, "", false, "", "", "https://10.0.0.5:3015/view/abc"},
+		{"proxied", false, "https", true, "", "", "https://10.0.0.5:3015/view/abc"},
+		{"proxied with domain", false, "https", true, "paste.example.com", "/pasty", "https://paste.example.com/pasty/view/abc"},
+		{"untrusted proxy header", false, "https", false, "", "", "http://10.0.0.5:3015/view/abc"},
+		{"junk proxy header", true, "gopher", true, "", "", "https://10.0.0.5:3015/view/abc"},
+		{"direct with domain", false, "", false, "paste.example.com:8443", "", "http://paste.example.com:8443/view/abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.TrustProxy = tt.trustProxy
+			cfg.DomainName = tt.domain
+			cfg.BasePath = tt.basePath
+
+			req := httptest.NewRequest("GET", "http://10.0.0.5:3015"+tt.basePath+"/file/abc?x=1", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+
+			if got := absoluteURL(req, "/view/abc"); got != tt.want {
+				t.Errorf("absoluteURL() = %q, want %q", got, tt.want)
+			}
+			wantCurrent := strings.TrimSuffix(tt.want, "/view/abc") + "/file/abc?x=1"
+			if got := currentURL(req); got != wantCurrent {
+				t.Errorf("currentURL() = %q, want %q", got, wantCurrent)
+			}
+		})
+	}
+}
+
+// Test routes, redirects and links honour a configured base path
+func TestBasePath(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	originalTmplDisplay := tmplDisplay
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+		tmplDisplay = originalTmplDisplay
+	})
+
+	cfg.BasePath = "/pasty"
+	snippets = make(map[string]Snippet)
+	tmplDisplay = template.Must(template.ParseFiles("templates/display.html"))
+	router := newRouter()
+
+	// Saving redirects under the base path
+	req := httptest.NewRequest("POST", "/pasty/save", strings.NewReader("text=hello"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("POST /pasty/save status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/pasty/display/") {
+		t.Fatalf("Redirect = %q, want it under /pasty/display/", location)
+	}
+
+	// The snippet page is served there and its links include the base path
+	req = httptest.NewRequest("GET", location, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s status = %d, want %d", location, w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{`href="/pasty/"`, `action="/pasty/delete/`, `href="/pasty/raw/`, `href="/pasty/display/`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Display page missing %s", want)
+		}
+	}
+
+	// Routes without the prefix no longer match
+	req = httptest.NewRequest("GET", strings.TrimPrefix(location, "/pasty"), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET without base path status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// The bare prefix redirects to the index
+	req = httptest.NewRequest("GET", "/pasty", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/pasty/" {
+		t.Errorf("GET /pasty = %d to %q, want redirect to /pasty/", w.Code, w.Header().Get("Location"))
+	}
+}
+
+// Test the default template directory holds every page template
+func TestParseTemplate_DefaultDir(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	cfg = DefaultConfig()
+	for _, name := range []string{"index.html", "display.html", "display_file.html", "download_file.html", "view.html", "notfound.html", "reveal.html", "unlock.html"} {
+		if tmpl := parseTemplate(name); tmpl == nil {
+			t.Errorf("parseTemplate(%q) = nil", name)
+		}
+	}
+}
+
+// Test dev mode picks up template edits on the next request, and that
+// production keeps the template parsed at startup
+func TestCurrentTemplate_DevMode(t *testing.T) {
+	originalCfg := cfg
+	originalNotFound := tmplNotFound
+	t.Cleanup(func() {
+		cfg = originalCfg
+		tmplNotFound = originalNotFound
+	})
+
+	cfg.TemplateDir = t.TempDir()
+	path := filepath.Join(cfg.TemplateDir, "notfound.html")
+	if err := os.WriteFile(path, []byte(`before: {{.Message}}`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	tmplNotFound = parseTemplate("notfound.html")
+	if err := os.WriteFile(path, []byte(`after: {{.Message}}`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		devMode bool
+		want    string
+	}{
+		{"production", false, "before: gone"},
+		{"dev mode", true, "after: gone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.DevMode = tt.devMode
+			w := httptest.NewRecorder()
+			renderNotFound(w, httptest.NewRequest("GET", "/nope", nil), "gone")
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("renderNotFound() body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A broken edit falls back to the last good template
+	cfg.DevMode = true
+	os.WriteFile(path, []byte(`{{.Message`), 0644)
+	w := httptest.NewRecorder()
+	renderNotFound(w, httptest.NewRequest("GET", "/nope", nil), "gone")
+	if got := w.Body.String(); got != "before: gone" {
+		t.Errorf("renderNotFound() with a broken template = %q, want the cached one", got)
+	}
+}
+
+// Test a raw POST body becomes a snippet and the response is its URL
+func TestHandleRawSave(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	cfg.EnableRawPaste = true
+
+	tests := []struct {
+		name        string
+		query       string
+		titleHeader string
+		body        string
+		wantStatus  int
+		wantTitle   string
+		wantBurn    bool
+		wantExpires bool
+	}{
+		{"plain", "", "", "package main\n\nfunc main() {\n}\n", http.StatusCreated, "package main", false, false},
+		{"title header", "?title=ignored", "From header", "hello", http.StatusCreated, "From header", false, false},
+		{"title query", "?title=From+query", "", "hello", http.StatusCreated, "From query", false, false},
+		{"burn and expiry", "?burn=1&expiry=1h", "", "secret", http.StatusCreated, "secret", true, true},
+		{"form-looking body kept as is", "", "", "title=x&text=y", http.StatusCreated, "title=x&text=y", false, false},
+		{"empty body", "", "", "", http.StatusBadRequest, "", false, false},
+		{"bad expiry", "?expiry=forever", "", "hello", http.StatusBadRequest, "", false, false},
+		{"too large", "", "", strings.Repeat("a", cfg.MaxSnippetBytes+1), http.StatusRequestEntityTooLarge, "", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+			router := newRouter()
+
+			req := httptest.NewRequest("POST", "/"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/plain")
+			if tt.titleHeader != "" {
+				req.Header.Set("X-Paste-Title", tt.titleHeader)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("POST / status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusCreated {
+				if len(snippets) != 0 {
+					t.Errorf("Rejected paste created %d snippets", len(snippets))
+				}
+				return
+			}
+
+			link := strings.TrimSpace(w.Body.String())
+			prefix := "http://example.com/display/"
+			if !strings.HasPrefix(link, prefix) {
+				t.Fatalf("Response = %q, want a URL starting with %s", link, prefix)
+			}
+			snippet, exists := snippets[strings.TrimPrefix(link, prefix)]
+			if !exists {
+				t.Fatalf("No snippet at %s", link)
+			}
+			if snippet.Text != tt.body {
+				t.Errorf("Snippet text = %q, want %q", snippet.Text, tt.body)
+			}
+			if snippet.Title != tt.wantTitle {
+				t.Errorf("Snippet title = %q, want %q", snippet.Title, tt.wantTitle)
+			}
+			if snippet.BurnAfterReading != tt.wantBurn {
+				t.Errorf("Snippet burn = %v, want %v", snippet.BurnAfterReading, tt.wantBurn)
+			}
+			if snippet.ExpiresAt.IsZero() == tt.wantExpires {
+				t.Errorf("Snippet ExpiresAt = %v, want expiry %v", snippet.ExpiresAt, tt.wantExpires)
+			}
+			if token := w.Header().Get("X-Delete-Token"); !validDeleteToken(snippet, token) {
+				t.Errorf("X-Delete-Token %q doesn't delete the snippet", token)
+			}
+		})
+	}
+}
+
+// Test POST / takes a raw body as the paste only with enable_raw_paste on,
+// and always hands forms to the form handler
+func TestHandleRootPost(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+
+	form := url.Values{"title": {"Form title"}, "text": {"form text"}}.Encode()
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	writer.WriteField("title", "Form title")
+	writer.WriteField("text", "form text")
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		rawPaste    bool
+		contentType string
+		body        string
+		wantStatus  int
+		wantText    string
+	}{
+		{"raw body", true, "text/plain", "raw text", http.StatusCreated, "raw text"},
+		{"raw body without a type", true, "", "raw text", http.StatusCreated, "raw text"},
+		{"urlencoded form", true, "application/x-www-form-urlencoded", form, http.StatusSeeOther, "form text"},
+		{"multipart form", true, writer.FormDataContentType(), multipartBody.String(), http.StatusSeeOther, "form text"},
+		{"form with charset", true, "application/x-www-form-urlencoded; charset=utf-8", form, http.StatusSeeOther, "form text"},
+		{"raw paste off", false, "application/x-www-form-urlencoded", form, http.StatusSeeOther, "form text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+			cfg.EnableRawPaste = tt.rawPaste
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			newRouter().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("POST / status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if len(snippets) != 1 {
+				t.Fatalf("POST / created %d snippets, want 1", len(snippets))
+			}
+			for _, snippet := range snippets {
+				if snippet.Body() != tt.wantText {
+					t.Errorf("Snippet text = %q, want %q", snippet.Body(), tt.wantText)
+				}
+			}
+		})
+	}
+}
+
+// Test how the burn field combines with default_burn and allow_burn_toggle
+func TestHandleSave_DefaultBurn(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	tests := []struct {
+		name        string
+		defaultBurn bool
+		allowToggle bool
+		burn        []string // nil leaves the field out
+		want        bool
+	}{
+		{"default off, no field", false, true, nil, false},
+		{"default on, no field", true, true, nil, true},
+		{"default on, unchecked", true, true, []string{"false"}, false},
+		{"default on, checked", true, true, []string{"false", "true"}, true},
+		{"default off, checked", false, true, []string{"false", "true"}, true},
+		{"toggle off forces on", true, false, []string{"false"}, true},
+		{"toggle off forces off", false, false, []string{"false", "true"}, false},
+		{"toggle off, no field", true, false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+			cfg.DefaultBurn = tt.defaultBurn
+			cfg.AllowBurnToggle = tt.allowToggle
+
+			form := url.Values{"title": {"T"}, "text": {"x"}}
+			if tt.burn != nil {
+				form["burn"] = tt.burn
+			}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+			}
+			for _, snippet := range snippets {
+				if snippet.BurnAfterReading != tt.want {
+					t.Errorf("BurnAfterReading = %v, want %v", snippet.BurnAfterReading, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Test appending twice builds up the snippet body line by line
+func TestAppendSnippet(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+
+	tokenHash := hashDeleteToken("secret-token")
+	snippets = map[string]Snippet{
+		"log":  {Title: "Log", Text: "first", DeleteTokenHash: tokenHash, CreatedAt: time.Now()},
+		"burn": {Title: "Burn", Text: "secret", DeleteTokenHash: tokenHash, BurnAfterReading: true},
+		"big":  {Title: "Big", Text: strings.Repeat("a", cfg.MaxSnippetBytes-2), DeleteTokenHash: tokenHash},
+	}
+	router := newRouter()
+
+	appendText := func(id, token, text string) int {
+		form := url.Values{"text": {text}, "token": {token}}
+		req := httptest.NewRequest("POST", "/append/"+id, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, text := range []string{"second", "third\n"} {
+		if code := appendText("log", "secret-token", text); code != http.StatusSeeOther {
+			t.Fatalf("Append %q status = %d, want %d", text, code, http.StatusSeeOther)
+		}
+	}
+	got := snippets["log"]
+	if want := "first\nsecond\nthird\n"; got.Text != want {
+		t.Errorf("Snippet text = %q, want %q", got.Text, want)
+	}
+	if got.ModifiedAt.IsZero() {
+		t.Error("ModifiedAt not set")
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  int
+	}{
+		{"unknown snippet", "nope", "secret-token", http.StatusNotFound},
+		{"wrong token", "log", "wrong", http.StatusForbidden},
+		{"burn after reading", "burn", "secret-token", http.StatusConflict},
+		{"too large combined", "big", "secret-token", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		if code := appendText(tt.id, tt.token, "more"); code != tt.want {
+			t.Errorf("%s: append status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if snippets["burn"].Text != "secret" || len(snippets["big"].Text) != cfg.MaxSnippetBytes-2 {
+		t.Error("Rejected append changed the snippet")
+	}
+}
+
+// Test a snippet right at max_snippet_bytes saves and one byte more is a 413
+func TestHandleSave_MaxSnippetBytes(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+	cfg.MaxSnippetBytes = 100
+
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"at the limit", 100, http.StatusSeeOther},
+		{"one byte over", 101, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+			form := url.Values{"title": {"T"}, "text": {strings.Repeat("a", tt.size)}}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("handleSave() status = %d, want %d", w.Code, tt.want)
+			}
+			if saved := len(snippets) == 1; saved != (tt.want == http.StatusSeeOther) {
+				t.Errorf("Snippet saved = %v", saved)
+			}
+		})
+	}
+
+	// The index tells the form what the limit is
+	initTestTemplates(t)
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		tmplIndex = originalTmplIndex
+	})
+	tmplIndex = template.Must(template.New("index").Parse(`{{.MaxSnippetBytes}}`))
+	w := httptest.NewRecorder()
+	serveIndex(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Body.String(); got != "100" {
+		t.Errorf("Index MaxSnippetBytes = %q, want 100", got)
+	}
+}
+
+// Test immutable snippets can't be deleted, appended to, burned or evicted,
+// but still display
+func TestImmutableSnippet(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalTmplDisplay := tmplDisplay
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		tmplDisplay = originalTmplDisplay
+		cfg = originalCfg
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	tmplDisplay = template.Must(template.ParseFiles("templates/display.html"))
+	snippets = make(map[string]Snippet)
+	router := newRouter()
+
+	post := func(target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/save", url.Values{"title": {"Audit"}, "text": {"record"}, "immutable": {"true"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Save status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	id := strings.TrimPrefix(loc.Path, "/display/")
+	token := loc.Query().Get("token")
+	if !snippets[id].Immutable {
+		t.Fatalf("Saved snippet isn't immutable: %+v", snippets[id])
+	}
+
+	if w := post("/delete/"+id, url.Values{"token": {token}}); w.Code != http.StatusForbidden {
+		t.Errorf("Delete status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := post("/append/"+id, url.Values{"token": {token}, "text": {"more"}}); w.Code != http.StatusForbidden {
+		t.Errorf("Append status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if got := snippets[id]; got.Text != "record" {
+		t.Errorf("Snippet text = %q after refused changes, want %q", got.Text, "record")
+	}
+
+	req := httptest.NewRequest("GET", "/display/"+id+"?token="+token, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "record") {
+		t.Errorf("Display status = %d, want %d with the text", w.Code, http.StatusOK)
+	}
+	if strings.Contains(w.Body.String(), "Delete Snippet") {
+		t.Error("Immutable snippet shown with a delete button")
+	}
+
+	// The flag survives a restart
+	saveSnippetsToFile(snippetsFile)
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	if !snippets[id].Immutable {
+		t.Error("Immutable flag not persisted")
+	}
+
+	// One that was somehow stored with burn set still isn't burned
+	snippets["imported"] = Snippet{Text: "kept", BurnAfterReading: true, Immutable: true}
+	cfg.BurnConfirm = false
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/raw/imported", nil))
+	if _, exists := snippets["imported"]; !exists || w.Body.String() != "kept" {
+		t.Errorf("Immutable snippet burned on read (body %q)", w.Body.String())
+	}
+
+	// Eviction passes them over, even if that leaves the limit exceeded
+	if evictOldestSnippet() {
+		t.Error("evictOldestSnippet() evicted an immutable snippet")
+	}
+}
+
+// Test immutable snippets can't be saved to burn or expire
+func TestHandleSave_ImmutableConflicts(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+	snippets = make(map[string]Snippet)
+
+	for _, form := range []url.Values{
+		{"text": {"x"}, "immutable": {"true"}, "burn": {"true"}},
+		{"text": {"x"}, "immutable": {"true"}, "expires": {"1h"}},
+	} {
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSave(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("handleSave(%v) status = %d, want %d", form, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(snippets) != 0 {
+		t.Errorf("Conflicting saves stored %d snippets, want 0", len(snippets))
 	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Render modes a snippet can be saved with
+const (
+	renderPlain    = "plain"
+	renderMarkdown = "markdown"
+)
+
+var (
+	markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+	// Strips scripts, event handlers and anything else that could run in
+	// the viewer's browser, keeping ordinary formatting.
+	markdownPolicy = bluemonday.UGCPolicy()
+)
+
+// validRenderMode reports whether mode can be used for a snippet. Empty means
+// plain.
+func validRenderMode(mode string) bool {
+	return mode == "" || mode == renderPlain || mode == renderMarkdown
+}
+
+// renderMarkdownHTML converts markdown source into sanitized HTML.
+func renderMarkdownHTML(source string) (string, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(source), &buf); err != nil {
+		return "", fmt.Errorf("rendering markdown: %w", err)
+	}
+	return markdownPolicy.Sanitize(buf.String()), nil
+}
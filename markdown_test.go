@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/gorilla/mux"
+)
+
+// Test markdown is converted to HTML with dangerous tags stripped
+func TestRenderMarkdownHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		notWant []string
+	}{
+		{"bold", "**bold**", []string{"<strong>bold</strong>"}, nil},
+		{"heading", "# Notes", []string{"<h1"}, nil},
+		{"list", "- one\n- two", []string{"<ul>", "<li>one</li>"}, nil},
+		{"script", "hi\n\n<script>alert(1)</script>", nil, []string{"<script", "alert(1)"}},
+		{"event handler", `<img src="x.png" onerror="alert(1)">`, nil, []string{"onerror"}},
+		{"javascript link", "[click](javascript:alert(1))", nil, []string{"javascript:"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderMarkdownHTML(tt.source)
+			if err != nil {
+				t.Fatalf("renderMarkdownHTML() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("renderMarkdownHTML(%q) = %q, want it to contain %q", tt.source, got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("renderMarkdownHTML(%q) = %q, should not contain %q", tt.source, got, notWant)
+				}
+			}
+		})
+	}
+}
+
+// Test displaySnippet renders markdown snippets and leaves plain ones alone
+func TestDisplaySnippet_Markdown(t *testing.T) {
+	originalSnippets := snippets
+	originalTmpl := tmplDisplay
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		tmplDisplay = originalTmpl
+	})
+
+	tmplDisplay = template.Must(template.New("display").Parse(`{{if .Markdown}}{{.HTML}}{{else}}{{.Text}}{{end}}`))
+	snippets = map[string]Snippet{
+		"md":    {Title: "Notes", Text: "**bold**", Render: renderMarkdown},
+		"plain": {Title: "Plain", Text: "**bold**"},
+	}
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"md", "<strong>bold</strong>"},
+		{"plain", "**bold**"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/display/"+tt.id, nil)
+			req = mux.SetURLVars(req, map[string]string{"url": tt.id})
+			w := httptest.NewRecorder()
+
+			displaySnippet(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("displaySnippet() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("displaySnippet() body = %q, want it to contain %q", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+// Test handleSave records the render mode and rejects unknown ones
+func TestHandleSave_Render(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+
+	tests := []struct {
+		render     string
+		wantStatus int
+	}{
+		{"", http.StatusSeeOther},
+		{"plain", http.StatusSeeOther},
+		{"markdown", http.StatusSeeOther},
+		{"html", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.render, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+
+			body := "text=hello&render=" + tt.render
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			handleSave(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handleSave() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusSeeOther {
+				if len(snippets) != 0 {
+					t.Error("Snippet saved despite an unknown render mode")
+				}
+				return
+			}
+			for _, snippet := range snippets {
+				if snippet.Render != tt.render {
+					t.Errorf("Render = %q, want %q", snippet.Render, tt.render)
+				}
+			}
+		})
+	}
+}
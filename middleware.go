@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// readOnlyPosts are POST routes that only read, so read-only mode lets them
+// through: revealing a burn-after-reading snippet (a POST only to keep link
+// previews from doing it) and unlocking a password-protected file.
+var readOnlyPosts = []string{"/reveal/", "/unlock/"}
+
+// readOnlyMiddleware rejects requests that would change anything while
+// cfg.ReadOnly is set. GET requests (viewing, downloading, streaming) are
+// let through as normal, as are readOnlyPosts.
+func readOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.ReadOnly && !isReadOnlyPost(r) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
+				http.Error(w, "This site is read-only", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isReadOnlyPost reports whether r is for one of readOnlyPosts
+func isReadOnlyPost(r *http.Request) bool {
+	for _, prefix := range readOnlyPosts {
+		if strings.HasPrefix(r.URL.Path, sitePath(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsMethods and corsHeaders are what cross-origin API callers may use
+const (
+	corsMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsHeaders = "Authorization, Content-Type, X-Admin-Token"
+)
+
+// corsMiddleware adds CORS headers to /api/ responses for callers from
+// cfg.AllowedOrigins. Other origins get no CORS headers at all, so the
+// browser keeps the response from them. The origin is only echoed back once
+// it's been found in the list.
+func corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, sitePath("/api/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := allowedOrigin(origin)
+		if allowed == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+		w.Header().Set("Access-Control-Allow-Methods", corsMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+		if cfg.CORSCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin, or
+// "" if it isn't allowed.
+func allowedOrigin(origin string) string {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// isPreflight matches OPTIONS requests for the preflight route
+func isPreflight(r *http.Request, _ *mux.RouteMatch) bool {
+	return r.Method == http.MethodOptions
+}
+
+// corsPreflight answers "OPTIONS /api/..." preflight requests; the headers
+// themselves come from corsMiddleware.
+func corsPreflight(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiTokenMiddleware requires a bearer token from cfg.APITokens on /api/
+// requests, answering 401 without one. With no tokens configured the API is
+// left open. Preflights are let through, since browsers send them without
+// credentials.
+func apiTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(cfg.APITokens) == 0 || r.Method == http.MethodOptions || !strings.HasPrefix(r.URL.Path, sitePath("/api/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, ok := apiTokenName(r.Header.Get("Authorization"))
+		if !ok {
+			logWarnf("Rejected API request for %s from %s", r.URL.Path, clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pasty"`)
+			writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "A valid bearer token is required")
+			return
+		}
+		logDebugf("API %s %s with token %q", r.Method, r.URL.Path, name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// viewAuthMiddleware turns away requests that haven't authenticated when
+// cfg.RequireAuthToView is set. It's applied to the routes that show
+// snippets and files; with the setting off they stay public.
+func viewAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.RequireAuthToView || authenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logWarnf("Rejected unauthenticated request for %s from %s", r.URL.Path, clientIP(r))
+		if len(cfg.APITokens) > 0 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pasty"`)
+		}
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	})
+}
+
+// authenticated reports whether a request carries any of the configured
+// credentials: a client certificate under mTLS, a bearer token from
+// cfg.APITokens, or the admin token.
+func authenticated(r *http.Request) bool {
+	if cfg.AuthEnabled && requestOwner(r) != "" {
+		return true
+	}
+	if _, ok := apiTokenName(r.Header.Get("Authorization")); ok {
+		return true
+	}
+	_, ok := adminIdentity(r)
+	return ok
+}
+
+// apiTokenName checks an Authorization header against cfg.APITokens,
+// returning the name of the token it carries. Every token is compared, in
+// constant time, so timing doesn't give away which one came close.
+func apiTokenName(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	given := hex.EncodeToString(sum[:])
+
+	name, ok := "", false
+	for _, t := range cfg.APITokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(strings.ToLower(t.SHA256))) == 1 {
+			name, ok = t.Name, true
+		}
+	}
+	return name, ok
+}
+
+// validInvite reports whether a paste or upload may go ahead under
+// cfg.InviteCodes: always when there are none, otherwise only with one of
+// them in the X-Invite-Code header or the "invite" form field. Like
+// apiTokenName, every code is compared in constant time.
+func validInvite(r *http.Request) bool {
+	if len(cfg.InviteCodes) == 0 {
+		return true
+	}
+	code := r.Header.Get("X-Invite-Code")
+	if code == "" {
+		code = r.FormValue("invite")
+	}
+	given := []byte(code)
+	ok := false
+	for _, code := range cfg.InviteCodes {
+		if code != "" && subtle.ConstantTimeCompare(given, []byte(code)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// requireInvite turns a request that creates what away with a 403 unless
+// validInvite lets it through. Every handler that creates a snippet or file
+// calls it before taking anything in.
+func requireInvite(w http.ResponseWriter, r *http.Request, what string, asJSON bool) bool {
+	if validInvite(r) {
+		return true
+	}
+	logWarnf("Rejected %s from %s: bad invite code", what, clientIP(r))
+	if asJSON {
+		writeAPIError(w, http.StatusForbidden, apiErrInvite, "A valid invite code is required")
+	} else {
+		http.Error(w, "A valid invite code is required", http.StatusForbidden)
+	}
+	return false
+}
+
+// longTransfer lifts the server's read and write deadlines for a single
+// request. The server-wide timeouts are sized for pages and form posts; a
+// multi-gigabyte upload or a long video stream would otherwise be cut off
+// when WriteTimeout/ReadTimeout expires. Headers are still bound by
+// ReadHeaderTimeout, since that runs before any handler.
+func longTransfer(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		// Not every ResponseWriter supports deadlines (e.g. in tests); the
+		// request just keeps the server defaults then
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		next(w, r)
+	})
+}
+
+// recoverMiddleware turns a panicking handler into a logged 500 instead of a
+// dropped connection. It goes outermost so it catches panics from the other
+// middleware too.
+func recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// Handlers use this to abort a response on purpose; let the
+			// server deal with it as usual
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			logErrorf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// Test read-only mode rejects saves and uploads but still serves snippets
+func TestReadOnlyMode(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	initTestTemplates(t)
+	snippets = map[string]Snippet{
+		"abc": {Title: "Test", Text: "Content"},
+	}
+	cfg.ReadOnly = true
+	router := newRouter()
+
+	form := url.Values{"title": {"New"}, "text": {"Blocked"}}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"save", "POST", "/save", form.Encode(), http.StatusForbidden},
+		{"upload", "POST", "/upload", "", http.StatusForbidden},
+		{"chunk", "PUT", "/upload/abc/0", "data", http.StatusForbidden},
+		{"delete", "POST", "/delete/abc", "", http.StatusForbidden},
+		{"display", "GET", "/display/abc", "", http.StatusOK},
+		{"reveal", "POST", "/reveal/abc", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+
+	if len(snippets) != 1 {
+		t.Errorf("Read-only mode changed snippets: got %d, want 1", len(snippets))
+	}
+}
+
+// Test requests pass through when read-only mode is off
+func TestReadOnlyMiddleware_Disabled(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+	cfg.ReadOnly = false
+
+	called := false
+	handler := readOnlyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest("POST", "/save", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if !called {
+		t.Error("POST should reach the handler when read-only mode is off")
+	}
+}
+
+// Test longTransfer lets a slow response outlive the server's WriteTimeout
+func TestLongTransfer(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "done")
+	}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		wantErr bool
+	}{
+		{"server timeout applies", http.HandlerFunc(slow), true},
+		{"lifted for long transfers", longTransfer(slow), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(tt.handler)
+			server.Config.WriteTimeout = 50 * time.Millisecond
+			server.Start()
+			defer server.Close()
+
+			resp, err := http.Get(server.URL)
+			if err == nil {
+				var body []byte
+				body, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err == nil && string(body) != "done" {
+					t.Errorf("Body = %q, want done", body)
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test a panicking handler still gets the client a 500
+func TestRecoverMiddleware(t *testing.T) {
+	router := newRouter()
+	router.HandleFunc("/test/panic", func(w http.ResponseWriter, r *http.Request) {
+		var tmpl *template.Template
+		tmpl.Execute(w, nil) // nil template, like a missing parse at startup
+	})
+
+	server := httptest.NewServer(recoverMiddleware(router))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/test/panic")
+	if err != nil {
+		t.Fatalf("GET /test/panic dropped the connection: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("GET /test/panic status = %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	// The server keeps working afterwards
+	resp, err = http.Get(server.URL + "/static/missing.css")
+	if err != nil {
+		t.Fatalf("GET after panic failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after panic status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+// Test CORS headers only go to allowed origins, and preflights get a 204
+func TestCORSMiddleware(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	tests := []struct {
+		name            string
+		origins         []string
+		credentials     bool
+		method          string
+		path            string
+		origin          string
+		wantStatus      int
+		wantAllowOrigin string
+	}{
+		{"allowed origin", []string{"https://app.example.com"}, false, "GET", "/api/snippets", "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"allowed origin with credentials", []string{"https://app.example.com"}, true, "GET", "/api/files", "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"disallowed origin", []string{"https://app.example.com"}, false, "GET", "/api/snippets", "https://evil.example.com", http.StatusOK, ""},
+		{"no origins configured", nil, false, "GET", "/api/snippets", "https://app.example.com", http.StatusOK, ""},
+		{"wildcard", []string{"*"}, false, "GET", "/api/snippets", "https://anyone.example.com", http.StatusOK, "*"},
+		{"preflight", []string{"https://app.example.com"}, false, "OPTIONS", "/api/snippets", "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"preflight from disallowed origin", []string{"https://app.example.com"}, false, "OPTIONS", "/api/snippets", "https://evil.example.com", http.StatusNoContent, ""},
+		{"not an API path", []string{"*"}, false, "GET", "/favicon.ico", "https://app.example.com", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AllowedOrigins = tt.origins
+			cfg.CORSCredentials = tt.credentials
+			cfg.StaticDir = t.TempDir()
+			router := newRouter()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == "OPTIONS" {
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			wantMethods := ""
+			if tt.wantAllowOrigin != "" {
+				wantMethods = corsMethods
+			}
+			if got := w.Header().Get("Access-Control-Allow-Methods"); got != wantMethods {
+				t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, wantMethods)
+			}
+			wantCredentials := ""
+			if tt.credentials && tt.wantAllowOrigin != "" {
+				wantCredentials = "true"
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, wantCredentials)
+			}
+		})
+	}
+}
+
+// Test api_tokens locks /api/ to requests with one of the bearer tokens
+func TestAPITokenMiddleware(t *testing.T) {
+	setupAPITest(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	sum := sha256.Sum256([]byte("ci-token"))
+	cfg.APITokens = []APIToken{
+		{Name: "other", SHA256: strings.Repeat("ab", sha256.Size)},
+		{Name: "ci", SHA256: hex.EncodeToString(sum[:])},
+	}
+	router := newRouter()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{"missing", "GET", "/api/snippets", "", http.StatusUnauthorized},
+		{"invalid", "GET", "/api/snippets", "Bearer wrong-token", http.StatusUnauthorized},
+		{"wrong scheme", "GET", "/api/snippets", "Basic ci-token", http.StatusUnauthorized},
+		{"valid", "GET", "/api/snippets", "Bearer ci-token", http.StatusOK},
+		{"valid, lowercase scheme", "GET", "/api/files", "bearer ci-token", http.StatusOK},
+		{"preflight", "OPTIONS", "/api/snippets", "", http.StatusNoContent},
+		{"not an API path", "GET", "/nope", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%s %s status = %d, want %d", tt.method, tt.path, w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusUnauthorized {
+				return
+			}
+			var apiErr APIError
+			if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Error.Code != apiErrUnauthorized {
+				t.Errorf("401 body = %q, want the %s API error", w.Body.String(), apiErrUnauthorized)
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+		})
+	}
+
+	// No tokens configured leaves the API open
+	cfg.APITokens = nil
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/snippets", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /api/snippets without api_tokens status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// Test require_auth_to_view puts snippet pages behind the bearer tokens, and
+// leaves them public when it's off
+func TestViewAuthMiddleware(t *testing.T) {
+	setupAPITest(t)
+	initTestTemplates(t)
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	sum := sha256.Sum256([]byte("viewer-token"))
+	cfg.APITokens = []APIToken{{Name: "viewer", SHA256: hex.EncodeToString(sum[:])}}
+
+	tests := []struct {
+		name       string
+		required   bool
+		path       string
+		auth       string
+		wantStatus int
+	}{
+		{"off", false, "/display/new", "", http.StatusOK},
+		{"on, no token", true, "/display/new", "", http.StatusUnauthorized},
+		{"on, wrong token", true, "/display/new", "Bearer nope", http.StatusUnauthorized},
+		{"on, token", true, "/display/new", "Bearer viewer-token", http.StatusOK},
+		{"on, raw", true, "/raw/new", "", http.StatusUnauthorized},
+		{"on, index", true, "/", "", http.StatusUnauthorized},
+		{"on, static", true, "/static/missing.css", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.RequireAuthToView = tt.required
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			newRouter().ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+			if w.Code == http.StatusUnauthorized && strings.Contains(w.Body.String(), "secret body") {
+				t.Error("Rejected request was shown the snippet")
+			}
+		})
+	}
+}
+
+// Test invite_codes gates pastes and uploads behind the invite field, and
+// leaves them open when empty
+func TestInviteCodes(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	snippetsFile = filepath.Join(tmpDir, "snippets.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	tests := []struct {
+		name   string
+		codes  []string
+		invite string
+		want   int
+	}{
+		{"open", nil, "", http.StatusSeeOther},
+		{"valid invite", []string{"first-code", "friends-2026"}, "friends-2026", http.StatusSeeOther},
+		{"wrong invite", []string{"friends-2026"}, "friends-2025", http.StatusForbidden},
+		{"no invite", []string{"friends-2026"}, "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.InviteCodes = tt.codes
+			snippets = make(map[string]Snippet)
+			files = make(map[string]FileInfo)
+
+			form := url.Values{"title": {"T"}, "text": {"hello"}, "invite": {tt.invite}}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+			if w.Code != tt.want {
+				t.Errorf("handleSave() status = %d, want %d", w.Code, tt.want)
+			}
+			if saved := len(snippets) == 1; saved != (tt.want == http.StatusSeeOther) {
+				t.Errorf("handleSave() stored %d snippets", len(snippets))
+			}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			writer.WriteField("invite", tt.invite)
+			part, _ := writer.CreateFormFile("file", "notes.txt")
+			part.Write([]byte("some notes"))
+			writer.Close()
+			req = httptest.NewRequest("POST", "/upload", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w = httptest.NewRecorder()
+			uploadFileHandler(w, req)
+			if w.Code != tt.want {
+				t.Errorf("uploadFileHandler() status = %d, want %d", w.Code, tt.want)
+			}
+			if stored := len(files) == 1; stored != (tt.want == http.StatusSeeOther) {
+				t.Errorf("uploadFileHandler() stored %d files", len(files))
+			}
+		})
+	}
+}
+
+// Test every route that creates a snippet or file checks the invite code,
+// taking it from the X-Invite-Code header too
+func TestInviteCodes_AllRoutes(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	snippetsFile = filepath.Join(tmpDir, "snippets.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+	cfg.InviteCodes = []string{"friends-2026"}
+	cfg.EnableRawPaste = true
+	router := newRouter()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		wantAllowed int
+	}{
+		{"raw paste", "/", "text/plain", "hello", http.StatusCreated},
+		{"api", "/api/snippets", "application/json", `{"text": "hello"}`, http.StatusCreated},
+		{"fetch", "/upload-url", "application/x-www-form-urlencoded", "url=ftp%3A%2F%2Fexample.com", http.StatusBadRequest},
+		{"chunked init", "/upload/init", "application/x-www-form-urlencoded", "filename=notes.txt&chunks=1", http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, invite := range []string{"", "friends-2025", "friends-2026"} {
+				req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", tt.contentType)
+				if invite != "" {
+					req.Header.Set("X-Invite-Code", invite)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				want := http.StatusForbidden
+				if invite == "friends-2026" {
+					want = tt.wantAllowed
+				}
+				if w.Code != want {
+					t.Errorf("Invite %q: status = %d, want %d", invite, w.Code, want)
+				}
+			}
+		})
+	}
+}
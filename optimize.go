@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// With cfg.OptimizeImages, image uploads are decoded and re-encoded as
+// cfg.OptimizeImageFormat, and the copy is kept under uploads/.optimized
+// when it comes out smaller. Views and downloads get the copy; the original
+// is still there for ?original=1. Like the gzipped copies it lives in a
+// subdirectory so the uploads listing never sees it.
+const optimizedDir = ".optimized"
+
+// maxOptimizePixels caps the images that get decoded, so a small file
+// claiming to be enormous can't eat all the memory
+const maxOptimizePixels = 64 << 20
+
+// OptimizedImage records a file's optimized copy: what it's served as, and
+// how big it and the original are
+type OptimizedImage struct {
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	OriginalSize int64  `json:"original_size"`
+}
+
+// optimizeFormats are the formats optimize_image_format can name, by
+// content type, with the extension served copies are named with
+var optimizeFormats = map[string]struct{ contentType, ext string }{
+	"jpeg": {"image/jpeg", ".jpg"},
+	"png":  {"image/png", ".png"},
+}
+
+// optimizableTypes are the image types worth re-encoding. GIFs are left
+// alone since they may be animated, SVGs since they aren't pixels.
+var optimizableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/bmp":  true,
+	"image/tiff": true,
+	"image/webp": true,
+}
+
+// optimizedPath is where a file's optimized copy is kept
+func optimizedPath(fileID string) string {
+	return filepath.Join(uploadsDir, optimizedDir, fileID)
+}
+
+// optimizeUpload writes the optimized copy of a new image upload, if it's
+// enabled, and returns fi with it recorded. Images that can't be decoded, or
+// whose copy isn't smaller, are left as they are.
+func optimizeUpload(fi FileInfo) FileInfo {
+	if !cfg.OptimizeImages || !optimizableTypes[fi.contentType()] {
+		return fi
+	}
+	format := optimizeFormats[cfg.OptimizeImageFormat]
+	src := fi.storedPath()
+	dst := optimizedPath(fi.ID)
+	if err := optimizeImage(src, dst, cfg.OptimizeImageFormat); err != nil {
+		logDebugf("Not optimizing %s: %v", fi.ID, err)
+		return fi
+	}
+
+	original, err1 := os.Stat(src)
+	optimized, err2 := os.Stat(dst)
+	if err1 != nil || err2 != nil || optimized.Size() >= original.Size() {
+		os.Remove(dst)
+		return fi
+	}
+	fi.Optimized = &OptimizedImage{
+		ContentType:  format.contentType,
+		Size:         optimized.Size(),
+		OriginalSize: original.Size(),
+	}
+	logDebugf("Optimized %s from %s to %s", fi.ID, humanBytes(original.Size()), humanBytes(optimized.Size()))
+	return fi
+}
+
+// optimizeImage decodes the image in src and writes it to dst as format, via
+// a .part file so a half-written copy is never served.
+func optimizeImage(src, dst, format string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	config, _, err := image.DecodeConfig(bufio.NewReader(in))
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxOptimizePixels {
+		return errors.New("image too large to decode")
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bufio.NewReader(in))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	partPath := dst + partSuffix
+	out, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(out, img)
+	default:
+		err = jpeg.Encode(out, flatten(img), &jpeg.Options{Quality: cfg.OptimizeImageQuality})
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, dst)
+}
+
+// flatten draws an image with transparency onto white, since JPEG has no
+// alpha and would otherwise turn transparent areas black
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// optimizedVersion returns the path of the copy a request for fi should
+// get instead of the original, unless it asked for ?original=1 or the copy
+// has gone missing.
+func optimizedVersion(r *http.Request, fi FileInfo) (string, bool) {
+	if fi.Optimized == nil || r.URL.Query().Get("original") == "1" {
+		return "", false
+	}
+	path := optimizedPath(fi.ID)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// optimizedName is the name an optimized copy is downloaded as: the
+// original's, with the extension of the format it's in now.
+func optimizedName(fi FileInfo) string {
+	for _, format := range optimizeFormats {
+		if format.contentType == fi.Optimized.ContentType {
+			return strings.TrimSuffix(fi.Name, filepath.Ext(fi.Name)) + format.ext
+		}
+	}
+	return fi.Name
+}
+
+// removeOptimized deletes a file's optimized copy, if it has one
+func removeOptimized(fileID string) {
+	if err := os.Remove(optimizedPath(fileID)); err != nil && !os.IsNotExist(err) {
+		logErrorf("Error removing optimized copy of %s: %v", fileID, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/bmp"
+)
+
+// setupOptimizeTest turns image optimization on with uploads in a temp dir
+func setupOptimizeTest(t *testing.T) {
+	t.Helper()
+
+	originalCfg := cfg
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(uploadsDir, 0755)
+	files = make(map[string]FileInfo)
+	cfg.OptimizeImages = true
+}
+
+// photo draws a photo-like image: smooth gradients with some grain, which
+// PNG stores poorly and JPEG well
+func photo(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for y := range height {
+		for x := range width {
+			seed = seed*1664525 + 1013904223
+			grain := uint8(seed >> 28)
+			img.Set(x, y, color.RGBA{uint8(x*255/width) + grain, uint8(y*255/height) + grain, 128 + grain, 255})
+		}
+	}
+	return img
+}
+
+// uploadImage posts data as name through uploadFileHandler and returns the
+// stored file's metadata
+func uploadImage(t *testing.T, name string, data []byte) FileInfo {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", name)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler(%s) status = %d: %s", name, w.Code, w.Body.String())
+	}
+	for _, fi := range files {
+		if fi.Name == name {
+			return fi
+		}
+	}
+	t.Fatalf("%s wasn't stored", name)
+	return FileInfo{}
+}
+
+// Test a big PNG is stored with a smaller JPEG copy that's served in its
+// place, while ?original=1 still gets the PNG
+func TestOptimizeUpload(t *testing.T) {
+	setupOptimizeTest(t)
+
+	var original bytes.Buffer
+	png.Encode(&original, photo(800, 600))
+	fi := uploadImage(t, "holiday.png", original.Bytes())
+
+	if fi.Optimized == nil {
+		t.Fatal("PNG upload wasn't optimized")
+	}
+	if fi.Optimized.OriginalSize != int64(original.Len()) || fi.Optimized.Size >= fi.Optimized.OriginalSize {
+		t.Errorf("Recorded sizes = %+v, want the copy under the original's %d", fi.Optimized, original.Len())
+	}
+	copyData, err := os.ReadFile(optimizedPath(fi.ID))
+	if err != nil {
+		t.Fatalf("No optimized copy on disk: %v", err)
+	}
+	if int64(len(copyData)) != fi.Optimized.Size {
+		t.Errorf("Copy is %d bytes, recorded as %d", len(copyData), fi.Optimized.Size)
+	}
+	img, format, err := image.Decode(bytes.NewReader(copyData))
+	if err != nil || format != "jpeg" || img.Bounds() != image.Rect(0, 0, 800, 600) {
+		t.Fatalf("Optimized copy decodes as %s %v, err %v; want an 800x600 JPEG", format, img.Bounds(), err)
+	}
+
+	req := httptest.NewRequest("GET", "/download/"+fi.ID, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": fi.ID})
+	w := httptest.NewRecorder()
+	downloadFileHandler(w, req)
+	if w.Header().Get("Content-Type") != "image/jpeg" || !bytes.Equal(w.Body.Bytes(), copyData) {
+		t.Errorf("Download = %s, %d bytes; want the JPEG copy", w.Header().Get("Content-Type"), w.Body.Len())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="holiday.jpg"; filename*=UTF-8''holiday.jpg` {
+		t.Errorf("Content-Disposition = %q, want holiday.jpg", cd)
+	}
+
+	req = httptest.NewRequest("GET", "/download/"+fi.ID+"?original=1", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": fi.ID})
+	w = httptest.NewRecorder()
+	downloadFileHandler(w, req)
+	if w.Header().Get("Content-Type") != "image/png" || !bytes.Equal(w.Body.Bytes(), original.Bytes()) {
+		t.Errorf("?original=1 = %s, %d bytes; want the uploaded PNG", w.Header().Get("Content-Type"), w.Body.Len())
+	}
+}
+
+// Test uncompressed BMPs are optimized and undecodable images are stored
+// untouched
+func TestOptimizeUpload_Formats(t *testing.T) {
+	setupOptimizeTest(t)
+
+	var bitmap bytes.Buffer
+	bmp.Encode(&bitmap, photo(200, 150))
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantOptimized bool
+	}{
+		{"scan.bmp", bitmap.Bytes(), true},
+		{"broken.png", []byte("\x89PNG\r\n\x1a\nnot really a png at all"), false},
+		{"notes.txt", []byte("not an image"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi := uploadImage(t, tt.name, tt.data)
+			if optimized := fi.Optimized != nil; optimized != tt.wantOptimized {
+				t.Errorf("Optimized = %v, want %v", optimized, tt.wantOptimized)
+			}
+			stored, _ := os.ReadFile(filepath.Join(uploadsDir, fi.ID))
+			if !bytes.Equal(stored, tt.data) {
+				t.Error("Original wasn't kept as uploaded")
+			}
+			if _, err := os.Stat(optimizedPath(fi.ID)); (err == nil) != tt.wantOptimized {
+				t.Errorf("Optimized copy on disk = %v, want %v", err == nil, tt.wantOptimized)
+			}
+		})
+	}
+}
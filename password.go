@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password-protected files keep a bcrypt hash of the password in FileInfo;
+// the plaintext is never stored or logged. Browsers unlock a file through
+// the unlock form, which sets a short-lived signed cookie for that file.
+// Scripts can pass ?pw= to /download/ and /stream/ instead.
+//
+// Password-protected snippets keep their hash in Snippet the same way. They
+// aren't unlocked for a while like files: the password form POSTs to
+// /reveal/{url}, which shows the snippet once, and /raw/{url} takes ?pw=.
+// Each wrong password counts against the snippet, and one more than
+// cfg.MaxUnlockAttempts destroys it.
+//
+// Every unlock attempt, for files or snippets, counts against the client's
+// IP too, and past cfg.UnlockAttemptsPerMinute they're turned away until the
+// next minute.
+
+// unlockTTL is how long an unlocked file stays unlocked in a browser
+const unlockTTL = time.Hour
+
+// unlockKey signs unlock cookies. It's made fresh at startup, so a restart
+// locks every file again.
+var unlockKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// Reasons unlockSnippet turns a password away
+var (
+	errUnlockLimited    = errors.New("too many unlock attempts")
+	errWrongPassword    = errors.New("wrong password")
+	errSnippetDestroyed = errors.New("destroyed after too many wrong passwords")
+)
+
+// unlockLimiter counts each IP's unlock attempts this minute
+var unlockLimiter = newAttemptLimiter()
+
+// attemptLimiter counts attempts per IP in fixed one-minute windows. The
+// counts start over each minute, so it never holds more than a minute's
+// worth of IPs.
+type attemptLimiter struct {
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+func newAttemptLimiter() *attemptLimiter {
+	return &attemptLimiter{counts: make(map[string]int)}
+}
+
+// allow counts an attempt from ip and reports whether it's within
+// cfg.UnlockAttemptsPerMinute. 0 means no limit.
+func (l *attemptLimiter) allow(ip string, now time.Time) bool {
+	if cfg.UnlockAttemptsPerMinute == 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if window := now.Truncate(time.Minute); !window.Equal(l.window) {
+		l.window = window
+		l.counts = make(map[string]int)
+	}
+	l.counts[ip]++
+	return l.counts[ip] <= cfg.UnlockAttemptsPerMinute
+}
+
+// setUnlockRetryAfter tells a rate-limited client when the next minute starts
+func setUnlockRetryAfter(w http.ResponseWriter) {
+	now := time.Now()
+	wait := now.Truncate(time.Minute).Add(time.Minute).Sub(now)
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+}
+
+// unlockPages are the pages the unlock form can send the browser back to
+var unlockPages = map[string]bool{"file": true, "view": true, "download": true}
+
+// hashPassword returns the bcrypt hash stored for a password.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// uploadPasswordHash hashes the password an upload was given, returning ""
+// if it wasn't given one.
+func uploadPasswordHash(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	return hashPassword(password)
+}
+
+// checkPassword reports whether password matches a stored bcrypt hash.
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// unlockCookieName is the cookie holding a file's unlock. File IDs contain
+// the original file name, which can't go in a cookie name as-is.
+func unlockCookieName(fileID string) string {
+	sum := sha256.Sum256([]byte(fileID))
+	return "pasty_unlock_" + hex.EncodeToString(sum[:8])
+}
+
+// unlockSignature signs a file's unlock until expires. The password hash is
+// part of it, so changing the password invalidates existing unlocks.
+func unlockSignature(fi FileInfo, expires int64) string {
+	mac := hmac.New(sha256.New, unlockKey)
+	mac.Write([]byte(fi.ID + "|" + fi.PasswordHash + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setUnlockCookie unlocks a file for this browser for unlockTTL.
+func setUnlockCookie(w http.ResponseWriter, r *http.Request, fi FileInfo) {
+	expires := time.Now().Add(unlockTTL).Unix()
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(fi.ID),
+		Value:    strconv.FormatInt(expires, 10) + "." + unlockSignature(fi, expires),
+		Path:     sitePath("/"),
+		MaxAge:   int(unlockTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// fileUnlocked reports whether the request may see the file: it isn't
+// protected, or the request carries a valid unlock cookie for it.
+func fileUnlocked(r *http.Request, fi FileInfo) bool {
+	if fi.PasswordHash == "" {
+		return true
+	}
+	cookie, err := r.Cookie(unlockCookieName(fi.ID))
+	if err != nil {
+		return false
+	}
+	expiresStr, signature, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(unlockSignature(fi, expires)))
+}
+
+// UnlockData is what the unlock page is rendered with
+type UnlockData struct {
+	Branding
+	Action        string // where the form posts, without the base path
+	What          string // "file" or "paste"
+	FileID        string
+	Next          string
+	WrongPassword bool
+	AttemptsLeft  int  // wrong passwords a snippet has left; 0 if there's no limit
+	Burns         bool // the snippet burns once it's shown
+}
+
+// renderUnlockPage answers a request for a locked file with the password
+// form. next is where the form sends the browser once it's unlocked.
+func renderUnlockPage(w http.ResponseWriter, r *http.Request, fileID, next string, wrongPassword bool) {
+	executeUnlockPage(w, UnlockData{
+		Branding:      siteBranding(),
+		Action:        "/unlock/" + fileID,
+		What:          "file",
+		FileID:        fileID,
+		Next:          next,
+		WrongPassword: wrongPassword,
+	})
+}
+
+// renderSnippetUnlockPage answers a request for a protected snippet with the
+// password form, which posts to /reveal/{url}.
+func renderSnippetUnlockPage(w http.ResponseWriter, r *http.Request, url string, snippet Snippet, wrongPassword bool) {
+	data := UnlockData{
+		Branding:      siteBranding(),
+		Action:        "/reveal/" + url,
+		What:          "paste",
+		WrongPassword: wrongPassword,
+		Burns:         snippet.burns(),
+	}
+	if cfg.MaxUnlockAttempts > 0 && !snippet.Immutable {
+		data.AttemptsLeft = cfg.MaxUnlockAttempts - snippet.FailedAttempts + 1
+	}
+	executeUnlockPage(w, data)
+}
+
+// executeUnlockPage writes the unlock page with a 403
+func executeUnlockPage(w http.ResponseWriter, data UnlockData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if err := currentTemplate(tmplUnlock, "unlock.html").Execute(w, data); err != nil {
+		logErrorf("Template execute error: %v", err)
+	}
+}
+
+// unlockFileHandler handles "POST /unlock/{id}" from the unlock form,
+// redirecting back to the page named by 'next' once the password checks out.
+func unlockFileHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := filepath.Base(mux.Vars(r)["id"])
+
+	next := r.FormValue("next")
+	if !unlockPages[next] {
+		next = "file"
+	}
+
+	fi, exists := resolveFile(fileID)
+	if !exists {
+		renderNotFound(w, r, "File not found")
+		return
+	}
+	if !unlockLimiter.allow(clientIP(r), time.Now()) {
+		logWarnf("Too many unlock attempts from %s", clientIP(r))
+		setUnlockRetryAfter(w)
+		http.Error(w, "Too many unlock attempts, try again in a minute", http.StatusTooManyRequests)
+		return
+	}
+	if fi.PasswordHash != "" && !checkPassword(fi.PasswordHash, r.FormValue("password")) {
+		logWarnf("Wrong password for file %s from %s", fileID, clientIP(r))
+		renderUnlockPage(w, r, fileID, next, true)
+		return
+	}
+
+	setUnlockCookie(w, r, fi)
+	http.Redirect(w, r, sitePath("/"+next+"/"+fileID), http.StatusSeeOther)
+}
+
+// checkFilePassword lets a request for a protected file through if it's
+// been unlocked or has the password in ?pw=, otherwise answering it with
+// the unlock page for next. Guesses in ?pw= count against unlockLimiter
+// like ones from the unlock form, and get a 429 past it.
+func checkFilePassword(w http.ResponseWriter, r *http.Request, fi FileInfo, next string) bool {
+	if fileUnlocked(r, fi) {
+		return true
+	}
+	pw := r.URL.Query().Get("pw")
+	if pw == "" {
+		renderUnlockPage(w, r, fi.ID, next, false)
+		return false
+	}
+	if !unlockLimiter.allow(clientIP(r), time.Now()) {
+		logWarnf("Too many unlock attempts from %s", clientIP(r))
+		setUnlockRetryAfter(w)
+		http.Error(w, "Too many unlock attempts, try again in a minute", http.StatusTooManyRequests)
+		return false
+	}
+	if !checkPassword(fi.PasswordHash, pw) {
+		logWarnf("Wrong password for file %s from %s", fi.ID, clientIP(r))
+		renderUnlockPage(w, r, fi.ID, next, true)
+		return false
+	}
+	return true
+}
+
+// unlockSnippet checks a password for a protected snippet. A wrong one is
+// counted against the snippet, and the one that takes it past
+// cfg.MaxUnlockAttempts destroys it; the right one starts the count over.
+// It returns os.ErrNotExist if the snippet has gone meanwhile.
+func unlockSnippet(r *http.Request, url string, snippet Snippet, password string) error {
+	ip := clientIP(r)
+	if !unlockLimiter.allow(ip, time.Now()) {
+		logWarnf("Too many unlock attempts from %s", ip)
+		return errUnlockLimited
+	}
+	// bcrypt is slow on purpose, so it's kept outside the lock
+	right := checkPassword(snippet.PasswordHash, password)
+
+	snippetsMu.Lock()
+	current, ok := snippets[url]
+	if !ok || current.expired(time.Now()) || current.PasswordHash != snippet.PasswordHash {
+		snippetsMu.Unlock()
+		return os.ErrNotExist
+	}
+	if right {
+		reset := current.FailedAttempts > 0
+		if reset {
+			current.FailedAttempts = 0
+			snippets[url] = current
+			logSnippetPut(url, current)
+		}
+		snippetsMu.Unlock()
+		if reset {
+			queueSnippetsSave()
+		}
+		return nil
+	}
+
+	current.FailedAttempts++
+	logWarnf("Wrong password for snippet %s from %s (%d so far)", url, ip, current.FailedAttempts)
+	// Immutable snippets are never deleted, so they only get the IP limit
+	if cfg.MaxUnlockAttempts > 0 && current.FailedAttempts > cfg.MaxUnlockAttempts && !current.Immutable {
+		delete(snippets, url)
+		logSnippetDelete(url)
+		snippetsMu.Unlock()
+		logWarnf("Destroyed snippet %s after %d wrong passwords", url, current.FailedAttempts)
+		saveSnippetsToFile(snippetsFile)
+		return errSnippetDestroyed
+	}
+	snippets[url] = current
+	logSnippetPut(url, current)
+	snippetsMu.Unlock()
+	queueSnippetsSave()
+	return errWrongPassword
+}
+
+// writeUnlockError answers a failed unlockSnippet, as JSON or plain text
+func writeUnlockError(w http.ResponseWriter, err error, asJSON bool) {
+	status, code, message := http.StatusForbidden, apiErrPassword, "Wrong password"
+	switch {
+	case errors.Is(err, errUnlockLimited):
+		setUnlockRetryAfter(w)
+		status, code, message = http.StatusTooManyRequests, apiErrRateLimited, "Too many unlock attempts, try again in a minute"
+	case errors.Is(err, errSnippetDestroyed):
+		status, code, message = http.StatusGone, apiErrDestroyed, "This paste was destroyed after too many wrong passwords"
+	case errors.Is(err, os.ErrNotExist):
+		status, code, message = http.StatusNotFound, apiErrNotFound, "Snippet not found"
+	}
+	if asJSON {
+		writeAPIError(w, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// setupPasswordTest uploads a file protected with "hunter2" and returns its ID
+func setupPasswordTest(t *testing.T) string {
+	t.Helper()
+
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	originalTmplUnlock := tmplUnlock
+	originalLimiter := unlockLimiter
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+		tmplUnlock = originalTmplUnlock
+		unlockLimiter = originalLimiter
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	tmplUnlock = template.Must(template.New("unlock").Parse(`unlock {{.FileID}} next={{.Next}} wrong={{.WrongPassword}}`))
+	unlockLimiter = newAttemptLimiter()
+
+	if w := uploadForm(t, "secret.txt", "top secret contents", map[string]string{"password": "hunter2"}); w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	for id := range files {
+		return id
+	}
+	t.Fatal("Upload wasn't registered")
+	return ""
+}
+
+// downloadFile requests /download/{id}, with optional query and cookies
+func downloadFile(fileID, query string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/download/"+url.PathEscape(fileID)+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": fileID})
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	downloadFileHandler(w, req)
+	return w
+}
+
+// Test the password is stored only as a bcrypt hash
+func TestUploadFileHandler_Password(t *testing.T) {
+	fileID := setupPasswordTest(t)
+
+	fi := files[fileID]
+	if fi.PasswordHash == "" || fi.PasswordHash == "hunter2" {
+		t.Fatalf("PasswordHash = %q, want a bcrypt hash", fi.PasswordHash)
+	}
+	if !checkPassword(fi.PasswordHash, "hunter2") {
+		t.Error("Stored hash doesn't match the password")
+	}
+
+	data, _ := os.ReadFile(filesFile)
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("Plaintext password written to files.json")
+	}
+}
+
+// Test downloads of a protected file need the right password
+func TestDownloadFileHandler_Password(t *testing.T) {
+	fileID := setupPasswordTest(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"no password", "", http.StatusForbidden, "wrong=false"},
+		{"wrong password", "?pw=wrong", http.StatusForbidden, "wrong=true"},
+		{"correct password", "?pw=hunter2", http.StatusOK, "top secret contents"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := downloadFile(fileID, tt.query)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("downloadFileHandler() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("downloadFileHandler() body = %q, want it to contain %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantStatus != http.StatusOK && strings.Contains(w.Body.String(), "top secret") {
+				t.Error("Locked response leaked the file contents")
+			}
+		})
+	}
+}
+
+// Test the unlock form sets a cookie that opens the file's pages
+func TestUnlockFileHandler(t *testing.T) {
+	fileID := setupPasswordTest(t)
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}, "next": {"download"}}
+		req := httptest.NewRequest("POST", "/unlock/x", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = mux.SetURLVars(req, map[string]string{"id": fileID})
+		w := httptest.NewRecorder()
+		unlockFileHandler(w, req)
+		return w
+	}
+
+	if w := unlock("wrong"); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Wrong password status = %d with %d cookies, want %d and none", w.Code, len(w.Result().Cookies()), http.StatusForbidden)
+	}
+
+	w := unlock("hunter2")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("unlockFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if location := w.Header().Get("Location"); location != "/download/"+fileID {
+		t.Errorf("Redirect = %q, want /download/%s", location, fileID)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("unlockFileHandler() set %d cookies, want 1", len(cookies))
+	}
+
+	if w := downloadFile(fileID, "", cookies[0]); w.Code != http.StatusOK || w.Body.String() != "top secret contents" {
+		t.Errorf("Download with unlock cookie = %d %q, want 200 with the contents", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/file/x", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": fileID})
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	displayFileHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("displayFileHandler() with unlock cookie status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// A tampered cookie doesn't unlock anything
+	forged := &http.Cookie{Name: cookies[0].Name, Value: cookies[0].Value + "0"}
+	if w := downloadFile(fileID, "", forged); w.Code != http.StatusForbidden {
+		t.Errorf("Download with forged cookie status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// Test the file and view pages show the unlock form for a protected file
+func TestFilePages_Password(t *testing.T) {
+	fileID := setupPasswordTest(t)
+
+	pages := []struct {
+		name    string
+		handler http.HandlerFunc
+		next    string
+	}{
+		{"file", displayFileHandler, "file"},
+		{"view", viewFileHandler, "view"},
+		{"stream", streamFileHandler, "download"},
+	}
+
+	for _, page := range pages {
+		req := httptest.NewRequest("GET", "/"+page.name+"/x", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": fileID})
+		w := httptest.NewRecorder()
+		page.handler(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s status = %d, want %d", page.name, w.Code, http.StatusForbidden)
+		}
+		if !strings.Contains(w.Body.String(), "next="+page.next) {
+			t.Errorf("%s body = %q, want the unlock form back to %s", page.name, w.Body.String(), page.next)
+		}
+	}
+}
+
+// Test password guesses in ?pw= count against unlock_attempts_per_minute on
+// every route that takes one, and past it get a 429 without being checked
+func TestFilePassword_QueryRateLimit(t *testing.T) {
+	fileID := setupPasswordTest(t)
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	cfg.UnlockAttemptsPerMinute = 3
+
+	routes := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"download", downloadFileHandler},
+		{"stream", streamFileHandler},
+		{"archive", archiveHandler},
+	}
+
+	for _, route := range routes {
+		unlockLimiter = newAttemptLimiter()
+		get := func(pw string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", "/"+route.name+"/x?pw="+pw, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": fileID})
+			w := httptest.NewRecorder()
+			route.handler(w, req)
+			return w
+		}
+
+		for i := range cfg.UnlockAttemptsPerMinute {
+			if w := get("guess"); w.Code != http.StatusForbidden {
+				t.Errorf("%s guess %d status = %d, want %d", route.name, i+1, w.Code, http.StatusForbidden)
+			}
+		}
+		w := get("hunter2")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s past the limit = %d, Retry-After %q; want 429 with Retry-After", route.name, w.Code, w.Header().Get("Retry-After"))
+		}
+		if strings.Contains(w.Body.String(), "top secret") {
+			t.Errorf("%s gave out the file past the limit", route.name)
+		}
+	}
+}
+
+// setupSnippetPasswordTest saves a snippet protected with "hunter2" and
+// returns its ID
+func setupSnippetPasswordTest(t *testing.T) string {
+	t.Helper()
+
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalTmplUnlock := tmplUnlock
+	originalLimiter := unlockLimiter
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		tmplUnlock = originalTmplUnlock
+		unlockLimiter = originalLimiter
+	})
+
+	initTestTemplates(t)
+	snippets = make(map[string]Snippet)
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	tmplUnlock = template.Must(template.New("unlock").Parse(`unlock {{.Action}} wrong={{.WrongPassword}} left={{.AttemptsLeft}}`))
+	unlockLimiter = newAttemptLimiter()
+
+	form := url.Values{"title": {"Secret"}, "text": {"the launch codes"}, "password": {"hunter2"}}
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	for id := range snippets {
+		return id
+	}
+	t.Fatal("Snippet wasn't saved")
+	return ""
+}
+
+// revealWithPassword posts a password to /reveal/{url}
+func revealWithPassword(id, password string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest("POST", "/reveal/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = mux.SetURLVars(req, map[string]string{"url": id})
+	w := httptest.NewRecorder()
+	revealSnippet(w, req)
+	return w
+}
+
+// Test a protected snippet is only shown with its password, and the right
+// one starts the count of wrong ones over
+func TestRevealSnippet_Password(t *testing.T) {
+	id := setupSnippetPasswordTest(t)
+
+	if hash := snippets[id].PasswordHash; hash == "" || strings.Contains(hash, "hunter2") {
+		t.Fatalf("PasswordHash = %q, want a bcrypt hash", hash)
+	}
+
+	req := httptest.NewRequest("GET", "/display/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"url": id})
+	w := httptest.NewRecorder()
+	displaySnippet(w, req)
+	if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "launch codes") {
+		t.Errorf("displaySnippet() = %d %q, want 403 with the password form", w.Code, w.Body.String())
+	}
+
+	for i := 1; i <= 2; i++ {
+		w := revealWithPassword(id, "wrong")
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "wrong=true") {
+			t.Fatalf("Wrong password = %d %q, want 403 with the form again", w.Code, w.Body.String())
+		}
+		if got := snippets[id].FailedAttempts; got != i {
+			t.Errorf("FailedAttempts after %d wrong passwords = %d", i, got)
+		}
+	}
+
+	w = revealWithPassword(id, "hunter2")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "the launch codes") {
+		t.Errorf("Right password = %d %q, want the snippet", w.Code, w.Body.String())
+	}
+	if got := snippets[id].FailedAttempts; got != 0 {
+		t.Errorf("FailedAttempts after the right password = %d, want 0", got)
+	}
+
+	req = httptest.NewRequest("GET", "/raw/"+id+"?pw=hunter2", nil)
+	req = mux.SetURLVars(req, map[string]string{"url": id})
+	w = httptest.NewRecorder()
+	rawSnippet(w, req)
+	if w.Body.String() != "the launch codes" {
+		t.Errorf("rawSnippet() with ?pw= = %d %q", w.Code, w.Body.String())
+	}
+}
+
+// Test the wrong password that takes a snippet past max_unlock_attempts
+// destroys it
+func TestRevealSnippet_PasswordDestroys(t *testing.T) {
+	id := setupSnippetPasswordTest(t)
+	cfg.MaxUnlockAttempts = 2
+
+	for i := 0; i < 2; i++ {
+		if w := revealWithPassword(id, "wrong"); w.Code != http.StatusForbidden {
+			t.Fatalf("Wrong password %d status = %d, want %d", i+1, w.Code, http.StatusForbidden)
+		}
+	}
+	w := revealWithPassword(id, "wrong")
+	if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "destroyed") {
+		t.Errorf("Last wrong password = %d %q, want 410 saying it was destroyed", w.Code, w.Body.String())
+	}
+	if _, exists := snippets[id]; exists {
+		t.Fatal("Snippet still there after too many wrong passwords")
+	}
+	saved, _ := os.ReadFile(snippetsFile)
+	if strings.Contains(string(saved), id) {
+		t.Error("Destroyed snippet still in snippets.json")
+	}
+
+	if w := revealWithPassword(id, "hunter2"); w.Code != http.StatusNotFound {
+		t.Errorf("Right password after destroying status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// Test each IP only gets unlock_attempts_per_minute tries, right or wrong
+func TestRevealSnippet_PasswordRateLimit(t *testing.T) {
+	id := setupSnippetPasswordTest(t)
+	cfg.UnlockAttemptsPerMinute = 2
+
+	revealWithPassword(id, "wrong")
+	revealWithPassword(id, "wrong")
+	w := revealWithPassword(id, "hunter2")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Third attempt = %d with Retry-After %q, want 429 with one", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := snippets[id].FailedAttempts; got != 2 {
+		t.Errorf("FailedAttempts = %d, want 2; rate-limited attempts shouldn't count", got)
+	}
+
+	// The limit is per IP and starts over the next minute
+	now := time.Now()
+	limiter := newAttemptLimiter()
+	limiter.allow("192.0.2.1", now)
+	limiter.allow("192.0.2.1", now)
+	if limiter.allow("192.0.2.1", now) {
+		t.Error("Third attempt in a minute allowed")
+	}
+	if !limiter.allow("198.51.100.7", now) {
+		t.Error("Another IP was limited")
+	}
+	if !limiter.allow("192.0.2.1", now.Add(time.Minute)) {
+		t.Error("Attempt the next minute not allowed")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// With cfg.PrecompressUploads, text-like uploads get a gzipped copy under
+// uploads/.gz, which downloads hand to clients that accept gzip. The copy
+// lives in a subdirectory so the uploads listing never sees it.
+const precompressedDir = ".gz"
+
+// precompressedPath is where a file's gzipped copy is kept
+func precompressedPath(fileID string) string {
+	return filepath.Join(uploadsDir, precompressedDir, fileID+".gz")
+}
+
+// compressibleType reports whether files of this content type are worth
+// gzipping. Media and PDFs are compressed already.
+func compressibleType(contentType string) bool {
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	switch contentType {
+	case "application/json", "application/xml", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// precompressUpload writes the gzipped copy of a new upload, if it's
+// enabled and worth it. A copy that doesn't come out smaller is dropped.
+func precompressUpload(fi FileInfo) {
+	if !cfg.PrecompressUploads || !compressibleType(getContentType(fi.Name)) {
+		return
+	}
+	src := fi.storedPath()
+	dst := precompressedPath(fi.ID)
+	if err := gzipFile(src, dst); err != nil {
+		logErrorf("Error precompressing %s: %v", fi.ID, err)
+		return
+	}
+
+	original, err1 := os.Stat(src)
+	compressed, err2 := os.Stat(dst)
+	if err1 != nil || err2 != nil || compressed.Size() >= original.Size() {
+		os.Remove(dst)
+	}
+}
+
+// gzipFile writes a gzipped copy of src to dst, via a .part file so a
+// half-written copy is never served.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	partPath := dst + partSuffix
+	out, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return err
+	}
+	return os.Rename(partPath, dst)
+}
+
+// servePrecompressed answers a whole-file download from the gzipped copy
+// when there is one and the client takes gzip, reporting whether it did.
+// The caller has already set Content-Type and friends for the original.
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fileID string, fi FileInfo) bool {
+	if fi.BurnAfterReading || r.Header.Get("Range") != "" {
+		return false
+	}
+	gz, err := os.Open(precompressedPath(fileID))
+	if err != nil {
+		return false
+	}
+	defer gz.Close()
+	stat, err := gz.Stat()
+	if err != nil {
+		return false
+	}
+
+	// Caches have to keep the two versions apart
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		return false
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	logDebugf("Serving gzipped copy of %s (size: %d bytes)", fileID, stat.Size())
+	if _, err := io.Copy(w, gz); err != nil {
+		logErrorf("File copy error: %v", err)
+	}
+	return true
+}
+
+// removePrecompressed deletes a file's gzipped copy, if it has one
+func removePrecompressed(fileID string) {
+	if err := os.Remove(precompressedPath(fileID)); err != nil && !os.IsNotExist(err) {
+		logErrorf("Error removing compressed copy of %s: %v", fileID, err)
+	}
+}
+
+// acceptsGzip reports whether the client's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		// "gzip;q=0" means anything but gzip
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test downloads come gzipped only to clients that accept it
+func TestServePrecompressed(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	cfg.PrecompressUploads = true
+	files = make(map[string]FileInfo)
+	filesFile = filepath.Join(tmpDir, "files.json")
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	content := strings.Repeat("the same line over and over\n", 200)
+	os.WriteFile(filepath.Join(uploadsDir, "1-log.txt"), []byte(content), 0644)
+	addFile(FileInfo{ID: "1-log.txt", Name: "log.txt", StoredName: "1-log.txt"})
+	if _, err := os.Stat(precompressedPath("1-log.txt")); err != nil {
+		t.Fatalf("No gzipped copy written: %v", err)
+	}
+
+	// Media isn't worth compressing
+	os.WriteFile(filepath.Join(uploadsDir, "2-clip.mp4"), []byte(content), 0644)
+	addFile(FileInfo{ID: "2-clip.mp4", Name: "clip.mp4", StoredName: "2-clip.mp4"})
+	if _, err := os.Stat(precompressedPath("2-clip.mp4")); err == nil {
+		t.Error("Gzipped copy written for a video")
+	}
+
+	// The copy is never listed as an upload of its own
+	if entries := listFileEntries("", false, 0); len(entries) != 2 {
+		t.Errorf("listFileEntries() = %d entries, want 2", len(entries))
+	}
+
+	router := newRouter()
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"accepts gzip", "gzip, deflate, br", true},
+		{"doesn't", "", false},
+		{"refuses gzip", "gzip;q=0, br", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/download/1-log.txt", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET /download/1-log.txt status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+				t.Errorf("Content-Type = %q, want text/plain", ct)
+			}
+			if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(w.Body.Len()) {
+				t.Errorf("Content-Length = %s, body is %d bytes", cl, w.Body.Len())
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", vary)
+			}
+
+			body := w.Body.Bytes()
+			gotGzip := w.Header().Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("Content-Encoding gzip = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			if gotGzip {
+				if len(body) >= len(content) {
+					t.Errorf("Gzipped body is %d bytes, original %d", len(body), len(content))
+				}
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("Body isn't gzip: %v", err)
+				}
+				body, _ = io.ReadAll(zr)
+			}
+			if string(body) != content {
+				t.Error("Downloaded content doesn't match the upload")
+			}
+		})
+	}
+
+	burnFile("1-log.txt")
+	if _, err := os.Stat(precompressedPath("1-log.txt")); !os.IsNotExist(err) {
+		t.Error("Gzipped copy left behind after the file was burned")
+	}
+}
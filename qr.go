@@ -0,0 +1,156 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
+)
+
+// The pages embed their QR codes as base64; these routes serve the same codes
+// as plain PNGs for hotlinking and printing:
+//
+//	GET /qr/snippet/{url}?size=512
+//	GET /qr/file/{id}
+
+const (
+	// defaultQRSize is the image width and height in pixels when no size is given
+	defaultQRSize = 256
+	// minQRSize and maxQRSize bound ?size=, so nobody asks for a 100000px image
+	minQRSize = 64
+	maxQRSize = 2048
+)
+
+// qrCacheSize is how many generated QR codes are kept in memory
+const qrCacheSize = 256
+
+// qrEncode makes a QR code PNG; tests swap it out to count calls
+var qrEncode = qrcode.Encode
+
+// qrCache keeps recently generated QR codes, least recently used first out.
+// The URLs they encode are stable, so entries never go stale.
+var qrCache = newPNGCache(qrCacheSize)
+
+// qrPNG returns the PNG QR code for content at size pixels, generating it
+// only if it isn't cached.
+func qrPNG(content string, size int) ([]byte, error) {
+	key := strconv.Itoa(size) + " " + content
+	if png, ok := qrCache.get(key); ok {
+		return png, nil
+	}
+	png, err := qrEncode(content, qrcode.Medium, size)
+	if err != nil {
+		return nil, err
+	}
+	qrCache.add(key, png)
+	return png, nil
+}
+
+// pngCache is a small LRU cache of images
+type pngCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+// pngCacheEntry is what the list elements hold
+type pngCacheEntry struct {
+	key string
+	png []byte
+}
+
+// newPNGCache makes a cache holding up to capacity images
+func newPNGCache(capacity int) *pngCache {
+	return &pngCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns the cached image for key, marking it recently used
+func (c *pngCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*pngCacheEntry).png, true
+}
+
+// add caches an image, dropping the least recently used one if full
+func (c *pngCache) add(key string, png []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*pngCacheEntry).png = png
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&pngCacheEntry{key: key, png: png})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*pngCacheEntry).key)
+	}
+}
+
+// qrSize reads ?size=, clamped to the allowed range. Anything unparseable
+// gets the default.
+func qrSize(r *http.Request) int {
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil {
+		return defaultQRSize
+	}
+	return min(max(size, minQRSize), maxQRSize)
+}
+
+// writeQRCode responds with a PNG QR code encoding content.
+func writeQRCode(w http.ResponseWriter, content string, size int) {
+	if content == "" {
+		http.Error(w, "Nothing to encode", http.StatusBadRequest)
+		return
+	}
+	png, err := qrPNG(content, size)
+	if err != nil {
+		logErrorf("QR code generation error: %v", err)
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(png)))
+	w.Write(png)
+}
+
+// snippetQRHandler handles "GET /qr/snippet/{url}", encoding the snippet's
+// display page.
+func snippetQRHandler(w http.ResponseWriter, r *http.Request) {
+	url := mux.Vars(r)["url"]
+
+	snippetsMu.RLock()
+	snippet, ok := snippets[url]
+	snippetsMu.RUnlock()
+	if url == "" || !ok || snippet.expired(time.Now()) {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeQRCode(w, absoluteURL(r, "/display/"+url), qrSize(r))
+}
+
+// fileQRHandler handles "GET /qr/file/{id}", encoding the file's view page
+// like the QR code on /file/ does.
+func fileQRHandler(w http.ResponseWriter, r *http.Request) {
+	fileID := filepath.Base(mux.Vars(r)["id"])
+
+	if _, exists := resolveFile(fileID); !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeQRCode(w, absoluteURL(r, "/view/"+fileID), qrSize(r))
+}
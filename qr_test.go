@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Test the QR routes return decodable PNGs and 404 for unknown IDs
+func TestQRHandlers(t *testing.T) {
+	originalSnippets := snippets
+	originalUploadsDir := uploadsDir
+	originalFiles := files
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		uploadsDir = originalUploadsDir
+		files = originalFiles
+	})
+
+	now := time.Now()
+	snippets = map[string]Snippet{
+		"abc":     {Title: "T", Text: "x", CreatedAt: now},
+		"expired": {Title: "T", Text: "x", CreatedAt: now, ExpiresAt: now.Add(-time.Minute)},
+	}
+	uploadsDir = t.TempDir()
+	files = map[string]FileInfo{}
+	os.WriteFile(filepath.Join(uploadsDir, "1-a.txt"), []byte("hello"), 0644)
+
+	router := newRouter()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantSize   int
+	}{
+		{"snippet", "/qr/snippet/abc", http.StatusOK, defaultQRSize},
+		{"snippet with size", "/qr/snippet/abc?size=512", http.StatusOK, 512},
+		{"size clamped up", "/qr/snippet/abc?size=1", http.StatusOK, minQRSize},
+		{"size clamped down", "/qr/snippet/abc?size=100000", http.StatusOK, maxQRSize},
+		{"bad size", "/qr/snippet/abc?size=big", http.StatusOK, defaultQRSize},
+		{"file", "/qr/file/1-a.txt", http.StatusOK, defaultQRSize},
+		{"unknown snippet", "/qr/snippet/nope", http.StatusNotFound, 0},
+		{"expired snippet", "/qr/snippet/expired", http.StatusNotFound, 0},
+		{"unknown file", "/qr/file/nope.txt", http.StatusNotFound, 0},
+		{"empty ID", "/qr/snippet/", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Content-Type = %q, want image/png", ct)
+			}
+			img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("GET %s didn't return a valid PNG: %v", tt.path, err)
+			}
+			if got := img.Bounds().Dx(); got != tt.wantSize {
+				t.Errorf("Image width = %d, want %d", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+// Test writeQRCode refuses to encode nothing
+func TestWriteQRCode_Empty(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeQRCode(w, "", defaultQRSize)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("writeQRCode(\"\") status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+// Test viewing the same file page twice only encodes its QR codes once
+func TestQRCache(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalEncode := qrEncode
+	originalCache := qrCache
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		qrEncode = originalEncode
+		qrCache = originalCache
+	})
+
+	uploadsDir = t.TempDir()
+	files = map[string]FileInfo{}
+	os.WriteFile(filepath.Join(uploadsDir, "1-a.txt"), []byte("hello"), 0644)
+
+	calls := 0
+	qrEncode = func(content string, level qrcode.RecoveryLevel, size int) ([]byte, error) {
+		calls++
+		return originalEncode(content, level, size)
+	}
+	qrCache = newPNGCache(qrCacheSize)
+	router := newRouter()
+
+	render := func() string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/file/1-a.txt", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /file/1-a.txt status = %d, want %d", w.Code, http.StatusOK)
+		}
+		return w.Body.String()
+	}
+
+	first := render()
+	if calls == 0 {
+		t.Fatal("First render didn't encode any QR codes")
+	}
+	firstCalls := calls
+	if second := render(); second != first {
+		t.Error("Second render differs from the first")
+	}
+	if calls != firstCalls {
+		t.Errorf("Second render encoded %d more QR codes, want 0", calls-firstCalls)
+	}
+
+	// A different size is a different image
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/qr/file/1-a.txt?size=300", nil))
+	if calls != firstCalls+1 {
+		t.Errorf("New size encoded %d QR codes, want 1", calls-firstCalls)
+	}
+}
+
+// Test the cache drops the least recently used image when full
+func TestPNGCache_Evicts(t *testing.T) {
+	cache := newPNGCache(2)
+	cache.add("a", []byte("a"))
+	cache.add("b", []byte("b"))
+	cache.get("a")
+	cache.add("c", []byte("c"))
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b is still cached, want it evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Orphans are files in the uploads directory with no entry in files.json:
+// leftovers from crashes, files copied in by hand, and so on. What to do
+// with them is cfg.OrphanPolicy.
+const (
+	orphanAdopt      = "adopt"
+	orphanQuarantine = "quarantine"
+	orphanIgnore     = "ignore"
+)
+
+// orphanGracePeriod keeps the sweep away from files modified this recently.
+// An upload is renamed into place just before it's added to the files map,
+// so a brand new file can look like an orphan for a moment.
+const orphanGracePeriod = time.Minute
+
+// validOrphanPolicy reports whether policy is one of the orphan policies
+func validOrphanPolicy(policy string) bool {
+	switch policy {
+	case orphanAdopt, orphanQuarantine, orphanIgnore:
+		return true
+	}
+	return false
+}
+
+// reconcileUploads applies cfg.OrphanPolicy to every orphan in the uploads
+// directory, returning how many it adopted or quarantined.
+func reconcileUploads(now time.Time) int {
+	if cfg.OrphanPolicy == orphanIgnore || cfg.OrphanPolicy == "" {
+		return 0
+	}
+
+	orphans := findOrphans(now)
+	handled := 0
+	for _, id := range orphans {
+		var err error
+		if cfg.OrphanPolicy == orphanAdopt {
+			err = adoptOrphan(id)
+		} else {
+			err = quarantineOrphan(id)
+		}
+		if err != nil {
+			logErrorf("Error handling orphaned upload %s: %v", id, err)
+			continue
+		}
+		handled++
+	}
+
+	if handled > 0 && cfg.OrphanPolicy == orphanAdopt {
+		saveFilesToFile(filesFile)
+	}
+	return handled
+}
+
+// findOrphans lists the uploads no files entry knows about. Directories
+// (chunked uploads in progress and shard_uploads' dated directories), partial
+// uploads and anything touched within orphanGracePeriod are left out.
+func findOrphans(now time.Time) []string {
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		logErrorf("Error reading uploads directory: %v", err)
+		return nil
+	}
+
+	var orphans []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), partSuffix) {
+			continue
+		}
+		if _, exists := lookupFile(entry.Name()); exists {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < orphanGracePeriod {
+			continue
+		}
+		orphans = append(orphans, entry.Name())
+	}
+	return orphans
+}
+
+// adoptOrphan adds an orphaned upload to the files map, recovering the
+// original name and upload time from the newFileID form if it has one.
+func adoptOrphan(id string) error {
+	path := filepath.Join(uploadsDir, id)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+
+	fi := FileInfo{ID: id, Name: id, StoredName: id, Checksum: checksum}
+	if uploadedAt := fileUploadTime(fi); !uploadedAt.IsZero() {
+		fi.UploadedAt = uploadedAt
+		_, fi.Name, _ = strings.Cut(id, "-")
+	} else {
+		fi.UploadedAt = info.ModTime()
+	}
+
+	filesMu.Lock()
+	files[id] = fi
+	filesMu.Unlock()
+	logInfof("Adopted orphaned upload %s", id)
+	return nil
+}
+
+// quarantineOrphan moves an orphaned upload out of the uploads directory.
+func quarantineOrphan(id string) error {
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(uploadsDir, id), filepath.Join(quarantineDir, id)); err != nil {
+		return err
+	}
+	logInfof("Moved orphaned upload %s to %s", id, quarantineDir)
+	return nil
+}
+
+// fileChecksum returns the hex SHA-256 of a file, as stored in FileInfo.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// startOrphanSweeper reconciles the uploads directory every interval. Zero
+// means only at startup.
+func startOrphanSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			reconcileUploads(time.Now())
+		}
+	}()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test each orphan policy against a stray file in the uploads directory
+func TestReconcileUploads(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	originalQuarantineDir := quarantineDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+		quarantineDir = originalQuarantineDir
+	})
+
+	tests := []struct {
+		policy         string
+		wantHandled    int
+		wantTracked    bool
+		wantInUploads  bool
+		wantQuarantine bool
+	}{
+		{orphanIgnore, 0, false, true, false},
+		{orphanAdopt, 1, true, true, false},
+		{orphanQuarantine, 1, false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			uploadsDir = filepath.Join(tmpDir, "uploads")
+			quarantineDir = filepath.Join(tmpDir, "quarantine")
+			filesFile = filepath.Join(tmpDir, "files.json")
+			os.MkdirAll(filepath.Join(uploadsDir, ".chunks"), 0755)
+			cfg.OrphanPolicy = tt.policy
+
+			// One tracked file, one stray, one upload still being written
+			os.WriteFile(filepath.Join(uploadsDir, "1-tracked.txt"), []byte("tracked"), 0644)
+			os.WriteFile(filepath.Join(uploadsDir, "1700000000000000000-stray.txt"), []byte("stray"), 0644)
+			os.WriteFile(filepath.Join(uploadsDir, "2-busy.txt"+partSuffix), []byte("busy"), 0644)
+			files = map[string]FileInfo{"1-tracked.txt": {ID: "1-tracked.txt", Name: "tracked.txt"}}
+
+			// Pretend a while has passed so nothing is inside the grace period
+			handled := reconcileUploads(time.Now().Add(2 * orphanGracePeriod))
+			if handled != tt.wantHandled {
+				t.Errorf("reconcileUploads() = %d, want %d", handled, tt.wantHandled)
+			}
+
+			stray := "1700000000000000000-stray.txt"
+			fi, tracked := files[stray]
+			if tracked != tt.wantTracked {
+				t.Errorf("Stray file tracked = %v, want %v", tracked, tt.wantTracked)
+			}
+			if tracked {
+				if fi.Name != "stray.txt" || fi.Checksum == "" || fi.UploadedAt.IsZero() {
+					t.Errorf("Adopted file = %+v, want name, checksum and upload time filled in", fi)
+				}
+				loaded := files
+				files = make(map[string]FileInfo)
+				loadFilesFromFile(filesFile)
+				if _, saved := files[stray]; !saved {
+					t.Error("Adopted file wasn't saved to files.json")
+				}
+				files = loaded
+			}
+			if _, err := os.Stat(filepath.Join(uploadsDir, stray)); (err == nil) != tt.wantInUploads {
+				t.Errorf("Stray file in uploads = %v, want %v", err == nil, tt.wantInUploads)
+			}
+			if _, err := os.Stat(filepath.Join(quarantineDir, stray)); (err == nil) != tt.wantQuarantine {
+				t.Errorf("Stray file in quarantine = %v, want %v", err == nil, tt.wantQuarantine)
+			}
+
+			// The tracked file and the partial upload are never touched
+			for _, name := range []string{"1-tracked.txt", "2-busy.txt" + partSuffix} {
+				if _, err := os.Stat(filepath.Join(uploadsDir, name)); err != nil {
+					t.Errorf("%s was moved: %v", name, err)
+				}
+			}
+			if _, adopted := files["2-busy.txt"+partSuffix]; adopted {
+				t.Error("Partial upload was adopted")
+			}
+		})
+	}
+}
+
+// Test a file that just landed isn't mistaken for an orphan
+func TestReconcileUploads_GracePeriod(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalQuarantineDir := quarantineDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		quarantineDir = originalQuarantineDir
+	})
+
+	uploadsDir = t.TempDir()
+	quarantineDir = t.TempDir()
+	files = make(map[string]FileInfo)
+	cfg.OrphanPolicy = orphanQuarantine
+	os.WriteFile(filepath.Join(uploadsDir, "1-new.txt"), []byte("new"), 0644)
+
+	if handled := reconcileUploads(time.Now()); handled != 0 {
+		t.Errorf("reconcileUploads() = %d, want 0 for a brand new file", handled)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, "1-new.txt")); err != nil {
+		t.Errorf("New file was moved: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseRecorder wraps a ResponseWriter to note the status sent and how
+// many body bytes went out, which the standard ResponseWriter doesn't tell
+// anyone. Flushing, hijacking and ReadFrom are passed through, so streaming,
+// websockets and sendfile work through it as usual.
+type responseRecorder struct {
+	http.ResponseWriter
+	StatusCode   int
+	BytesWritten int64
+
+	wroteHeader bool
+}
+
+// newResponseRecorder wraps w. Until a handler says otherwise the status is
+// 200, as it would be on the wire.
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, StatusCode: http.StatusOK}
+}
+
+// WriteHeader records the status. Only the first call counts, as with the
+// server's own writer; informational 1xx responses don't count at all.
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader && status >= 200 {
+		rr.StatusCode = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(p)
+	rr.BytesWritten += int64(n)
+	return n, err
+}
+
+// ReadFrom hands the copy to the writer underneath, so io.Copy into the
+// recorder still gets the server's sendfile path for files, and counts what
+// went out.
+func (rr *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	rr.wroteHeader = true
+	n, err := io.Copy(rr.ResponseWriter, src)
+	rr.BytesWritten += n
+	return n, err
+}
+
+// Flush sends what's been written so far, if the writer underneath can
+func (rr *responseRecorder) Flush() {
+	rr.wroteHeader = true
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands over the connection, if the writer underneath can
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the real writer
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// complete reports whether a successful response with the whole of a
+// size-byte body has gone out, e.g. so a burn-after-reading file is only
+// burned by a download that got all of it.
+func (rr *responseRecorder) complete(size int64) bool {
+	return rr.StatusCode == http.StatusOK && rr.BytesWritten == size
+}
+
+// recordResponses wraps every response in a responseRecorder, so anything
+// further in can find out how it went with recordedResponse.
+func recordResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(newResponseRecorder(w), r)
+	})
+}
+
+// recordedResponse finds the responseRecorder in w, looking through any
+// writers wrapped around it. It returns nil when recordResponses isn't in
+// front of the handler, as in tests calling one directly.
+func recordedResponse(w http.ResponseWriter) *responseRecorder {
+	for {
+		switch writer := w.(type) {
+		case *responseRecorder:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test the recorder keeps the status that went out
+func TestResponseRecorder_Status(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		want    int
+	}{
+		{"implicit 200", func(w http.ResponseWriter) { io.WriteString(w, "hi") }, http.StatusOK},
+		{"nothing written", func(w http.ResponseWriter) {}, http.StatusOK},
+		{"error", func(w http.ResponseWriter) { http.Error(w, "gone", http.StatusNotFound) }, http.StatusNotFound},
+		{"second WriteHeader ignored", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusCreated},
+		{"WriteHeader after body ignored", func(w http.ResponseWriter) {
+			io.WriteString(w, "hi")
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusOK},
+		{"informational skipped", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		}, http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newResponseRecorder(httptest.NewRecorder())
+			tt.handler(recorder)
+			if recorder.StatusCode != tt.want {
+				t.Errorf("StatusCode = %d, want %d", recorder.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// Test the recorder counts the body bytes written, and complete only holds
+// for a 200 with all of them
+func TestResponseRecorder_BytesWritten(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := newResponseRecorder(w)
+	io.WriteString(recorder, "hello, ")
+	io.Copy(recorder, strings.NewReader("world"))
+
+	if recorder.BytesWritten != 12 {
+		t.Errorf("BytesWritten = %d, want 12", recorder.BytesWritten)
+	}
+	if w.Body.String() != "hello, world" {
+		t.Errorf("Body = %q, want it passed through", w.Body.String())
+	}
+	if !recorder.complete(12) || recorder.complete(13) {
+		t.Error("complete() doesn't match the bytes written")
+	}
+
+	partial := newResponseRecorder(httptest.NewRecorder())
+	partial.WriteHeader(http.StatusPartialContent)
+	io.WriteString(partial, "hello")
+	if partial.complete(5) {
+		t.Error("complete() = true for a 206")
+	}
+}
+
+// Test flushes reach the writer underneath, directly and through a
+// ResponseController, and hijacking fails cleanly when it can't
+func TestResponseRecorder_Flush(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := newResponseRecorder(w)
+	var _ http.Flusher = recorder
+	var _ http.Hijacker = recorder
+
+	io.WriteString(recorder, "chunk")
+	recorder.Flush()
+	if !w.Flushed {
+		t.Error("Flush() didn't reach the underlying writer")
+	}
+
+	w = httptest.NewRecorder()
+	if err := http.NewResponseController(newResponseRecorder(w)).Flush(); err != nil {
+		t.Errorf("ResponseController.Flush() = %v", err)
+	}
+	if !w.Flushed {
+		t.Error("ResponseController flush didn't reach the underlying writer")
+	}
+
+	if _, _, err := recorder.Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack() = %v, want http.ErrNotSupported", err)
+	}
+}
+
+// wrappingWriter stands in for a writer some other middleware wraps around
+// the recorder
+type wrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (w *wrappingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Test handlers behind recordResponses can find the recorder, and see what
+// they wrote through it
+func TestRecordResponses(t *testing.T) {
+	var found *responseRecorder
+	handler := recordResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "teapot", http.StatusTeapot)
+		found = recordedResponse(w)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if found == nil {
+		t.Fatal("recordedResponse() = nil behind recordResponses")
+	}
+	if found.StatusCode != http.StatusTeapot || found.BytesWritten != int64(len("teapot\n")) {
+		t.Errorf("Recorded %d, %d bytes", found.StatusCode, found.BytesWritten)
+	}
+
+	// Through another writer's Unwrap, it's still found
+	wrapped := &wrappingWriter{ResponseWriter: found}
+	if recordedResponse(wrapped) != found {
+		t.Error("recordedResponse() didn't look through the wrapping writer")
+	}
+	if recordedResponse(httptest.NewRecorder()) != nil {
+		t.Error("recordedResponse() found a recorder that isn't there")
+	}
+}
+
+// readerFromWriter is a writer with its own ReadFrom, like the server's
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+// Test io.Copy through the recorder reaches the writer's own ReadFrom, so
+// sendfile isn't lost, and the bytes are still counted
+func TestResponseRecorder_ReadFrom(t *testing.T) {
+	w := &readerFromWriter{ResponseRecorder: httptest.NewRecorder()}
+	recorder := newResponseRecorder(w)
+
+	// Limited like http.ServeContent's, so io.Copy takes dst.ReadFrom
+	n, err := io.Copy(recorder, io.LimitReader(strings.NewReader("file contents"), 1<<20))
+	if err != nil || n != 13 {
+		t.Fatalf("io.Copy() = %d, %v", n, err)
+	}
+	if !w.readFrom {
+		t.Error("io.Copy didn't reach the underlying ReadFrom")
+	}
+	if recorder.BytesWritten != 13 || !recorder.complete(13) {
+		t.Errorf("BytesWritten = %d, want 13", recorder.BytesWritten)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+// A file whose link has leaked can be moved to a new ID without uploading it
+// again:
+//
+//	curl -X POST -H "X-Admin-Token: ..." http://localhost:3015/rotate-file/1674490732123456-report.pdf
+//
+// The old ID stops working straight away. The file keeps its name, checksum
+// and settings, and the response carries its new links. Having the link
+// isn't enough, since that's what leaked: it takes admin credentials, or
+// under mTLS the file's owner, who also has to have unlocked it if it has a
+// password.
+
+// RotatedFile is the response to "POST /rotate-file/{id}"
+type RotatedFile struct {
+	ID          string `json:"id"`
+	FileURL     string `json:"file_url"`
+	ViewURL     string `json:"view_url"`
+	DownloadURL string `json:"download_url"`
+}
+
+// rotateFileHandler handles "POST /rotate-file/{id}".
+func rotateFileHandler(w http.ResponseWriter, r *http.Request) {
+	fi, exists := resolveFile(mux.Vars(r)["id"])
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	who, admin := adminIdentity(r)
+	if !admin {
+		owner, filtered := ownerFilter(r)
+		if !filtered || owner == "" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		// Someone else's file is treated as missing rather than forbidden,
+		// so its ID can't be confirmed
+		if fi.Owner != owner {
+			http.NotFound(w, r)
+			return
+		}
+		if !fileUnlocked(r, fi) {
+			http.Error(w, "Unlock the file before rotating it", http.StatusForbidden)
+			return
+		}
+		who = owner
+	}
+
+	oldID := fi.ID
+	fi, err := rotateFile(fi)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logErrorf("Error rotating file %s: %v", oldID, err)
+		http.Error(w, "Cannot rotate file", http.StatusInternalServerError)
+		return
+	}
+	logInfof("Rotated file %s to %s for %s", oldID, fi.ID, who)
+
+	writeJSON(w, http.StatusOK, RotatedFile{
+		ID:          fi.ID,
+		FileURL:     absoluteURL(r, "/file/"+fi.ID),
+		ViewURL:     absoluteURL(r, "/view/"+fi.ID),
+		DownloadURL: absoluteURL(r, "/download/"+fi.ID),
+	})
+}
+
+// rotateFile renames a stored file, and its gzipped copy if it has one, to
+// a new ID and re-keys its metadata. It returns the file's new FileInfo.
+// Files on disk without metadata are renamed but stay untracked.
+func rotateFile(fi FileInfo) (FileInfo, error) {
+	oldID := fi.ID
+	newID := newFileID(fi.Name)
+	oldPath := fi.storedPath()
+	// A sharded file stays in its day's directory
+	newStoredName := path.Join(path.Dir(fi.StoredName), newID)
+	if !fi.sharded() {
+		newStoredName = newID
+	}
+
+	// The rename happens under the lock so nothing can look the file up
+	// between it moving on disk and moving in the map
+	filesMu.Lock()
+	fi.ID, fi.StoredName = newID, newStoredName
+	if err := os.Rename(oldPath, fi.storedPath()); err != nil {
+		filesMu.Unlock()
+		return FileInfo{}, err
+	}
+	_, tracked := files[oldID]
+	if tracked {
+		delete(files, oldID)
+		files[newID] = fi
+	}
+	filesMu.Unlock()
+
+	if err := os.Rename(precompressedPath(oldID), precompressedPath(newID)); err != nil && !os.IsNotExist(err) {
+		logErrorf("Error moving compressed copy of %s: %v", oldID, err)
+		removePrecompressed(oldID)
+	}
+	if err := os.Rename(optimizedPath(oldID), optimizedPath(newID)); err != nil && !os.IsNotExist(err) {
+		logErrorf("Error moving optimized copy of %s: %v", oldID, err)
+		removeOptimized(oldID)
+	}
+
+	if tracked {
+		saveFilesToFile(filesFile)
+	}
+	return fi, nil
+}
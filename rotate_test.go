@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// setupRotateTest stores one tracked file and returns its ID
+func setupRotateTest(t *testing.T, content string) string {
+	t.Helper()
+
+	originalCfg := cfg
+	originalFiles := files
+	originalFilesFile := filesFile
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		filesFile = originalFilesFile
+		uploadsDir = originalUploadsDir
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(uploadsDir, 0755)
+	cfg.AdminToken = "admin-secret"
+
+	id := "1674490732123456-report.txt"
+	os.WriteFile(filepath.Join(uploadsDir, id), []byte(content), 0644)
+	files = map[string]FileInfo{
+		id: {ID: id, Name: "report.txt", StoredName: id, Checksum: "abc123", Owner: "alice"},
+	}
+	return id
+}
+
+// postRotate calls rotateFileHandler for id, as an admin unless req says
+// otherwise
+func postRotate(id string, req *http.Request) *httptest.ResponseRecorder {
+	if req == nil {
+		req = httptest.NewRequest("POST", "/rotate-file/"+id, nil)
+		req.Header.Set("X-Admin-Token", "admin-secret")
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	rotateFileHandler(w, req)
+	return w
+}
+
+// download fetches a file the way /download/{id} does
+func download(id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/download/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	downloadFileHandler(w, req)
+	return w
+}
+
+// Test a rotated file is served from its new ID with the same name and
+// checksum, and its old ID is gone
+func TestRotateFileHandler(t *testing.T) {
+	oldID := setupRotateTest(t, "quarterly numbers")
+
+	w := postRotate(oldID, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotateFileHandler() status = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var rotated RotatedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &rotated); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	if rotated.ID == oldID || !strings.HasSuffix(rotated.ID, "-report.txt") {
+		t.Errorf("New ID = %q, want a fresh ID for report.txt", rotated.ID)
+	}
+	if rotated.DownloadURL != "http://example.com/download/"+rotated.ID {
+		t.Errorf("DownloadURL = %q", rotated.DownloadURL)
+	}
+
+	if w := download(oldID); w.Code != http.StatusNotFound {
+		t.Errorf("Old ID status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = download(rotated.ID)
+	if w.Code != http.StatusOK || w.Body.String() != "quarterly numbers" {
+		t.Errorf("New ID = %d %q, want the original content", w.Code, w.Body.String())
+	}
+
+	fi, exists := files[rotated.ID]
+	if !exists || fi.Name != "report.txt" || fi.Checksum != "abc123" || fi.StoredName != rotated.ID {
+		t.Errorf("Rotated metadata = %+v", fi)
+	}
+	if _, exists := files[oldID]; exists {
+		t.Error("Old ID still in the files map")
+	}
+
+	saved, _ := os.ReadFile(filesFile)
+	if !strings.Contains(string(saved), rotated.ID) || strings.Contains(string(saved), oldID) {
+		t.Error("files.json wasn't updated with the new ID")
+	}
+}
+
+// Test missing files and other people's files can't be rotated, and
+// having the link alone isn't enough
+func TestRotateFileHandler_NotAllowed(t *testing.T) {
+	id := setupRotateTest(t, "mine")
+
+	if w := postRotate("no-such-file.txt", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Missing file status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := postRotate(id, httptest.NewRequest("POST", "/rotate-file/"+id, nil)); w.Code != http.StatusForbidden {
+		t.Errorf("Anonymous rotate status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	wrong := httptest.NewRequest("POST", "/rotate-file/"+id, nil)
+	wrong.Header.Set("X-Admin-Token", "guess")
+	if w := postRotate(id, wrong); w.Code != http.StatusForbidden {
+		t.Errorf("Wrong admin token status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	cfg.AuthEnabled = true
+	req := httptest.NewRequest("POST", "/rotate-file/"+id, nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "mallory"}}}}
+	if w := postRotate(id, req); w.Code != http.StatusNotFound {
+		t.Errorf("Someone else's file status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	// The owner still needs the password for a protected file
+	fi := files[id]
+	fi.PasswordHash, _ = hashPassword("hunter2")
+	files[id] = fi
+	req = httptest.NewRequest("POST", "/rotate-file/"+id, nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}}}
+	if w := postRotate(id, req); w.Code != http.StatusForbidden {
+		t.Errorf("Owner without the password status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	if _, exists := files[id]; !exists {
+		t.Error("File was rotated by someone not allowed to")
+	}
+}
+
+// Test under mTLS the owner can rotate their own file without admin
+// credentials
+func TestRotateFileHandler_Owner(t *testing.T) {
+	id := setupRotateTest(t, "mine")
+	cfg.AuthEnabled = true
+
+	req := httptest.NewRequest("POST", "/rotate-file/"+id, nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}}}
+	if w := postRotate(id, req); w.Code != http.StatusOK {
+		t.Errorf("Owner rotate status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if _, exists := files[id]; exists {
+		t.Error("Owner's rotate left the old ID in place")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// Big snippet bodies can be kept gzipped in memory, trading some CPU on
+// every read for a lot less RAM on an instance full of logs and dumps.
+// Bodies over cfg.CompressSnippetsOver bytes are compressed; everything
+// reads and writes them through Body and SetBody, never Text directly.
+//
+// snippets.json gets plaintext bodies unless cfg.CompressSnippetsOnDisk is
+// set. With an encryption key, bodies are always uncompressed before being
+// encrypted, so nothing is ever written in the clear.
+
+// Body returns the snippet's text, uncompressing it if need be.
+func (s Snippet) Body() string {
+	if s.TextGzip == nil {
+		return s.Text
+	}
+	text, err := gunzipText(s.TextGzip)
+	if err != nil {
+		logErrorf("Error uncompressing snippet body: %v", err)
+		return ""
+	}
+	return text
+}
+
+// SetBody replaces the snippet's text, compressing it when it's over
+// cfg.CompressSnippetsOver bytes and that actually makes it smaller.
+func (s *Snippet) SetBody(text string) {
+	s.Text, s.TextGzip = text, nil
+	if cfg.CompressSnippetsOver == 0 || len(text) <= cfg.CompressSnippetsOver {
+		return
+	}
+	compressed, err := gzipText(text)
+	if err != nil {
+		logErrorf("Error compressing snippet body: %v", err)
+		return
+	}
+	if len(compressed) < len(text) {
+		s.Text, s.TextGzip = "", compressed
+	}
+}
+
+// gzipText compresses a snippet body.
+func gzipText(text string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, text); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipText uncompresses a body made by gzipText.
+func gunzipText(compressed []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	text, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+// plainSnippets returns a copy of snippetsMap with every body uncompressed,
+// for writing out.
+func plainSnippets(snippetsMap map[string]Snippet) map[string]Snippet {
+	plain := make(map[string]Snippet, len(snippetsMap))
+	for id, snippet := range snippetsMap {
+		if snippet.TextGzip != nil {
+			snippet.Text, snippet.TextGzip = snippet.Body(), nil
+		}
+		plain[id] = snippet
+	}
+	return plain
+}
+
+// compressSnippets applies cfg.CompressSnippetsOver to snippets read in from
+// disk or an import, in place: big plaintext bodies get compressed, and
+// compressed ones are uncompressed again if the setting has been turned off.
+func compressSnippets(snippetsMap map[string]Snippet) {
+	for id, snippet := range snippetsMap {
+		snippet.SetBody(snippet.Body())
+		snippetsMap[id] = snippet
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bigBody is a log-like body well over the thresholds used below
+var bigBody = strings.Repeat("2026-01-02 15:04:05 INFO request handled in 3ms\n", 2000)
+
+// Test bodies come back exactly as set, and only big ones are compressed
+func TestSnippetBody(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+
+	tests := []struct {
+		name           string
+		threshold      int
+		text           string
+		wantCompressed bool
+	}{
+		{"off", 0, bigBody, false},
+		{"small", 1024, "hello\nworld", false},
+		{"at threshold", len("hello"), "hello", false},
+		{"big", 1024, bigBody, true},
+		{"incompressible", 4, "a\x00é", false},
+		{"empty", 1024, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.CompressSnippetsOver = tt.threshold
+
+			var s Snippet
+			s.SetBody(tt.text)
+
+			if got := s.Body(); got != tt.text {
+				t.Errorf("Body() returned %d bytes, want the %d set", len(got), len(tt.text))
+			}
+			if compressed := s.TextGzip != nil; compressed != tt.wantCompressed {
+				t.Errorf("Compressed = %v, want %v", compressed, tt.wantCompressed)
+			}
+			if tt.wantCompressed {
+				if s.Text != "" {
+					t.Error("Compressed snippet still holds the plain text")
+				}
+				if len(s.TextGzip) >= len(tt.text)/10 {
+					t.Errorf("Compressed body is %d bytes, want well under %d", len(s.TextGzip), len(tt.text))
+				}
+			}
+		})
+	}
+}
+
+// Test compressed snippets are saved as plain text unless
+// compress_snippets_on_disk is set, and load back the same either way
+func TestSaveAndLoadSnippets_Compressed(t *testing.T) {
+	for _, onDisk := range []bool{false, true} {
+		originalSnippets := snippets
+		originalCfg := cfg
+		t.Cleanup(func() {
+			snippets = originalSnippets
+			cfg = originalCfg
+		})
+
+		cfg.CompressSnippetsOver = 1024
+		cfg.CompressSnippetsOnDisk = onDisk
+		var big Snippet
+		big.SetBody(bigBody)
+		snippets = map[string]Snippet{
+			"big":   big,
+			"small": {Text: "small text"},
+		}
+
+		filename := filepath.Join(t.TempDir(), "snippets.json")
+		saveSnippetsToFile(filename)
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read saved snippets: %v", err)
+		}
+		var saved map[string]Snippet
+		if err := json.Unmarshal(data, &saved); err != nil {
+			t.Fatalf("Failed to parse saved JSON: %v", err)
+		}
+		if gotCompressed := saved["big"].TextGzip != nil; gotCompressed != onDisk {
+			t.Errorf("onDisk=%v: saved compressed = %v", onDisk, gotCompressed)
+		}
+
+		snippets = make(map[string]Snippet)
+		loadSnippetsFromFile(filename)
+
+		if snippets["big"].TextGzip == nil {
+			t.Errorf("onDisk=%v: big snippet not compressed after loading", onDisk)
+		}
+		if snippets["big"].Body() != bigBody || snippets["small"].Body() != "small text" {
+			t.Errorf("onDisk=%v: loaded bodies don't match what was saved", onDisk)
+		}
+	}
+}
+
+// Test an encryption key keeps compressed bodies out of snippets.json
+func TestSaveSnippets_CompressedEncrypted(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+
+	cfg.CompressSnippetsOver = 1024
+	cfg.CompressSnippetsOnDisk = true
+	cfg.EncryptionKey = testEncryptionKey
+	var big Snippet
+	big.SetBody(bigBody)
+	snippets = map[string]Snippet{"big": big}
+
+	filename := filepath.Join(t.TempDir(), "snippets.json")
+	saveSnippetsToFile(filename)
+
+	data, _ := os.ReadFile(filename)
+	if bytes.Contains(data, []byte("text_gzip")) {
+		t.Error("Encrypted save wrote a compressed body")
+	}
+
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(filename)
+	if snippets["big"].Body() != bigBody {
+		t.Error("Loaded body doesn't match what was saved")
+	}
+}
+
+// Test changing the threshold recompresses or expands loaded bodies
+func TestCompressSnippets(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+
+	cfg.CompressSnippetsOver = 1024
+	var big Snippet
+	big.SetBody(bigBody)
+	loaded := map[string]Snippet{"gz": big, "plain": {Text: bigBody}}
+
+	cfg.CompressSnippetsOver = 0
+	compressSnippets(loaded)
+	for id, s := range loaded {
+		if s.TextGzip != nil || s.Text != bigBody {
+			t.Errorf("%s: still compressed with compression off", id)
+		}
+	}
+
+	cfg.CompressSnippetsOver = 1024
+	compressSnippets(loaded)
+	for id, s := range loaded {
+		if s.TextGzip == nil || s.Body() != bigBody {
+			t.Errorf("%s: not compressed over the threshold", id)
+		}
+	}
+}
+
+// Benchmark reading back a compressed body, the cost paid on every view
+func BenchmarkSnippetBody(b *testing.B) {
+	originalCfg := cfg
+	b.Cleanup(func() { cfg = originalCfg })
+
+	cfg.CompressSnippetsOver = 1024
+	var s Snippet
+	s.SetBody(bigBody)
+	b.SetBytes(int64(len(bigBody)))
+	b.ReportMetric(float64(len(s.TextGzip)), "stored-bytes")
+
+	for b.Loop() {
+		if s.Body() != bigBody {
+			b.Fatal("Body() doesn't match what was set")
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// staticHandler serves files from dir under prefix (normally /static/).
+// Directory listings are not served.
+func staticHandler(prefix, dir string) http.Handler {
+	fileServer := http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// faviconHandler serves favicon.ico from dir.
+func faviconHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join(dir, "favicon.ico"))
+	})
+}
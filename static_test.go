@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test static files and the favicon are served through the router
+func TestStaticRoutes(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "style.css"), []byte("body { color: red; }"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "favicon.ico"), []byte("\x00\x00\x01\x00"), 0644)
+	os.Mkdir(filepath.Join(tmpDir, "js"), 0755)
+	cfg.StaticDir = tmpDir
+	router := newRouter()
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantType   string
+	}{
+		{"/static/style.css", http.StatusOK, "text/css"},
+		{"/favicon.ico", http.StatusOK, "image/"},
+		{"/static/missing.js", http.StatusNotFound, ""},
+		{"/static/js/", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.wantType) {
+				t.Errorf("GET %s Content-Type = %q, want %s", tt.path, ct, tt.wantType)
+			}
+		})
+	}
+}
+
+// Test the bundled favicon exists so the default config can serve it
+func TestBundledFavicon(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(DefaultConfig().StaticDir, "favicon.ico")); err != nil {
+		t.Errorf("Bundled favicon missing: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Snippets can carry tags, given as a comma-separated "tags" field. The
+// snippet page links each one to /tag/{tag}, which is the index listing just
+// the snippets with that tag.
+
+// Limits on a snippet's tags
+const (
+	maxTags      = 10
+	maxTagLength = 32
+)
+
+// tagPattern is what a tag may be made of once normalized: letters, digits,
+// dashes and underscores, so it can go in a URL path as it is
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// parseTags normalizes a comma-separated list of tags: each is trimmed and
+// lowercased, with inner spaces turned into dashes, and empty ones and
+// repeats are dropped. It returns an error for a tag that's too long or has
+// other characters in it, or for too many tags.
+func parseTags(list string) ([]string, error) {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		tag = normalizeTag(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("Tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("Tag %q can only have letters, digits, dashes and underscores", tag)
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("A snippet can have at most %d tags", maxTags)
+	}
+	return tags, nil
+}
+
+// normalizeTag is a tag as it's stored and matched
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.Join(strings.Fields(tag), "-"))
+}
+
+// snippetsTagged returns the subset of snippetsMap carrying tag
+func snippetsTagged(snippetsMap map[string]Snippet, tag string) map[string]Snippet {
+	tagged := make(map[string]Snippet)
+	for id, snippet := range snippetsMap {
+		if slices.Contains(snippet.Tags, tag) {
+			tagged[id] = snippet
+		}
+	}
+	return tagged
+}
+
+// tagHandler handles "GET /tag/{tag}", the index page listing every snippet
+// with the tag, or the same as JSON.
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeTag(mux.Vars(r)["tag"])
+	if !tagPattern.MatchString(tag) {
+		renderNotFound(w, r, "No such tag")
+		return
+	}
+	serveIndexPage(w, r, tag)
+}
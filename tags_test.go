@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Test tags are trimmed, lowercased and deduplicated, with bad ones refused
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []string
+		wantErr bool
+	}{
+		{"none", "", nil, false},
+		{"normalized", " Go, notes ,GO,,machine  Learning", []string{"go", "notes", "machine-learning"}, false},
+		{"unicode", "Café,日本語", []string{"café", "日本語"}, false},
+		{"too long", strings.Repeat("a", maxTagLength+1), nil, true},
+		{"longest", strings.Repeat("a", maxTagLength), []string{strings.Repeat("a", maxTagLength)}, false},
+		{"bad characters", "go,<script>", nil, true},
+		{"path", "../admin", nil, true},
+		{"too many", "a,b,c,d,e,f,g,h,i,j,k", nil, true},
+		{"repeats don't count", "a,b,c,d,e,f,g,h,i,j,A,b", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTags(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTags(%q) error = %v, wantErr %v", tt.list, err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseTags(%q) = %q, want %q", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+// setupTagsTest starts with no snippets and a temp snippets file
+func setupTagsTest(t *testing.T) {
+	t.Helper()
+
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+	})
+	snippets = make(map[string]Snippet)
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+}
+
+// Test handleSave stores the normalized tags and refuses bad ones
+func TestHandleSave_Tags(t *testing.T) {
+	setupTagsTest(t)
+
+	save := func(tags string) *httptest.ResponseRecorder {
+		form := url.Values{"title": {"Tagged"}, "text": {"tagged text"}, "tags": {tags}}
+		req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handleSave(w, req)
+		return w
+	}
+
+	if w := save("Go, Notes, go"); w.Code != http.StatusSeeOther {
+		t.Fatalf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	for _, snippet := range snippets {
+		if !slices.Equal(snippet.Tags, []string{"go", "notes"}) {
+			t.Errorf("Saved tags = %q, want [go notes]", snippet.Tags)
+		}
+	}
+
+	if w := save("not/a/tag"); w.Code != http.StatusBadRequest {
+		t.Errorf("Bad tag status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(snippets) != 1 {
+		t.Errorf("%d snippets saved, want 1; the bad tag shouldn't be", len(snippets))
+	}
+}
+
+// Test /tag/{tag} lists only the snippets carrying the tag, newest first
+func TestTagHandler(t *testing.T) {
+	setupTagsTest(t)
+	now := time.Now()
+	snippets = map[string]Snippet{
+		"old":      {Title: "Old", Text: "a", CreatedAt: now.Add(-time.Hour), Tags: []string{"go"}},
+		"new":      {Title: "New", Text: "b", CreatedAt: now, Tags: []string{"notes", "go"}},
+		"other":    {Title: "Other", Text: "c", CreatedAt: now, Tags: []string{"notes"}},
+		"untagged": {Title: "Untagged", Text: "d", CreatedAt: now},
+		"unlisted": {Title: "Unlisted", Text: "e", CreatedAt: now, Tags: []string{"go"}, Visibility: visibilityUnlisted},
+	}
+
+	req := httptest.NewRequest("GET", "/tag/Go?format=json", nil)
+	req = mux.SetURLVars(req, map[string]string{"tag": "Go"})
+	w := httptest.NewRecorder()
+	tagHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("tagHandler() status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var data IndexData
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("Response is not JSON: %v", err)
+	}
+	var ids []string
+	for _, snippet := range data.Snippets {
+		ids = append(ids, snippet.ID)
+	}
+	if !slices.Equal(ids, []string{"new", "old"}) {
+		t.Errorf("Snippets tagged go = %q, want [new old]", ids)
+	}
+	if data.Tag != "go" {
+		t.Errorf("Tag = %q, want go", data.Tag)
+	}
+
+	req = httptest.NewRequest("GET", "/tag/<b>", nil)
+	req = mux.SetURLVars(req, map[string]string{"tag": "<b>"})
+	w = httptest.NewRecorder()
+	tagHandler(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Bad tag status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
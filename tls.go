@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tlsVersions maps min_tls_version settings to their tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion looks up a min_tls_version setting.
+func tlsVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf(`min_tls_version %q is not one of "1.0", "1.1", "1.2" or "1.3"`, version)
+	}
+	return v, nil
+}
+
+// cipherSuiteIDs looks up cipher suites by name. Only the suites Go
+// considers secure can be used.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// buildTLSConfig returns the TLS settings for the HTTPS listener. With auth
+// enabled, clients must present a certificate signed by one of the
+// configured CAs, issued to one of the allowed CNs.
+func buildTLSConfig(config Config) (*tls.Config, error) {
+	minVersion, err := tlsVersion(config.MinTLSVersion)
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cipherSuiteIDs(config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+	}
+	if !config.AuthEnabled {
+		return tlsConfig, nil
+	}
+
+	caPool, err := loadCACerts(config.caCertFiles())
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.ClientCAs = caPool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+	// The chain has already been verified against ClientCAs by the time this
+	// runs; all that's left is checking who it was issued to.
+	allowed := config.allowedCNs()
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errors.New("no verified client certificate")
+		}
+		cert := verifiedChains[0][0]
+		name, ok := allowedCertName(cert, allowed)
+		if !ok {
+			logWarnf("Rejected client certificate for CN %q", cert.Subject.CommonName)
+			return fmt.Errorf("client certificate CN %q is not allowed", cert.Subject.CommonName)
+		}
+		logDebugf("Accepted client certificate for %q", name)
+		return nil
+	}
+
+	return tlsConfig, nil
+}
+
+// serveHTTPSRedirect listens for plain HTTP on config.RedirectHTTPPort and
+// redirects every request to the HTTPS listener on httpsPort.
+func serveHTTPSRedirect(host, httpsPort string, config Config) {
+	addr := net.JoinHostPort(host, strconv.Itoa(config.RedirectHTTPPort))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           httpsRedirectHandler(httpsPort),
+		ReadTimeout:       time.Duration(config.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(config.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(config.IdleTimeout) * time.Second,
+	}
+	fmt.Printf("Redirecting http://%s/ to HTTPS\n", addr)
+	log.Fatal(server.ListenAndServe())
+}
+
+// httpsRedirectHandler sends requests to the same host, path and query over
+// HTTPS on httpsPort, leaving the port out when it's 443.
+func httpsRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// allowedCertName returns the first of the cert's names that's allowed: its
+// CN, then its DNS and email SANs.
+func allowedCertName(cert *x509.Certificate, allowed []string) (string, bool) {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, name := range names {
+		if name != "" && slices.Contains(allowed, name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// loadCACerts builds a pool from the given PEM files, and the *.pem and
+// *.crt files in any directories among them. Ones that can't be read are
+// logged and skipped; it's only an error if nothing could be loaded.
+func loadCACerts(paths []string) (*x509.CertPool, error) {
+	var files []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			for _, pattern := range []string{"*.pem", "*.crt"} {
+				matches, _ := filepath.Glob(filepath.Join(path, pattern))
+				files = append(files, matches...)
+			}
+			continue
+		}
+		files = append(files, path)
+	}
+
+	caPool := x509.NewCertPool()
+	loaded := 0
+	for _, file := range files {
+		caCert, err := os.ReadFile(file)
+		if err != nil {
+			logWarnf("Skipping CA cert %s: %v", file, err)
+			continue
+		}
+		if !caPool.AppendCertsFromPEM(caCert) {
+			logWarnf("Skipping CA cert %s: no certificates found", file)
+			continue
+		}
+		logInfof("Trusting client certificates from CA %s", file)
+		loaded++
+	}
+	if loaded == 0 {
+		return nil, fmt.Errorf("no CA certificates could be loaded from %s", strings.Join(paths, ", "))
+	}
+	return caPool, nil
+}
+
+// requestOwner returns the CN of the client certificate the request was made
+// with, or "" when there isn't one (plain HTTP or TLS without mTLS).
+func requestOwner(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// ownerFilter decides whose content a request gets to list. With auth
+// disabled everything is shown (single-user local use); with auth enabled
+// only the client's own content is, unless their CN is an admin.
+func ownerFilter(r *http.Request) (owner string, filtered bool) {
+	if !cfg.AuthEnabled {
+		return "", false
+	}
+	owner = requestOwner(r)
+	if isAdminCN(owner) {
+		return owner, false
+	}
+	return owner, true
+}
+
+// isAdminCN reports whether cn is listed in AdminCNs
+func isAdminCN(cn string) bool {
+	if cn == "" {
+		return false
+	}
+	for _, admin := range cfg.AdminCNs {
+		if cn == admin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// testCA is a throwaway certificate authority for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCA creates a self-signed CA
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issueClientCert signs a client certificate with the given CN and DNS SANs
+func (ca *testCA) issueClientCert(t *testing.T, cn string, dnsNames ...string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create client cert: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// verifyClient runs a client cert through the config's verification the way
+// the handshake would
+func verifyClient(t *testing.T, tlsConfig *tls.Config, cert *x509.Certificate) error {
+	t.Helper()
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:     tlsConfig.ClientCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return err
+	}
+	return tlsConfig.VerifyPeerCertificate([][]byte{cert.Raw}, chains)
+}
+
+// Test buildTLSConfig without auth doesn't ask for client certs
+func TestBuildTLSConfig_NoAuth(t *testing.T) {
+	config := DefaultConfig()
+	config.SSLEnabled = true
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		t.Fatalf("buildTLSConfig() error = %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		t.Errorf("ClientAuth = %v, want NoClientCert", tlsConfig.ClientAuth)
+	}
+}
+
+// Test min_tls_version and cipher_suites end up in the TLS config, with and
+// without mTLS
+func TestBuildTLSConfig_MinVersion(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := filepath.Join(t.TempDir(), "ca_cert.pem")
+	os.WriteFile(caFile, ca.pem, 0644)
+
+	tests := []struct {
+		name    string
+		version string
+		suites  []string
+		auth    bool
+		want    uint16
+		wantErr bool
+	}{
+		{"default", "1.2", nil, false, tls.VersionTLS12, false},
+		{"tls 1.3", "1.3", nil, false, tls.VersionTLS13, false},
+		{"tls 1.3 with mtls", "1.3", nil, true, tls.VersionTLS13, false},
+		{"cipher suites", "1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, true, tls.VersionTLS12, false},
+		{"unknown version", "1.4", nil, false, 0, true},
+		{"unknown suite", "1.2", []string{"TLS_NOPE"}, false, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.SSLEnabled = true
+			config.MinTLSVersion = tt.version
+			config.CipherSuites = tt.suites
+			if tt.auth {
+				config.AuthEnabled = true
+				config.CACertFile = caFile
+				config.Username = "alice"
+			}
+
+			tlsConfig, err := buildTLSConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tlsConfig.MinVersion != tt.want {
+				t.Errorf("MinVersion = %x, want %x", tlsConfig.MinVersion, tt.want)
+			}
+			if len(tlsConfig.CipherSuites) != len(tt.suites) {
+				t.Errorf("CipherSuites = %v, want %d suites", tlsConfig.CipherSuites, len(tt.suites))
+			}
+			if len(tt.suites) > 0 && tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+				t.Errorf("CipherSuites[0] = %x, want TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", tlsConfig.CipherSuites[0])
+			}
+		})
+	}
+}
+
+// Test plain HTTP requests are sent to the HTTPS site with their path and
+// query intact
+func TestHTTPSRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsPort string
+		host      string
+		target    string
+		want      string
+	}{
+		{"3015", "pasty.lan:8080", "/display/abc?nums=1", "https://pasty.lan:3015/display/abc?nums=1"},
+		{"443", "pasty.lan:8080", "/", "https://pasty.lan/"},
+		{"443", "pasty.lan", "/raw/a%2Fb", "https://pasty.lan/raw/a%2Fb"},
+		{"8443", "[::1]:8080", "/file/1-a.txt", "https://[::1]:8443/file/1-a.txt"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		httpsRedirectHandler(tt.httpsPort).ServeHTTP(w, req)
+
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s%s status = %d, want %d", tt.host, tt.target, w.Code, http.StatusMovedPermanently)
+		}
+		if loc := w.Header().Get("Location"); loc != tt.want {
+			t.Errorf("%s%s redirected to %q, want %q", tt.host, tt.target, loc, tt.want)
+		}
+	}
+}
+
+// Test buildTLSConfig with auth only accepts the configured username
+func TestBuildTLSConfig_Auth(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := filepath.Join(t.TempDir(), "ca_cert.pem")
+	os.WriteFile(caFile, ca.pem, 0644)
+
+	config := DefaultConfig()
+	config.SSLEnabled = true
+	config.AuthEnabled = true
+	config.CACertFile = caFile
+	config.Username = "alice"
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		t.Fatalf("buildTLSConfig() error = %v", err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("ClientAuth = %v, want RequireAndVerifyClientCert", tlsConfig.ClientAuth)
+	}
+
+	if err := verifyClient(t, tlsConfig, ca.issueClientCert(t, "alice")); err != nil {
+		t.Errorf("Cert for configured username rejected: %v", err)
+	}
+	if err := verifyClient(t, tlsConfig, ca.issueClientCert(t, "mallory")); err == nil {
+		t.Error("Cert for other username accepted")
+	}
+}
+
+// Test buildTLSConfig fails when the CA cert can't be read
+func TestBuildTLSConfig_MissingCA(t *testing.T) {
+	config := DefaultConfig()
+	config.SSLEnabled = true
+	config.AuthEnabled = true
+	config.CACertFile = filepath.Join(t.TempDir(), "missing.pem")
+	config.Username = "alice"
+
+	if _, err := buildTLSConfig(config); err == nil {
+		t.Error("buildTLSConfig() error = nil, want error for missing CA cert")
+	}
+}
+
+// Test any of the allowed CNs can connect, and nobody else
+func TestBuildTLSConfig_AllowedCNs(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	caFile := filepath.Join(t.TempDir(), "ca_cert.pem")
+	os.WriteFile(caFile, ca.pem, 0644)
+
+	config := DefaultConfig()
+	config.SSLEnabled = true
+	config.AuthEnabled = true
+	config.CACertFile = caFile
+	config.Username = "ignored"
+	config.AllowedCNs = []string{"alice", "bob", "carol.example.com"}
+
+	tlsConfig, err := buildTLSConfig(config)
+	if err != nil {
+		t.Fatalf("buildTLSConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantPass bool
+	}{
+		{"alice", ca.issueClientCert(t, "alice"), true},
+		{"bob", ca.issueClientCert(t, "bob"), true},
+		{"carol by SAN", ca.issueClientCert(t, "Carol", "carol.example.com"), true},
+		{"mallory", ca.issueClientCert(t, "mallory"), false},
+		{"mallory with other SAN", ca.issueClientCert(t, "mallory", "mallory.example.com"), false},
+		{"username is replaced by the list", ca.issueClientCert(t, "ignored"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyClient(t, tlsConfig, tt.cert)
+			if (err == nil) != tt.wantPass {
+				t.Errorf("verifyClient() error = %v, want accepted = %v", err, tt.wantPass)
+			}
+		})
+	}
+}
+
+// Test several CAs can be trusted at once, from files or a directory
+func TestBuildTLSConfig_MultipleCAs(t *testing.T) {
+	oldCA := newTestCA(t, "Old CA")
+	newCA := newTestCA(t, "New CA")
+	otherCA := newTestCA(t, "Other CA")
+
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old_ca.pem")
+	newFile := filepath.Join(dir, "new_ca.pem")
+	os.WriteFile(oldFile, oldCA.pem, 0644)
+	os.WriteFile(newFile, newCA.pem, 0644)
+
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr bool
+	}{
+		{"two files", []string{oldFile, newFile}, false},
+		{"directory", []string{dir}, false},
+		{"missing ones are skipped", []string{oldFile, filepath.Join(dir, "missing.pem"), newFile}, false},
+		{"none loadable", []string{filepath.Join(dir, "missing.pem")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.SSLEnabled = true
+			config.AuthEnabled = true
+			config.CACertFiles = tt.files
+			config.Username = "alice"
+
+			tlsConfig, err := buildTLSConfig(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := verifyClient(t, tlsConfig, oldCA.issueClientCert(t, "alice")); err != nil {
+				t.Errorf("Cert from old CA rejected: %v", err)
+			}
+			if err := verifyClient(t, tlsConfig, newCA.issueClientCert(t, "alice")); err != nil {
+				t.Errorf("Cert from new CA rejected: %v", err)
+			}
+			if err := verifyClient(t, tlsConfig, otherCA.issueClientCert(t, "alice")); err == nil {
+				t.Error("Cert from untrusted CA accepted")
+			}
+		})
+	}
+}
+
+// Test requestOwner reads the CN from the client certificate
+func TestRequestOwner(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+
+	tests := []struct {
+		name string
+		tls  *tls.ConnectionState
+		want string
+	}{
+		{"plain HTTP", nil, ""},
+		{"TLS without client cert", &tls.ConnectionState{}, ""},
+		{"mTLS", &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{ca.issueClientCert(t, "alice")},
+		}, "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{TLS: tt.tls}
+			if got := requestOwner(req); got != tt.want {
+				t.Errorf("requestOwner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test handleSave and uploadFileHandler record the client cert CN as owner
+// and log it at the default level
+func TestOwnerRecorded(t *testing.T) {
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		log.SetOutput(os.Stderr)
+	})
+
+	cfg.LogLevel = "info"
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	snippets = make(map[string]Snippet)
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+
+	ca := newTestCA(t, "Test CA")
+	connState := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{ca.issueClientCert(t, "alice")},
+	}
+
+	form := url.Values{}
+	form.Add("text", "owned content")
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.TLS = connState
+	handleSave(httptest.NewRecorder(), req)
+
+	for id, snippet := range snippets {
+		if snippet.Owner != "alice" {
+			t.Errorf("Snippet %s owner = %q, want alice", id, snippet.Owner)
+		}
+		if !strings.Contains(logged.String(), "Snippet "+id+" created by alice") {
+			t.Errorf("Log %q doesn't record the snippet's owner", logged.String())
+		}
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "owned.txt")
+	part.Write([]byte("owned file"))
+	writer.Close()
+
+	req = httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.TLS = connState
+	uploadFileHandler(httptest.NewRecorder(), req)
+
+	if len(files) != 1 {
+		t.Fatalf("Have %d files, want 1", len(files))
+	}
+	for id, fi := range files {
+		if fi.Owner != "alice" {
+			t.Errorf("File %s owner = %q, want alice", id, fi.Owner)
+		}
+		if !strings.Contains(logged.String(), "File "+id+" uploaded by alice") {
+			t.Errorf("Log %q doesn't record the file's owner", logged.String())
+		}
+	}
+}
+
+// Test ownerFilter decides who sees what on the index
+func TestOwnerFilter(t *testing.T) {
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+
+	ca := newTestCA(t, "Test CA")
+	requestAs := func(cn string) *http.Request {
+		return &http.Request{TLS: &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{ca.issueClientCert(t, cn)},
+		}}
+	}
+
+	tests := []struct {
+		name         string
+		authEnabled  bool
+		cn           string
+		wantOwner    string
+		wantFiltered bool
+	}{
+		{"auth disabled", false, "alice", "", false},
+		{"auth enabled", true, "alice", "alice", true},
+		{"auth enabled admin", true, "root", "root", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AuthEnabled = tt.authEnabled
+			cfg.AdminCNs = []string{"root"}
+
+			owner, filtered := ownerFilter(requestAs(tt.cn))
+			if owner != tt.wantOwner || filtered != tt.wantFiltered {
+				t.Errorf("ownerFilter() = (%q, %v), want (%q, %v)", owner, filtered, tt.wantOwner, tt.wantFiltered)
+			}
+		})
+	}
+}
+
+// Test serveIndex only lists the client's own content under mTLS
+func TestServeIndex_OwnerFiltered(t *testing.T) {
+	originalSnippets := snippets
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalCfg := cfg
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		cfg = originalCfg
+		tmplIndex = originalTmplIndex
+	})
+
+	tmplIndex = template.Must(template.New("index").Parse(
+		`{{range .Snippets}}snippet:{{.ID}} {{end}}{{range .Files}}file:{{.ID}} {{end}}`))
+
+	snippets = map[string]Snippet{
+		"aaa": {Title: "Alice's", Owner: "alice"},
+		"bbb": {Title: "Bob's", Owner: "bob"},
+	}
+
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+	for _, name := range []string{"1-alice.txt", "2-bob.txt", "3-untracked.txt"} {
+		os.WriteFile(filepath.Join(uploadsDir, name), []byte("x"), 0644)
+	}
+	files = map[string]FileInfo{
+		"1-alice.txt": {ID: "1-alice.txt", Name: "alice.txt", StoredName: "1-alice.txt", Owner: "alice"},
+		"2-bob.txt":   {ID: "2-bob.txt", Name: "bob.txt", StoredName: "2-bob.txt", Owner: "bob"},
+	}
+
+	ca := newTestCA(t, "Test CA")
+	cfg.AdminCNs = []string{"root"}
+
+	tests := []struct {
+		name        string
+		authEnabled bool
+		cn          string
+		want        []string
+		notWant     []string
+	}{
+		{"auth disabled shows all", false, "alice",
+			[]string{"snippet:aaa", "snippet:bbb", "file:1-alice.txt", "file:2-bob.txt", "file:3-untracked.txt"}, nil},
+		{"auth enabled shows own", true, "alice",
+			[]string{"snippet:aaa", "file:1-alice.txt"},
+			[]string{"snippet:bbb", "file:2-bob.txt", "file:3-untracked.txt"}},
+		{"admin shows all", true, "root",
+			[]string{"snippet:aaa", "snippet:bbb", "file:1-alice.txt", "file:2-bob.txt", "file:3-untracked.txt"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.AuthEnabled = tt.authEnabled
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{ca.issueClientCert(t, tt.cn)},
+			}
+			w := httptest.NewRecorder()
+			serveIndex(w, req)
+
+			body := w.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("Index missing %s, got: %s", s, body)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("Index should not list %s, got: %s", s, body)
+				}
+			}
+		})
+	}
+}
@@ -1,42 +1,387 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
-	qrcode "github.com/skip2/go-qrcode"
 )
 
 // FileInfo holds metadata about an uploaded file
 type FileInfo struct {
-	ID         string // e.g. "1674490732123456-MyPic.png"
-	Name       string // original file name from user
-	StoredName string // actual name used on disk
+	ID               string          `json:"id"`          // e.g. "1674490732123456-MyPic.png"
+	Name             string          `json:"name"`        // original file name from user
+	StoredName       string          `json:"stored_name"` // path on disk under uploads/: the ID, or YYYY/MM/DD/<id> when sharded
+	BurnAfterReading bool            `json:"burn_after_reading"`
+	Owner            string          `json:"owner,omitempty"`     // client cert CN under mTLS
+	ExpiresAt        time.Time       `json:"expires_at,omitzero"` // zero means never
+	UploadedAt       time.Time       `json:"uploaded_at,omitzero"`
+	Checksum         string          `json:"sha256,omitempty"`
+	PasswordHash     string          `json:"password_hash,omitempty"` // bcrypt; empty means not protected
+	ContentType      string          `json:"content_type,omitempty"`  // as uploaded; empty means go by the extension
+	Optimized        *OptimizedImage `json:"optimized,omitempty"`     // smaller copy served instead; see optimize.go
 }
 
 var files = make(map[string]FileInfo)
 
-// buildFileEntries converts a files map to a list of FileEntry for display
-func buildFileEntries(filesMap map[string]FileInfo) []FileEntry {
+// filesMu guards the files map, same rules as snippetsMu
+var filesMu sync.RWMutex
+
+// Global path for file metadata storage
+var filesFile string
+
+// storedPath is where a file's bytes are on disk. Files from before
+// StoredName was kept are under their ID.
+func (fi FileInfo) storedPath() string {
+	if fi.StoredName == "" {
+		return filepath.Join(uploadsDir, fi.ID)
+	}
+	return filepath.Join(uploadsDir, filepath.FromSlash(fi.StoredName))
+}
+
+// sharded reports whether a file is stored in a dated subdirectory rather
+// than straight under uploads/
+func (fi FileInfo) sharded() bool {
+	return strings.Contains(fi.StoredName, "/")
+}
+
+// newStoredName returns the StoredName for a new upload: its ID, or with
+// cfg.ShardUploads the ID under a directory for the day, which keeps
+// uploads/ from growing to tens of thousands of entries.
+func newStoredName(fileID string, now time.Time) string {
+	if !cfg.ShardUploads {
+		return fileID
+	}
+	return now.Format("2006/01/02") + "/" + fileID
+}
+
+// fileStoredPath is where fileID is on disk: wherever its metadata says, or
+// straight under uploads/ for files without any.
+func fileStoredPath(fileID string) string {
+	if fi, exists := lookupFile(fileID); exists {
+		return fi.storedPath()
+	}
+	return filepath.Join(uploadsDir, fileID)
+}
+
+// partSuffix marks uploads that are still being written
+const partSuffix = ".part"
+
+// copyUpload copies an upload to disk; tests swap it out to simulate failures
+var copyUpload = io.Copy
+
+// uploadSlots holds one token per upload being received, bounding them to
+// cfg.MaxConcurrentUploads. Nil means no limit.
+var uploadSlots chan struct{}
+
+// uploadSlotWait is how long an upload waits for a free slot before it's
+// turned away
+var uploadSlotWait = 2 * time.Second
+
+// uploadRetryAfter is the Retry-After, in seconds, sent with the 503 when
+// every slot is taken
+const uploadRetryAfter = "5"
+
+// newUploadSlots makes the uploadSlots semaphore for a limit of n
+func newUploadSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// acquireUploadSlot takes a slot in uploadSlots, waiting up to
+// uploadSlotWait, and returns the function that gives it back. It reports
+// false if none came free in time.
+func acquireUploadSlot(r *http.Request) (release func(), ok bool) {
+	slots := uploadSlots
+	if slots == nil {
+		return func() {}, true
+	}
+	timer := time.NewTimer(uploadSlotWait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-timer.C:
+		return nil, false
+	case <-r.Context().Done():
+		return nil, false
+	}
+}
+
+// takeUploadSlot is acquireUploadSlot for a handler about to write an
+// upload to disk, answering with a 503 itself when there's no slot.
+func takeUploadSlot(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	release, ok = acquireUploadSlot(r)
+	if !ok {
+		logWarnf("Turned away upload from %s: %d uploads already in progress", clientIP(r), cap(uploadSlots))
+		w.Header().Set("Retry-After", uploadRetryAfter)
+		http.Error(w, "Too many uploads in progress, try again shortly", http.StatusServiceUnavailable)
+	}
+	return release, ok
+}
+
+// buildFileEntries converts a files map to a list of FileEntry for display,
+// newest first, up to maxResults (0 for all of them)
+func buildFileEntries(filesMap map[string]FileInfo, maxResults int) []FileEntry {
 	var entries []FileEntry
 	for id, info := range filesMap {
-		entries = append(entries, FileEntry{
-			ID:   id,
-			Name: info.Name,
-		})
+		entry := FileEntry{
+			ID:         id,
+			Name:       info.Name,
+			UploadedAt: fileUploadTime(info),
+		}
+		if stat, err := os.Stat(info.storedPath()); err == nil {
+			entry.Size = stat.Size()
+			entry.SizeHuman = humanBytes(stat.Size())
+		}
+		entries = append(entries, entry)
+	}
+	sortFileEntries(entries)
+	return capFileEntries(entries, maxResults)
+}
+
+// capFileEntries keeps the first maxResults entries; 0 keeps them all
+func capFileEntries(entries []FileEntry, maxResults int) []FileEntry {
+	if maxResults > 0 && len(entries) > maxResults {
+		return entries[:maxResults]
 	}
 	return entries
 }
 
+// sortFileEntries orders entries newest first, by ID when times match
+func sortFileEntries(entries []FileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].UploadedAt.Equal(entries[j].UploadedAt) {
+			return entries[i].UploadedAt.After(entries[j].UploadedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+
+// fileUploadTime returns when a file was uploaded. Files saved before
+// UploadedAt existed fall back to the UnixNano prefix newFileID puts in the
+// ID. Returns the zero time if neither is available.
+func fileUploadTime(fi FileInfo) time.Time {
+	if !fi.UploadedAt.IsZero() {
+		return fi.UploadedAt
+	}
+	prefix, _, found := strings.Cut(fi.ID, "-")
+	if !found {
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// listFileEntries returns the uploads on disk for the index and the API,
+// newest first, up to maxResults (0 for all of them). When filtered is set,
+// only files uploaded by owner are included.
+func listFileEntries(owner string, filtered bool, maxResults int) []FileEntry {
+	var fileEntries []FileEntry
+
+	entries, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		logErrorf("Error reading uploads directory: %v", err)
+		return nil
+	}
+	for _, entry := range entries {
+		// Skip directories and uploads that are still in progress
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), partSuffix) {
+			continue
+		}
+		fileName := entry.Name()
+		// Files we have no metadata for have no known owner either
+		fi, exists := lookupFile(fileName)
+		if filtered && (!exists || fi.Owner != owner) {
+			continue
+		}
+		if exists && fi.sharded() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fileEntries = append(fileEntries, fileEntry(fileName, fi, exists, info))
+	}
+
+	// Sharded uploads are in the dated directories skipped above, so they're
+	// found from their metadata instead
+	var sharded []FileInfo
+	filesMu.RLock()
+	for _, fi := range files {
+		if fi.sharded() && (!filtered || fi.Owner == owner) {
+			sharded = append(sharded, fi)
+		}
+	}
+	filesMu.RUnlock()
+	for _, fi := range sharded {
+		if info, err := os.Stat(fi.storedPath()); err == nil {
+			fileEntries = append(fileEntries, fileEntry(fi.ID, fi, true, info))
+		}
+	}
+
+	sortFileEntries(fileEntries)
+	return capFileEntries(fileEntries, maxResults)
+}
+
+// fileEntry builds the listing entry for a stored file, named and dated by
+// its metadata if it has any and by the file otherwise
+func fileEntry(fileID string, fi FileInfo, exists bool, info os.FileInfo) FileEntry {
+	uploadedAt := fileUploadTime(fi)
+	if !exists || uploadedAt.IsZero() {
+		uploadedAt = info.ModTime()
+	}
+	name := fileID
+	if exists && fi.Name != "" {
+		name = fi.Name
+	}
+	return FileEntry{
+		ID:         fileID,
+		Name:       name,
+		UploadedAt: uploadedAt,
+		Size:       info.Size(),
+		SizeHuman:  humanBytes(info.Size()),
+		Checksum:   fi.Checksum,
+	}
+}
+
+// humanBytes formats a byte count for people, e.g. "3.2 MB". Units are
+// powers of 1024.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 5; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// lookupFile returns the metadata for an uploaded file, if we have any
+func lookupFile(fileID string) (FileInfo, bool) {
+	filesMu.RLock()
+	defer filesMu.RUnlock()
+	fi, exists := files[fileID]
+	return fi, exists
+}
+
+// resolveFile finds a file the handlers can serve. Files on disk we have
+// metadata for get it from the files map; files on disk without any (copied
+// in by hand, or from before files.json existed) get a stand-in named after
+// the stored file. Files missing from disk, still uploading or expired
+// aren't found.
+func resolveFile(fileID string) (FileInfo, bool) {
+	fileID = filepath.Base(fileID)
+	if strings.HasSuffix(fileID, partSuffix) {
+		return FileInfo{}, false
+	}
+	fi, exists := lookupFile(fileID)
+	if !exists {
+		fi = FileInfo{ID: fileID, Name: fileID, StoredName: fileID}
+	}
+	stat, err := os.Stat(fi.storedPath())
+	if err != nil || !stat.Mode().IsRegular() {
+		return FileInfo{}, false
+	}
+	if exists && fi.expired(time.Now()) {
+		return FileInfo{}, false
+	}
+	return fi, true
+}
+
+// loadFilesFromFile loads file metadata from JSON into the global `files` map.
+func loadFilesFromFile(filename string) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		logInfof("No %s file found, starting with empty file metadata.\n", filename)
+		return
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Could not open %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(&files); err != nil {
+		logErrorf("Failed to decode JSON from %s: %v", filename, err)
+		file.Close()
+		backupCorruptFile(filename)
+		files = make(map[string]FileInfo)
+		return
+	}
+
+	logInfof("Loaded %d files from %s.\n", len(files), filename)
+}
+
+// saveFilesToFile saves the global `files` map to disk as JSON, the same way
+// saveSnippetsToFile does for snippets.
+func saveFilesToFile(filename string) {
+	filesMu.RLock()
+	data, err := json.MarshalIndent(files, "", "  ")
+	count := len(files)
+	filesMu.RUnlock()
+	if err != nil {
+		logErrorf("Error marshaling files data: %v", err)
+		return
+	}
+
+	tmpFile := filename + ".tmp"
+	if err = os.WriteFile(tmpFile, data, 0644); err != nil {
+		logErrorf("Error writing temp file %s: %v", tmpFile, err)
+		return
+	}
+
+	if err = os.Rename(tmpFile, filename); err != nil {
+		logErrorf("Error renaming temp file: %v", err)
+		return
+	}
+
+	logDebugf("Successfully saved %d files to %s.\n", count, filename)
+}
+
+// burnFile removes a burn-after-reading file from disk and the files map.
+func burnFile(fileID string) {
+	if err := os.Remove(fileStoredPath(fileID)); err != nil && !os.IsNotExist(err) {
+		logErrorf("Error removing burned file %s: %v", fileID, err)
+	}
+	removePrecompressed(fileID)
+	removeOptimized(fileID)
+
+	filesMu.Lock()
+	delete(files, fileID)
+	filesMu.Unlock()
+
+	saveFilesToFile(filesFile)
+	logDebugf("Burned file %s after reading", fileID)
+}
+
 // getContentType returns the MIME type based on file extension
 func getContentType(filename string) string {
 	ext := filepath.Ext(filename)
@@ -59,6 +404,10 @@ func getContentType(filename string) string {
 		return "image/gif"
 	case ".webp":
 		return "image/webp"
+	case ".bmp":
+		return "image/bmp"
+	case ".tif", ".tiff":
+		return "image/tiff"
 	case ".txt":
 		return "text/plain"
 	case ".html", ".htm":
@@ -78,139 +427,140 @@ func getContentType(filename string) string {
 	}
 }
 
-// serveFile is a helper that serves a file with specified content disposition
+// genericContentTypes say nothing more than "some bytes", so the extension
+// is a better guess
+var genericContentTypes = map[string]bool{
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+}
+
+// activeContentTypes are ones browsers run scripts from. An upload claiming
+// one is served by its extension instead, so naming a file .png can't get
+// it rendered as a page on this site.
+var activeContentTypes = map[string]bool{
+	"text/html":              true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"text/javascript":        true,
+	"application/javascript": true,
+	"text/xml":               true,
+	"application/xml":        true,
+}
+
+// uploadContentType returns the Content-Type a client gave an upload, to be
+// served in place of one guessed from the extension. Generic and active
+// types aren't kept, and neither are any parameters except charset.
+func uploadContentType(header string) string {
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil || genericContentTypes[mediaType] || activeContentTypes[mediaType] {
+		return ""
+	}
+	if charset := params["charset"]; charset != "" {
+		return mime.FormatMediaType(mediaType, map[string]string{"charset": charset})
+	}
+	return mediaType
+}
+
+// contentType is the type a file is served as: the one it was uploaded
+// with, else a guess from its name.
+func (fi FileInfo) contentType() string {
+	if fi.ContentType != "" {
+		return fi.ContentType
+	}
+	return getContentType(fi.Name)
+}
+
+// serveFile is a helper that serves a file with specified content disposition.
+// Burn-after-reading files are removed once their whole body has been sent;
+// partial (range) or interrupted transfers leave them in place.
 func serveFile(w http.ResponseWriter, r *http.Request, fileID string, inline bool) {
 	// Clean the filename to prevent directory traversal attacks
 	fileID = filepath.Base(fileID)
-	fullPath := filepath.Join(uploadsDir, fileID)
 
-	// Check if file exists
-	stat, err := os.Stat(fullPath)
-	if os.IsNotExist(err) {
-		log.Printf("File not found: %s", fullPath)
-		http.NotFound(w, r)
+	fi, exists := resolveFile(fileID)
+	if !exists {
+		logDebugf("File not found: %s", fileID)
+		renderNotFound(w, r, "File not found")
 		return
 	}
 
+	// An optimized image is sent in place of the original unless
+	// ?original=1 asks for that
+	fullPath, contentType, filename := fi.storedPath(), fi.contentType(), fi.Name
+	if path, ok := optimizedVersion(r, fi); ok {
+		fullPath, contentType, filename = path, fi.Optimized.ContentType, optimizedName(fi)
+	}
+
 	f, err := os.Open(fullPath)
 	if err != nil {
-		log.Printf("File open error: %v", err)
-		http.NotFound(w, r)
+		logErrorf("File open error: %v", err)
+		renderNotFound(w, r, "File not found")
 		return
 	}
 	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		logErrorf("File stat error: %v", err)
+		renderNotFound(w, r, "File not found")
+		return
+	}
 
-	// Try to get original filename from files map, otherwise use the stored name
-	filename := fileID
-	if fi, exists := files[fileID]; exists {
-		filename = fi.Name
+	// Protected files need an unlock cookie or the password in ?pw=
+	if !checkFilePassword(w, r, fi, "download") {
+		return
 	}
 
 	// Set appropriate headers
-	contentType := getContentType(filename)
 	w.Header().Set("Content-Type", contentType)
 
 	if inline {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
+		w.Header().Set("Content-Disposition", contentDisposition("inline", filename))
 	} else {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", filename))
 	}
 
-	// Critical for iOS: set Content-Length header
 	fileSize := stat.Size()
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", fileSize))
-
-	// Set cache control headers for media files
-	w.Header().Set("Cache-Control", "public, max-age=3600")
-
-	// Handle HTTP Range requests (HTTP 206 Partial Content)
-	// This is important for iOS to support seeking and streaming
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader != "" {
-		// Parse Range header (e.g., "bytes=0-1023" or "bytes=1024-")
-		start, end, err := parseRange(rangeHeader, fileSize)
-		if err == nil {
-			// Seek to start position
-			if _, err := f.Seek(start, 0); err == nil {
-				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
-				w.Header().Set("Content-Length", fmt.Sprintf("%d", end-start+1))
-				w.Header().Set("Accept-Ranges", "bytes")
-				w.WriteHeader(http.StatusPartialContent)
-				log.Printf("Serving range request for %s: bytes %d-%d/%d", filename, start, end, fileSize)
-				io.CopyN(w, f, end-start+1)
-				return
-			}
-		}
-	}
 
-	// Set Accept-Ranges header to indicate we support range requests
-	w.Header().Set("Accept-Ranges", "bytes")
-
-	log.Printf("Serving file: %s (size: %d bytes, inline: %v)", filename, fileSize, inline)
-
-	_, err = io.Copy(w, f)
-	if err != nil {
-		log.Printf("File copy error: %v", err)
-	}
-}
-
-// parseRange parses an HTTP Range header and returns start and end byte positions
-func parseRange(rangeHeader string, fileSize int64) (int64, int64, error) {
-	// Simple parser for "bytes=start-end" format
-	if len(rangeHeader) < 6 || rangeHeader[:6] != "bytes=" {
-		return 0, 0, fmt.Errorf("invalid range format")
+	// Set cache control headers for media files. Shared caches mustn't
+	// keep a copy of a protected file for others.
+	if fi.PasswordHash != "" {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
 	}
 
-	rangeSpec := rangeHeader[6:]
-	parts := strings.Split(rangeSpec, "-")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid range format")
+	// Whole-file downloads can come from the gzipped copy. Burn-after-reading
+	// files always come from the original, which is what gets burned.
+	if servePrecompressed(w, r, fileID, fi) {
+		return
 	}
 
-	var start, end int64
-	var err error
-
-	if parts[0] == "" {
-		// Suffix range: "-500" means last 500 bytes
-		if parts[1] == "" {
-			return 0, 0, fmt.Errorf("invalid range format")
-		}
-		suffixLength, err := strconv.ParseInt(parts[1], 10, 64)
-		if err != nil || suffixLength < 0 {
-			return 0, 0, fmt.Errorf("invalid suffix length")
-		}
-		start = fileSize - suffixLength
-		if start < 0 {
-			start = 0
-		}
-		end = fileSize - 1
-	} else {
-		// Normal range: "100-200"
-		start, err = strconv.ParseInt(parts[0], 10, 64)
-		if err != nil || start < 0 {
-			return 0, 0, fmt.Errorf("invalid start position")
-		}
-
-		if parts[1] == "" {
-			// Open-ended range: "100-" means from 100 to end
-			end = fileSize - 1
-		} else {
-			end, err = strconv.ParseInt(parts[1], 10, 64)
-			if err != nil || end < start {
-				return 0, 0, fmt.Errorf("invalid end position")
-			}
-		}
+	// ServeContent answers Range requests with a 206, so downloads can be
+	// resumed or fetched in parallel chunks (several ranges come back as
+	// multipart/byteranges, unsatisfiable ones as a 416), along with HEAD and
+	// If-Modified-Since. iOS needs ranges to seek in videos. A burn file has
+	// no modification time, so a 304 can't stand in for the download that
+	// burns it.
+	modTime := stat.ModTime()
+	if fi.BurnAfterReading {
+		modTime = time.Time{}
 	}
-
-	if start >= fileSize {
-		return 0, 0, fmt.Errorf("range start beyond file size")
+	logDebugf("Serving file: %s (size: %d bytes, inline: %v, range: %q)", filename, fileSize, inline, r.Header.Get("Range"))
+	// The server already records every response; a handler called on its
+	// own, as in tests, gets a recorder of its own
+	recorded := recordedResponse(w)
+	if recorded == nil {
+		recorded = newResponseRecorder(w)
+		w = recorded
 	}
+	http.ServeContent(w, r, filename, modTime, f)
 
-	if end >= fileSize {
-		end = fileSize - 1
+	// Only a complete download burns the file, not a range or a HEAD
+	if fi.BurnAfterReading && recorded.complete(fileSize) && r.Method != http.MethodHead {
+		f.Close()
+		burnFile(fileID)
 	}
-
-	return start, end, nil
 }
 
 // isVideoFile checks if the file is a video based on extension
@@ -242,6 +592,48 @@ func isTextFile(filename string) bool {
 	return ext == ".txt" || ext == ".html" || ext == ".htm" || ext == ".json" || ext == ".xml"
 }
 
+// isBinaryFile reports whether the start of a file looks like something other
+// than text: a NUL byte, or bytes that aren't UTF-8. A character cut off at
+// the end of head doesn't count.
+func isBinaryFile(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	return !utf8.Valid(trimPartialRune(head))
+}
+
+// trimPartialRune drops a UTF-8 character cut off at the end of b
+func trimPartialRune(b []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && len(b) > i; i++ {
+		if utf8.RuneStart(b[len(b)-1-i]) {
+			if !utf8.FullRune(b[len(b)-1-i:]) {
+				return b[:len(b)-1-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// readFileHead returns up to n bytes from the start of a file, and whether
+// there's more after them.
+func readFileHead(path string, n int) ([]byte, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	head := make([]byte, n+1)
+	read, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	if read > n {
+		return head[:n], true, nil
+	}
+	return head[:read], false, nil
+}
+
 // viewFileHandler renders an HTML page with embedded media player/viewer
 func viewFileHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -249,21 +641,19 @@ func viewFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Clean the filename
 	fileID = filepath.Base(fileID)
-	fullPath := filepath.Join(uploadsDir, fileID)
-
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		http.NotFound(w, r)
+	fi, exists := resolveFile(fileID)
+	if !exists {
+		renderNotFound(w, r, "File not found")
 		return
 	}
-
-	// Try to get original filename from files map
-	filename := fileID
-	if fi, exists := files[fileID]; exists {
-		filename = fi.Name
+	fullPath := fi.storedPath()
+	if !fileUnlocked(r, fi) {
+		renderUnlockPage(w, r, fileID, "view", false)
+		return
 	}
+	filename := fi.Name
 
-	contentType := getContentType(filename)
+	contentType := fi.contentType()
 
 	// Read text content if it's a text file
 	var textContent string
@@ -275,10 +665,10 @@ func viewFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate QR code for current page
-	currentPageURL := fmt.Sprintf("%s://%s%s", scheme(r), r.Host, r.RequestURI)
-	homeQRCode, _ := generateQRCodeBase64(currentPageURL)
+	homeQRCode, _ := generateQRCodeBase64(currentURL(r))
 
 	data := struct {
+		Branding
 		FileName    string
 		StreamURL   string
 		DownloadURL string
@@ -291,9 +681,10 @@ func viewFileHandler(w http.ResponseWriter, r *http.Request) {
 		TextContent string
 		HomeQRCode  string
 	}{
+		Branding:    siteBranding(),
 		FileName:    filename,
-		StreamURL:   fmt.Sprintf("/stream/%s", fileID),
-		DownloadURL: fmt.Sprintf("/download/%s", fileID),
+		StreamURL:   sitePath("/stream/" + fileID),
+		DownloadURL: sitePath("/download/" + fileID),
 		ContentType: contentType,
 		IsVideo:     isVideoFile(filename),
 		IsAudio:     isAudioFile(filename),
@@ -304,8 +695,8 @@ func viewFileHandler(w http.ResponseWriter, r *http.Request) {
 		HomeQRCode:  homeQRCode,
 	}
 
-	if err := tmplView.Execute(w, data); err != nil {
-		log.Printf("Template execute error: %v", err)
+	if err := currentTemplate(tmplView, "view.html").Execute(w, data); err != nil {
+		logErrorf("Template execute error: %v", err)
 		http.Error(w, "Template error", http.StatusInternalServerError)
 	}
 }
@@ -317,19 +708,14 @@ func streamFileHandler(w http.ResponseWriter, r *http.Request) {
 	serveFile(w, r, fileID, true)
 }
 
-// downloadFileHandler streams the requested file as an attachment for download
+// downloadFileHandler streams the requested file as an attachment for
+// download. ?disposition=inline asks the browser to open it instead; any other
+// value is ignored.
 func downloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
-	serveFile(w, r, fileID, false)
-}
-
-// scheme tries to detect http vs https, for building absolute URLs in displayFileHandler
-func scheme(r *http.Request) string {
-	if r.TLS != nil {
-		return "https"
-	}
-	return "http"
+	inline := r.URL.Query().Get("disposition") == "inline"
+	serveFile(w, r, fileID, inline)
 }
 
 // uploadFileHandler handles the "POST /upload" route.
@@ -340,53 +726,288 @@ func uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The body is only read once there's a slot, so the ones waiting don't
+	// take up memory or disk
+	release, ok := takeUploadSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
 	// Parse up to 10 MB
-	r.ParseMultipartForm(10 << 20)
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadBytes)
+	err := r.ParseMultipartForm(10 << 20)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, uploadTooLargeMessage(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !requireInvite(w, r, "upload", false) {
+		return
+	}
 
 	file, handler, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("Error retrieving file from form data: %v", err)
+		logErrorf("Error retrieving file from form data: %v", err)
 		http.Error(w, "Error retrieving file", http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	// Ensure uploads dir exists
-	os.MkdirAll(uploadsDir, 0755)
+	ttl, err := parseExpiry(r.FormValue("expires"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// Build a unique ID / filename for the stored file
-	// For example, <timestamp>-<originalname>
-	uniqueID := fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(handler.Filename))
-	fullPath := filepath.Join(uploadsDir, uniqueID)
+	filename := cleanFileName(handler.Filename)
+	if filename == "" {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
 
-	dst, err := os.Create(fullPath)
-	if err != nil {
-		log.Printf("Error creating file on server: %v", err)
-		http.Error(w, "Cannot create file on server", http.StatusInternalServerError)
+	// Refuse disallowed types before anything touches the disk
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(file, head)
+	if err := checkUploadType(filename, head[:n]); err != nil {
+		logWarnf("Rejected upload %q: %v", filename, err)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		logErrorf("Error rewinding upload: %v", err)
+		http.Error(w, "Cannot save file", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	passwordHash, err := uploadPasswordHash(r.FormValue("password"))
 	if err != nil {
-		log.Printf("Error saving file: %v", err)
+		logErrorf("Error hashing upload password: %v", err)
 		http.Error(w, "Cannot save file", http.StatusInternalServerError)
 		return
 	}
 
+	uniqueID := newFileID(filename)
 	fi := FileInfo{
-		ID:         uniqueID,
-		Name:       handler.Filename,
-		StoredName: uniqueID,
+		ID:               uniqueID,
+		Name:             filename,
+		StoredName:       newStoredName(uniqueID, time.Now()),
+		BurnAfterReading: r.FormValue("burn") == "true",
+		Owner:            requestOwner(r),
+		ExpiresAt:        expiryTime(ttl),
+		PasswordHash:     passwordHash,
+		ContentType:      uploadContentType(handler.Header.Get("Content-Type")),
+	}
+	existingID, err := storeUpload(&fi, file)
+	if err != nil {
+		logErrorf("Error saving file: %v", err)
+		http.Error(w, "Cannot save file", http.StatusInternalServerError)
+		return
+	}
+	if existingID != "" {
+		http.Redirect(w, r, sitePath("/file/"+existingID), http.StatusSeeOther)
+		return
+	}
+
+	addFile(fi)
+	notifyFileWebhook(r, fi)
+
+	http.Redirect(w, r, sitePath("/file/"+uniqueID), http.StatusSeeOther)
+}
+
+// uploadTooLargeMessage tells the client how big a file can be
+func uploadTooLargeMessage() string {
+	return fmt.Sprintf("Files are limited to %s", humanBytes(cfg.MaxUploadBytes))
+}
+
+// maxFileNameLength caps the bytes kept of an uploaded file's name, leaving
+// room for the timestamp newFileID adds within a 255-byte file name limit
+const maxFileNameLength = 200
+
+// maxExtensionLength is the longest extension cleanFileName keeps when it
+// shortens a name
+const maxExtensionLength = 16
+
+// cleanFileName makes a client-supplied file name safe to store and serve:
+// any directory part is dropped, control characters and invalid UTF-8 are
+// removed, and it's cut to maxFileNameLength bytes, keeping the extension.
+// It returns "" if nothing usable is left.
+func cleanFileName(name string) string {
+	// Old browsers send the whole Windows path
+	name = name[strings.LastIndex(name, "\\")+1:]
+	name = filepath.Base(strings.ToValidUTF8(name, ""))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxExtensionLength {
+			ext = ""
+		}
+		name = truncateBytes(strings.TrimSuffix(name, ext), maxFileNameLength-len(ext)) + ext
+	}
+	return name
+}
+
+// truncateBytes cuts s to at most n bytes without splitting a character
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// contentDisposition builds a Content-Disposition header naming a file.
+// filename has a plain ASCII stand-in for old clients, and filename* the
+// real name, RFC 5987-encoded.
+func contentDisposition(disposition, name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encodeRFC5987(name))
+}
+
+// encodeRFC5987 percent-encodes everything in s but RFC 5987's attr-chars
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// newFileID builds a unique ID / filename for a stored file,
+// for example <timestamp>-<originalname>
+func newFileID(filename string) string {
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(filename))
+}
+
+// storeUpload writes src into the uploads directory under fi.StoredName and
+// records its SHA-256 in fi.Checksum. It writes to a .part file first and
+// only renames once the copy succeeds, so an aborted upload never shows up
+// in the uploads listing.
+//
+// With dedup on, if the same owner already has an identical file the new
+// copy is thrown away and the existing file's ID is returned instead; the
+// existing file keeps its original name.
+func storeUpload(fi *FileInfo, src io.Reader) (existingID string, err error) {
+	fullPath := fi.storedPath()
+	partPath := fullPath + partSuffix
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", err
+	}
+
+	dst, err := os.Create(partPath)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = copyUpload(dst, io.TeeReader(src, hash))
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	fi.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if existing, found := findDuplicate(*fi); found {
+		os.Remove(partPath)
+		logDebugf("Upload %s has the same content as %s, keeping %s", fi.Name, existing.ID, existing.Name)
+		return existing.ID, nil
 	}
-	files[uniqueID] = fi
 
-	http.Redirect(w, r, "/file/"+uniqueID, http.StatusSeeOther)
+	if err := os.Rename(partPath, fullPath); err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	return "", nil
+}
+
+// findDuplicate looks for a stored file with the same checksum that fi can
+// be pointed at instead. Burn-after-reading and expiring files are never
+// shared, since one person's download or expiry would take the other's file
+// with it, and neither are password-protected ones. Only the same owner's
+// files are considered.
+func findDuplicate(fi FileInfo) (FileInfo, bool) {
+	if !cfg.Dedup || fi.BurnAfterReading || !fi.ExpiresAt.IsZero() || fi.PasswordHash != "" {
+		return FileInfo{}, false
+	}
+
+	filesMu.RLock()
+	defer filesMu.RUnlock()
+	for _, existing := range files {
+		if existing.Checksum != fi.Checksum || existing.Owner != fi.Owner {
+			continue
+		}
+		if existing.BurnAfterReading || !existing.ExpiresAt.IsZero() || existing.PasswordHash != "" {
+			continue
+		}
+		if _, err := os.Stat(existing.storedPath()); err != nil {
+			continue
+		}
+		return existing, true
+	}
+	return FileInfo{}, false
+}
+
+// addFile registers a stored upload in the files map and saves the metadata.
+func addFile(fi FileInfo) {
+	if fi.UploadedAt.IsZero() {
+		fi.UploadedAt = time.Now()
+	}
+
+	fi = optimizeUpload(fi)
+
+	filesMu.Lock()
+	files[fi.ID] = fi
+	filesMu.Unlock()
+
+	if fi.Owner != "" {
+		logInfof("File %s uploaded by %s", fi.ID, fi.Owner)
+	}
+
+	precompressUpload(fi)
+
+	saveFilesToFile(filesFile)
+}
+
+// notifyFileWebhook announces a new upload
+func notifyFileWebhook(r *http.Request, fi FileInfo) {
+	notifyWebhook(WebhookEvent{
+		Type:        "file",
+		ID:          fi.ID,
+		TitleOrName: fi.Name,
+		URL:         absoluteURL(r, "/file/"+fi.ID),
+		Owner:       fi.Owner,
+	})
 }
 
 // generateQRCodeBase64 generates a QR code for the given URL and returns it as base64-encoded string
 func generateQRCodeBase64(url string) (string, error) {
-	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	png, err := qrPNG(url, defaultQRSize)
 	if err != nil {
 		return "", err
 	}
@@ -399,51 +1020,169 @@ func displayFileHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Clean the filename to prevent directory traversal attacks
 	fileID = filepath.Base(fileID)
-	fullPath := filepath.Join(uploadsDir, fileID)
+	fi, exists := resolveFile(fileID)
+	if !exists {
+		renderNotFound(w, r, "File not found")
+		return
+	}
+	fullPath := fi.storedPath()
+	if !fileUnlocked(r, fi) {
+		renderUnlockPage(w, r, fileID, "file", false)
+		return
+	}
+	filename := fi.Name
+
+	head, truncated, err := readFileHead(fullPath, max(cfg.FilePreviewBytes, sniffLen))
+	if err != nil {
+		logErrorf("File read error: %v", err)
+		renderNotFound(w, r, "File not found")
+		return
+	}
 
-	// Check if file exists on disk
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		http.NotFound(w, r)
+	// Binary files there's nothing to show for just get a download button.
+	// Formats browsers can't show count as images once they're optimized.
+	isImage := isImageFile(filename) || fi.Optimized != nil
+	isMedia := isImage || isVideoFile(filename) || isAudioFile(filename)
+	if !isMedia && !isArchiveFile(filename) && !isPDFFile(filename) && isBinaryFile(head[:min(len(head), sniffLen)]) {
+		renderDownloadPage(w, fi, fullPath)
 		return
 	}
 
-	// Try to get original filename from files map, otherwise use the stored name
-	filename := fileID
-	if fi, exists := files[fileID]; exists {
-		filename = fi.Name
+	// Text gets a preview and media a player, except for burn-after-reading
+	// files, which this page would give away without burning
+	var preview string
+	if !fi.BurnAfterReading && !isMedia && cfg.FilePreviewBytes > 0 {
+		if len(head) > cfg.FilePreviewBytes {
+			head, truncated = head[:cfg.FilePreviewBytes], true
+		}
+		if !isBinaryFile(head) {
+			preview = string(trimPartialRune(head))
+		}
 	}
+	showMedia := isMedia && !fi.BurnAfterReading
 
 	// QR code points to view URL for inline viewing on mobile
-	viewURL := fmt.Sprintf("%s://%s/view/%s", scheme(r), r.Host, fileID)
+	viewURL := absoluteURL(r, "/view/"+fileID)
 
 	// QR code generation
 	base64QR, err := generateQRCodeBase64(viewURL)
 	if err != nil {
-		log.Printf("QR code generation error: %v", err)
+		logErrorf("QR code generation error: %v", err)
 		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
 		return
 	}
 
 	// Generate QR code for current page
-	currentPageURL := fmt.Sprintf("%s://%s%s", scheme(r), r.Host, r.RequestURI)
-	homeQRCode, _ := generateQRCodeBase64(currentPageURL)
+	homeQRCode, _ := generateQRCodeBase64(currentURL(r))
+
+	var originalURL string
+	if fi.Optimized != nil {
+		originalURL = sitePath("/download/" + fileID + "?original=1")
+	}
+
+	// Zips list what's inside
+	var archive *ArchiveListing
+	if isArchiveFile(filename) {
+		if listing, err := listZipEntries(fullPath); err == nil {
+			archive = &listing
+		} else {
+			logErrorf("Error reading archive %s: %v", fileID, err)
+		}
+	}
 
 	data := struct {
+		Branding
 		FileName    string
 		ViewURL     string
 		DownloadURL string
+		StreamURL   string
 		QRCodeData  string
 		HomeQRCode  string
+		OriginalURL string // set when downloads get an optimized copy
+		Archive     *ArchiveListing
+		Preview     string // start of a text file
+		Truncated   bool   // the file goes on past Preview
+		PreviewSize string
+		InlineImage string // data URI for a small image, used in place of StreamURL
+		IsImage     bool
+		IsVideo     bool
+		IsAudio     bool
 	}{
+		Branding:    siteBranding(),
 		FileName:    filename,
-		ViewURL:     fmt.Sprintf("/view/%s", fileID),
-		DownloadURL: fmt.Sprintf("/download/%s", fileID),
+		ViewURL:     sitePath("/view/" + fileID),
+		DownloadURL: sitePath("/download/" + fileID),
+		StreamURL:   sitePath("/stream/" + fileID),
 		QRCodeData:  base64QR,
 		HomeQRCode:  homeQRCode,
+		OriginalURL: originalURL,
+		Archive:     archive,
+		Preview:     preview,
+		Truncated:   truncated,
+		PreviewSize: humanBytes(int64(len(preview))),
+		InlineImage: inlineImage(r, fi, showMedia && isImage),
+		IsImage:     showMedia && isImage,
+		IsVideo:     showMedia && isVideoFile(filename),
+		IsAudio:     showMedia && isAudioFile(filename),
+	}
+
+	if err := currentTemplate(tmplDisplayFile, "display_file.html").Execute(w, data); err != nil {
+		logErrorf("Template execute error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
+// inlineImage returns a data URI holding the image a file page shows, the
+// optimized copy if there is one, when it's no bigger than
+// cfg.InlineImageMaxBytes. Otherwise, or when the page has no image, it
+// returns "" and the page loads it from /stream.
+func inlineImage(r *http.Request, fi FileInfo, isImage bool) string {
+	if !isImage || cfg.InlineImageMaxBytes <= 0 {
+		return ""
+	}
+	fullPath, contentType := fi.storedPath(), fi.contentType()
+	if path, ok := optimizedVersion(r, fi); ok {
+		fullPath, contentType = path, fi.Optimized.ContentType
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return ""
+	}
+
+	stat, err := os.Stat(fullPath)
+	if err != nil || stat.Size() > cfg.InlineImageMaxBytes {
+		return ""
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		logErrorf("Error reading image %s: %v", fi.ID, err)
+		return ""
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// renderDownloadPage shows the download-only page for a binary file.
+func renderDownloadPage(w http.ResponseWriter, fi FileInfo, fullPath string) {
+	var size int64
+	if stat, err := os.Stat(fullPath); err == nil {
+		size = stat.Size()
+	}
+
+	data := struct {
+		Branding
+		FileName    string
+		Size        string
+		ContentType string
+		DownloadURL string
+	}{
+		Branding:    siteBranding(),
+		FileName:    fi.Name,
+		Size:        humanBytes(size),
+		ContentType: fi.contentType(),
+		DownloadURL: sitePath("/download/" + fi.ID),
 	}
 
-	if err := tmplDisplayFile.Execute(w, data); err != nil {
-		log.Printf("Template execute error: %v", err)
+	if err := currentTemplate(tmplDownloadFile, "download_file.html").Execute(w, data); err != nil {
+		logErrorf("Template execute error: %v", err)
 		http.Error(w, "Template error", http.StatusInternalServerError)
 	}
 }
@@ -4,15 +4,24 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/base64"
+	"fmt"
+	"image/png"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -23,6 +32,8 @@ func init() {
 	uploadsDir = "uploads"
 	tmplView = template.Must(template.ParseFiles("templates/view.html"))
 	tmplDisplayFile = template.Must(template.ParseFiles("templates/display_file.html"))
+	tmplDownloadFile = template.Must(template.ParseFiles("templates/download_file.html"))
+	tmplNotFound = template.Must(template.ParseFiles("templates/notfound.html"))
 }
 
 // Test getContentType function
@@ -138,6 +149,30 @@ func TestGenerateQRCodeBase64(t *testing.T) {
 }
 
 // Test buildFileEntries function
+// Test humanBytes at each unit boundary
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{1024 * 1024, "1.0 MB"},
+		{3355443, "3.2 MB"},
+		{1024 * 1024 * 1024, "1.0 GB"},
+		{5 * 1024 * 1024 * 1024 * 1024, "5.0 TB"},
+	}
+
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
 func TestBuildFileEntries(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -173,7 +208,7 @@ func TestBuildFileEntries(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			results := buildFileEntries(tt.filesMap)
+			results := buildFileEntries(tt.filesMap, 0)
 			if len(results) != tt.wantCount {
 				t.Errorf("buildFileEntries() returned %d entries, want %d", len(results), tt.wantCount)
 			}
@@ -193,6 +228,35 @@ func TestBuildFileEntries(t *testing.T) {
 	}
 }
 
+// Test buildFileEntries orders files newest first
+func TestBuildFileEntries_NewestFirst(t *testing.T) {
+	now := time.Now()
+	filesMap := map[string]FileInfo{
+		"old":    {ID: "old", Name: "old.txt", UploadedAt: now.Add(-2 * time.Hour)},
+		"newest": {ID: "newest", Name: "newest.txt", UploadedAt: now},
+		"middle": {ID: "middle", Name: "middle.txt", UploadedAt: now.Add(-time.Hour)},
+		// Saved before UploadedAt existed; the time comes from the ID
+		fmt.Sprintf("%d-legacy.txt", now.Add(-30*time.Minute).UnixNano()): {
+			ID:   fmt.Sprintf("%d-legacy.txt", now.Add(-30*time.Minute).UnixNano()),
+			Name: "legacy.txt",
+		},
+	}
+
+	results := buildFileEntries(filesMap, 0)
+
+	var got []string
+	for _, entry := range results {
+		got = append(got, entry.Name)
+	}
+	want := []string{"newest.txt", "legacy.txt", "middle.txt", "old.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildFileEntries() order = %v, want %v", got, want)
+	}
+	if results[0].UploadedAt.IsZero() {
+		t.Error("UploadedAt not set on entries")
+	}
+}
+
 // Test uploadFileHandler
 func TestUploadFileHandler(t *testing.T) {
 	originalFiles := files
@@ -242,6 +306,188 @@ func TestUploadFileHandler(t *testing.T) {
 	}
 }
 
+// Test the Content-Type a file was uploaded with is what it's downloaded
+// with, rather than a guess from its extension
+func TestUploadFileHandler_ContentType(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="track.dat"`)
+	header.Set("Content-Type", "audio/flac")
+	part, _ := writer.CreatePart(header)
+	part.Write([]byte("fLaC not really"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	for id, fi := range files {
+		if fi.ContentType != "audio/flac" {
+			t.Errorf("ContentType = %q, want audio/flac", fi.ContentType)
+		}
+		for _, handler := range []http.HandlerFunc{downloadFileHandler, streamFileHandler} {
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/download/"+id, nil), map[string]string{"id": id})
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if got := w.Header().Get("Content-Type"); got != "audio/flac" {
+				t.Errorf("Served Content-Type = %q, want audio/flac", got)
+			}
+		}
+	}
+}
+
+// Test a file name with a newline in it can't inject headers into the
+// download, and a very long one is cut down
+func TestUploadFileHandler_UnsafeFileName(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	tests := []struct {
+		name        string
+		disposition string
+		wantName    string
+	}{
+		{"newline", `form-data; name="file"; filename*=UTF-8''evil%0D%0AX-Injected%3A%20yes.txt`, "evilX-Injected: yes.txt"},
+		{"very long", `form-data; name="file"; filename="` + strings.Repeat("a", 300) + `.txt"`, strings.Repeat("a", maxFileNameLength-4) + ".txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files = make(map[string]FileInfo)
+			uploadsDir = t.TempDir()
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", tt.disposition)
+			part, _ := writer.CreatePart(header)
+			part.Write([]byte("contents"))
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/upload", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			w := httptest.NewRecorder()
+			uploadFileHandler(w, req)
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("uploadFileHandler() status = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body.String())
+			}
+
+			for id, fi := range files {
+				if fi.Name != tt.wantName {
+					t.Errorf("Name = %q, want %q", fi.Name, tt.wantName)
+				}
+				if len(id) > 255 {
+					t.Errorf("ID is %d bytes, too long for a file name", len(id))
+				}
+
+				req := mux.SetURLVars(httptest.NewRequest("GET", "/download/"+url.PathEscape(id), nil), map[string]string{"id": id})
+				w := httptest.NewRecorder()
+				downloadFileHandler(w, req)
+				if w.Header().Get("X-Injected") != "" {
+					t.Error("File name injected a header")
+				}
+				want := contentDisposition("attachment", tt.wantName)
+				if got := w.Header().Get("Content-Disposition"); got != want {
+					t.Errorf("Content-Disposition = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+// Test cleanFileName drops paths and control characters and shortens long
+// names without losing the extension
+func TestCleanFileName(t *testing.T) {
+	long := strings.Repeat("é", 150) + ".tar.gz"
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\photo.jpg`, "photo.jpg"},
+		{"line\nbreak\r.txt", "linebreak.txt"},
+		{"tab\there\x00.txt", "tabhere.txt"},
+		{"bad\xffutf8.txt", "badutf8.txt"},
+		{"  spaced.txt  ", "spaced.txt"},
+		{"", ""},
+		{"..", ""},
+		{"\n", ""},
+		{long, strings.Repeat("é", (maxFileNameLength-3)/2) + ".gz"},
+		{strings.Repeat("b", 250) + "." + strings.Repeat("x", 50), strings.Repeat("b", maxFileNameLength)},
+	}
+
+	for _, tt := range tests {
+		got := cleanFileName(tt.name)
+		if got != tt.want {
+			t.Errorf("cleanFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if len(got) > maxFileNameLength {
+			t.Errorf("cleanFileName(%q) is %d bytes, over %d", tt.name, len(got), maxFileNameLength)
+		}
+	}
+}
+
+// Test contentDisposition quotes an ASCII stand-in and encodes the real name
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"notes.txt", `attachment; filename="notes.txt"; filename*=UTF-8''notes.txt`},
+		{"naïve résumé.pdf", `attachment; filename="na_ve r_sum_.pdf"; filename*=UTF-8''na%C3%AFve%20r%C3%A9sum%C3%A9.pdf`},
+		{"say \"hi\"\n.txt", `attachment; filename="say _hi__.txt"; filename*=UTF-8''say%20%22hi%22%0A.txt`},
+	}
+
+	for _, tt := range tests {
+		if got := contentDisposition("attachment", tt.name); got != tt.want {
+			t.Errorf("contentDisposition(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Test which uploaded Content-Types are kept
+func TestUploadContentType(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"audio/flac", "audio/flac"},
+		{"text/csv; charset=UTF-8; header=present", "text/csv; charset=UTF-8"},
+		{"", ""},
+		{"application/octet-stream", ""},
+		{"text/html", ""},
+		{"image/svg+xml", ""},
+		{"not a type", ""},
+	}
+
+	for _, tt := range tests {
+		if got := uploadContentType(tt.header); got != tt.want {
+			t.Errorf("uploadContentType(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
 // Test uploadFileHandler with wrong method
 func TestUploadFileHandler_WrongMethod(t *testing.T) {
 	req := httptest.NewRequest("GET", "/upload", nil)
@@ -326,6 +572,140 @@ func TestDownloadFileHandler(t *testing.T) {
 	}
 }
 
+// Test downloads honour Range requests for resuming and parallel chunks,
+// still as attachments
+func TestDownloadFileHandler_Range(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	uploadsDir = t.TempDir()
+	testFileName := "123-big.bin"
+	content := "0123456789abcdefghijklmnopqrstuvwxyz"
+	os.WriteFile(filepath.Join(uploadsDir, testFileName), []byte(content), 0644)
+	files = map[string]FileInfo{
+		testFileName: {ID: testFileName, Name: "big.bin", StoredName: testFileName},
+	}
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		wantStatus   int
+		wantBody     string
+		wantRange    string
+		wantMultiple bool
+	}{
+		{"resume", "bytes=10-", http.StatusPartialContent, content[10:], "bytes 10-35/36", false},
+		{"chunk", "bytes=0-9", http.StatusPartialContent, content[:10], "bytes 0-9/36", false},
+		{"suffix", "bytes=-6", http.StatusPartialContent, content[30:], "bytes 30-35/36", false},
+		{"several chunks", "bytes=0-3,20-23", http.StatusPartialContent, "", "", true},
+		{"past the end", "bytes=100-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */36", false},
+		{"whole file", "", http.StatusOK, content, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/download/"+testFileName, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": testFileName})
+			w := httptest.NewRecorder()
+			downloadFileHandler(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.wantRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantRange)
+			}
+			if tt.wantStatus == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+				t.Errorf("Content-Disposition = %q, want attachment", cd)
+			}
+			if w.Header().Get("Accept-Ranges") != "bytes" {
+				t.Error("Accept-Ranges missing")
+			}
+
+			if !tt.wantMultiple {
+				if w.Body.String() != tt.wantBody {
+					t.Errorf("Body = %q, want %q", w.Body.String(), tt.wantBody)
+				}
+				return
+			}
+			mediaType, params, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+			if mediaType != "multipart/byteranges" {
+				t.Fatalf("Content-Type = %q, want multipart/byteranges", mediaType)
+			}
+			var parts []string
+			reader := multipart.NewReader(w.Body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					break
+				}
+				data, _ := io.ReadAll(part)
+				parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+			}
+			if got := strings.Join(parts, ","); got != "bytes 0-3/36 0123,bytes 20-23/36 klmn" {
+				t.Errorf("Parts = %q", got)
+			}
+		})
+	}
+}
+
+// Test downloadFileHandler honours ?disposition=inline and ignores other values
+func TestDownloadFileHandler_Disposition(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	uploadsDir = t.TempDir()
+	testFileName := "123-doc.pdf"
+	os.WriteFile(filepath.Join(uploadsDir, testFileName), []byte("%PDF-1.4"), 0644)
+	files = map[string]FileInfo{
+		testFileName: {ID: testFileName, Name: "doc.pdf", StoredName: testFileName},
+	}
+
+	tests := []struct {
+		query           string
+		wantDisposition string
+	}{
+		{"", "attachment"},
+		{"?disposition=inline", "inline"},
+		{"?disposition=attachment", "attachment"},
+		{"?disposition=bogus", "attachment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/download/"+testFileName+tt.query, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": testFileName})
+			w := httptest.NewRecorder()
+
+			downloadFileHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("downloadFileHandler() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, tt.wantDisposition+";") {
+				t.Errorf("Content-Disposition = %q, want %s", cd, tt.wantDisposition)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/pdf" {
+				t.Errorf("Content-Type = %q, want application/pdf", ct)
+			}
+		})
+	}
+}
+
 // Test downloadFileHandler with file not in map (direct from filesystem)
 func TestDownloadFileHandler_DirectFromFilesystem(t *testing.T) {
 	originalFiles := files
@@ -397,6 +777,9 @@ func TestDownloadFileHandler_NotFound(t *testing.T) {
 	if w.Code != http.StatusNotFound {
 		t.Errorf("downloadFileHandler() status = %d, want %d", w.Code, http.StatusNotFound)
 	}
+	if !strings.Contains(w.Body.String(), "not found") {
+		t.Errorf("Response body should mention not found, got: %s", w.Body.String())
+	}
 }
 
 // Test file type detection functions
@@ -603,6 +986,141 @@ func TestDisplayFileHandler(t *testing.T) {
 	}
 }
 
+// Test text files get a preview capped at file_preview_bytes, and binary
+// files the download-only page
+func TestDisplayFileHandler_ByType(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalTmplDisplayFile := tmplDisplayFile
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		tmplDisplayFile = originalTmplDisplayFile
+	})
+
+	tmplDisplayFile = template.Must(template.ParseFiles("templates/display_file.html"))
+	uploadsDir = t.TempDir()
+	files = make(map[string]FileInfo)
+	cfg.FilePreviewBytes = 16
+
+	os.WriteFile(filepath.Join(uploadsDir, "1-notes.log"), []byte("line one <b>\nline two and a lot more after it"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-short.txt"), []byte("all of it"), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-firmware.bin"), []byte{0x7f, 'E', 'L', 'F', 0, 0, 0xff, 0xfe}, 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-burn.txt"), []byte("secret contents"), 0644)
+	files["1-burn.txt"] = FileInfo{ID: "1-burn.txt", Name: "burn.txt", StoredName: "1-burn.txt", BurnAfterReading: true}
+
+	tests := []struct {
+		id          string
+		wantIn      []string
+		wantMissing []string
+	}{
+		{"1-notes.log", []string{"Preview", "line one &lt;b&gt;\nlin", "Showing the first 16 B"}, []string{"line two and"}},
+		{"1-short.txt", []string{"all of it"}, []string{"Showing the first"}},
+		{"1-firmware.bin", []string{"Download File", "no preview", "/download/1-firmware.bin"}, []string{"View/Play", "Preview"}},
+		{"1-burn.txt", []string{"View/Play"}, []string{"secret contents", "Preview"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/file/"+tt.id, nil), map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			displayFileHandler(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("displayFileHandler() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			body := w.Body.String()
+			for _, want := range tt.wantIn {
+				if !strings.Contains(body, want) {
+					t.Errorf("Page should contain %q", want)
+				}
+			}
+			for _, unwanted := range tt.wantMissing {
+				if strings.Contains(body, unwanted) {
+					t.Errorf("Page shouldn't contain %q", unwanted)
+				}
+			}
+		})
+	}
+}
+
+// Test an image under inline_image_max_bytes is embedded in its page as a
+// data URI, and a bigger one is loaded from /stream
+func TestDisplayFileHandler_InlineImage(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalTmplDisplayFile := tmplDisplayFile
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		tmplDisplayFile = originalTmplDisplayFile
+	})
+
+	tmplDisplayFile = template.Must(template.ParseFiles("templates/display_file.html"))
+	uploadsDir = t.TempDir()
+	files = make(map[string]FileInfo)
+	cfg.InlineImageMaxBytes = 4 << 10
+
+	var small, large bytes.Buffer
+	png.Encode(&small, photo(8, 8))
+	png.Encode(&large, photo(200, 200))
+	os.WriteFile(filepath.Join(uploadsDir, "1-icon.png"), small.Bytes(), 0644)
+	os.WriteFile(filepath.Join(uploadsDir, "1-photo.png"), large.Bytes(), 0644)
+
+	tests := []struct {
+		id          string
+		want        string
+		wantMissing string
+	}{
+		{"1-icon.png", `src="data:image/png;base64,` + base64.StdEncoding.EncodeToString(small.Bytes()) + `"`, `src="/stream/1-icon.png"`},
+		{"1-photo.png", `src="/stream/1-photo.png"`, base64.StdEncoding.EncodeToString(large.Bytes()[:48])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			req := mux.SetURLVars(httptest.NewRequest("GET", "/file/"+tt.id, nil), map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+			displayFileHandler(w, req)
+
+			body := w.Body.String()
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("Page should contain %.60q", tt.want)
+			}
+			if strings.Contains(body, tt.wantMissing) {
+				t.Errorf("Page shouldn't contain %q", tt.wantMissing)
+			}
+		})
+	}
+}
+
+// Test isBinaryFile tells text from binary, allowing for a character cut
+// off at the end
+func TestIsBinaryFile(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"ascii", []byte("hello world\n"), false},
+		{"utf-8", []byte("héllo wörld"), false},
+		{"cut off character", []byte("héllo wörld")[:9], false},
+		{"empty", nil, false},
+		{"nul byte", []byte("abc\x00def"), true},
+		{"latin-1", []byte{'c', 'a', 'f', 0xe9, ' ', 'o', 'k'}, true},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), true},
+	}
+
+	for _, tt := range tests {
+		if got := isBinaryFile(tt.head); got != tt.want {
+			t.Errorf("isBinaryFile(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 // Test displayFileHandler with file not in map (direct from filesystem)
 func TestDisplayFileHandler_DirectFromFilesystem(t *testing.T) {
 	originalFiles := files
@@ -674,6 +1192,9 @@ func TestDisplayFileHandler_NotFound(t *testing.T) {
 	if w.Code != http.StatusNotFound {
 		t.Errorf("displayFileHandler() status = %d, want %d", w.Code, http.StatusNotFound)
 	}
+	if !strings.Contains(w.Body.String(), "not found") {
+		t.Errorf("Response body should mention not found, got: %s", w.Body.String())
+	}
 }
 
 // Test file upload and download integration
@@ -739,3 +1260,640 @@ func TestFileUploadDownloadIntegration(t *testing.T) {
 		t.Errorf("Downloaded content = %s, want %s", downloadedContent, testContent)
 	}
 }
+
+// Test a failed upload copy leaves nothing behind in the uploads directory
+func TestUploadFileHandler_CopyFailure(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalCopyUpload := copyUpload
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		copyUpload = originalCopyUpload
+	})
+
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	// Write part of the file, then fail like a dropped connection would
+	copyUpload = func(dst io.Writer, src io.Reader) (int64, error) {
+		n, _ := io.CopyN(dst, src, 4)
+		return n, io.ErrUnexpectedEOF
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "partial.txt")
+	part.Write([]byte("this upload will not finish"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	uploadFileHandler(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if len(files) != 0 {
+		t.Errorf("Failed upload registered %d files, want 0", len(files))
+	}
+
+	uploadedFiles, _ := os.ReadDir(uploadsDir)
+	if len(uploadedFiles) != 0 {
+		t.Errorf("Found %d stray files in uploads directory, want 0", len(uploadedFiles))
+	}
+}
+
+// Test a burn-after-reading file is gone after its first full download
+func TestDownloadFileHandler_BurnAfterReading(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(uploadsDir, 0755)
+
+	testContent := "one time secret"
+	testFileName := "secret.txt"
+	os.WriteFile(filepath.Join(uploadsDir, testFileName), []byte(testContent), 0644)
+
+	files = map[string]FileInfo{
+		testFileName: {
+			ID:               testFileName,
+			Name:             "secret.txt",
+			StoredName:       testFileName,
+			BurnAfterReading: true,
+		},
+	}
+
+	// A partial range read must not burn the file
+	req := httptest.NewRequest("GET", "/download/"+testFileName, nil)
+	req.Header.Set("Range", "bytes=0-3")
+	req = mux.SetURLVars(req, map[string]string{"id": testFileName})
+	w := httptest.NewRecorder()
+	downloadFileHandler(w, req)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("Range download status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if _, exists := files[testFileName]; !exists {
+		t.Fatal("File burned after a partial download")
+	}
+
+	// First full download succeeds
+	req = httptest.NewRequest("GET", "/download/"+testFileName, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": testFileName})
+	w = httptest.NewRecorder()
+	downloadFileHandler(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("First download status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if w.Body.String() != testContent {
+		t.Errorf("First download body = %q, want %q", w.Body.String(), testContent)
+	}
+
+	// Second download is gone
+	req = httptest.NewRequest("GET", "/download/"+testFileName, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": testFileName})
+	w = httptest.NewRecorder()
+	downloadFileHandler(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Second download status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, testFileName)); !os.IsNotExist(err) {
+		t.Error("Burned file still exists on disk")
+	}
+}
+
+// Test behind the server's own recorder, a full download over a real
+// connection still burns the file
+func TestDownloadFileHandler_BurnThroughServer(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+	})
+
+	tmpDir := t.TempDir()
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	os.MkdirAll(uploadsDir, 0755)
+	os.WriteFile(filepath.Join(uploadsDir, "secret.txt"), []byte("one time secret"), 0644)
+	files = map[string]FileInfo{
+		"secret.txt": {ID: "secret.txt", Name: "secret.txt", StoredName: "secret.txt", BurnAfterReading: true},
+	}
+
+	server := httptest.NewServer(recordResponses(newRouter()))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/download/secret.txt")
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "one time secret" {
+		t.Fatalf("Download = %d %q", resp.StatusCode, body)
+	}
+	if _, exists := lookupFile("secret.txt"); exists {
+		t.Error("File wasn't burned by a full download through the server")
+	}
+}
+
+// Test saveFilesToFile and loadFilesFromFile
+func TestSaveAndLoadFiles(t *testing.T) {
+	originalFiles := files
+	t.Cleanup(func() {
+		files = originalFiles
+	})
+
+	filename := filepath.Join(t.TempDir(), "files.json")
+	files = map[string]FileInfo{
+		"123-a.txt": {ID: "123-a.txt", Name: "a.txt", StoredName: "123-a.txt", BurnAfterReading: true},
+	}
+
+	saveFilesToFile(filename)
+
+	files = make(map[string]FileInfo)
+	loadFilesFromFile(filename)
+
+	got, exists := files["123-a.txt"]
+	if !exists {
+		t.Fatal("File metadata not loaded")
+	}
+	if got.Name != "a.txt" || !got.BurnAfterReading {
+		t.Errorf("Loaded file metadata mismatch: %+v", got)
+	}
+}
+
+// Test a corrupt files.json is backed up and we start empty
+func TestLoadFilesFromFile_Corrupt(t *testing.T) {
+	originalFiles := files
+	t.Cleanup(func() {
+		files = originalFiles
+	})
+
+	filename := filepath.Join(t.TempDir(), "files.json")
+	os.WriteFile(filename, []byte(`{"123-a.txt": {"id": "123-a.txt"}, nope`), 0644)
+
+	files = make(map[string]FileInfo)
+	loadFilesFromFile(filename)
+
+	if len(files) != 0 {
+		t.Errorf("Expected empty files map, got %d entries", len(files))
+	}
+	if backups, _ := filepath.Glob(filename + ".corrupt.*"); len(backups) != 1 {
+		t.Errorf("Found backups %v, want one", backups)
+	}
+}
+
+// Test upload redirects and file page links include the base path
+func TestUploadFileHandler_BasePath(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	cfg.BasePath = "/pasty"
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "notes.txt")
+	part.Write([]byte("hello"))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/pasty/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "/pasty/file/") {
+		t.Fatalf("Redirect = %q, want it under /pasty/file/", location)
+	}
+	fileID := strings.TrimPrefix(location, "/pasty/file/")
+
+	req = httptest.NewRequest("GET", location, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": fileID})
+	w = httptest.NewRecorder()
+	displayFileHandler(w, req)
+
+	page := w.Body.String()
+	for _, want := range []string{"/pasty/view/" + fileID, "/pasty/download/" + fileID, `href="/pasty/"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("File page missing %s", want)
+		}
+	}
+}
+
+// uploadForm posts content as a multipart upload and returns the response
+func uploadForm(t *testing.T, filename, content string, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	part, _ := writer.CreateFormFile("file", filename)
+	part.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+	return w
+}
+
+// Test uploading identical bytes twice stores a single copy
+func TestUploadFileHandler_Dedup(t *testing.T) {
+	tests := []struct {
+		name      string
+		dedup     bool
+		fields    map[string]string
+		wantFiles int
+	}{
+		{"dedup on", true, nil, 1},
+		{"dedup off", false, nil, 2},
+		{"burn after reading is never shared", true, map[string]string{"burn": "true"}, 2},
+		{"expiring files are never shared", true, map[string]string{"expires": "1h"}, 2},
+		{"protected files are never shared", true, map[string]string{"password": "hunter2"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalFiles := files
+			originalUploadsDir := uploadsDir
+			originalFilesFile := filesFile
+			originalCfg := cfg
+			t.Cleanup(func() {
+				files = originalFiles
+				uploadsDir = originalUploadsDir
+				filesFile = originalFilesFile
+				cfg = originalCfg
+			})
+
+			tmpDir := t.TempDir()
+			files = make(map[string]FileInfo)
+			uploadsDir = filepath.Join(tmpDir, "uploads")
+			filesFile = filepath.Join(tmpDir, "files.json")
+			cfg.Dedup = tt.dedup
+
+			first := uploadForm(t, "report.pdf", "same bytes", tt.fields)
+			second := uploadForm(t, "report-copy.pdf", "same bytes", tt.fields)
+
+			entries, _ := os.ReadDir(uploadsDir)
+			if len(entries) != tt.wantFiles {
+				t.Errorf("Files on disk = %d, want %d", len(entries), tt.wantFiles)
+			}
+			if len(files) != tt.wantFiles {
+				t.Errorf("Files in map = %d, want %d", len(files), tt.wantFiles)
+			}
+
+			sameTarget := first.Header().Get("Location") == second.Header().Get("Location")
+			if sameTarget != (tt.wantFiles == 1) {
+				t.Errorf("Redirects %q and %q, want same target = %v", first.Header().Get("Location"), second.Header().Get("Location"), tt.wantFiles == 1)
+			}
+			if tt.wantFiles == 1 {
+				for _, fi := range files {
+					if fi.Name != "report.pdf" {
+						t.Errorf("Name = %q, want the original report.pdf", fi.Name)
+					}
+					if fi.Checksum == "" {
+						t.Error("Checksum not recorded")
+					}
+				}
+			}
+		})
+	}
+}
+
+// Test with ShardUploads an upload is stored in its day's directory, and is
+// still downloaded and listed from there
+func TestUploadFileHandler_Sharded(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	originalCfg := cfg
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+		cfg = originalCfg
+	})
+
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	cfg.ShardUploads = true
+
+	if w := uploadForm(t, "notes.txt", "sharded bytes", nil); w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Files in map = %d, want 1", len(files))
+	}
+	var fi FileInfo
+	for _, f := range files {
+		fi = f
+	}
+
+	day := time.Now().Format("2006/01/02")
+	if fi.StoredName != day+"/"+fi.ID {
+		t.Errorf("StoredName = %q, want %q", fi.StoredName, day+"/"+fi.ID)
+	}
+	data, err := os.ReadFile(filepath.Join(uploadsDir, filepath.FromSlash(day), fi.ID))
+	if err != nil || string(data) != "sharded bytes" {
+		t.Errorf("Dated file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(uploadsDir, fi.ID)); !os.IsNotExist(err) {
+		t.Error("File also stored at the top of the uploads directory")
+	}
+
+	if w := download(fi.ID); w.Code != http.StatusOK || w.Body.String() != "sharded bytes" {
+		t.Errorf("Download = %d %q, want the uploaded content", w.Code, w.Body.String())
+	}
+	entries := listFileEntries("", false, 0)
+	if len(entries) != 1 || entries[0].ID != fi.ID || entries[0].Size != int64(len("sharded bytes")) {
+		t.Errorf("listFileEntries() = %+v, want just %s", entries, fi.ID)
+	}
+}
+
+// Test uploads outside AllowedExtensions are refused with 415 and not stored
+func TestUploadFileHandler_AllowedExtensions(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     int
+	}{
+		{"allowed", "notes.txt", "just some text", http.StatusSeeOther},
+		{"disallowed exe", "setup.exe", "MZ\x90\x00binary", http.StatusUnsupportedMediaType},
+		{"exe renamed", "notes.txt", "MZ\x90\x00binary", http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalFiles := files
+			originalUploadsDir := uploadsDir
+			originalFilesFile := filesFile
+			originalCfg := cfg
+			t.Cleanup(func() {
+				files = originalFiles
+				uploadsDir = originalUploadsDir
+				filesFile = originalFilesFile
+				cfg = originalCfg
+			})
+
+			tmpDir := t.TempDir()
+			files = make(map[string]FileInfo)
+			uploadsDir = filepath.Join(tmpDir, "uploads")
+			filesFile = filepath.Join(tmpDir, "files.json")
+			cfg.AllowedExtensions = []string{".txt", ".png", ".pdf"}
+
+			w := uploadForm(t, tt.filename, tt.content, nil)
+
+			if w.Code != tt.want {
+				t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, tt.want)
+			}
+			entries, _ := os.ReadDir(uploadsDir)
+			if tt.want == http.StatusUnsupportedMediaType {
+				if len(entries) != 0 || len(files) != 0 {
+					t.Errorf("Rejected upload left %d files on disk, %d in map", len(entries), len(files))
+				}
+				if !strings.Contains(w.Body.String(), "not") {
+					t.Errorf("Body = %q, want an explanation", w.Body.String())
+				}
+				return
+			}
+
+			// The stored copy is complete even though the start was sniffed
+			data, _ := os.ReadFile(filepath.Join(uploadsDir, entries[0].Name()))
+			if string(data) != tt.content {
+				t.Errorf("Stored content = %q, want %q", data, tt.content)
+			}
+		})
+	}
+}
+
+// Test uploads bigger than max_upload_bytes are refused
+func TestUploadFileHandler_TooLarge(t *testing.T) {
+	originalCfg := cfg
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		cfg = originalCfg
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	cfg.MaxUploadBytes = 100
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "big.txt")
+	part.Write(bytes.Repeat([]byte("x"), 1000))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	uploadFileHandler(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(files) != 0 {
+		t.Errorf("Oversize upload registered %d files, want 0", len(files))
+	}
+}
+
+// Test resolveFile prefers the files map, falls back to what's on disk and
+// misses files that aren't servable
+func TestResolveFile(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+	})
+
+	uploadsDir = t.TempDir()
+	for _, name := range []string{"1-known.txt", "2-loose.txt", "4-expired.txt", "5-partial.txt.part"} {
+		os.WriteFile(filepath.Join(uploadsDir, name), []byte("data"), 0644)
+	}
+	os.Mkdir(filepath.Join(uploadsDir, "subdir"), 0755)
+	files = map[string]FileInfo{
+		"1-known.txt":   {ID: "1-known.txt", Name: "known.txt", StoredName: "1-known.txt", Checksum: "abc"},
+		"3-gone.txt":    {ID: "3-gone.txt", Name: "gone.txt", StoredName: "3-gone.txt"},
+		"4-expired.txt": {ID: "4-expired.txt", Name: "expired.txt", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantFound  bool
+		wantName   string
+		wantSHA256 string
+	}{
+		{"map hit", "1-known.txt", true, "known.txt", "abc"},
+		{"filesystem fallback", "2-loose.txt", true, "2-loose.txt", ""},
+		{"traversal cleaned", "../2-loose.txt", true, "2-loose.txt", ""},
+		{"miss", "9-nothing.txt", false, "", ""},
+		{"in map but not on disk", "3-gone.txt", false, "", ""},
+		{"expired", "4-expired.txt", false, "", ""},
+		{"still uploading", "5-partial.txt.part", false, "", ""},
+		{"directory", "subdir", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fi, found := resolveFile(tt.id)
+			if found != tt.wantFound {
+				t.Fatalf("resolveFile(%q) found = %v, want %v", tt.id, found, tt.wantFound)
+			}
+			if !found {
+				return
+			}
+			if fi.Name != tt.wantName || fi.Checksum != tt.wantSHA256 {
+				t.Errorf("resolveFile(%q) = %+v, want name %q and checksum %q", tt.id, fi, tt.wantName, tt.wantSHA256)
+			}
+			if fi.ID != filepath.Base(tt.id) {
+				t.Errorf("resolveFile(%q) ID = %q, want %q", tt.id, fi.ID, filepath.Base(tt.id))
+			}
+		})
+	}
+}
+
+// slowBody is an upload body that holds its slot until release is closed,
+// keeping count of how many are being read at once
+type slowBody struct {
+	r        io.Reader
+	started  bool
+	release  chan struct{}
+	inFlight *atomic.Int32
+	maxSeen  *atomic.Int32
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		n := b.inFlight.Add(1)
+		for {
+			seen := b.maxSeen.Load()
+			if n <= seen || b.maxSeen.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		<-b.release
+	}
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		b.inFlight.Add(-1)
+	}
+	return n, err
+}
+
+// Test uploads over max_concurrent_uploads are turned away with a 503 while
+// the ones holding a slot finish
+func TestUploadFileHandler_ConcurrencyLimit(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalSlots := uploadSlots
+	originalWait := uploadSlotWait
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		uploadSlots = originalSlots
+		uploadSlotWait = originalWait
+	})
+
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(t.TempDir(), "uploads")
+	os.MkdirAll(uploadsDir, 0755)
+
+	const limit, uploads = 2, 6
+	uploadSlots = newUploadSlots(limit)
+	uploadSlotWait = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	var inFlight, maxSeen atomic.Int32
+	codes := make(chan int, uploads)
+	busy := make(chan string, uploads)
+	var wg sync.WaitGroup
+	for i := range uploads {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", fmt.Sprintf("upload%d.txt", i))
+		fmt.Fprintf(part, "content of upload %d", i)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/upload", &slowBody{r: body, release: release, inFlight: &inFlight, maxSeen: &maxSeen})
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		wg.Go(func() {
+			w := httptest.NewRecorder()
+			uploadFileHandler(w, req)
+			if w.Code == http.StatusServiceUnavailable {
+				busy <- w.Header().Get("Retry-After")
+			}
+			codes <- w.Code
+		})
+	}
+
+	// The ones without a slot give up while the others are still sending
+	for range uploads - limit {
+		if retryAfter := <-busy; retryAfter == "" {
+			t.Error("503 without Retry-After")
+		}
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusSeeOther] != limit || counts[http.StatusServiceUnavailable] != uploads-limit {
+		t.Errorf("Statuses = %v, want %d uploads stored and %d turned away", counts, limit, uploads-limit)
+	}
+	if n := maxSeen.Load(); n > limit {
+		t.Errorf("%d uploads were received at once, want at most %d", n, limit)
+	}
+	if len(files) != limit {
+		t.Errorf("Stored %d files, want %d", len(files), limit)
+	}
+	if len(uploadSlots) != 0 {
+		t.Errorf("%d slots still held after every upload finished", len(uploadSlots))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A paste can be checked before it's saved by picking a format to validate
+// it as. One that doesn't parse is sent back to the form with the parser's
+// error, and one that does is stored with that format as its language.
+const (
+	validateNone = "none"
+	validateJSON = "json"
+	validateYAML = "yaml"
+)
+
+// validFormat reports whether format is one a paste can be validated as;
+// empty means none.
+func validFormat(format string) bool {
+	switch format {
+	case "", validateNone, validateJSON, validateYAML:
+		return true
+	}
+	return false
+}
+
+// validateBody parses text as format, returning the syntax error if it
+// doesn't parse. Formats that don't validate always pass.
+func validateBody(format, text string) error {
+	switch format {
+	case validateJSON:
+		return validateJSONBody(text)
+	case validateYAML:
+		return validateYAMLBody(text)
+	}
+	return nil
+}
+
+// validateJSONBody checks text is a single JSON value, reporting where a
+// syntax error is by line and column
+func validateJSONBody(text string) error {
+	var value any
+	err := json.Unmarshal([]byte(text), &value)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := lineColumn(text, syntaxErr.Offset)
+		return fmt.Errorf("invalid JSON at line %d, column %d: %v", line, column, syntaxErr)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	return nil
+}
+
+// validateYAMLBody checks every document in text parses as YAML. The
+// parser's errors already say which line is wrong.
+func validateYAMLBody(text string) error {
+	decoder := yaml.NewDecoder(strings.NewReader(text))
+	for {
+		var value any
+		err := decoder.Decode(&value)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid YAML: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+	}
+}
+
+// lineColumn turns a byte offset into text into a 1-based line and column.
+// JSON's offsets point just past the byte that broke parsing, so that byte
+// is the column reported.
+func lineColumn(text string, offset int64) (int, int) {
+	before := []byte(text[:min(int(offset), len(text))])
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return line, max(column, 1)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// Test validateBody passes what parses and points at what doesn't
+func TestValidateBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		text    string
+		wantErr string
+	}{
+		{"valid JSON", validateJSON, `{"port": 3015}`, ""},
+		{"JSON syntax error", validateJSON, "{\n  \"port\": 3015,\n}", "line 3, column 1"},
+		{"JSON trailing data", validateJSON, `{} {}`, "line 1, column 4"},
+		{"empty JSON", validateJSON, "", "invalid JSON"},
+		{"valid YAML", validateYAML, "port: 3015\nhosts:\n  - a\n  - b\n", ""},
+		{"YAML syntax error", validateYAML, "port: 3015\nhost: a: b\n", "line 2"},
+		{"bad second YAML document", validateYAML, "a: 1\n---\nb: [\n", "invalid YAML"},
+		{"none", validateNone, "{not json", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBody(tt.format, tt.text)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBody() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateBody() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Test handleSave saves a paste that validates, with the format as its
+// language, and sends one that doesn't back to the form with the error
+func TestHandleSave_Validate(t *testing.T) {
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		tmplIndex = originalTmplIndex
+	})
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	tmplIndex = template.Must(template.ParseFiles("templates/index.html"))
+
+	tests := []struct {
+		name         string
+		validate     string
+		text         string
+		wantStatus   int
+		wantLanguage string
+		wantBody     string
+	}{
+		{"valid JSON", "json", `{"port": 3015}`, http.StatusSeeOther, "json", ""},
+		{"invalid JSON", "json", "{\n  \"port\": 3015,\n}", http.StatusBadRequest, "", "line 3, column 1"},
+		{"valid YAML", "yaml", "port: 3015\n", http.StatusSeeOther, "yaml", ""},
+		{"none", "none", "{\n  \"port\": 3015,\n}", http.StatusSeeOther, languagePlain, ""},
+		{"not chosen", "", "{not json", http.StatusSeeOther, languagePlain, ""},
+		{"unknown format", "toml", "port = 3015", http.StatusBadRequest, "", "Unknown format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippets = make(map[string]Snippet)
+
+			form := url.Values{"title": {"Config"}, "text": {tt.text}, "validate": {tt.validate}}
+			req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handleSave(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("handleSave() status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("Response doesn't mention %q: %s", tt.wantBody, w.Body.String())
+			}
+			if tt.wantLanguage == "" {
+				if len(snippets) != 0 {
+					t.Errorf("Saved %d snippets, want none", len(snippets))
+				}
+				return
+			}
+			if len(snippets) != 1 {
+				t.Fatalf("Saved %d snippets, want 1", len(snippets))
+			}
+			for _, snippet := range snippets {
+				if snippet.Language != tt.wantLanguage {
+					t.Errorf("Language = %q, want %q", snippet.Language, tt.wantLanguage)
+				}
+			}
+		})
+	}
+}
+
+// Test a paste sent back to the form keeps its title and text
+func TestHandleSave_ValidateKeepsDraft(t *testing.T) {
+	originalSnippets := snippets
+	originalTmplIndex := tmplIndex
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		tmplIndex = originalTmplIndex
+	})
+	snippets = make(map[string]Snippet)
+	tmplIndex = template.Must(template.ParseFiles("templates/index.html"))
+
+	form := url.Values{"title": {"<My config>"}, "text": {`{"a": }`}, "validate": {"json"}}
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+
+	body := w.Body.String()
+	if !strings.Contains(body, `value="&lt;My config&gt;"`) {
+		t.Error("Form doesn't keep the escaped title")
+	}
+	if !strings.Contains(body, `{&#34;a&#34;: }</textarea>`) {
+		t.Error("Form doesn't keep the escaped text")
+	}
+}
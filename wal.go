@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Snippets saved between two writes of snippets.json would be lost in a
+// crash, so every create, change and delete is also appended to a
+// write-ahead log, snippets.wal, before the request is answered. Each line
+// is one walEntry. On startup the log is replayed over snippets.json and
+// cleared.
+//
+// Saving snippets.json compacts the log: just before the snapshot is taken
+// the log is moved aside to snippets.wal.old, so later changes start a new
+// one, and the old one is removed once the save has landed. If the save
+// fails both are kept and replayed in order.
+//
+// Bodies are logged in plain text, or encrypted like snippets.json when
+// encryption_key is set.
+
+// walFile is the log next to snippetsFile. It's empty when there's nothing
+// to log to, as in tests that don't set one up.
+var walFile string
+
+// walMu serializes writes to the log and moving it aside. It's taken with
+// snippetsMu already held.
+var walMu sync.Mutex
+
+// Kinds of walEntry
+const (
+	walPut    = "put"
+	walDelete = "delete"
+)
+
+// walEntry is one line of the log: a snippet saved under ID, or ID deleted
+type walEntry struct {
+	Op      string   `json:"op"`
+	ID      string   `json:"id"`
+	Snippet *Snippet `json:"snippet,omitempty"`
+}
+
+// walPath returns the log file for a snippets file, e.g. snippets.wal for
+// snippets.json.
+func walPath(snippetsFilename string) string {
+	return strings.TrimSuffix(snippetsFilename, ".json") + ".wal"
+}
+
+// logSnippetPut records a created or changed snippet. Callers must hold the
+// snippetsMu write lock.
+func logSnippetPut(id string, snippet Snippet) {
+	if walFile == "" {
+		return
+	}
+	key, err := decodeEncryptionKey(cfg.EncryptionKey)
+	if err != nil {
+		logErrorf("Error logging snippet %s: %v", id, err)
+		return
+	}
+	plain := plainSnippets(map[string]Snippet{id: snippet})
+	if key != nil {
+		if plain, err = encryptSnippets(plain, key); err != nil {
+			logErrorf("Error logging snippet %s: %v", id, err)
+			return
+		}
+	}
+	logged := plain[id]
+	writeWAL(walEntry{Op: walPut, ID: id, Snippet: &logged})
+}
+
+// logSnippetDelete records deleted snippets. Callers must hold the
+// snippetsMu write lock.
+func logSnippetDelete(ids ...string) {
+	if walFile == "" || len(ids) == 0 {
+		return
+	}
+	entries := make([]walEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = walEntry{Op: walDelete, ID: id}
+	}
+	writeWAL(entries...)
+}
+
+// writeWAL appends entries to the log and syncs it to disk.
+func writeWAL(entries ...walEntry) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			logErrorf("Error encoding log entry for %s: %v", entry.ID, err)
+			return
+		}
+	}
+
+	walMu.Lock()
+	defer walMu.Unlock()
+	f, err := os.OpenFile(walFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		logErrorf("Error opening %s: %v", walFile, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		logErrorf("Error writing %s: %v", walFile, err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		logErrorf("Error syncing %s: %v", walFile, err)
+	}
+}
+
+// rotateWAL moves the log aside before a save of snippets.json, so what's
+// logged from now on isn't lost with it. An old log still there from a
+// failed save has this one added to the end. Callers must hold snippetsMu.
+func rotateWAL() {
+	if walFile == "" {
+		return
+	}
+	walMu.Lock()
+	defer walMu.Unlock()
+
+	oldFile := walFile + ".old"
+	if _, err := os.Stat(oldFile); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(walFile, oldFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logErrorf("Error moving %s aside: %v", walFile, err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(walFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = appendFile(oldFile, data)
+	}
+	if err != nil {
+		logErrorf("Error moving %s aside: %v", walFile, err)
+		return
+	}
+	os.Remove(walFile)
+}
+
+// appendFile adds data to the end of a file and syncs it
+func appendFile(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compactedWAL drops the log moved aside by rotateWAL once snippets.json
+// holds everything in it.
+func compactedWAL() {
+	if walFile == "" {
+		return
+	}
+	if err := os.Remove(walFile + ".old"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logErrorf("Error removing %s.old: %v", walFile, err)
+	}
+}
+
+// replaySnippetsWAL applies what's logged since snippets.json was last
+// written to the loaded snippets, then saves them, which clears the log. It
+// returns how many entries were replayed. A last line cut off by the crash
+// is skipped.
+func replaySnippetsWAL() int {
+	if walFile == "" {
+		return 0
+	}
+	key, err := decodeEncryptionKey(cfg.EncryptionKey)
+	if err != nil {
+		log.Fatalf("Invalid encryption key: %v", err)
+	}
+
+	replayed, found := 0, false
+	for _, filename := range []string{walFile + ".old", walFile} {
+		f, err := os.Open(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Could not open %s: %v", filename, err)
+		}
+		found = true
+
+		scanner := bufio.NewScanner(f)
+		// JSON escaping can make a line several times the size of its body
+		scanner.Buffer(nil, 8*cfg.MaxSnippetBytes+(1<<20))
+		for line := 1; scanner.Scan(); line++ {
+			var entry walEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				logWarnf("Skipping unreadable line %d of %s: %v", line, filename, err)
+				continue
+			}
+			if err := applyWALEntry(entry, key); err != nil {
+				logWarnf("Skipping line %d of %s: %v", line, filename, err)
+				continue
+			}
+			replayed++
+		}
+		if err := scanner.Err(); err != nil {
+			logErrorf("Error reading %s: %v", filename, err)
+		}
+		f.Close()
+	}
+	if !found {
+		return 0
+	}
+
+	logInfof("Replayed %d changes from %s.\n", replayed, walFile)
+	saveSnippetsToFile(snippetsFile)
+	return replayed
+}
+
+// applyWALEntry makes the change one walEntry records.
+func applyWALEntry(entry walEntry, key []byte) error {
+	switch {
+	case entry.Op == walDelete:
+		delete(snippets, entry.ID)
+	case entry.Op == walPut && entry.Snippet != nil:
+		one := map[string]Snippet{entry.ID: *entry.Snippet}
+		if err := decryptSnippets(one, key); err != nil {
+			return err
+		}
+		compressSnippets(one)
+		snippets[entry.ID] = one[entry.ID]
+	default:
+		return errors.New("unknown entry " + entry.Op)
+	}
+	return nil
+}
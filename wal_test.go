@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupWALTest points snippetsFile and walFile at a temp dir and stops
+// saves being written straight away, as if the flusher were running
+func setupWALTest(t *testing.T) {
+	t.Helper()
+
+	originalCfg := cfg
+	originalSnippets := snippets
+	originalSnippetsFile := snippetsFile
+	originalWALFile := walFile
+	originalFlusher := snippetsFlusher.Load()
+	t.Cleanup(func() {
+		cfg = originalCfg
+		snippets = originalSnippets
+		snippetsFile = originalSnippetsFile
+		walFile = originalWALFile
+		snippetsFlusher.Store(originalFlusher)
+		snippetsDirty.Store(false)
+	})
+
+	snippetsFile = filepath.Join(t.TempDir(), "snippets.json")
+	walFile = walPath(snippetsFile)
+	snippets = make(map[string]Snippet)
+	snippetsFlusher.Store(true)
+}
+
+// restart throws away the in-memory snippets and loads them again, the way
+// startup does
+func restart() {
+	snippets = make(map[string]Snippet)
+	loadSnippetsFromFile(snippetsFile)
+	replaySnippetsWAL()
+}
+
+// Test a paste saved just before a crash, with snippets.json never
+// written, comes back from the log
+func TestReplaySnippetsWAL(t *testing.T) {
+	setupWALTest(t)
+
+	form := url.Values{"title": {"Crash test"}, "text": {"survives the crash"}}
+	req := httptest.NewRequest("POST", "/save", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if _, err := os.Stat(snippetsFile); !os.IsNotExist(err) {
+		t.Fatal("snippets.json was written; the crash can't be simulated")
+	}
+
+	restart()
+
+	if len(snippets) != 1 {
+		t.Fatalf("Replay recovered %d snippets, want 1", len(snippets))
+	}
+	for _, snippet := range snippets {
+		if snippet.Title != "Crash test" || snippet.Body() != "survives the crash" {
+			t.Errorf("Recovered snippet = %+v", snippet)
+		}
+	}
+	if _, err := os.Stat(walFile); !os.IsNotExist(err) {
+		t.Error("Log still there after replay")
+	}
+	saved, _ := os.ReadFile(snippetsFile)
+	if !strings.Contains(string(saved), "survives the crash") {
+		t.Error("Replay didn't save the recovered snippet to snippets.json")
+	}
+}
+
+// Test deletes are replayed in order after an older snapshot, and a line
+// cut off by the crash is skipped
+func TestReplaySnippetsWAL_Deletes(t *testing.T) {
+	setupWALTest(t)
+
+	snippets = map[string]Snippet{
+		"keep": {Text: "keep me"},
+		"gone": {Text: "delete me"},
+	}
+	saveSnippetsToFile(snippetsFile)
+
+	snippetsMu.Lock()
+	snippets["new"] = Snippet{Text: "new"}
+	logSnippetPut("new", snippets["new"])
+	delete(snippets, "gone")
+	logSnippetDelete("gone")
+	snippetsMu.Unlock()
+	f, _ := os.OpenFile(walFile, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"op":"put","id":"half","snip`)
+	f.Close()
+
+	restart()
+
+	if _, exists := snippets["gone"]; exists {
+		t.Error("Deleted snippet came back")
+	}
+	if snippets["keep"].Body() != "keep me" || snippets["new"].Body() != "new" {
+		t.Errorf("Snippets after replay = %v", snippets)
+	}
+	if _, exists := snippets["half"]; exists {
+		t.Error("Cut-off line was applied")
+	}
+}
+
+// Test saving snippets.json clears the log, and changes logged while a
+// failed save's log is still around are kept with it
+func TestSaveSnippetsToFile_CompactsWAL(t *testing.T) {
+	setupWALTest(t)
+
+	snippetsMu.Lock()
+	snippets["one"] = Snippet{Text: "one"}
+	logSnippetPut("one", snippets["one"])
+	snippetsMu.Unlock()
+
+	// A save that can't land leaves the log moved aside
+	goodFile := snippetsFile
+	snippetsFile = filepath.Join(t.TempDir(), "missing", "snippets.json")
+	saveSnippetsToFile(snippetsFile)
+	snippetsFile = goodFile
+	if _, err := os.Stat(walFile + ".old"); err != nil {
+		t.Fatalf("Failed save dropped the log: %v", err)
+	}
+
+	snippetsMu.Lock()
+	snippets["two"] = Snippet{Text: "two"}
+	logSnippetPut("two", snippets["two"])
+	snippetsMu.Unlock()
+
+	// Crash before anything else is saved
+	restart()
+	if snippets["one"].Body() != "one" || snippets["two"].Body() != "two" {
+		t.Errorf("Snippets after replay = %v", snippets)
+	}
+	for _, leftover := range []string{walFile, walFile + ".old"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s still there after a successful save", filepath.Base(leftover))
+		}
+	}
+}
+
+// Test logged bodies are encrypted when an encryption key is set
+func TestLogSnippetPut_Encrypted(t *testing.T) {
+	setupWALTest(t)
+	cfg.EncryptionKey = testEncryptionKey
+
+	snippetsMu.Lock()
+	snippets["abc"] = Snippet{Text: "hunter2 is my password"}
+	logSnippetPut("abc", snippets["abc"])
+	snippetsMu.Unlock()
+
+	data, _ := os.ReadFile(walFile)
+	if strings.Contains(string(data), "hunter2") {
+		t.Error("Log contains the plaintext snippet body")
+	}
+
+	restart()
+	if snippets["abc"].Body() != "hunter2 is my password" {
+		t.Errorf("Replayed body = %q", snippets["abc"].Body())
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WebhookEvent is posted to cfg.WebhookURL when something new is shared
+type WebhookEvent struct {
+	Type        string `json:"type"` // "snippet" or "file"
+	ID          string `json:"id"`
+	TitleOrName string `json:"title_or_name"`
+	URL         string `json:"url"`
+	Owner       string `json:"owner"`
+}
+
+// webhookClient has a timeout so a hung webhook can't pile up goroutines
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyWebhook posts event to the configured webhook in the background.
+// Failures are only logged; they never affect the request that caused them.
+func notifyWebhook(event WebhookEvent) {
+	url := cfg.WebhookURL
+	if url == "" {
+		return
+	}
+
+	go func() {
+		body, err := json.Marshal(event)
+		if err != nil {
+			logErrorf("Error encoding webhook payload: %v", err)
+			return
+		}
+		resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logWarnf("Webhook for %s %s failed: %v", event.Type, event.ID, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			logWarnf("Webhook for %s %s returned %s", event.Type, event.ID, resp.Status)
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureWebhook starts a server that records webhook payloads and points
+// the config at it
+func captureWebhook(t *testing.T) <-chan WebhookEvent {
+	t.Helper()
+
+	events := make(chan WebhookEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Webhook payload is not valid JSON: %v", err)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Webhook Content-Type = %q, want application/json", ct)
+		}
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+
+	originalCfg := cfg
+	t.Cleanup(func() {
+		cfg = originalCfg
+	})
+	cfg.WebhookURL = server.URL
+	return events
+}
+
+// waitForWebhook returns the next payload or fails after a timeout
+func waitForWebhook(t *testing.T, events <-chan WebhookEvent) WebhookEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Webhook was not called")
+		return WebhookEvent{}
+	}
+}
+
+// Test saving a snippet posts a webhook with its details
+func TestWebhook_Snippet(t *testing.T) {
+	originalSnippets := snippets
+	t.Cleanup(func() {
+		snippets = originalSnippets
+	})
+	snippets = make(map[string]Snippet)
+	events := captureWebhook(t)
+
+	req := httptest.NewRequest("POST", "http://paste.lan/save", strings.NewReader("title=Standup&text=notes"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	event := waitForWebhook(t, events)
+	var id string
+	for key := range snippets {
+		id = key
+	}
+	want := WebhookEvent{
+		Type:        "snippet",
+		ID:          id,
+		TitleOrName: "Standup",
+		URL:         "http://paste.lan/display/" + id,
+	}
+	if event != want {
+		t.Errorf("Webhook payload = %+v, want %+v", event, want)
+	}
+}
+
+// Test uploading a file posts a webhook with its details
+func TestWebhook_File(t *testing.T) {
+	originalFiles := files
+	originalUploadsDir := uploadsDir
+	originalFilesFile := filesFile
+	t.Cleanup(func() {
+		files = originalFiles
+		uploadsDir = originalUploadsDir
+		filesFile = originalFilesFile
+	})
+	tmpDir := t.TempDir()
+	files = make(map[string]FileInfo)
+	uploadsDir = filepath.Join(tmpDir, "uploads")
+	filesFile = filepath.Join(tmpDir, "files.json")
+	events := captureWebhook(t)
+
+	w := uploadForm(t, "photo.png", "not really a png", nil)
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("uploadFileHandler() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	event := waitForWebhook(t, events)
+	if event.Type != "file" || event.TitleOrName != "photo.png" {
+		t.Errorf("Webhook payload = %+v, want a file event for photo.png", event)
+	}
+	if _, exists := files[event.ID]; !exists {
+		t.Errorf("Webhook ID %q is not a stored file", event.ID)
+	}
+	if !strings.HasSuffix(event.URL, "/file/"+event.ID) {
+		t.Errorf("Webhook URL = %q, want it to link the file page", event.URL)
+	}
+}
+
+// Test a failing webhook doesn't affect the request
+func TestWebhook_Failure(t *testing.T) {
+	originalSnippets := snippets
+	originalCfg := cfg
+	t.Cleanup(func() {
+		snippets = originalSnippets
+		cfg = originalCfg
+	})
+	snippets = make(map[string]Snippet)
+	cfg.WebhookURL = "http://127.0.0.1:1/unreachable"
+
+	req := httptest.NewRequest("POST", "/save", strings.NewReader("text=hello"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleSave(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Errorf("handleSave() status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if len(snippets) != 1 {
+		t.Errorf("Snippet not saved when the webhook fails")
+	}
+}